@@ -0,0 +1,215 @@
+// Package accesslog provides a middleware that writes a single record of access log for every request,
+// in the Apache Combined Log Format, JSON lines, or a custom template.
+package accesslog
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/middleware"
+)
+
+const (
+	// upstreamHostKey is the key of the upstream host in Context.Data.
+	upstreamHostKey = "accesslog.upstream_host"
+	// redacted is the replacement of redacted values.
+	redacted = "REDACTED"
+)
+
+// defaultRedactHeaders is the headers redacted if Config.RedactHeaders is nil.
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// SampleRule is a rule of sampling access logs by status code.
+type SampleRule struct {
+	// MinStatus is the minimum status code (inclusive) that the rule applies to.
+	MinStatus int
+	// MaxStatus is the maximum status code (inclusive) that the rule applies to.
+	MaxStatus int
+	// Rate is the ratio (from 0 to 1) of records to be written.
+	Rate float64
+}
+
+// Config is the configuration of the access log middleware.
+type Config struct {
+	// Format is the Formatter of records. JSONFormat is used if it is nil.
+	Format Formatter
+	// Output is where records are written. os.Stdout is used if it is nil.
+	// Use RotatingFile to write to files with rotation.
+	Output io.Writer
+	// Sampling is a list of rules to decide whether a record is written. The first rule matching the status code
+	// is applied, and records not matching any rule are always written.
+	// For example, logging all 5xx and 1% of 2xx:
+	//
+	//	[]SampleRule{{MinStatus: 500, MaxStatus: 599, Rate: 1}, {MinStatus: 200, MaxStatus: 299, Rate: 0.01}}
+	Sampling []SampleRule
+	// Headers is a list of request headers recorded in Record.Headers.
+	Headers []string
+	// RedactHeaders is a list of headers whose values are replaced with "REDACTED" in Record.Headers.
+	// Authorization, Proxy-Authorization, Cookie and Set-Cookie are redacted if it is nil.
+	RedactHeaders []string
+	// RedactQuery is a list of query parameters whose values are replaced with "REDACTED",
+	// in both Record.Query and the query of Record.Referer.
+	RedactQuery []string
+	// TrustProxyHeaders uses the last address in the X-Forwarded-For header as the client IP,
+	// which is appended by the proxy in front of the gateway. See gateway.ClientIP.
+	// Enable it only if the gateway is behind a trusted proxy.
+	TrustProxyHeaders bool
+}
+
+// Middleware provides a middleware that writes a record of access log after the response has been written.
+// Errors of writing records are logged to Context.Logger.
+// Requests made by the gateway itself (see gateway.IsBackground) are not logged.
+func Middleware(config Config) gateway.Handler {
+	if config.Format == nil {
+		config.Format = JSONFormat
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = defaultRedactHeaders
+	}
+	redactHeaders := map[string]bool{}
+	for _, h := range config.RedactHeaders {
+		redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	redactQuery := map[string]bool{}
+	for _, q := range config.RedactQuery {
+		redactQuery[q] = true
+	}
+	var mu sync.Mutex
+
+	return func(context *gateway.Context) {
+		if gateway.IsBackground(context.Request) {
+			return
+		}
+		start := time.Now()
+		req := context.Request
+		context.OnFinish(func() {
+			status := context.StatusCode
+			if !sample(config.Sampling, status) {
+				return
+			}
+
+			r := &Record{
+				Time:         start,
+				ClientIP:     gateway.ClientIP(req, config.TrustProxyHeaders),
+				Method:       req.Method,
+				Path:         req.URL.EscapedPath(),
+				Query:        redactRawQuery(req.URL.RawQuery, redactQuery),
+				Proto:        req.Proto,
+				Service:      context.GetServiceName(),
+				Status:       status,
+				BytesOut:     context.BytesWritten(),
+				Duration:     time.Since(start),
+				UserAgent:    req.UserAgent(),
+				Referer:      redactURL(req.Referer(), redactQuery),
+				RequestID:    middleware.GetRequestID(context),
+				UpstreamHost: GetUpstreamHost(context),
+			}
+			if req.ContentLength > 0 {
+				r.BytesIn = req.ContentLength
+			}
+			if principal := context.GetPrincipal(); principal != nil {
+				r.User = principal.Subject
+			} else if user, _, ok := req.BasicAuth(); ok {
+				r.User = user
+			}
+			if len(config.Headers) > 0 {
+				r.Headers = map[string]string{}
+				for _, h := range config.Headers {
+					key := http.CanonicalHeaderKey(h)
+					if value := req.Header.Get(key); value != "" {
+						if redactHeaders[key] {
+							value = redacted
+						}
+						r.Headers[key] = value
+					}
+				}
+			}
+
+			data, err := config.Format.Format(r)
+			if err == nil {
+				mu.Lock()
+				_, err = config.Output.Write(data)
+				mu.Unlock()
+			}
+			if err != nil {
+				context.Logger.WithError(err).Error("failed to write access log")
+			}
+		})
+	}
+}
+
+// SetUpstreamHost records the host of the upstream which the request is sent to.
+func SetUpstreamHost(context *gateway.Context, host string) {
+	context.Data[upstreamHostKey] = host
+}
+
+// GetUpstreamHost gets the host of the upstream which the request is sent to.
+func GetUpstreamHost(context *gateway.Context) string {
+	host, _ := context.Data[upstreamHostKey].(string)
+	return host
+}
+
+// Transport wraps an http.RoundTripper and records the upstream host,
+// if the request is created from gateway.Context.Request.Context().
+// http.DefaultTransport is used if the given one is nil.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return gateway.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if c := gateway.FromContext(req.Context()); c != nil {
+			SetUpstreamHost(c, req.URL.Host)
+		}
+		return rt.RoundTrip(req)
+	})
+}
+
+// sample decides whether the record of the status code should be written.
+func sample(rules []SampleRule, status int) bool {
+	for _, rule := range rules {
+		if status >= rule.MinStatus && status <= rule.MaxStatus {
+			return rule.Rate >= 1 || rand.Float64() < rule.Rate
+		}
+	}
+	return true
+}
+
+// redactRawQuery replaces values of the given query parameters, keeping the order of parameters.
+func redactRawQuery(rawQuery string, keys map[string]bool) string {
+	if rawQuery == "" || len(keys) == 0 {
+		return rawQuery
+	}
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		key := part
+		if idx := strings.IndexByte(part, '='); idx != -1 {
+			key = part[:idx]
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil && keys[unescaped] {
+			parts[i] = key + "=" + redacted
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// redactURL replaces values of the given query parameters in a URL.
+func redactURL(rawURL string, keys map[string]bool) string {
+	if len(keys) == 0 {
+		return rawURL
+	}
+	idx := strings.IndexByte(rawURL, '?')
+	if idx == -1 {
+		return rawURL
+	}
+	return rawURL[:idx+1] + redactRawQuery(rawURL[idx+1:], keys)
+}
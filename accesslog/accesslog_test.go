@@ -0,0 +1,204 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/middleware"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(handlers ...gateway.Handler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	s.UseConfig(cfg)
+	s.UseMiddlewares(handlers...)
+	s.Register("api.hello", func(context *gateway.Context) {
+		if context.Request.URL.Query().Get("panic") != "" {
+			panic("boom")
+		}
+		if context.Request.URL.Query().Get("stream") != "" {
+			context.ResponseStream = strings.NewReader("streamed")
+			return
+		}
+		if context.Request.URL.Query().Get("fail") != "" {
+			context.StatusCode = http.StatusInternalServerError
+		}
+		SetUpstreamHost(context, "backend:8080")
+		context.Response = []byte("hello")
+	})
+	return s.Handler()
+}
+
+func TestMiddleware_JSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := testServer(middleware.RequestID(), Middleware(Config{
+		Output:      buf,
+		Headers:     []string{"Authorization", "X-Custom"},
+		RedactQuery: []string{"token"},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/hello?a=1&token=secret", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Custom", "custom")
+	req.Header.Set("Referer", "http://example.com/?token=secret")
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	result := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, "10.0.0.1", result["client_ip"])
+	assert.Equal(t, "/hello", result["path"])
+	assert.Equal(t, "a=1&token=REDACTED", result["query"])
+	assert.Equal(t, "http://example.com/?token=REDACTED", result["referer"])
+	assert.Equal(t, "api.hello", result["service"])
+	assert.Equal(t, float64(200), result["status"])
+	assert.Equal(t, float64(5), result["bytes_out"])
+	assert.Equal(t, "req-1", result["request_id"])
+	assert.Equal(t, "backend:8080", result["upstream_host"])
+	assert.Contains(t, result, "duration_ms")
+	assert.Equal(t, map[string]interface{}{"Authorization": "REDACTED", "X-Custom": "custom"}, result["headers"])
+}
+
+func TestMiddleware_Stream(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := testServer(Middleware(Config{Output: buf}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello?stream=1", nil))
+
+	result := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, float64(8), result["bytes_out"])
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := testServer(Middleware(Config{Output: buf}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello?panic=1", nil))
+
+	result := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, float64(http.StatusInternalServerError), result["status"])
+}
+
+func TestMiddleware_Background(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := testServer(Middleware(Config{Output: buf}))
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(gateway.WithBackground(req.Context())))
+	assert.Empty(t, buf.String())
+}
+
+func TestMiddleware_Sampling(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := testServer(Middleware(Config{
+		Output: buf,
+		Format: TemplateFormat("{{.Status}}"),
+		Sampling: []SampleRule{
+			{MinStatus: 500, MaxStatus: 599, Rate: 1},
+			{MinStatus: 200, MaxStatus: 299, Rate: 0},
+		},
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello?fail=1", nil))
+	assert.Equal(t, "500\n", buf.String())
+}
+
+func TestMiddleware_Principal(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := testServer(Middleware(Config{Output: buf}), func(context *gateway.Context) {
+		context.SetPrincipal(&gateway.Principal{Subject: "service-a", Method: "mtls"})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.SetBasicAuth("unverified", "password")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	result := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, "service-a", result["user"])
+}
+
+func TestCombinedFormat(t *testing.T) {
+	data, err := CombinedFormat.Format(&Record{
+		Time:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		ClientIP:  "127.0.0.1",
+		User:      "frank",
+		Method:    http.MethodGet,
+		Path:      "/apache_pb.gif",
+		Query:     "a=1",
+		Proto:     "HTTP/1.0",
+		Status:    200,
+		BytesOut:  2326,
+		UserAgent: `Mozilla/4.08 "quoted"`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.0" 200 2326 "-" "Mozilla/4.08 \"quoted\""`+"\n", string(data))
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "access.log")
+
+	f := NewRotatingFile(filename, 10, 2)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, f.Close())
+
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(name)
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read(filename))
+	assert.Equal(t, "third\n", read(filename+".1"))
+	assert.Equal(t, "second\n", read(filename+".2"))
+	_, err = os.Stat(filename + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = f.Write([]byte("closed"))
+	assert.Error(t, err)
+	assert.False(t, strings.Contains(read(filename), "closed"))
+}
+
+func TestRotatingFile_RotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "access.log")
+	// the file cannot be renamed to a non-empty directory
+	assert.NoError(t, os.MkdirAll(filepath.Join(filename+".1", "foo"), 0755))
+
+	f := NewRotatingFile(filename, 10, 1)
+	defer f.Close()
+	assert.Error(t, f.Rotate())
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, err := f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\nthird\n", string(data))
+
+	// the file is rotated once it can be
+	assert.NoError(t, os.RemoveAll(filename+".1"))
+	_, err = f.Write([]byte("fourth\n"))
+	assert.NoError(t, err)
+	data, err = ioutil.ReadFile(filename + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\nthird\n", string(data))
+}
@@ -0,0 +1,136 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Formatter formats a Record as a line of access log.
+type Formatter interface {
+	// Format formats a Record. The result should end with a line feed.
+	Format(r *Record) ([]byte, error)
+}
+
+// FormatterFunc is a function that implements Formatter.
+type FormatterFunc func(r *Record) ([]byte, error)
+
+// Format implements Formatter.
+func (f FormatterFunc) Format(r *Record) ([]byte, error) {
+	return f(r)
+}
+
+// JSONFormat formats a Record as a line of JSON.
+var JSONFormat Formatter = FormatterFunc(func(r *Record) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+})
+
+// CombinedFormat formats a Record in the Apache Combined Log Format:
+//
+//	127.0.0.1 - user [10/Oct/2000:13:55:36 -0700] "GET /hello?a=1 HTTP/1.1" 200 2326 "referer" "user agent"
+var CombinedFormat Formatter = FormatterFunc(func(r *Record) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(dashIfEmpty(r.ClientIP))
+	buf.WriteString(" - ")
+	buf.WriteString(dashIfEmpty(r.User))
+	buf.WriteString(" [")
+	buf.WriteString(r.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	buf.WriteString(`] "`)
+	buf.WriteString(r.Method)
+	buf.WriteByte(' ')
+	buf.WriteString(r.Path)
+	if r.Query != "" {
+		buf.WriteByte('?')
+		buf.WriteString(r.Query)
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(r.Proto)
+	buf.WriteString(`" `)
+	buf.WriteString(strconv.Itoa(r.Status))
+	buf.WriteByte(' ')
+	if r.BytesOut > 0 {
+		buf.WriteString(strconv.FormatInt(r.BytesOut, 10))
+	} else {
+		buf.WriteByte('-')
+	}
+	buf.WriteString(` "`)
+	buf.WriteString(escapeQuoted(dashIfEmpty(r.Referer)))
+	buf.WriteString(`" "`)
+	buf.WriteString(escapeQuoted(dashIfEmpty(r.UserAgent)))
+	buf.WriteString("\"\n")
+	return buf.Bytes(), nil
+})
+
+// TemplateFormat creates a Formatter with a text/template executed against the Record.
+// A line feed is added if the result does not end with one. It panics if the template is invalid.
+//
+// For example:
+//
+//	accesslog.TemplateFormat(`{{.Time.Format "2006-01-02T15:04:05Z07:00"}} {{.Service}} {{.Status}} {{.Duration}}`)
+func TemplateFormat(text string) Formatter {
+	tmpl := template.Must(template.New("accesslog").Parse(text))
+	return FormatterFunc(func(r *Record) ([]byte, error) {
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, r); err != nil {
+			return nil, err
+		}
+		if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	})
+}
+
+// Record is a record of access log.
+type Record struct {
+	Time         time.Time         `json:"time"`
+	ClientIP     string            `json:"client_ip"`
+	User         string            `json:"user,omitempty"`
+	Method       string            `json:"method"`
+	Path         string            `json:"path"`
+	Query        string            `json:"query,omitempty"`
+	Proto        string            `json:"proto"`
+	Service      string            `json:"service"`
+	Status       int               `json:"status"`
+	BytesIn      int64             `json:"bytes_in"`
+	BytesOut     int64             `json:"bytes_out"`
+	Duration     time.Duration     `json:"-"`
+	UserAgent    string            `json:"user_agent,omitempty"`
+	Referer      string            `json:"referer,omitempty"`
+	RequestID    string            `json:"request_id,omitempty"`
+	UpstreamHost string            `json:"upstream_host,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, formatting the duration in milliseconds.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	type record Record
+	return json.Marshal(struct {
+		*record
+		DurationMS float64 `json:"duration_ms"`
+	}{(*record)(r), float64(r.Duration) / float64(time.Millisecond)})
+}
+
+// dashIfEmpty returns "-" for an empty string, as the Combined Log Format does.
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeQuoted escapes a string inside double quotes.
+func escapeQuoted(s string) string {
+	if !strings.ContainsAny(s, "\"\\\n\r") {
+		return s
+	}
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
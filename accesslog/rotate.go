@@ -0,0 +1,143 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer that writes to a file and rotates it when it exceeds the maximum size.
+//
+// When rotating, "access.log" is renamed to "access.log.1", "access.log.1" to "access.log.2" and so on,
+// and the oldest backup is removed if there are more than the maximum number of backups.
+type RotatingFile struct {
+	filename   string
+	maxSize    int64
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// NewRotatingFile opens a RotatingFile which rotates when the size in bytes exceeds maxSize,
+// keeping at most maxBackups rotated files. It panics if the file cannot be opened.
+func NewRotatingFile(filename string, maxSize int64, maxBackups int) *RotatingFile {
+	if maxSize <= 0 {
+		panic("invalid max size")
+	}
+	if maxBackups < 0 {
+		panic("invalid max backups")
+	}
+	r := &RotatingFile{filename: filename, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Write implements io.Writer. The file is rotated before writing if it would exceed the maximum size.
+// If the file cannot be rotated, it is written to without rotating, and rotating is retried on the next write.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		_ = r.rotate()
+	}
+	if r.file == nil {
+		// the file failed to be reopened
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file immediately.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// open opens the file for appending.
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the backups and opens a new file.
+// If the backups cannot be shifted, the current file is reopened.
+func (r *RotatingFile) rotate() error {
+	if r.closed {
+		return os.ErrClosed
+	}
+	if r.file != nil {
+		err := r.file.Close()
+		r.file = nil
+		if err != nil {
+			_ = r.open()
+			return err
+		}
+	}
+	if err := r.shift(); err != nil {
+		_ = r.open()
+		return err
+	}
+	return r.open()
+}
+
+// shift renames the file and the backups to the next backup names, removing the oldest backup.
+func (r *RotatingFile) shift() error {
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	_ = os.Remove(r.backupName(r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(r.backupName(i), r.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.filename, r.backupName(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// backupName returns the file name of the n-th backup.
+func (r *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", r.filename, n)
+}
@@ -0,0 +1,309 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultDrainTimeout is the timeout of a drain triggered from the admin listener without a timeout given.
+	defaultDrainTimeout = 30 * time.Second
+	// drainPollInterval is the interval of checking in-flight requests while draining.
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// ReadinessCheck checks if a dependency of the Server is ready to serve requests.
+// It returns a non-nil error if the dependency is not ready.
+type ReadinessCheck func() error
+
+// adminConfig is the configuration of the admin listener.
+type adminConfig struct {
+	// addr is the address of the admin listener. The admin listener is disabled if it is empty.
+	addr string
+	// handlers is a map of extra handlers on the admin listener, with pattern as the key.
+	handlers map[string]http.Handler
+	// readinessChecks is a map of readiness checks, with name as the key.
+	readinessChecks map[string]ReadinessCheck
+}
+
+// UseAdmin enables the admin listener on the given address, which runs alongside the main listener.
+// The admin listener serves:
+//
+// "/healthz" reports liveness of the Server.
+//
+// "/readyz" reports readiness of the Server. It fails during startup, while draining, or if any readiness check fails.
+//
+// "/drain" (POST only) stops reporting readiness and waits for in-flight requests to finish.
+// The optional query "timeout" (for example: "?timeout=10s") limits the time of waiting.
+//
+// "/routes" serves the route table. See RouteTableHandler.
+//
+// "/config" serves the current configurations of the Server.
+//
+// "/upgrade" (POST only) upgrades the Server if upgrades are enabled. See UseUpgrade.
+//
+// The admin listener should never be exposed on the public network.
+func (s *Server) UseAdmin(addr string) {
+	s.adminConfig.addr = addr
+}
+
+// HandleAdmin registers an extra handler on the admin listener. It overrides built-in handlers of the same pattern.
+func (s *Server) HandleAdmin(pattern string, handler http.Handler) {
+	if handler == nil {
+		panic("nil handler")
+	}
+	if s.adminConfig.handlers == nil {
+		s.adminConfig.handlers = map[string]http.Handler{}
+	}
+	s.adminConfig.handlers[pattern] = handler
+}
+
+// AddReadinessCheck adds a readiness check by name. The Server is not ready if any of the readiness checks fails.
+func (s *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	if check == nil {
+		panic("nil readiness check")
+	}
+	if s.adminConfig.readinessChecks == nil {
+		s.adminConfig.readinessChecks = map[string]ReadinessCheck{}
+	}
+	s.adminConfig.readinessChecks[name] = check
+}
+
+// IsReady checks if the Server is ready to serve requests.
+// The Server is not ready before it starts listening, while it is draining, or if any readiness check fails.
+func (s *Server) IsReady() bool {
+	ready, _ := s.checkReadiness()
+	return ready
+}
+
+// InFlight returns the number of requests being handled.
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// Drain stops reporting readiness and waits for in-flight requests to finish, until the context is done.
+// The Server still serves new requests while draining, so that the load balancer has time to stop sending them.
+func (s *Server) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.logger.WithField("in_flight", s.InFlight()).Info("draining server")
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			s.logger.WithField("in_flight", s.InFlight()).Warn("drain timeout")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s.logger.Info("server drained")
+	return nil
+}
+
+// checkReadiness checks readiness of the Server, and returns the result of every check with its name as the key.
+func (s *Server) checkReadiness() (bool, map[string]string) {
+	ready := true
+	result := map[string]string{}
+
+	if atomic.LoadInt32(&s.listening) == 0 {
+		ready = false
+		result["listener"] = "not listening"
+	} else {
+		result["listener"] = "ok"
+	}
+	if atomic.LoadInt32(&s.draining) != 0 {
+		ready = false
+		result["drain"] = "draining"
+	} else {
+		result["drain"] = "ok"
+	}
+	for name, check := range s.adminConfig.readinessChecks {
+		if err := check(); err != nil {
+			ready = false
+			result[name] = err.Error()
+		} else {
+			result[name] = "ok"
+		}
+	}
+	return ready, result
+}
+
+// prepareAdmin creates the admin http.Server, or returns nil if the admin listener is disabled.
+func (s *Server) prepareAdmin() *http.Server {
+	if s.adminConfig.addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	builtin := map[string]http.Handler{
+		"/healthz": http.HandlerFunc(s.serveHealthz),
+		"/readyz":  http.HandlerFunc(s.serveReadyz),
+		"/drain":   http.HandlerFunc(s.serveDrain),
+		"/routes":  s.RouteTableHandler(),
+		"/config":  http.HandlerFunc(s.serveConfig),
+	}
+	if s.upgrade != nil {
+		builtin["/upgrade"] = http.HandlerFunc(s.serveUpgrade)
+	}
+	for pattern, handler := range builtin {
+		if _, ok := s.adminConfig.handlers[pattern]; !ok {
+			mux.Handle(pattern, handler)
+		}
+	}
+	for pattern, handler := range s.adminConfig.handlers {
+		mux.Handle(pattern, handler)
+	}
+
+	return &http.Server{
+		Addr:              s.adminConfig.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+	}
+}
+
+// runAdmin starts the admin listener in background. It does nothing if the admin http.Server is nil.
+// If the admin listener cannot be created, the Server does not start: the main listeners which have been opened
+// are closed, and the error is returned.
+func (s *Server) runAdmin(svr *http.Server) error {
+	if svr == nil {
+		return nil
+	}
+	ln := takeInheritedAdmin()
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", svr.Addr); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.adminListener = ln
+	s.mu.Unlock()
+	go func() {
+		s.logger.WithField("addr", ln.Addr().String()).Info("start admin server")
+		if err := svr.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.logger.WithError(err).Error("admin server error")
+		}
+	}()
+	return nil
+}
+
+// serveHealthz serves the liveness endpoint.
+func (s *Server) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// serveReadyz serves the readiness endpoint.
+func (s *Server) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	ready, checks := s.checkReadiness()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeAdminJSON(w, status, map[string]interface{}{
+		"ready":  ready,
+		"checks": checks,
+	})
+}
+
+// serveDrain serves the drain trigger.
+func (s *Server) serveDrain(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout := defaultDrainTimeout
+	if t := req.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			writeAdminJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid timeout"})
+			return
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	if err := s.Drain(ctx); err != nil {
+		writeAdminJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"drained":   false,
+			"in_flight": s.InFlight(),
+			"error":     err.Error(),
+		})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"drained":   true,
+		"in_flight": s.InFlight(),
+	})
+}
+
+// adminEndpointConfig is an entry of Config in the config dump.
+type adminEndpointConfig struct {
+	Path    string `json:"path"`
+	Method  string `json:"method"`
+	Service string `json:"service"`
+}
+
+// serveConfig serves the config dump.
+func (s *Server) serveConfig(w http.ResponseWriter, req *http.Request) {
+	endpoints := make([]adminEndpointConfig, 0, len(s.config))
+	for endpoint, service := range s.config {
+		endpoints = append(endpoints, adminEndpointConfig{Path: endpoint.Path, Method: endpoint.Method, Service: service})
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+
+	services := make([]string, 0, len(s.service))
+	for name := range s.service {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+
+	middleware := make([]string, 0, len(s.middleware))
+	for _, m := range s.middleware {
+		middleware = append(middleware, handlerName(m))
+	}
+
+	errorHandlers := make([]int, 0, len(s.errorConfig))
+	for status := range s.errorConfig {
+		errorHandlers = append(errorHandlers, status)
+	}
+	sort.Ints(errorHandlers)
+
+	checks := make([]string, 0, len(s.adminConfig.readinessChecks))
+	for name := range s.adminConfig.readinessChecks {
+		checks = append(checks, name)
+	}
+	sort.Strings(checks)
+
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"endpoints":        endpoints,
+		"services":         services,
+		"middleware":       middleware,
+		"error_handlers":   errorHandlers,
+		"readiness_checks": checks,
+	})
+}
+
+// writeAdminJSON writes a JSON response on the admin listener.
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAdminServer() *Server {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.UseAdmin("127.0.0.1:0")
+	return s
+}
+
+func TestServer_PrepareAdmin(t *testing.T) {
+	s := Default()
+	assert.Nil(t, s.prepareAdmin())
+
+	s = testAdminServer()
+	s.HandleAdmin("/custom", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	admin := s.prepareAdmin()
+	assert.NotNil(t, admin)
+
+	w := httptest.NewRecorder()
+	admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/custom", nil))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+}
+
+func TestServer_Readyz(t *testing.T) {
+	s := testAdminServer()
+	var failing int32
+	s.AddReadinessCheck("db", func() error {
+		if atomic.LoadInt32(&failing) != 0 {
+			return errors.New("db down")
+		}
+		return nil
+	})
+	admin := s.prepareAdmin()
+
+	readyz := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		result := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return w.Code, result
+	}
+
+	// not listening yet
+	code, result := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, false, result["ready"])
+
+	atomic.StoreInt32(&s.listening, 1)
+	code, _ = readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, s.IsReady())
+
+	atomic.StoreInt32(&failing, 1)
+	code, result = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "db down", result["checks"].(map[string]interface{})["db"])
+}
+
+func TestServer_Drain(t *testing.T) {
+	s := testAdminServer()
+	atomic.StoreInt32(&s.listening, 1)
+	atomic.AddInt64(&s.inFlight, 1)
+
+	// timeout with in-flight requests
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Drain(ctx))
+	assert.False(t, s.IsReady())
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt64(&s.inFlight, -1)
+	}()
+	admin := s.prepareAdmin()
+	w := httptest.NewRecorder()
+	admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/drain?timeout=1s", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(0), s.InFlight())
+
+	w = httptest.NewRecorder()
+	admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/drain", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestServer_ServeConfig(t *testing.T) {
+	s := testRouteServer()
+	s.UseAdmin("127.0.0.1:0")
+	admin := s.prepareAdmin()
+
+	w := httptest.NewRecorder()
+	admin.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	result := struct {
+		Endpoints []adminEndpointConfig `json:"endpoints"`
+		Services  []string              `json:"services"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Len(t, result.Endpoints, 3)
+	assert.Equal(t, adminEndpointConfig{Path: "/foo", Method: http.MethodGet, Service: "foo"}, result.Endpoints[0])
+	assert.Equal(t, []string{"*", "api.gateway"}, result.Services)
+}
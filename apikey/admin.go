@@ -0,0 +1,193 @@
+package apikey
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// keyView is a key in responses of the admin API, without hashes.
+type keyView struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name,omitempty"`
+	Services          []string   `json:"services"`
+	DailyQuota        int64      `json:"daily_quota,omitempty"`
+	MonthlyQuota      int64      `json:"monthly_quota,omitempty"`
+	DailyUsage        int64      `json:"daily_usage"`
+	MonthlyUsage      int64      `json:"monthly_usage"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+	Revoked           bool       `json:"revoked"`
+	Active            bool       `json:"active"`
+	// Key is the API key, which is only returned when it is created or rotated.
+	Key string `json:"key,omitempty"`
+}
+
+// createRequest is the request body of creating a key.
+type createRequest struct {
+	Name         string    `json:"name"`
+	Services     []string  `json:"services"`
+	DailyQuota   int64     `json:"daily_quota"`
+	MonthlyQuota int64     `json:"monthly_quota"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// AdminHandler provides the admin API of keys in the store, which is meant to be registered on the admin listener
+// with its prefix stripped:
+//
+//	s.HandleAdmin("/apikeys/", http.StripPrefix("/apikeys", apikey.AdminHandler(store)))
+//
+// The API has the following endpoints, where responses never include hashes, and the API key is only included
+// when it is created or rotated:
+//
+//	GET  /                 lists keys with their usage
+//	POST /                 creates a key with a JSON body of name, services, daily_quota, monthly_quota and expires_at
+//	GET  /{id}             gets a key with its usage
+//	POST /{id}/rotate      rotates a key; the old API key is valid during the optional grace period (?grace=1h)
+//	POST /{id}/revoke      revokes a key
+func AdminHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case parts[0] == "" && r.Method == http.MethodGet:
+			listKeys(w, store)
+		case parts[0] == "" && r.Method == http.MethodPost:
+			createKey(w, r, store)
+		case parts[0] == "":
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			key, err := store.Get(parts[0])
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, view(store, key, ""))
+		case len(parts) == 2 && parts[1] == "rotate" && r.Method == http.MethodPost:
+			var grace time.Duration
+			if g := r.URL.Query().Get("grace"); g != "" {
+				var err error
+				if grace, err = time.ParseDuration(g); err != nil || grace < 0 {
+					writeError(w, http.StatusBadRequest, "invalid grace period")
+					return
+				}
+			}
+			apiKey, key, err := Rotate(store, parts[0], grace)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, view(store, key, apiKey))
+		case len(parts) == 2 && parts[1] == "revoke" && r.Method == http.MethodPost:
+			if err := Revoke(store, parts[0]); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			key, err := store.Get(parts[0])
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, view(store, key, ""))
+		case len(parts) <= 2:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+// listKeys writes all keys.
+func listKeys(w http.ResponseWriter, store Store) {
+	keys, err := store.List()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	views := make([]keyView, 0, len(keys))
+	for _, key := range keys {
+		views = append(views, view(store, key, ""))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// createKey creates a key from the request body.
+func createKey(w http.ResponseWriter, r *http.Request, store Store) {
+	var req createRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Services) == 0 {
+		writeError(w, http.StatusBadRequest, "services required")
+		return
+	}
+	if req.DailyQuota < 0 || req.MonthlyQuota < 0 {
+		writeError(w, http.StatusBadRequest, "invalid quota")
+		return
+	}
+	apiKey, key, err := Create(store, Key{
+		Name:         req.Name,
+		Services:     req.Services,
+		DailyQuota:   req.DailyQuota,
+		MonthlyQuota: req.MonthlyQuota,
+		ExpiresAt:    req.ExpiresAt,
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, view(store, key, apiKey))
+}
+
+// view converts a key to its view with the current usage.
+func view(store Store, key *Key, apiKey string) keyView {
+	now := time.Now()
+	daily, _ := store.Usage(key.ID, dailyPeriod(now))
+	monthly, _ := store.Usage(key.ID, monthlyPeriod(now))
+	v := keyView{
+		ID:           key.ID,
+		Name:         key.Name,
+		Services:     key.Services,
+		DailyQuota:   key.DailyQuota,
+		MonthlyQuota: key.MonthlyQuota,
+		DailyUsage:   daily,
+		MonthlyUsage: monthly,
+		CreatedAt:    key.CreatedAt,
+		Revoked:      key.Revoked,
+		Active:       key.IsActive(now),
+		Key:          apiKey,
+	}
+	if !key.ExpiresAt.IsZero() {
+		v.ExpiresAt = &key.ExpiresAt
+	}
+	if key.PreviousHash != "" && now.Before(key.PreviousExpiresAt) {
+		v.PreviousExpiresAt = &key.PreviousExpiresAt
+	}
+	return v
+}
+
+// writeStoreError writes an error of the store.
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// writeError writes an error in JSON.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeJSON writes the value in JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
@@ -0,0 +1,78 @@
+package apikey
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(handler http.Handler, method string, path string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var result map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &result)
+	return w, result
+}
+
+func TestAdminHandler(t *testing.T) {
+	store := NewMemoryStore()
+	admin := http.StripPrefix("/apikeys", AdminHandler(store))
+	handler := testServer(Config{Store: store})
+
+	w, created := adminRequest(admin, http.MethodPost, "/apikeys/",
+		`{"name":"partner","services":["api"],"daily_quota":100,"expires_at":"2100-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	apiKey := created["key"].(string)
+	id := created["id"].(string)
+	assert.Equal(t, "partner", created["name"])
+	assert.Equal(t, "2100-01-01T00:00:00Z", created["expires_at"])
+	assert.NotContains(t, w.Body.String(), "hash")
+	assert.Equal(t, http.StatusOK, request(handler, "/foo", apiKey).Code)
+
+	w, got := adminRequest(admin, http.MethodGet, "/apikeys/"+id, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, got["key"])
+	assert.Equal(t, float64(1), got["daily_usage"])
+	assert.Equal(t, true, got["active"])
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/apikeys/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+
+	w, rotated := adminRequest(admin, http.MethodPost, "/apikeys/"+id+"/rotate?grace=1h", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	newKey := rotated["key"].(string)
+	assert.NotEqual(t, apiKey, newKey)
+	assert.NotNil(t, rotated["previous_expires_at"])
+	assert.Equal(t, http.StatusOK, request(handler, "/foo", apiKey).Code)
+	assert.Equal(t, http.StatusOK, request(handler, "/foo", newKey).Code)
+
+	w, revoked := adminRequest(admin, http.MethodPost, "/apikeys/"+id+"/revoke", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, revoked["revoked"])
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/foo", newKey).Code)
+
+	w, _ = adminRequest(admin, http.MethodPost, "/apikeys/", `{"name":"no services"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = adminRequest(admin, http.MethodPost, "/apikeys/", `invalid`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = adminRequest(admin, http.MethodPost, "/apikeys/"+id+"/rotate?grace=invalid", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = adminRequest(admin, http.MethodGet, "/apikeys/missing", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = adminRequest(admin, http.MethodDelete, "/apikeys/", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = adminRequest(admin, http.MethodGet, "/apikeys/"+id+"/revoke", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = adminRequest(admin, http.MethodGet, "/apikeys/a/b/c", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,163 @@
+// Package apikey provides API keys with per-key Service scoping, daily and monthly quotas, expiry and revocation,
+// a middleware that authenticates requests with them, and an admin API to manage them.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// keyPrefix is the prefix of generated API keys, which makes them easy to recognize, for example, by secret scanners.
+const keyPrefix = "gwk_"
+
+// ErrInvalidKey is the error of an API key which is unknown, revoked or expired.
+var ErrInvalidKey = errors.New("apikey: invalid key")
+
+// Key is an API key. Only the hash of the key is stored.
+type Key struct {
+	// ID is the unique ID of the key, which is not secret.
+	ID string `json:"id"`
+	// Name is a description of the key, such as the name of the partner.
+	Name string `json:"name,omitempty"`
+	// Hash is the hex-encoded SHA-256 hash of the key.
+	Hash string `json:"hash"`
+	// PreviousHash is the hash of the key before rotation, which is valid until PreviousExpiresAt.
+	PreviousHash string `json:"previous_hash,omitempty"`
+	// PreviousExpiresAt is when the key before rotation expires.
+	PreviousExpiresAt time.Time `json:"previous_expires_at,omitempty"`
+	// Services is a list of Service names which the key can call. A name allows the Service and all Service under it,
+	// in the same way that Service handlers are matched: "api" allows "api" and "api.foo". An asterisk (*) allows all.
+	Services []string `json:"services"`
+	// DailyQuota is the maximum number of requests per day in UTC. No limit if it is zero.
+	DailyQuota int64 `json:"daily_quota,omitempty"`
+	// MonthlyQuota is the maximum number of requests per month in UTC. No limit if it is zero.
+	MonthlyQuota int64 `json:"monthly_quota,omitempty"`
+	// CreatedAt is when the key is created.
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is when the key expires. The key never expires if it is zero.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// Revoked is whether the key is revoked.
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// clone creates a copy of the key.
+func (k *Key) clone() *Key {
+	c := *k
+	c.Services = append([]string(nil), k.Services...)
+	return &c
+}
+
+// IsActive checks if the key is not revoked and not expired at the time.
+func (k *Key) IsActive(now time.Time) bool {
+	return !k.Revoked && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// Allows checks if the key can call the Service.
+func (k *Key) Allows(service string) bool {
+	for _, name := range gateway.ServiceHierarchy(service) {
+		for _, allowed := range k.Services {
+			if allowed == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Hash hashes an API key.
+func Hash(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// generate generates a random API key and its ID.
+func generate() (id string, apiKey string, err error) {
+	buf := make([]byte, 40)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(buf[:8]), keyPrefix + base64.RawURLEncoding.EncodeToString(buf[8:]), nil
+}
+
+// Create creates a key from the template, with a new ID and a new API key, and saves it to the store.
+// The API key is returned only once, since only its hash is kept.
+func Create(store Store, template Key) (string, *Key, error) {
+	id, apiKey, err := generate()
+	if err != nil {
+		return "", nil, err
+	}
+	key := template.clone()
+	key.ID = id
+	key.Hash = Hash(apiKey)
+	key.PreviousHash, key.PreviousExpiresAt = "", time.Time{}
+	key.CreatedAt = time.Now().UTC()
+	key.Revoked = false
+	if err := store.Save(key); err != nil {
+		return "", nil, err
+	}
+	return apiKey, key, nil
+}
+
+// Rotate generates a new API key for the key of the ID. The old API key is still valid during the grace period,
+// so that clients can switch to the new one. It is invalid immediately if grace is zero.
+func Rotate(store Store, id string, grace time.Duration) (string, *Key, error) {
+	key, err := store.Get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	_, apiKey, err := generate()
+	if err != nil {
+		return "", nil, err
+	}
+	key.PreviousHash, key.PreviousExpiresAt = "", time.Time{}
+	if grace > 0 {
+		key.PreviousHash, key.PreviousExpiresAt = key.Hash, time.Now().Add(grace).UTC()
+	}
+	key.Hash = Hash(apiKey)
+	if err := store.Save(key); err != nil {
+		return "", nil, err
+	}
+	return apiKey, key, nil
+}
+
+// Revoke revokes the key of the ID at runtime.
+func Revoke(store Store, id string) error {
+	key, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	key.Revoked = true
+	return store.Save(key)
+}
+
+// Lookup finds the active key of the API key.
+func Lookup(store Store, apiKey string, now time.Time) (*Key, error) {
+	hash := Hash(apiKey)
+	key, err := store.FindByHash(hash)
+	if err == ErrNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !key.IsActive(now) || (hash == key.PreviousHash && !now.Before(key.PreviousExpiresAt)) {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// dailyPeriod is the name of the usage period of the day.
+func dailyPeriod(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// monthlyPeriod is the name of the usage period of the month.
+func monthlyPeriod(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
@@ -0,0 +1,168 @@
+package apikey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreate(t *testing.T) {
+	store := NewMemoryStore()
+	apiKey, key, err := Create(store, Key{Name: "partner", Services: []string{"api"}, Revoked: true})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(apiKey, keyPrefix))
+	assert.Equal(t, Hash(apiKey), key.Hash)
+	assert.False(t, key.Revoked)
+	assert.False(t, key.CreatedAt.IsZero())
+	assert.NotContains(t, key.Hash, apiKey)
+
+	found, err := Lookup(store, apiKey, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+	assert.Equal(t, "partner", found.Name)
+
+	_, err = Lookup(store, "unknown", time.Now())
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func TestRotate(t *testing.T) {
+	store := NewMemoryStore()
+	oldKey, key, err := Create(store, Key{Services: []string{"*"}})
+	assert.NoError(t, err)
+
+	newKey, _, err := Rotate(store, key.ID, time.Hour)
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldKey, newKey)
+	now := time.Now()
+	_, err = Lookup(store, newKey, now)
+	assert.NoError(t, err)
+	_, err = Lookup(store, oldKey, now)
+	assert.NoError(t, err)
+	_, err = Lookup(store, oldKey, now.Add(2*time.Hour))
+	assert.Equal(t, ErrInvalidKey, err)
+
+	// rotating again without grace invalidates both
+	latest, _, err := Rotate(store, key.ID, 0)
+	assert.NoError(t, err)
+	for _, k := range []string{oldKey, newKey} {
+		_, err = Lookup(store, k, now)
+		assert.Equal(t, ErrInvalidKey, err)
+	}
+	_, err = Lookup(store, latest, now)
+	assert.NoError(t, err)
+
+	_, _, err = Rotate(store, "missing", 0)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestRevokeAndExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	apiKey, key, err := Create(store, Key{Services: []string{"*"}, ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+
+	_, err = Lookup(store, apiKey, now)
+	assert.NoError(t, err)
+	_, err = Lookup(store, apiKey, now.Add(time.Hour))
+	assert.Equal(t, ErrInvalidKey, err)
+
+	assert.NoError(t, Revoke(store, key.ID))
+	_, err = Lookup(store, apiKey, now)
+	assert.Equal(t, ErrInvalidKey, err)
+	assert.Equal(t, ErrNotFound, Revoke(store, "missing"))
+}
+
+func TestKey_Allows(t *testing.T) {
+	key := &Key{Services: []string{"api.foo", "public"}}
+	assert.True(t, key.Allows("api.foo"))
+	assert.True(t, key.Allows("api.foo.bar"))
+	assert.True(t, key.Allows("public"))
+	assert.False(t, key.Allows("api"))
+	assert.False(t, key.Allows("api.foobar"))
+	assert.False(t, (&Key{}).Allows("api"))
+	assert.True(t, (&Key{Services: []string{"*"}}).Allows("anything.at.all"))
+}
+
+func TestMemoryStore_Usage(t *testing.T) {
+	store := NewMemoryStore()
+	_, key, err := Create(store, Key{Services: []string{"*"}})
+	assert.NoError(t, err)
+
+	n, err := store.AddUsage(key.ID, "2020-01-01", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	_, _ = store.AddUsage(key.ID, "2020-01", 1)
+	n, _ = store.AddUsage(key.ID, "2020-01-01", 2)
+	assert.Equal(t, int64(3), n)
+
+	// a new day drops the previous day, but not the month
+	n, _ = store.AddUsage(key.ID, "2020-01-02", 1)
+	assert.Equal(t, int64(1), n)
+	n, _ = store.Usage(key.ID, "2020-01-01")
+	assert.Equal(t, int64(0), n)
+	n, _ = store.Usage(key.ID, "2020-01")
+	assert.Equal(t, int64(1), n)
+
+	_, err = store.AddUsage("missing", "2020-01-01", 1)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.Usage("missing", "2020-01-01")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStore_Copies(t *testing.T) {
+	store := NewMemoryStore()
+	_, key, err := Create(store, Key{Services: []string{"api"}})
+	assert.NoError(t, err)
+
+	got, err := store.Get(key.ID)
+	assert.NoError(t, err)
+	got.Services[0] = "admin"
+	got.Revoked = true
+	got, _ = store.Get(key.ID)
+	assert.Equal(t, []string{"api"}, got.Services)
+	assert.False(t, got.Revoked)
+
+	keys, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "keys.json")
+
+	store := NewFileStore(filename)
+	apiKey, key, err := Create(store, Key{Name: "partner", Services: []string{"api"}, DailyQuota: 10})
+	assert.NoError(t, err)
+	_, err = store.AddUsage(key.ID, dailyPeriod(time.Now()), 3)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Flush())
+
+	data, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), apiKey)
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reloaded := NewFileStore(filename)
+	found, err := Lookup(reloaded, apiKey, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "partner", found.Name)
+	assert.Equal(t, int64(10), found.DailyQuota)
+	n, err := reloaded.Usage(key.ID, dailyPeriod(time.Now()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("invalid"), 0600))
+	assert.Panics(t, func() {
+		NewFileStore(filename)
+	})
+}
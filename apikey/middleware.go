@@ -0,0 +1,131 @@
+package apikey
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// KeyDataKey is the key of the authenticated *Key in Context.Data.
+	KeyDataKey = "apikey.key"
+	// DefaultHeader is the default header with the API key.
+	DefaultHeader = "X-API-Key"
+)
+
+// Config is the configuration of the API key middleware.
+type Config struct {
+	// Store keeps keys. It is required.
+	Store Store
+	// Header is the header with the API key. It is "X-API-Key" if empty.
+	Header string
+	// Query is the query parameter with the API key, which is used if the header does not have one.
+	// The query is not used if it is empty. Consider redacting it from access logs.
+	Query string
+	// Optional allows requests without an API key, for example, to be authenticated by other middleware.
+	// Requests with an invalid API key are always rejected.
+	Optional bool
+}
+
+// Middleware provides a middleware that authenticates requests with API keys, puts the key in Context.Data
+// with KeyDataKey, and sets the Principal of the Context with the key ID as the subject.
+//
+// Requests with a missing, unknown, revoked or expired API key get "401 Unauthorized", requests to Service
+// not allowed by the key get "403 Forbidden", and requests beyond the daily or monthly quota get
+// "429 Too Many Requests" with a Retry-After header, all from the error handler of the Server.
+// Only allowed requests count towards the quotas.
+func Middleware(config Config) gateway.Handler {
+	if config.Store == nil {
+		panic("no store for API keys")
+	}
+	if config.Header == "" {
+		config.Header = DefaultHeader
+	}
+
+	return func(context *gateway.Context) {
+		apiKey := context.Request.Header.Get(config.Header)
+		if apiKey == "" && config.Query != "" {
+			apiKey = context.Request.URL.Query().Get(config.Query)
+		}
+		if apiKey == "" {
+			if !config.Optional {
+				context.AbortWithStatus(http.StatusUnauthorized)
+			}
+			return
+		}
+
+		now := time.Now()
+		key, err := Lookup(config.Store, apiKey, now)
+		if err == ErrInvalidKey {
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			context.Logger.WithError(err).Error("failed to look up API key")
+			context.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		logger := context.Logger.WithField("api_key_id", key.ID)
+		if !key.Allows(context.GetConfiguredServiceName()) {
+			logger.Info("service not allowed for API key")
+			context.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		if retryAfter, err := takeQuota(config.Store, key, now); err != nil {
+			logger.WithError(err).Error("failed to count API key usage")
+			context.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		} else if retryAfter > 0 {
+			logger.Info("API key quota exceeded")
+			context.Header.Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+			context.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+
+		context.Data[KeyDataKey] = key
+		context.SetPrincipal(&gateway.Principal{
+			Subject:    key.ID,
+			Method:     "apikey",
+			Attributes: map[string]interface{}{"name": key.Name},
+		})
+	}
+}
+
+// GetKey gets the authenticated key of the request. It returns nil if the request does not have a valid API key.
+func GetKey(context *gateway.Context) *Key {
+	key, _ := context.Data[KeyDataKey].(*Key)
+	return key
+}
+
+// takeQuota counts a request towards the quotas of the key. If a quota is exceeded, the request is not counted,
+// and the time until the quota is reset is returned.
+func takeQuota(store Store, key *Key, now time.Time) (time.Duration, error) {
+	day, month := dailyPeriod(now), monthlyPeriod(now)
+	utc := now.UTC()
+	dayEnd := time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+	monthEnd := time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+	daily, err := store.AddUsage(key.ID, day, 1)
+	if err != nil {
+		return 0, err
+	}
+	if key.DailyQuota > 0 && daily > key.DailyQuota {
+		_, err = store.AddUsage(key.ID, day, -1)
+		return dayEnd.Sub(now), err
+	}
+	monthly, err := store.AddUsage(key.ID, month, 1)
+	if err != nil {
+		return 0, err
+	}
+	if key.MonthlyQuota > 0 && monthly > key.MonthlyQuota {
+		_, err = store.AddUsage(key.ID, day, -1)
+		if err == nil {
+			_, err = store.AddUsage(key.ID, month, -1)
+		}
+		return monthEnd.Sub(now), err
+	}
+	return 0, nil
+}
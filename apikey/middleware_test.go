@@ -0,0 +1,108 @@
+package apikey
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(config Config) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", http.MethodGet, "api.foo")
+	cfg.Add("/admin", http.MethodGet, "admin.users")
+	s.UseConfig(cfg)
+	s.UseMiddleware(Middleware(config))
+	s.Register("*", func(context *gateway.Context) {
+		if key := GetKey(context); key != nil {
+			context.Response = []byte("hello " + key.Name)
+			if p := context.GetPrincipal(); p == nil || p.Subject != key.ID || p.Method != "apikey" {
+				context.Response = []byte("invalid principal")
+			}
+		} else {
+			context.Response = []byte("hello anonymous")
+		}
+	})
+	return s.Handler()
+}
+
+func request(handler http.Handler, path string, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if apiKey != "" {
+		req.Header.Set(DefaultHeader, apiKey)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	apiKey, key, err := Create(store, Key{Name: "partner", Services: []string{"api"}})
+	assert.NoError(t, err)
+	handler := testServer(Config{Store: store, Query: "api_key"})
+
+	w := request(handler, "/foo", apiKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello partner", w.Body.String())
+
+	w = request(handler, "/foo?api_key="+apiKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusForbidden, request(handler, "/admin", apiKey).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/foo", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/foo", "gwk_unknown").Code)
+
+	assert.NoError(t, Revoke(store, key.ID))
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/foo", apiKey).Code)
+}
+
+func TestMiddleware_Optional(t *testing.T) {
+	handler := testServer(Config{Store: NewMemoryStore(), Optional: true})
+	w := request(handler, "/foo", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello anonymous", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/foo", "gwk_unknown").Code)
+}
+
+func TestMiddleware_Quota(t *testing.T) {
+	store := NewMemoryStore()
+	apiKey, key, err := Create(store, Key{Services: []string{"*"}, DailyQuota: 2, MonthlyQuota: 3})
+	assert.NoError(t, err)
+	handler := testServer(Config{Store: store})
+
+	assert.Equal(t, http.StatusOK, request(handler, "/foo", apiKey).Code)
+	assert.Equal(t, http.StatusOK, request(handler, "/foo", apiKey).Code)
+	w := request(handler, "/foo", apiKey)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.True(t, retryAfter > 0 && retryAfter <= 86400)
+
+	// rejected requests are not counted
+	now := time.Now()
+	n, _ := store.Usage(key.ID, dailyPeriod(now))
+	assert.Equal(t, int64(2), n)
+
+	// a new day, but the monthly quota is reached after one more request
+	store.usage[key.ID] = map[string]int64{monthlyPeriod(now): 3}
+	w = request(handler, "/foo", apiKey)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	n, _ = store.Usage(key.ID, dailyPeriod(now))
+	assert.Equal(t, int64(0), n)
+	n, _ = store.Usage(key.ID, monthlyPeriod(now))
+	assert.Equal(t, int64(3), n)
+}
+
+func TestMiddleware_NoStore(t *testing.T) {
+	assert.Panics(t, func() {
+		Middleware(Config{})
+	})
+}
@@ -0,0 +1,231 @@
+package apikey
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound is the error of a key which does not exist.
+var ErrNotFound = errors.New("apikey: key not found")
+
+// Store keeps keys and their usage counters. It should be safe for concurrent use.
+// Keys returned by a Store are copies, which can be modified and saved with Save.
+type Store interface {
+	// Get gets the key of the ID.
+	Get(id string) (*Key, error)
+	// FindByHash gets the key with the hash, either the current one or the previous one during rotation.
+	FindByHash(hash string) (*Key, error)
+	// List lists all keys, sorted by ID.
+	List() ([]*Key, error)
+	// Save creates or updates a key by its ID.
+	Save(key *Key) error
+	// AddUsage adds delta to the usage counter of the key in the period, and returns the new value.
+	AddUsage(id string, period string, delta int64) (int64, error)
+	// Usage gets the usage counter of the key in the period.
+	Usage(id string, period string) (int64, error)
+}
+
+// MemoryStore is a Store in memory.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	hashes map[string]string
+	usage  map[string]map[string]int64
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: map[string]*Key{}, hashes: map[string]string{}, usage: map[string]map[string]int64{}}
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(id string) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return key.clone(), nil
+}
+
+// FindByHash implements Store.
+func (m *MemoryStore) FindByHash(hash string) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	id, ok := m.hashes[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.keys[id].clone(), nil
+}
+
+// List implements Store.
+func (m *MemoryStore) List() ([]*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]*Key, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key.clone())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(key *Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.save(key)
+	return nil
+}
+
+// save saves the key and indexes its hashes. The lock must be held.
+func (m *MemoryStore) save(key *Key) {
+	if old, ok := m.keys[key.ID]; ok {
+		delete(m.hashes, old.Hash)
+		delete(m.hashes, old.PreviousHash)
+	}
+	key = key.clone()
+	m.keys[key.ID] = key
+	m.hashes[key.Hash] = key.ID
+	if key.PreviousHash != "" {
+		m.hashes[key.PreviousHash] = key.ID
+	}
+}
+
+// AddUsage implements Store. Counters of other periods of the same kind (daily or monthly) are dropped.
+func (m *MemoryStore) AddUsage(id string, period string, delta int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[id]; !ok {
+		return 0, ErrNotFound
+	}
+	usage, ok := m.usage[id]
+	if !ok {
+		usage = map[string]int64{}
+		m.usage[id] = usage
+	}
+	if _, ok := usage[period]; !ok {
+		for p := range usage {
+			if len(p) == len(period) {
+				delete(usage, p)
+			}
+		}
+	}
+	usage[period] += delta
+	return usage[period], nil
+}
+
+// Usage implements Store.
+func (m *MemoryStore) Usage(id string, period string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.keys[id]; !ok {
+		return 0, ErrNotFound
+	}
+	return m.usage[id][period], nil
+}
+
+// fileData is the content of the file of FileStore.
+type fileData struct {
+	Keys  []*Key                      `json:"keys"`
+	Usage map[string]map[string]int64 `json:"usage,omitempty"`
+}
+
+// FileStore is a Store in memory backed by a JSON file.
+//
+// The file is written whenever a key is saved. Usage counters are written with keys and by Flush,
+// so call Flush periodically and before the Server stops to keep them across restarts.
+type FileStore struct {
+	*MemoryStore
+	filename string
+	fileMu   sync.Mutex
+}
+
+// NewFileStore creates a FileStore with keys loaded from the file. The file is created when a key is saved
+// if it does not exist. It panics if the file cannot be loaded.
+func NewFileStore(filename string) *FileStore {
+	f := &FileStore{MemoryStore: NewMemoryStore(), filename: filename}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return f
+	}
+	if err != nil {
+		panic(err)
+	}
+	var content fileData
+	if err := json.Unmarshal(data, &content); err != nil {
+		panic(err)
+	}
+	for _, key := range content.Keys {
+		f.save(key)
+	}
+	for id, usage := range content.Usage {
+		if _, ok := f.keys[id]; ok {
+			f.usage[id] = usage
+		}
+	}
+	return f
+}
+
+// Save implements Store.
+func (f *FileStore) Save(key *Key) error {
+	if err := f.MemoryStore.Save(key); err != nil {
+		return err
+	}
+	return f.Flush()
+}
+
+// Flush writes all keys and usage counters to the file, replacing it atomically.
+func (f *FileStore) Flush() error {
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	f.mu.RLock()
+	content := fileData{Keys: make([]*Key, 0, len(f.keys)), Usage: map[string]map[string]int64{}}
+	for _, key := range f.keys {
+		content.Keys = append(content.Keys, key.clone())
+	}
+	for id, usage := range f.usage {
+		copied := make(map[string]int64, len(usage))
+		for p, n := range usage {
+			copied[p] = n
+		}
+		content.Usage[id] = copied
+	}
+	f.mu.RUnlock()
+	sort.Slice(content.Keys, func(i, j int) bool {
+		return content.Keys[i].ID < content.Keys[j].ID
+	})
+
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.filename), filepath.Base(f.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
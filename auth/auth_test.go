@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+)
+
+func testServer(auth gateway.Handler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	cfg.Add("/hello", http.MethodPost, "api.hello")
+	s.UseConfig(cfg)
+	s.UseMiddleware(auth)
+	s.Register("*", func(context *gateway.Context) {
+		if p := context.GetPrincipal(); p != nil {
+			context.Response = []byte(p.Method + ":" + p.Subject)
+		} else {
+			context.Response = []byte("anonymous")
+		}
+	})
+	return s.Handler()
+}
+
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,175 @@
+// Package auth provides middleware that authenticate requests with basic auth, HMAC signatures
+// or client certificates, and set the Principal of the gateway.Context.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// Authenticator verifies user names and passwords. It should be safe for concurrent use.
+type Authenticator interface {
+	// Authenticate checks if the password of the user is correct.
+	Authenticate(user string, password string) bool
+}
+
+// AuthenticatorFunc is a function that implements Authenticator.
+type AuthenticatorFunc func(user string, password string) bool
+
+// Authenticate implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(user string, password string) bool {
+	return f(user, password)
+}
+
+// BcryptFunc compares a bcrypt hash with a password, returning nil if they match,
+// such as bcrypt.CompareHashAndPassword of golang.org/x/crypto/bcrypt.
+type BcryptFunc func(hash []byte, password []byte) error
+
+// errNoBcrypt is the error of verifying a bcrypt hash without a BcryptFunc.
+var errNoBcrypt = errors.New("no bcrypt function")
+
+// Htpasswd is an Authenticator of users in an htpasswd file.
+//
+// Passwords hashed with bcrypt ("$2y$", "$2a$" or "$2b$") are verified with the BcryptFunc, so that the module
+// does not depend on a third-party bcrypt implementation, for example:
+//
+//	auth.NewHtpasswdFile("/etc/gateway/htpasswd", bcrypt.CompareHashAndPassword)
+//
+// SHA-1 hashes ("{SHA}") are supported for legacy files only. Other formats are rejected.
+type Htpasswd struct {
+	filename string
+	bcrypt   BcryptFunc
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// NewHtpasswdFile creates an Htpasswd with users loaded from the file. It panics if the file cannot be loaded.
+func NewHtpasswdFile(filename string, bcrypt BcryptFunc) *Htpasswd {
+	h := &Htpasswd{filename: filename, bcrypt: bcrypt}
+	if err := h.Reload(); err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// NewHtpasswd creates an Htpasswd of the content of an htpasswd file. It panics if the content is invalid.
+func NewHtpasswd(content []byte, bcrypt BcryptFunc) *Htpasswd {
+	users, err := parseHtpasswd(content)
+	if err != nil {
+		panic(err)
+	}
+	return &Htpasswd{bcrypt: bcrypt, users: users}
+}
+
+// Reload loads the users from the file again, for example, after the file is changed.
+// The users are kept if it fails.
+func (h *Htpasswd) Reload() error {
+	if h.filename == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(h.filename)
+	if err != nil {
+		return err
+	}
+	users, err := parseHtpasswd(content)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.users = users
+	h.mu.Unlock()
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (h *Htpasswd) Authenticate(user string, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[user]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	return verifyHtpasswd(hash, password, h.bcrypt) == nil
+}
+
+// parseHtpasswd parses the content of an htpasswd file into a map of users to hashes.
+func parseHtpasswd(content []byte) (map[string]string, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.IndexByte(line, ':')
+		if idx <= 0 {
+			return nil, errors.New("invalid htpasswd line")
+		}
+		users[line[:idx]] = line[idx+1:]
+	}
+	return users, scanner.Err()
+}
+
+// verifyHtpasswd verifies a password with a hash of an htpasswd file.
+func verifyHtpasswd(hash string, password string, bcrypt BcryptFunc) error {
+	switch {
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$"):
+		if bcrypt == nil {
+			return errNoBcrypt
+		}
+		return bcrypt([]byte(hash), []byte(password))
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) != 1 {
+			return errors.New("password mismatch")
+		}
+		return nil
+	}
+	return errors.New("unsupported hash")
+}
+
+// BasicConfig is the configuration of the basic auth middleware.
+type BasicConfig struct {
+	// Authenticator verifies user names and passwords, such as Htpasswd. It is required.
+	Authenticator Authenticator
+	// Realm is the realm in the WWW-Authenticate header. It is "Restricted" if empty.
+	Realm string
+}
+
+// Basic provides a middleware that authenticates requests with basic auth (RFC 7617),
+// and sets the Principal of the Context with the user name as the subject.
+// Requests without valid credentials get "401 Unauthorized" from the error handler of the Server.
+func Basic(config BasicConfig) gateway.Handler {
+	if config.Authenticator == nil {
+		panic("no authenticator for basic auth")
+	}
+	if config.Realm == "" {
+		config.Realm = "Restricted"
+	}
+	challenge := `Basic realm="` + strings.Replace(config.Realm, `"`, `\"`, -1) + `", charset="UTF-8"`
+
+	return func(context *gateway.Context) {
+		user, password, ok := context.Request.BasicAuth()
+		if !ok || !config.Authenticator.Authenticate(user, password) {
+			if ok {
+				context.Logger.WithField("user", user).Info("basic auth failed")
+			}
+			context.Header.Set("WWW-Authenticate", challenge)
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		context.SetPrincipal(&gateway.Principal{Subject: user, Method: "basic"})
+	}
+}
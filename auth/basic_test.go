@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBcrypt accepts hashes in the form of "$2y$10$<password>", standing in for a real bcrypt implementation.
+func fakeBcrypt(hash []byte, password []byte) error {
+	if string(hash) == "$2y$10$"+string(password) {
+		return nil
+	}
+	return errors.New("mismatch")
+}
+
+const testHtpasswd = `# users
+alice:$2y$10$wonderland
+bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+carol:$apr1$unsupported
+`
+
+func TestHtpasswd(t *testing.T) {
+	h := NewHtpasswd([]byte(testHtpasswd), fakeBcrypt)
+	assert.True(t, h.Authenticate("alice", "wonderland"))
+	assert.False(t, h.Authenticate("alice", "wrong"))
+	assert.True(t, h.Authenticate("bob", "password"))
+	assert.False(t, h.Authenticate("bob", "wrong"))
+	assert.False(t, h.Authenticate("carol", "unsupported"))
+	assert.False(t, h.Authenticate("dave", ""))
+
+	// bcrypt hashes cannot be verified without a BcryptFunc
+	assert.False(t, NewHtpasswd([]byte(testHtpasswd), nil).Authenticate("alice", "wonderland"))
+
+	assert.Panics(t, func() {
+		NewHtpasswd([]byte("invalid line"), nil)
+	})
+}
+
+func TestHtpasswdFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "htpasswd")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("alice:$2y$10$old\n"), 0600))
+
+	h := NewHtpasswdFile(filename, fakeBcrypt)
+	assert.True(t, h.Authenticate("alice", "old"))
+
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("alice:$2y$10$new\n"), 0600))
+	assert.NoError(t, h.Reload())
+	assert.False(t, h.Authenticate("alice", "old"))
+	assert.True(t, h.Authenticate("alice", "new"))
+
+	// the users are kept if reloading fails
+	assert.NoError(t, os.Remove(filename))
+	assert.Error(t, h.Reload())
+	assert.True(t, h.Authenticate("alice", "new"))
+
+	assert.Panics(t, func() {
+		NewHtpasswdFile(filename, nil)
+	})
+}
+
+func TestBasic(t *testing.T) {
+	handler := testServer(Basic(BasicConfig{
+		Authenticator: NewHtpasswd([]byte(testHtpasswd), fakeBcrypt),
+		Realm:         "gateway",
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.SetBasicAuth("alice", "wonderland")
+	w := serve(handler, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "basic:alice", w.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.SetBasicAuth("alice", "wrong")
+	w = serve(handler, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="gateway", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+
+	w = serve(handler, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	assert.Panics(t, func() {
+		Basic(BasicConfig{})
+	})
+}
+
+func TestBasic_AuthenticatorFunc(t *testing.T) {
+	handler := testServer(Basic(BasicConfig{Authenticator: AuthenticatorFunc(func(user string, password string) bool {
+		return user == "svc" && password == "token"
+	})}))
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.SetBasicAuth("svc", "token")
+	w := serve(handler, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "basic:svc", w.Body.String())
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// SignatureHeader is the default header with the HMAC signature.
+	SignatureHeader = "X-Signature"
+	// defaultTolerance is the default maximum difference between the timestamp of a signature and the current time.
+	defaultTolerance = 5 * time.Minute
+	// defaultMaxBodySize is the default maximum size of a signed request body.
+	defaultMaxBodySize = 1 << 20
+)
+
+// HMACConfig is the configuration of the HMAC signature middleware.
+type HMACConfig struct {
+	// Secrets is the secrets of senders by key ID. It is required.
+	Secrets map[string][]byte
+	// Header is the header with the signature. It is "X-Signature" if empty.
+	Header string
+	// Tolerance is the maximum difference between the timestamp of a signature and the current time.
+	// It is five minutes if zero.
+	Tolerance time.Duration
+	// MaxBodySize is the maximum size in bytes of a signed request body. It is 1 MiB if zero.
+	MaxBodySize int64
+}
+
+// Sign signs a request body at the time, and returns the value of the signature header, in the form of
+// "t=<unix time>,k=<key ID>,v1=<signature>". The signature is the hex-encoded HMAC-SHA256 of
+// "<unix time>.<body>" with the secret.
+func Sign(keyID string, secret []byte, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",k=" + keyID + ",v1=" + signature(secret, t, body)
+}
+
+// signature computes the hex-encoded signature of the body at the time.
+func signature(secret []byte, t string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = io.WriteString(mac, t)
+	_, _ = io.WriteString(mac, ".")
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMAC provides a middleware that authenticates requests signed with HMAC-SHA256 over the body (see Sign),
+// and sets the Principal of the Context with the key ID as the subject.
+// The signature header can have multiple "v1" signatures, so that a sender can rotate its secret.
+//
+// Requests are rejected with "401 Unauthorized" if the signature is missing or invalid, the timestamp is out of
+// the tolerance, or the same signature has been seen (a replay), and with "413 Request Entity Too Large"
+// if the body is too large, all from the error handler of the Server.
+// The body is read into memory and replaced, so that the following handlers can read it again.
+// Seen signatures are kept in memory until they are out of the tolerance, so replays are only detected by the same
+// gateway instance.
+func HMAC(config HMACConfig) gateway.Handler {
+	if len(config.Secrets) == 0 {
+		panic("no secrets for HMAC")
+	}
+	if config.Header == "" {
+		config.Header = SignatureHeader
+	}
+	if config.Tolerance == 0 {
+		config.Tolerance = defaultTolerance
+	}
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = defaultMaxBodySize
+	}
+	seen := &replayCache{seen: map[string]time.Time{}}
+
+	return func(context *gateway.Context) {
+		req := context.Request
+		t, keyID, signatures := parseSignatureHeader(req.Header.Get(config.Header))
+		secret, ok := config.Secrets[keyID]
+		if !ok || len(signatures) == 0 {
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		logger := context.Logger.WithField("key_id", keyID)
+		unix, err := strconv.ParseInt(t, 10, 64)
+		now := time.Now()
+		if err != nil || now.Sub(time.Unix(unix, 0)) > config.Tolerance || time.Unix(unix, 0).Sub(now) > config.Tolerance {
+			logger.Info("signature timestamp out of tolerance")
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		var body []byte
+		if req.Body != nil {
+			body, err = ioutil.ReadAll(io.LimitReader(req.Body, config.MaxBodySize+1))
+			_ = req.Body.Close()
+			if err != nil {
+				logger.WithError(err).Warn("failed to read request body")
+				context.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > config.MaxBodySize {
+				context.AbortWithStatus(http.StatusRequestEntityTooLarge)
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		expected := signature(secret, t, body)
+		valid := false
+		for _, s := range signatures {
+			if hmac.Equal([]byte(s), []byte(expected)) {
+				valid = true
+			}
+		}
+		if !valid {
+			logger.Info("invalid signature")
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if !seen.add(keyID+":"+expected, time.Unix(unix, 0).Add(config.Tolerance), now) {
+			logger.Info("replayed signature")
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		context.SetPrincipal(&gateway.Principal{Subject: keyID, Method: "hmac"})
+	}
+}
+
+// parseSignatureHeader parses the signature header into the timestamp, the key ID and the signatures.
+func parseSignatureHeader(header string) (t string, keyID string, signatures []string) {
+	for _, item := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			t = kv[1]
+		case "k":
+			keyID = kv[1]
+		case "v1":
+			signatures = append(signatures, strings.ToLower(kv[1]))
+		}
+	}
+	return t, keyID, signatures
+}
+
+// replayCache keeps signatures which have been seen until they expire.
+type replayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// add adds a signature, returning false if it has been seen and not expired.
+func (r *replayCache) add(signature string, expires time.Time, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.lastSweep) > time.Minute {
+		r.lastSweep = now
+		for s, e := range r.seen {
+			if now.After(e) {
+				delete(r.seen, s)
+			}
+		}
+	}
+	if e, ok := r.seen[signature]; ok && !now.After(e) {
+		return false
+	}
+	r.seen[signature] = expires
+	return true
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedRequest(body string, header string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(body))
+	if header != "" {
+		req.Header.Set(SignatureHeader, header)
+	}
+	return req
+}
+
+func TestHMAC(t *testing.T) {
+	secrets := map[string][]byte{"sender": []byte("secret")}
+	var body string
+	handler := testServer(HMAC(HMACConfig{Secrets: secrets, MaxBodySize: 16}))
+	verify := HMAC(HMACConfig{Secrets: secrets})
+	echo := testServer(func(context *gateway.Context) {
+		verify(context)
+		if context.StatusCode == 0 || context.StatusCode == http.StatusOK {
+			data, _ := ioutil.ReadAll(context.Request.Body)
+			body = string(data)
+		}
+	})
+
+	now := time.Now()
+	w := serve(handler, signedRequest(`{"event":"a"}`, Sign("sender", []byte("secret"), now, []byte(`{"event":"a"}`))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hmac:sender", w.Body.String())
+
+	// the body can be read again
+	w = serve(echo, signedRequest(`{"event":"b"}`, Sign("sender", []byte("secret"), now, []byte(`{"event":"b"}`))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"event":"b"}`, body)
+
+	// replayed
+	w = serve(handler, signedRequest(`{"event":"a"}`, Sign("sender", []byte("secret"), now, []byte(`{"event":"a"}`))))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// tampered body
+	w = serve(handler, signedRequest(`{"event":"c"}`, Sign("sender", []byte("secret"), now, []byte(`{"event":"d"}`))))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// wrong secret, unknown key, no signature
+	w = serve(handler, signedRequest(`{}`, Sign("sender", []byte("wrong"), now, []byte(`{}`))))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(handler, signedRequest(`{}`, Sign("other", []byte("secret"), now, []byte(`{}`))))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(handler, signedRequest(`{}`, ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// out of tolerance
+	old := now.Add(-10 * time.Minute)
+	w = serve(handler, signedRequest(`{}`, Sign("sender", []byte("secret"), old, []byte(`{}`))))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	future := now.Add(10 * time.Minute)
+	w = serve(handler, signedRequest(`{}`, Sign("sender", []byte("secret"), future, []byte(`{}`))))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// too large
+	large := strings.Repeat("a", 17)
+	w = serve(handler, signedRequest(large, Sign("sender", []byte("secret"), now, []byte(large))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestHMAC_Rotation(t *testing.T) {
+	handler := testServer(HMAC(HMACConfig{Secrets: map[string][]byte{"sender": []byte("new")}}))
+	now := time.Now()
+	header := Sign("sender", []byte("old"), now, []byte("x"))
+	header += ",v1=" + signature([]byte("new"), strings.TrimPrefix(strings.Split(header, ",")[0], "t="), []byte("x"))
+	w := serve(handler, signedRequest("x", header))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReplayCache(t *testing.T) {
+	cache := &replayCache{seen: map[string]time.Time{}}
+	now := time.Now()
+	assert.True(t, cache.add("a", now.Add(time.Minute), now))
+	assert.False(t, cache.add("a", now.Add(time.Minute), now))
+	assert.True(t, cache.add("a", now.Add(3*time.Minute), now.Add(2*time.Minute)))
+	assert.Len(t, cache.seen, 1)
+}
+
+func TestHMAC_NoSecrets(t *testing.T) {
+	assert.Panics(t, func() {
+		HMAC(HMACConfig{})
+	})
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// CertificateIdentity gets the identity of a client certificate: the first URI SAN (such as a SPIFFE ID) if any,
+// or the common name of the subject. It is the same as gateway.CertificateIdentity.
+func CertificateIdentity(cert *x509.Certificate) string {
+	return gateway.CertificateIdentity(cert)
+}
+
+// ClientCertConfig is the configuration of the client certificate middleware.
+type ClientCertConfig struct {
+	// Identity gets the identity of a verified client certificate. CertificateIdentity is used if it is nil.
+	Identity func(cert *x509.Certificate) string
+	// Allowed is a list of allowed identities. All verified certificates are allowed if it is empty.
+	Allowed []string
+	// Optional allows requests without a verified client certificate, for example, to be authenticated by other
+	// middleware. Requests with a verified certificate not in Allowed are always rejected.
+	Optional bool
+}
+
+// ClientCertificate provides a middleware that authenticates requests with client certificates (mutual TLS),
+// and sets the Principal of the Context with the identity of the certificate as the subject.
+// The leaf certificate is in the "certificate" attribute of the Principal.
+//
+// Only certificates verified by the TLS server are used (see Context.ClientCertificate), so the server must be
+// configured with client CAs, such as TLSConfig.ClientCAFile of gateway.Server.
+// Requests without a verified certificate get "401 Unauthorized", and requests with a certificate not allowed get
+// "403 Forbidden", both from the error handler of the Server.
+func ClientCertificate(config ClientCertConfig) gateway.Handler {
+	if config.Identity == nil {
+		config.Identity = CertificateIdentity
+	}
+	allowed := map[string]bool{}
+	for _, identity := range config.Allowed {
+		allowed[identity] = true
+	}
+
+	return func(context *gateway.Context) {
+		cert := context.ClientCertificate()
+		if cert == nil {
+			if !config.Optional {
+				context.AbortWithStatus(http.StatusUnauthorized)
+			}
+			return
+		}
+		identity := config.Identity(cert)
+		if identity == "" || (len(allowed) > 0 && !allowed[identity]) {
+			context.Logger.WithField("identity", identity).Info("client certificate not allowed")
+			context.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		context.SetPrincipal(&gateway.Principal{
+			Subject:    identity,
+			Method:     "mtls",
+			Attributes: map[string]interface{}{"certificate": cert},
+		})
+	}
+}
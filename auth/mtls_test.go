@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithCert(cert *x509.Certificate) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		req.TLS.PeerCertificates = []*x509.Certificate{cert}
+		req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return req
+}
+
+func TestClientCertificate(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/default/sa/orders")
+	orders := &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}, URIs: []*url.URL{spiffe}}
+	billing := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+
+	handler := testServer(ClientCertificate(ClientCertConfig{}))
+	w := serve(handler, requestWithCert(orders))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mtls:spiffe://example.org/ns/default/sa/orders", w.Body.String())
+	w = serve(handler, requestWithCert(billing))
+	assert.Equal(t, "mtls:billing", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, requestWithCert(nil)).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, httptest.NewRequest(http.MethodGet, "/hello", nil)).Code)
+
+	// unverified certificates are not used
+	req := requestWithCert(billing)
+	req.TLS.VerifiedChains = nil
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, req).Code)
+
+	handler = testServer(ClientCertificate(ClientCertConfig{
+		Identity: func(cert *x509.Certificate) string {
+			return cert.Subject.CommonName
+		},
+		Allowed:  []string{"orders"},
+		Optional: true,
+	}))
+	w = serve(handler, requestWithCert(orders))
+	assert.Equal(t, "mtls:orders", w.Body.String())
+	assert.Equal(t, http.StatusForbidden, serve(handler, requestWithCert(billing)).Code)
+	w = serve(handler, requestWithCert(nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "anonymous", w.Body.String())
+}
@@ -0,0 +1,22 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+)
+
+// backgroundKey is the key marking the context.Context of background requests.
+type backgroundKey struct{}
+
+// WithBackground marks the context of a request made by the gateway itself and served by the Server,
+// such as revalidating a cached response in background.
+func WithBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// IsBackground checks if the request is made by the gateway itself, with a context from WithBackground.
+// Middlewares accounting requests of clients, such as rate limits, metrics and access logs, skip these requests.
+func IsBackground(req *http.Request) bool {
+	background, _ := req.Context().Value(backgroundKey{}).(bool)
+	return background
+}
@@ -0,0 +1,15 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBackground(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.False(t, IsBackground(req))
+	assert.True(t, IsBackground(req.WithContext(WithBackground(req.Context()))))
+}
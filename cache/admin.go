@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// AdminHandler provides the admin API purging cached responses, which is meant to be registered on the admin listener
+// with its prefix stripped:
+//
+//	s.HandleAdmin("/cache/", http.StripPrefix("/cache", c.AdminHandler()))
+//
+// The API has the following endpoints, which respond with the number of purged entries:
+//
+//	POST /purge?key={key}        purges the cached response of the key, including all its variants
+//	POST /purge?prefix={prefix}  purges the cached responses whose keys start with the prefix
+func (c *Cache) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Trim(r.URL.Path, "/") != "purge" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		var n int
+		var err error
+		switch {
+		case query.Get("key") != "":
+			n, err = c.Purge(query.Get("key"))
+		case query.Get("prefix") != "":
+			n, err = c.PurgePrefix(query.Get("prefix"))
+		default:
+			writeError(w, http.StatusBadRequest, "key or prefix is required")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+	})
+}
+
+// writeError writes an error message in JSON.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeJSON writes the value in JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	c := New(Config{})
+	for _, key := range []string{"/users/1", "/users/1\x00Accept=json", "/users/2", "/orders/1"} {
+		assert.NoError(t, c.Store().Set(key, &Entry{Key: key}))
+	}
+	handler := http.StripPrefix("/cache", c.AdminHandler())
+	call := func(method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := call(http.MethodPost, "/cache/purge?key=/users/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"purged": 2}`, w.Body.String())
+
+	w = call(http.MethodPost, "/cache/purge?prefix=/users/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"purged": 1}`, w.Body.String())
+	entry, _ := c.Store().Get("/orders/1")
+	assert.NotNil(t, entry)
+
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/cache/purge").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, call(http.MethodGet, "/cache/purge?key=/orders/1").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPost, "/cache/other").Code)
+}
@@ -0,0 +1,602 @@
+// Package cache provides a middleware caching responses in a Store, following the rules of shared HTTP caches:
+// responses are cached according to Cache-Control, Expires and Vary, revalidated with conditional requests,
+// and served stale while being revalidated or if the upstream fails, as allowed by the responses.
+//
+// The cache is usually used with the admin API purging entries:
+//
+//	c := cache.New(cache.Config{Store: cache.NewMemoryStore(256 << 20)})
+//	s.UseMiddleware(c.Middleware())
+//	s.HandleAdmin("/cache/", http.StripPrefix("/cache", c.AdminHandler()))
+package cache
+
+import (
+	stdcontext "context"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/etag"
+)
+
+const (
+	// defaultMaxEntrySize is the default maximum size of a response body to be cached.
+	defaultMaxEntrySize = 1 << 20
+	// defaultStaleRetention is the default time that stale responses are kept for revalidation.
+	defaultStaleRetention = time.Hour
+	// defaultCollapseTimeout is the default time that requests wait for a concurrent request of the same response.
+	defaultCollapseTimeout = 10 * time.Second
+	// revalidateTimeout is the timeout of revalidating a response in background.
+	revalidateTimeout = 30 * time.Second
+)
+
+// StatusHeader is the response header telling how the response is served by the cache:
+//
+//	HIT          the response is fresh in the cache
+//	MISS         the response is from the upstream
+//	STALE        the response is stale in the cache, because it is being revalidated or the upstream fails
+//	REVALIDATED  the response in the cache is revalidated by the upstream
+//	BYPASS       the request does not use the cache
+const StatusHeader = "X-Cache"
+
+// cacheableStatus is the status codes of responses that can be cached.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// KeyFunc gets the key of the cached response of a request. Requests with an empty key are not cached.
+type KeyFunc func(context *gateway.Context) string
+
+// ByURL gets the key of the path and the query of the request URL, which is the default KeyFunc.
+func ByURL() KeyFunc {
+	return func(context *gateway.Context) string {
+		u := context.Request.URL
+		if u.RawQuery == "" {
+			return u.EscapedPath()
+		}
+		return u.EscapedPath() + "?" + u.RawQuery
+	}
+}
+
+// ByHostAndURL gets the key of the host, the path and the query of the request URL,
+// for the gateway serving multiple hosts.
+func ByHostAndURL() KeyFunc {
+	byURL := ByURL()
+	return func(context *gateway.Context) string {
+		return strings.ToLower(context.Request.Host) + byURL(context)
+	}
+}
+
+// Config is the configuration of the cache.
+type Config struct {
+	// Store keeps cached responses. NewMemoryStore(0) is used if it is nil.
+	Store Store
+	// TTLs overrides the freshness lifetime of responses of Service, regardless of Cache-Control and Expires.
+	// The TTL is looked up with gateway.LookupService. A zero TTL disables caching of the Service.
+	// Responses with "no-store" or "private" are never cached.
+	TTLs map[string]time.Duration
+	// Key gets the key of the cached response of a request. ByURL() is used if it is nil.
+	Key KeyFunc
+	// MaxEntrySize is the maximum size of a response body to be cached. It is 1 MiB if zero.
+	MaxEntrySize int64
+	// StaleWhileRevalidate is how long responses can be served stale while being revalidated in background,
+	// for responses without the stale-while-revalidate directive. Responses are not served stale if it is zero.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long responses can be served stale if revalidating them fails with a 5xx status,
+	// for responses without the stale-if-error directive. Responses are not served stale if it is zero.
+	StaleIfError time.Duration
+	// StaleRetention is how long stale responses with an ETag or a Last-Modified header are kept,
+	// so that they can be revalidated with conditional requests. It is 1 hour if zero.
+	StaleRetention time.Duration
+	// CollapseTimeout is how long requests of a response wait for a concurrent request of the same response,
+	// instead of requesting the upstream themselves. It is 10 seconds if zero, and they do not wait if negative.
+	CollapseTimeout time.Duration
+}
+
+// Cache caches responses in a Store.
+type Cache struct {
+	config Config
+	// calls is the requests of responses from the upstream, which concurrent requests of the same response wait for.
+	calls gateway.CallGroup[struct{}]
+}
+
+// call is a request of a response from the upstream.
+type call = gateway.Call[struct{}]
+
+// revalidationKey is the key of the call in the context.Context of requests revalidating responses in background.
+type revalidationKey struct{}
+
+// New creates a Cache with the configuration.
+func New(config Config) *Cache {
+	for name, ttl := range config.TTLs {
+		if ttl < 0 {
+			panic("negative cache TTL of " + name)
+		}
+	}
+	if config.MaxEntrySize < 0 || config.StaleWhileRevalidate < 0 || config.StaleIfError < 0 ||
+		config.StaleRetention < 0 {
+		panic("negative cache configuration")
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore(0)
+	}
+	if config.Key == nil {
+		config.Key = ByURL()
+	}
+	if config.MaxEntrySize == 0 {
+		config.MaxEntrySize = defaultMaxEntrySize
+	}
+	if config.StaleRetention == 0 {
+		config.StaleRetention = defaultStaleRetention
+	}
+	if config.CollapseTimeout == 0 {
+		config.CollapseTimeout = defaultCollapseTimeout
+	}
+	return &Cache{config: config}
+}
+
+// Store gets the Store of the Cache.
+func (c *Cache) Store() Store {
+	return c.config.Store
+}
+
+// Purge purges the cached response of the key, including all its variants selected by Vary.
+// It returns the number of deleted entries.
+func (c *Cache) Purge(key string) (int, error) {
+	n, err := c.config.Store.DeletePrefix(key + "\x00")
+	if err != nil {
+		return n, err
+	}
+	ok, err := c.config.Store.Delete(key)
+	if ok {
+		n++
+	}
+	return n, err
+}
+
+// PurgePrefix purges the cached responses whose keys start with the prefix. It returns the number of deleted entries.
+func (c *Cache) PurgePrefix(prefix string) (int, error) {
+	return c.config.Store.DeletePrefix(prefix)
+}
+
+// Middleware provides a middleware that serves GET and HEAD requests from the cache, and caches the responses.
+//
+// Concurrent requests missing the same response wait for the first one instead of all requesting the upstream.
+// Stale responses are revalidated by the following handlers with If-None-Match and If-Modified-Since headers,
+// and are refreshed with a "304 Not Modified" response. Responses served stale while being revalidated are
+// revalidated by a copy of the request served by the Server in background, marked by gateway.WithBackground
+// so that rate limits, metrics and access logs skip it.
+// Successful requests of other methods purge the cached response of their key.
+//
+// Response streams are cached while they are written, if they are not larger than Config.MaxEntrySize.
+// The headers set before the middleware calls the following handlers are not cached.
+func (c *Cache) Middleware() gateway.Handler {
+	return func(context *gateway.Context) {
+		req := context.Request
+		key := c.config.Key(context)
+		if key == "" {
+			return
+		}
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			context.Next()
+			if context.StatusCode >= 200 && context.StatusCode < 400 {
+				if _, err := c.Purge(key); err != nil {
+					context.Logger.WithError(err).Warn("failed to purge cached response")
+				}
+			}
+			return
+		}
+		if _, ttl, ok := gateway.LookupService(c.config.TTLs, context.GetConfiguredServiceName()); ok && ttl == 0 {
+			return
+		}
+		if revalidation, ok := req.Context().Value(revalidationKey{}).(*call); ok {
+			c.fetch(context, key, c.lookup(context, key), revalidation)
+			return
+		}
+
+		cc := parseCacheControl(req.Header)
+		if cc.has("no-store") {
+			context.Header.Set(StatusHeader, "BYPASS")
+			return
+		}
+		maxAge, hasMaxAge := cc.seconds("max-age")
+		revalidate := cc.has("no-cache") || (hasMaxAge && maxAge == 0) || req.Header.Get("Pragma") == "no-cache"
+
+		entry := c.lookup(context, key)
+		now := time.Now()
+		if entry != nil && !revalidate {
+			if entry.fresh(now) {
+				c.serve(context, entry, "HIT")
+				return
+			}
+			if entry.staleWithin(now, entry.StaleWhileRevalidate) {
+				c.serve(context, entry, "STALE")
+				c.revalidate(context, key)
+				return
+			}
+		}
+
+		current, leader := c.calls.Join(key)
+		if !leader {
+			waited := c.wait(context, current)
+			current = nil
+			if waited && !revalidate {
+				entry = c.lookup(context, key)
+				if entry != nil && entry.fresh(time.Now()) {
+					c.serve(context, entry, "HIT")
+					return
+				}
+			}
+		}
+		c.fetch(context, key, entry, current)
+	}
+}
+
+// lookup gets the cached response of the request, selecting the variant by Vary.
+func (c *Cache) lookup(context *gateway.Context, key string) *Entry {
+	entry, err := c.config.Store.Get(key)
+	if err == nil && entry != nil && entry.isVariants() {
+		entry, err = c.config.Store.Get(variantKey(key, entry.Vary, context.Request.Header))
+	}
+	if err != nil {
+		context.Logger.WithError(err).Warn("failed to get cached response")
+		return nil
+	}
+	return entry
+}
+
+// wait waits for the call. It returns false if it times out or the request is canceled.
+func (c *Cache) wait(context *gateway.Context, current *call) bool {
+	if c.config.CollapseTimeout < 0 {
+		return false
+	}
+	_, ok := current.Wait(context.Request.Context(), c.config.CollapseTimeout)
+	return ok
+}
+
+// fetch gets the response from the following handlers, revalidating the cached response if any, and caches it.
+// The call is finished once the response is cached or known not to be cached, or if the following handlers panic.
+func (c *Cache) fetch(context *gateway.Context, key string, entry *Entry, current *call) {
+	returned := false
+	defer func() {
+		if !returned {
+			current.Finish(struct{}{})
+		}
+	}()
+	req := context.Request
+	before := context.Header.Clone()
+	conditional := false
+	if entry != nil && entry.StatusCode == http.StatusOK &&
+		req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+			conditional = true
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+			conditional = true
+		}
+	}
+	context.Next()
+	returned = true
+	if conditional {
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+	}
+
+	now := time.Now()
+	switch {
+	case conditional && context.StatusCode == http.StatusNotModified:
+		header := gateway.DiffHeader(before, context.Header)
+		context.Header = before
+		c.serve(context, c.refresh(context, entry, header, now), "REVALIDATED")
+		current.Finish(struct{}{})
+	case entry != nil && context.StatusCode >= 500 && entry.staleWithin(now, entry.StaleIfError):
+		context.Header = before
+		c.serve(context, entry, "STALE")
+		current.Finish(struct{}{})
+	default:
+		c.store(context, key, before, now, current)
+	}
+}
+
+// refresh updates the cached response with the headers of a "304 Not Modified" response, and stores it.
+func (c *Cache) refresh(context *gateway.Context, entry *Entry, header http.Header, now time.Time) *Entry {
+	updated := *entry
+	updated.Header = entry.Header.Clone()
+	for key, values := range header {
+		switch key {
+		case "Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding", StatusHeader:
+		default:
+			updated.Header[key] = values
+		}
+	}
+	if header.Get("Date") == "" {
+		// the response is as new as the "304 Not Modified" response
+		updated.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	if !c.freshen(context, &updated, now) {
+		if _, err := c.config.Store.Delete(entry.Key); err != nil {
+			context.Logger.WithError(err).Warn("failed to delete cached response")
+		}
+		return &updated
+	}
+	if err := c.config.Store.Set(updated.Key, &updated); err != nil {
+		context.Logger.WithError(err).Warn("failed to store cached response")
+	}
+	return &updated
+}
+
+// store caches the response of the following handlers if it can be cached.
+func (c *Cache) store(context *gateway.Context, key string, before http.Header, now time.Time, current *call) {
+	context.Header.Set(StatusHeader, "MISS")
+	entry := c.cacheable(context, before, now)
+	if entry == nil {
+		current.Finish(struct{}{})
+		return
+	}
+	vary := varyNames(entry.Header)
+	requestHeader := context.Request.Header.Clone()
+	save := func(body []byte) {
+		entry.Body = body
+		c.save(context, key, entry, vary, requestHeader)
+	}
+
+	if context.ResponseStream == nil {
+		if int64(len(context.Response)) <= c.config.MaxEntrySize {
+			save(append([]byte(nil), context.Response...))
+		}
+		current.Finish(struct{}{})
+		return
+	}
+	if length, err := strconv.ParseInt(context.Header.Get("Content-Length"), 10, 64); err == nil &&
+		length > c.config.MaxEntrySize {
+		current.Finish(struct{}{})
+		return
+	}
+	context.ResponseStream = &captureReader{
+		reader: context.ResponseStream,
+		limit:  c.config.MaxEntrySize,
+		done: func(body []byte) {
+			if body != nil {
+				save(body)
+			}
+			current.Finish(struct{}{})
+		},
+	}
+}
+
+// cacheable creates an Entry of the response of the following handlers, without the body.
+// It returns nil if the response cannot be cached.
+func (c *Cache) cacheable(context *gateway.Context, before http.Header, now time.Time) *Entry {
+	if context.Request.Method != http.MethodGet || !cacheableStatus[context.StatusCode] || len(context.Trailer) > 0 {
+		return nil
+	}
+	header := gateway.DiffHeader(before, context.Header)
+	header.Del(StatusHeader)
+	header.Del("Age")
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || cc.has("private") || header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	if vary := varyNames(header); len(vary) > 0 && vary[0] == "*" {
+		return nil
+	}
+	if context.Request.Header.Get("Authorization") != "" &&
+		!cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return nil
+	}
+	entry := &Entry{StatusCode: context.StatusCode, Header: header}
+	if !c.freshen(context, entry, now) {
+		return nil
+	}
+	return entry
+}
+
+// freshen sets the freshness of the Entry from its headers and the TTL of the Service.
+// It returns false if the response should not be cached.
+func (c *Cache) freshen(context *gateway.Context, entry *Entry, now time.Time) bool {
+	cc := parseCacheControl(entry.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	_, ttl, ok := gateway.LookupService(c.config.TTLs, context.GetConfiguredServiceName())
+	if !ok {
+		if ttl, ok = lifetime(cc, entry.Header); !ok && !cc.has("no-cache") {
+			// no heuristic freshness
+			return false
+		}
+	}
+	if cc.has("no-cache") {
+		ttl = 0
+	}
+	entry.Date = responseDate(entry.Header, now)
+	entry.Expires = entry.Date.Add(ttl)
+
+	entry.StaleWhileRevalidate = c.config.StaleWhileRevalidate
+	if d, ok := cc.seconds("stale-while-revalidate"); ok {
+		entry.StaleWhileRevalidate = d
+	}
+	entry.StaleIfError = c.config.StaleIfError
+	if d, ok := cc.seconds("stale-if-error"); ok {
+		entry.StaleIfError = d
+	}
+	if cc.has("no-cache") || cc.has("must-revalidate") || cc.has("proxy-revalidate") {
+		entry.StaleWhileRevalidate = 0
+		entry.StaleIfError = 0
+	}
+
+	stale := entry.StaleWhileRevalidate
+	if entry.StaleIfError > stale {
+		stale = entry.StaleIfError
+	}
+	if entry.hasValidators() && c.config.StaleRetention > stale {
+		stale = c.config.StaleRetention
+	}
+	entry.RemoveAt = entry.Expires.Add(stale)
+	return entry.RemoveAt.After(now)
+}
+
+// save stores the Entry of the key, or of its variant selected by the request headers if the response has Vary.
+func (c *Cache) save(context *gateway.Context, key string, entry *Entry, vary []string, requestHeader http.Header) {
+	entry.Key = key
+	if len(vary) > 0 {
+		variants := &Entry{Key: key, Vary: vary, RemoveAt: entry.RemoveAt}
+		if existing, err := c.config.Store.Get(key); err == nil && existing != nil && existing.isVariants() &&
+			slices.Equal(existing.Vary, vary) && existing.RemoveAt.After(variants.RemoveAt) {
+			variants.RemoveAt = existing.RemoveAt
+		}
+		if err := c.config.Store.Set(key, variants); err != nil {
+			context.Logger.WithError(err).Warn("failed to store cached response")
+			return
+		}
+		entry.Key = variantKey(key, vary, requestHeader)
+	}
+	if err := c.config.Store.Set(entry.Key, entry); err != nil {
+		context.Logger.WithError(err).Warn("failed to store cached response")
+	}
+}
+
+// serve serves the cached response, stopping the following handlers.
+// It responds "304 Not Modified" if the request is conditional and the response has not been modified,
+// or "412 Precondition Failed" if a precondition fails.
+func (c *Cache) serve(context *gateway.Context, entry *Entry, status string) {
+	context.Interrupt()
+	if closer, ok := context.ResponseStream.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	context.ResponseStream = nil
+	condition := 0
+	if entry.StatusCode == http.StatusOK {
+		modified, _ := http.ParseTime(entry.Header.Get("Last-Modified"))
+		condition = etag.Evaluate(context.Request, true, entry.Header.Get("ETag"), modified)
+	}
+	context.Header.Set(StatusHeader, status)
+	if condition == http.StatusPreconditionFailed {
+		context.Response = nil
+		context.AbortWithStatus(condition)
+		return
+	}
+	for key, values := range entry.Header {
+		context.Header[key] = append([]string(nil), values...)
+	}
+	context.Header.Set("Age", strconv.FormatInt(int64(entry.age(time.Now())/time.Second), 10))
+	if condition == http.StatusNotModified {
+		context.StatusCode = http.StatusNotModified
+		context.Response = nil
+		return
+	}
+	context.StatusCode = entry.StatusCode
+	context.Response = entry.Body
+}
+
+// revalidate revalidates the cached response of the key in background with a copy of the request,
+// unless it is being requested.
+func (c *Cache) revalidate(context *gateway.Context, key string) {
+	server := context.GetServer()
+	if server == nil {
+		return
+	}
+	ctx, cancel := stdcontext.WithTimeout(gateway.WithBackground(stdcontext.Background()), revalidateTimeout)
+	req := context.Request.Clone(ctx)
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since",
+		"If-Range", "Range", "Cache-Control", "Pragma"} {
+		req.Header.Del(name)
+	}
+	current, leader := c.calls.Join(key)
+	if !leader {
+		cancel()
+		return
+	}
+	req = req.WithContext(stdcontext.WithValue(ctx, revalidationKey{}, current))
+	go func() {
+		defer cancel()
+		// the call is not finished by the middleware if the request does not reach it
+		defer current.Finish(struct{}{})
+		defer func() {
+			// the Server panics again with http.ErrAbortHandler, which only aborts the discarded response
+			if r := recover(); r != nil && r != http.ErrAbortHandler {
+				panic(r)
+			}
+		}()
+		server.ServeHTTP(&discardWriter{header: http.Header{}}, req)
+	}()
+}
+
+// captureReader captures a response stream while it is read, up to the limit.
+// The done function is called once with the whole body when it ends, or with nil if it is too large or fails.
+type captureReader struct {
+	reader io.Reader
+	limit  int64
+	done   func(body []byte)
+
+	body []byte
+	over bool
+	once sync.Once
+}
+
+// Read reads the stream.
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if !r.over {
+		if int64(len(r.body)+n) > r.limit {
+			r.over = true
+			r.body = nil
+		} else {
+			r.body = append(r.body, p[:n]...)
+		}
+	}
+	if err == io.EOF && !r.over {
+		body := r.body
+		if body == nil {
+			body = []byte{}
+		}
+		r.once.Do(func() { r.done(body) })
+	} else if err != nil {
+		r.once.Do(func() { r.done(nil) })
+	}
+	return n, err
+}
+
+// Close closes the stream if it is an io.Closer.
+func (r *captureReader) Close() error {
+	r.once.Do(func() { r.done(nil) })
+	if closer, ok := r.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// discardWriter is an http.ResponseWriter discarding the response, used by revalidation in background.
+type discardWriter struct {
+	header http.Header
+}
+
+// Header gets the headers.
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+// Write discards the data.
+func (w *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// WriteHeader discards the status code.
+func (w *discardWriter) WriteHeader(int) {}
@@ -0,0 +1,462 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// upstream is a handler counting requests, which responds with the headers and the body of the path.
+type upstream struct {
+	mu       sync.Mutex
+	requests int64
+	header   map[string]http.Header
+	status   map[string]int
+	// delay delays the responses.
+	delay time.Duration
+	// conditional is the conditional headers of the last request.
+	conditional string
+	// background tells if the last request is made by the gateway itself.
+	background bool
+}
+
+func newUpstream() *upstream {
+	return &upstream{header: map[string]http.Header{}, status: map[string]int{}}
+}
+
+func (u *upstream) set(path string, status int, header http.Header) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status[path] = status
+	u.header[path] = header
+}
+
+func (u *upstream) count() int64 {
+	return atomic.LoadInt64(&u.requests)
+}
+
+func (u *upstream) handle(context *gateway.Context) {
+	n := atomic.AddInt64(&u.requests, 1)
+	if u.delay > 0 {
+		time.Sleep(u.delay)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	path := context.Request.URL.Path
+	u.conditional = context.Request.Header.Get("If-None-Match")
+	u.background = gateway.IsBackground(context.Request)
+	for key, values := range u.header[path] {
+		context.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	if status, ok := u.status[path]; ok {
+		context.StatusCode = status
+	}
+	if context.StatusCode == http.StatusOK && u.conditional != "" && u.conditional == context.Header.Get("ETag") {
+		context.StatusCode = http.StatusNotModified
+		return
+	}
+	if strings.HasPrefix(path, "/stream") {
+		context.ResponseStream = strings.NewReader("stream " + strconv.FormatInt(n, 10))
+		return
+	}
+	context.Response = []byte(context.Request.URL.RequestURI() + " " + strconv.FormatInt(n, 10) + " " +
+		context.Request.Header.Get("Accept-Language"))
+}
+
+func testServer(c *Cache, u *upstream) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	for _, path := range []string{"/foo", "/bar", "/vary", "/stream", "/private", "/error", "/notag"} {
+		cfg.Add(path, http.MethodGet, "api"+strings.Replace(path, "/", ".", -1))
+		cfg.Add(path, http.MethodHead, "api"+strings.Replace(path, "/", ".", -1))
+		cfg.Add(path, http.MethodPost, "api"+strings.Replace(path, "/", ".", -1))
+	}
+	cfg.Add("/nocache", http.MethodGet, "internal.nocache")
+	s.UseConfig(cfg)
+	s.UseMiddleware(func(context *gateway.Context) {
+		// headers set before the cache are not cached
+		context.Header.Set("X-Request", context.Request.Header.Get("X-Request"))
+	})
+	s.UseMiddleware(c.Middleware())
+	s.Register("*", u.handle)
+	return s.Handler()
+}
+
+func request(handler http.Handler, method string, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func get(handler http.Handler, target string) *httptest.ResponseRecorder {
+	return request(handler, http.MethodGet, target, nil)
+}
+
+// past gets a Date header of the time ago.
+func past(d time.Duration) string {
+	return time.Now().Add(-d).UTC().Format(http.TimeFormat)
+}
+
+func TestMiddleware(t *testing.T) {
+	u := newUpstream()
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}})
+	handler := testServer(New(Config{}), u)
+
+	w := get(handler, "/foo?a=1")
+	assert.Equal(t, "/foo?a=1 1 ", w.Body.String())
+	assert.Equal(t, "MISS", w.Header().Get(StatusHeader))
+
+	w = request(handler, http.MethodGet, "/foo?a=1", http.Header{"X-Request": {"second"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/foo?a=1 1 ", w.Body.String())
+	assert.Equal(t, "HIT", w.Header().Get(StatusHeader))
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "0", w.Header().Get("Age"))
+	assert.Equal(t, "second", w.Header().Get("X-Request"))
+
+	// the query is a part of the key
+	assert.Equal(t, "/foo?a=2 2 ", get(handler, "/foo?a=2").Body.String())
+
+	// HEAD requests are served from the cache
+	w = request(handler, http.MethodHead, "/foo?a=1", nil)
+	assert.Equal(t, "HIT", w.Header().Get(StatusHeader))
+	assert.Equal(t, int64(2), u.count())
+
+	// the client can bypass the cache or ask for revalidation
+	w = request(handler, http.MethodGet, "/foo?a=1", http.Header{"Cache-Control": {"no-store"}})
+	assert.Equal(t, "/foo?a=1 3 ", w.Body.String())
+	assert.Equal(t, "BYPASS", w.Header().Get(StatusHeader))
+	w = request(handler, http.MethodGet, "/foo?a=1", http.Header{"Cache-Control": {"no-cache"}})
+	assert.Equal(t, "/foo?a=1 4 ", w.Body.String())
+	assert.Equal(t, "MISS", w.Header().Get(StatusHeader))
+	assert.Equal(t, "/foo?a=1 4 ", get(handler, "/foo?a=1").Body.String())
+
+	// unsafe methods purge the response
+	w = request(handler, http.MethodPost, "/foo?a=1", nil)
+	assert.Equal(t, "/foo?a=1 5 ", w.Body.String())
+	assert.Equal(t, "/foo?a=1 6 ", get(handler, "/foo?a=1").Body.String())
+}
+
+func TestMiddleware_NotCacheable(t *testing.T) {
+	u := newUpstream()
+	u.set("/foo", http.StatusOK, http.Header{})
+	u.set("/private", http.StatusOK, http.Header{"Cache-Control": {"private, max-age=60"}})
+	u.set("/bar", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}})
+	u.set("/vary", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}})
+	u.set("/error", http.StatusInternalServerError, http.Header{"Cache-Control": {"max-age=60"}})
+	handler := testServer(New(Config{}), u)
+
+	for _, path := range []string{"/foo", "/private", "/bar", "/vary", "/error"} {
+		get(handler, path)
+		w := get(handler, path)
+		assert.Equal(t, "MISS", w.Header().Get(StatusHeader), path)
+	}
+	assert.Equal(t, int64(10), u.count())
+
+	// requests with Authorization are only cached if the response allows it
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}})
+	auth := http.Header{"Authorization": {"Bearer token"}}
+	request(handler, http.MethodGet, "/foo", auth)
+	assert.Equal(t, "MISS", request(handler, http.MethodGet, "/foo", auth).Header().Get(StatusHeader))
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"public, max-age=60"}})
+	request(handler, http.MethodGet, "/foo", auth)
+	assert.Equal(t, "HIT", request(handler, http.MethodGet, "/foo", auth).Header().Get(StatusHeader))
+}
+
+func TestMiddleware_TTLs(t *testing.T) {
+	u := newUpstream()
+	u.set("/foo", http.StatusOK, http.Header{})
+	u.set("/bar", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}})
+	u.set("/private", http.StatusOK, http.Header{"Cache-Control": {"private"}})
+	handler := testServer(New(Config{TTLs: map[string]time.Duration{
+		"api.foo":  time.Minute,
+		"api":      time.Nanosecond,
+		"internal": 0,
+	}}), u)
+
+	// the TTL overrides the response
+	get(handler, "/foo")
+	assert.Equal(t, "HIT", get(handler, "/foo").Header().Get(StatusHeader))
+	get(handler, "/bar")
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, "MISS", get(handler, "/bar").Header().Get(StatusHeader))
+
+	// private responses are never cached
+	u.set("/private", http.StatusOK, http.Header{"Cache-Control": {"private"}})
+	get(handler, "/private")
+	assert.Equal(t, "MISS", get(handler, "/private").Header().Get(StatusHeader))
+
+	// a zero TTL disables the cache
+	get(handler, "/nocache")
+	assert.Equal(t, "", get(handler, "/nocache").Header().Get(StatusHeader))
+
+	assert.Panics(t, func() {
+		New(Config{TTLs: map[string]time.Duration{"api": -time.Second}})
+	})
+}
+
+func TestMiddleware_Vary(t *testing.T) {
+	u := newUpstream()
+	u.set("/vary", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}})
+	c := New(Config{})
+	handler := testServer(c, u)
+
+	en := http.Header{"Accept-Language": {"en"}}
+	fr := http.Header{"Accept-Language": {"fr"}}
+	assert.Equal(t, "/vary 1 en", request(handler, http.MethodGet, "/vary", en).Body.String())
+	assert.Equal(t, "/vary 2 fr", request(handler, http.MethodGet, "/vary", fr).Body.String())
+	w := request(handler, http.MethodGet, "/vary", en)
+	assert.Equal(t, "/vary 1 en", w.Body.String())
+	assert.Equal(t, "HIT", w.Header().Get(StatusHeader))
+	assert.Equal(t, "/vary 2 fr", request(handler, http.MethodGet, "/vary", fr).Body.String())
+	assert.Equal(t, "/vary 3 ", get(handler, "/vary").Body.String())
+
+	// all variants are purged
+	n, err := c.Purge("/vary")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "/vary 4 en", request(handler, http.MethodGet, "/vary", en).Body.String())
+}
+
+func TestMiddleware_Revalidate(t *testing.T) {
+	u := newUpstream()
+	u.set("/foo", http.StatusOK, http.Header{
+		"Cache-Control": {"max-age=1"},
+		"Date":          {past(time.Minute)},
+		"ETag":          {`"v1"`},
+	})
+	handler := testServer(New(Config{}), u)
+
+	assert.Equal(t, "/foo 1 ", get(handler, "/foo").Body.String())
+	assert.Equal(t, "", u.conditional)
+
+	// the stale response is revalidated with its ETag
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "ETag": {`"v1"`}})
+	w := get(handler, "/foo")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/foo 1 ", w.Body.String())
+	assert.Equal(t, "REVALIDATED", w.Header().Get(StatusHeader))
+	assert.Equal(t, `"v1"`, u.conditional)
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+
+	// and is fresh again
+	w = get(handler, "/foo")
+	assert.Equal(t, "HIT", w.Header().Get(StatusHeader))
+	assert.Equal(t, int64(2), u.count())
+
+	// conditional requests of clients are answered by the cache
+	w = request(handler, http.MethodGet, "/foo", http.Header{"If-None-Match": {`W/"v1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	w = request(handler, http.MethodGet, "/foo", http.Header{"If-None-Match": {`"v2"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(handler, http.MethodGet, "/foo", http.Header{"If-Match": {`"v2"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, int64(2), u.count())
+
+	// a changed response replaces the cached one
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"no-cache"}, "ETag": {`"v2"`}})
+	w = request(handler, http.MethodGet, "/foo", http.Header{"Cache-Control": {"max-age=0"}})
+	assert.Equal(t, "/foo 3 ", w.Body.String())
+	assert.Equal(t, "MISS", w.Header().Get(StatusHeader))
+	w = get(handler, "/foo")
+	assert.Equal(t, "/foo 3 ", w.Body.String())
+	assert.Equal(t, "REVALIDATED", w.Header().Get(StatusHeader))
+	assert.Equal(t, `"v2"`, u.conditional)
+}
+
+func TestMiddleware_StaleWhileRevalidate(t *testing.T) {
+	u := newUpstream()
+	u.set("/foo", http.StatusOK, http.Header{
+		"Cache-Control": {"max-age=1, stale-while-revalidate=3600"},
+		"Date":          {past(time.Minute)},
+	})
+	c := New(Config{})
+	handler := testServer(c, u)
+
+	assert.Equal(t, "/foo 1 ", get(handler, "/foo").Body.String())
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}})
+	w := get(handler, "/foo")
+	assert.Equal(t, "/foo 1 ", w.Body.String())
+	assert.Equal(t, "STALE", w.Header().Get(StatusHeader))
+	assert.Equal(t, "60", w.Header().Get("Age"))
+
+	// the response is revalidated in background
+	assert.Eventually(t, func() bool {
+		return get(handler, "/foo").Header().Get(StatusHeader) == "HIT"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "/foo 2 ", get(handler, "/foo").Body.String())
+	assert.Equal(t, int64(2), u.count())
+	assert.True(t, u.background)
+}
+
+func TestMiddleware_StaleIfError(t *testing.T) {
+	u := newUpstream()
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"max-age=1"}, "Date": {past(time.Minute)}})
+	u.set("/bar", http.StatusOK, http.Header{
+		"Cache-Control": {"max-age=1, must-revalidate"},
+		"Date":          {past(time.Minute)},
+	})
+	handler := testServer(New(Config{StaleIfError: time.Hour}), u)
+
+	get(handler, "/foo")
+	get(handler, "/bar")
+	u.set("/foo", http.StatusServiceUnavailable, http.Header{"Retry-After": {"1"}})
+	u.set("/bar", http.StatusServiceUnavailable, http.Header{})
+	w := get(handler, "/foo")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/foo 1 ", w.Body.String())
+	assert.Equal(t, "STALE", w.Header().Get(StatusHeader))
+	assert.Equal(t, "", w.Header().Get("Retry-After"))
+
+	// must-revalidate does not allow stale responses
+	w = get(handler, "/bar")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestMiddleware_Collapse(t *testing.T) {
+	u := newUpstream()
+	u.delay = 50 * time.Millisecond
+	u.set("/foo", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}})
+	handler := testServer(New(Config{}), u)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get(handler, "/foo").Body.String()
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), u.count())
+	for _, body := range bodies {
+		assert.Equal(t, "/foo 1 ", body)
+	}
+
+	// requests do not wait if disabled
+	u.set("/bar", http.StatusOK, http.Header{"Cache-Control": {"no-store"}})
+	handler = testServer(New(Config{CollapseTimeout: -1}), u)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(handler, "/bar")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(4), u.count())
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	c := New(Config{})
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.UseMiddleware(c.Middleware())
+	s.Register("*", func(context *gateway.Context) {
+		if context.Request.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		panic("upstream panic")
+	})
+	cfg := gateway.Config{}
+	cfg.Add("/panic", http.MethodGet, "panic")
+	cfg.Add("/abort", http.MethodGet, "abort")
+	s.UseConfig(cfg)
+	handler := s.Handler()
+
+	// the call is finished when the following handlers panic
+	assert.Equal(t, http.StatusInternalServerError, get(handler, "/panic").Code)
+	current, leader := c.calls.Join("/panic")
+	assert.True(t, leader)
+	current.Finish(struct{}{})
+
+	// and when the revalidation in background aborts
+	assert.Panics(t, func() { get(handler, "/abort") })
+	assert.NoError(t, c.Store().Set("/abort", &Entry{
+		Key: "/abort", StatusCode: http.StatusOK, Body: []byte("stale"),
+		Header:               http.Header{"Cache-Control": {"max-age=1"}},
+		Expires:              time.Now().Add(-time.Second),
+		StaleWhileRevalidate: time.Hour,
+		RemoveAt:             time.Now().Add(time.Hour),
+	}))
+	assert.Equal(t, "STALE", get(handler, "/abort").Header().Get(StatusHeader))
+	assert.Eventually(t, func() bool {
+		current, leader := c.calls.Join("/abort")
+		current.Finish(struct{}{})
+		return leader
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMiddleware_Stream(t *testing.T) {
+	u := newUpstream()
+	u.set("/stream", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}})
+	handler := testServer(New(Config{}), u)
+
+	assert.Equal(t, "stream 1", get(handler, "/stream").Body.String())
+	w := get(handler, "/stream")
+	assert.Equal(t, "stream 1", w.Body.String())
+	assert.Equal(t, "HIT", w.Header().Get(StatusHeader))
+
+	// streams larger than the limit are not cached
+	handler = testServer(New(Config{MaxEntrySize: 4}), u)
+	assert.Equal(t, "stream 2", get(handler, "/stream").Body.String())
+	assert.Equal(t, "stream 3", get(handler, "/stream").Body.String())
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(http.Header{"Cache-Control": {`Public, max-age="60"`, "stale-if-error=x"}})
+	assert.True(t, cc.has("public"))
+	d, ok := cc.seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+	_, ok = cc.seconds("stale-if-error")
+	assert.False(t, ok)
+
+	d, ok = lifetime(cacheControl{}, http.Header{
+		"Date":    {"Mon, 02 Jan 2006 15:04:05 GMT"},
+		"Expires": {"Mon, 02 Jan 2006 15:05:05 GMT"},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+	d, ok = lifetime(cacheControl{}, http.Header{"Expires": {"0"}})
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+	_, ok = lifetime(cacheControl{}, http.Header{})
+	assert.False(t, ok)
+}
+
+func TestCaptureReader(t *testing.T) {
+	var captured []byte
+	r := &captureReader{reader: strings.NewReader("hello"), limit: 5, done: func(body []byte) {
+		captured = body
+	}}
+	body, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "hello", string(captured))
+
+	captured = []byte("unset")
+	r = &captureReader{reader: strings.NewReader("hello"), limit: 4, done: func(body []byte) {
+		captured = body
+	}}
+	_, _ = ioutil.ReadAll(r)
+	assert.Nil(t, captured)
+}
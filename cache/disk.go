@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DiskStore is a Store keeping every entry in a file of a directory, so that entries survive restarts and
+// can be shared by processes on the same host.
+//
+// It is not bounded by size. Expired entries are removed when they are read, or by Cleanup.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore creates a DiskStore in the directory, which is created if it does not exist.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// Get gets the entry of the key. It returns nil if there is no entry.
+func (d *DiskStore) Get(key string) (*Entry, error) {
+	filename := d.filename(key)
+	entry, err := d.read(filename)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	if entry.Key != key {
+		// a hash collision
+		return nil, nil
+	}
+	if entry.expired(time.Now()) {
+		_ = os.Remove(filename)
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// Set stores the entry of the key, replacing the file atomically.
+func (d *DiskStore) Set(key string, entry *Entry) error {
+	stored := *entry
+	stored.Key = key
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&stored); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(d.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.filename(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete deletes the entry of the key, returning whether it existed.
+func (d *DiskStore) Delete(key string) (bool, error) {
+	err := os.Remove(d.filename(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeletePrefix deletes the entries whose keys start with the prefix, returning the number of deleted entries.
+// It reads every file in the directory.
+func (d *DiskStore) DeletePrefix(prefix string) (int, error) {
+	return d.deleteIf(func(entry *Entry) bool {
+		return strings.HasPrefix(entry.Key, prefix)
+	})
+}
+
+// Cleanup deletes expired entries, returning the number of deleted entries. It reads every file in the directory.
+func (d *DiskStore) Cleanup() (int, error) {
+	now := time.Now()
+	return d.deleteIf(func(entry *Entry) bool {
+		return entry.expired(now)
+	})
+}
+
+// deleteIf deletes the entries matching the function.
+func (d *DiskStore) deleteIf(match func(entry *Entry) bool) (int, error) {
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		filename := filepath.Join(d.dir, file.Name())
+		entry, err := d.read(filename)
+		if err != nil || entry == nil || !match(entry) {
+			continue
+		}
+		if err := os.Remove(filename); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// read reads an entry from a file. It returns nil if the file does not exist.
+func (d *DiskStore) read(filename string) (*Entry, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// filename gets the name of the file of the key.
+func (d *DiskStore) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	// Key is the key of the entry.
+	Key string
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// Header is the headers of the response.
+	Header http.Header
+	// Body is the body of the response.
+	Body []byte
+	// Date is when the response was generated.
+	Date time.Time
+	// Expires is when the response becomes stale.
+	Expires time.Time
+	// StaleWhileRevalidate is how long the response can be served after it becomes stale,
+	// while it is being revalidated in background.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long the response can be served after it becomes stale, if revalidating it fails.
+	StaleIfError time.Duration
+	// RemoveAt is when the entry is no longer useful and can be removed by the Store.
+	RemoveAt time.Time
+	// Vary is the names of request headers that select the response. If it is not empty, the entry has no response,
+	// and the responses are stored as separate entries for each combination of the values of these headers.
+	Vary []string
+}
+
+// size gets the approximate size of the entry in bytes.
+func (e *Entry) size() int64 {
+	size := int64(len(e.Key) + len(e.Body))
+	for key, values := range e.Header {
+		size += int64(len(key))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	for _, name := range e.Vary {
+		size += int64(len(name))
+	}
+	return size
+}
+
+// expired checks if the entry can be removed.
+func (e *Entry) expired(now time.Time) bool {
+	return !e.RemoveAt.IsZero() && now.After(e.RemoveAt)
+}
+
+// isVariants checks if the entry only points to variants selected by request headers.
+func (e *Entry) isVariants() bool {
+	return len(e.Vary) > 0
+}
+
+// age gets the age of the response.
+func (e *Entry) age(now time.Time) time.Duration {
+	if age := now.Sub(e.Date); age > 0 {
+		return age
+	}
+	return 0
+}
+
+// fresh checks if the response is fresh.
+func (e *Entry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// staleWithin checks if the response has been stale for no more than d.
+func (e *Entry) staleWithin(now time.Time, d time.Duration) bool {
+	return d > 0 && !now.After(e.Expires.Add(d))
+}
+
+// hasValidators checks if the response can be revalidated with a conditional request.
+func (e *Entry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// cacheControl is the directives of a Cache-Control header, with lower-case names.
+type cacheControl map[string]string
+
+// parseCacheControl parses the Cache-Control headers.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	return cc
+}
+
+// has checks if there is a directive.
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds gets the value of a directive in seconds.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// responseDate gets when a response was generated from its Date and Age headers.
+// It is now if the headers are missing or invalid.
+func responseDate(header http.Header, now time.Time) time.Time {
+	date := now
+	if d, err := http.ParseTime(header.Get("Date")); err == nil && d.Before(now) {
+		date = d
+	}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		if d := now.Add(-time.Duration(age) * time.Second); d.Before(date) {
+			date = d
+		}
+	}
+	return date
+}
+
+// lifetime gets the freshness lifetime of a response from s-maxage, max-age or Expires.
+// It returns false if the response has no explicit lifetime.
+func lifetime(cc cacheControl, header http.Header) (time.Duration, bool) {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d, true
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d, true
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid dates mean already expired
+			return 0, true
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		if d := t.Sub(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// varyNames gets the names of request headers in the Vary header. It returns "*" if the response varies on anything.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return []string{"*"}
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// variantKey gets the key of the variant of a response selected by the request headers.
+func variantKey(key string, vary []string, header http.Header) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMemoryStoreSize is the default size of a MemoryStore in bytes.
+const defaultMemoryStoreSize = 64 << 20
+
+// Store keeps cached entries. It must be safe for concurrent use.
+type Store interface {
+	// Get gets the entry of the key. It returns nil if there is no entry.
+	Get(key string) (*Entry, error)
+	// Set stores the entry of the key, replacing the existing one. Entries must not be modified after stored.
+	Set(key string, entry *Entry) error
+	// Delete deletes the entry of the key, returning whether it existed.
+	Delete(key string) (bool, error)
+	// DeletePrefix deletes the entries whose keys start with the prefix, returning the number of deleted entries.
+	DeletePrefix(prefix string) (int, error)
+}
+
+// MemoryStore is a Store in memory bounded by the total size of entries.
+// The least recently used entries are evicted when it is full.
+type MemoryStore struct {
+	maxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+// memoryItem is an item in the LRU list of a MemoryStore.
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// NewMemoryStore creates a MemoryStore holding up to maxSize bytes of entries. It is 64 MiB if maxSize is zero.
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	if maxSize < 0 {
+		panic("negative cache size")
+	}
+	if maxSize == 0 {
+		maxSize = defaultMemoryStoreSize
+	}
+	return &MemoryStore{maxSize: maxSize, lru: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get gets the entry of the key. It returns nil if there is no entry.
+func (m *MemoryStore) Get(key string) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := element.Value.(*memoryItem)
+	if item.entry.expired(time.Now()) {
+		m.remove(element)
+		return nil, nil
+	}
+	m.lru.MoveToFront(element)
+	return item.entry, nil
+}
+
+// Set stores the entry of the key, evicting the least recently used entries if it is full.
+// Entries larger than the size of the MemoryStore are not stored.
+func (m *MemoryStore) Set(key string, entry *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if element, ok := m.items[key]; ok {
+		m.remove(element)
+	}
+	size := entry.size()
+	if size > m.maxSize {
+		return nil
+	}
+	m.items[key] = m.lru.PushFront(&memoryItem{key: key, entry: entry, size: size})
+	m.size += size
+	for m.size > m.maxSize {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// Delete deletes the entry of the key, returning whether it existed.
+func (m *MemoryStore) Delete(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.items[key]
+	if ok {
+		m.remove(element)
+	}
+	return ok, nil
+}
+
+// DeletePrefix deletes the entries whose keys start with the prefix, returning the number of deleted entries.
+func (m *MemoryStore) DeletePrefix(prefix string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for key, element := range m.items {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(element)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Size gets the total size of entries in bytes.
+func (m *MemoryStore) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// Len gets the number of entries.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.items)
+}
+
+// remove removes an element. m.mu must be held.
+func (m *MemoryStore) remove(element *list.Element) {
+	item := m.lru.Remove(element).(*memoryItem)
+	delete(m.items, item.key)
+	m.size -= item.size
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore tests the behaviors shared by all Store.
+func testStore(t *testing.T, store Store) {
+	entry, err := store.Get("/foo")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+
+	assert.NoError(t, store.Set("/foo", &Entry{Key: "/foo", StatusCode: 200, Body: []byte("foo")}))
+	assert.NoError(t, store.Set("/foo?a=1", &Entry{Key: "/foo?a=1", Body: []byte("foo?a=1")}))
+	assert.NoError(t, store.Set("/bar", &Entry{Key: "/bar", Body: []byte("bar")}))
+	assert.NoError(t, store.Set("/expired", &Entry{Key: "/expired", RemoveAt: time.Now().Add(-time.Second)}))
+
+	entry, err = store.Get("/foo")
+	assert.NoError(t, err)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, 200, entry.StatusCode)
+		assert.Equal(t, "foo", string(entry.Body))
+	}
+	entry, err = store.Get("/expired")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+
+	n, err := store.DeletePrefix("/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	entry, _ = store.Get("/foo?a=1")
+	assert.Nil(t, entry)
+
+	ok, err := store.Delete("/bar")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = store.Delete("/bar")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(0))
+	assert.Panics(t, func() {
+		NewMemoryStore(-1)
+	})
+}
+
+func TestMemoryStore_Evict(t *testing.T) {
+	store := NewMemoryStore(30)
+	assert.NoError(t, store.Set("a", &Entry{Key: "a", Body: make([]byte, 9)}))
+	assert.NoError(t, store.Set("b", &Entry{Key: "b", Body: make([]byte, 9)}))
+	assert.NoError(t, store.Set("c", &Entry{Key: "c", Body: make([]byte, 9)}))
+	assert.Equal(t, int64(30), store.Size())
+
+	// "a" is used recently, so "b" is evicted
+	entry, _ := store.Get("a")
+	assert.NotNil(t, entry)
+	assert.NoError(t, store.Set("d", &Entry{Key: "d", Body: make([]byte, 9)}))
+	assert.Equal(t, 3, store.Len())
+	entry, _ = store.Get("b")
+	assert.Nil(t, entry)
+	entry, _ = store.Get("a")
+	assert.NotNil(t, entry)
+
+	// entries larger than the store are not stored
+	assert.NoError(t, store.Set("e", &Entry{Key: "e", Body: make([]byte, 30)}))
+	entry, _ = store.Get("e")
+	assert.Nil(t, entry)
+	assert.Equal(t, 3, store.Len())
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewDiskStore(dir)
+	assert.NoError(t, err)
+	testStore(t, store)
+
+	// entries are kept in files
+	assert.NoError(t, store.Set("/foo", &Entry{Key: "/foo", Body: []byte("foo"), RemoveAt: time.Now().Add(time.Hour)}))
+	assert.NoError(t, store.Set("/bar", &Entry{Key: "/bar", RemoveAt: time.Now().Add(-time.Second)}))
+	store, err = NewDiskStore(dir)
+	assert.NoError(t, err)
+	entry, err := store.Get("/foo")
+	assert.NoError(t, err)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, "foo", string(entry.Body))
+	}
+
+	n, err := store.Cleanup()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CallGroup collapses concurrent calls of the same key, such as identical requests to the upstream,
+// so that the first caller executes the call and the others wait for its result. The zero value is ready to use.
+type CallGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*Call[T]
+}
+
+// Call is a call of a key in a CallGroup.
+type Call[T any] struct {
+	group  *CallGroup[T]
+	key    string
+	done   chan struct{}
+	once   sync.Once
+	result T
+}
+
+// Join joins the call of the key, starting one if there is none. It returns true if the call is started,
+// in which case the caller executes it and calls Finish, even if it fails.
+func (g *CallGroup[T]) Join(key string) (*Call[T], bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if current, ok := g.calls[key]; ok {
+		return current, false
+	}
+	if g.calls == nil {
+		g.calls = map[string]*Call[T]{}
+	}
+	current := &Call[T]{group: g, key: key, done: make(chan struct{})}
+	g.calls[key] = current
+	return current, true
+}
+
+// Finish finishes the call with the result, waking up the callers waiting for it. New callers of the key start
+// another call afterwards. Only the first Finish has effect, and it does nothing if the Call is nil.
+func (c *Call[T]) Finish(result T) {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		c.group.mu.Lock()
+		if c.group.calls[c.key] == c {
+			delete(c.group.calls, c.key)
+		}
+		c.group.mu.Unlock()
+		c.result = result
+		close(c.done)
+	})
+}
+
+// Wait waits for the call to finish, up to the timeout or until the context is done.
+// It returns the result, and false if the call has not finished.
+func (c *Call[T]) Wait(ctx context.Context, timeout time.Duration) (T, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return c.result, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	var zero T
+	return zero, false
+}
+
+// DiffHeader gets the headers changed from before to after, such as the headers of a response set by
+// the following handlers of a middleware.
+func DiffHeader(before http.Header, after http.Header) http.Header {
+	diff := http.Header{}
+	for key, values := range after {
+		if !slices.Equal(before[key], values) {
+			diff[key] = append([]string(nil), values...)
+		}
+	}
+	return diff
+}
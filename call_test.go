@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallGroup(t *testing.T) {
+	g := &CallGroup[string]{}
+	first, leader := g.Join("foo")
+	assert.True(t, leader)
+	second, leader := g.Join("foo")
+	assert.False(t, leader)
+	assert.Same(t, first, second)
+	_, leader = g.Join("bar")
+	assert.True(t, leader)
+
+	// waiting times out
+	_, ok := second.Wait(context.Background(), time.Millisecond)
+	assert.False(t, ok)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = second.Wait(ctx, time.Minute)
+	assert.False(t, ok)
+
+	go first.Finish("result")
+	result, ok := second.Wait(context.Background(), time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, "result", result)
+	// only the first Finish has effect
+	first.Finish("other")
+	result, _ = first.Wait(context.Background(), time.Minute)
+	assert.Equal(t, "result", result)
+
+	// a new call is started afterwards
+	third, leader := g.Join("foo")
+	assert.True(t, leader)
+	assert.NotSame(t, first, third)
+	// finishing an old call does not finish the new one
+	first.Finish("")
+	fourth, leader := g.Join("foo")
+	assert.False(t, leader)
+	assert.Same(t, third, fourth)
+
+	var nilCall *Call[string]
+	assert.NotPanics(t, func() {
+		nilCall.Finish("")
+	})
+}
+
+func TestDiffHeader(t *testing.T) {
+	before := http.Header{"X-Same": {"1"}, "X-Changed": {"1"}, "X-Removed": {"1"}}
+	after := http.Header{"X-Same": {"1"}, "X-Changed": {"1", "2"}, "X-New": {"1"}}
+	diff := DiffHeader(before, after)
+	assert.Equal(t, http.Header{"X-Changed": {"1", "2"}, "X-New": {"1"}}, diff)
+	diff["X-New"][0] = "2"
+	assert.Equal(t, "1", after.Get("X-New"))
+}
@@ -0,0 +1,27 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP gets the IP of the client of the request.
+// If trustProxyHeaders is true, the last address in the X-Forwarded-For header is used, which is appended by
+// the proxy in front of the gateway. The addresses before it are not used, since clients can set them themselves.
+// Enable it only if the gateway is behind a trusted proxy.
+func ClientIP(req *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			addrs := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
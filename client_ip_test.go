@@ -0,0 +1,27 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", " 192.0.2.1 , 192.0.2.2 ")
+	assert.Equal(t, "10.0.0.1", ClientIP(req, false))
+	// the addresses set by the client are not used
+	assert.Equal(t, "192.0.2.2", ClientIP(req, true))
+	req.Header.Add("X-Forwarded-For", "192.0.2.3")
+	assert.Equal(t, "192.0.2.3", ClientIP(req, true))
+	req.Header.Set("X-Forwarded-For", "192.0.2.1,")
+	assert.Equal(t, "10.0.0.1", ClientIP(req, true))
+
+	req.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", ClientIP(req, true))
+	req.RemoteAddr = "@"
+	assert.Equal(t, "@", ClientIP(req, false))
+}
@@ -0,0 +1,248 @@
+// Package coalesce provides a middleware that coalesces identical concurrent requests of a Service,
+// so that one execution of the handler serves all of them.
+package coalesce
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// defaultMaxWait is the default time that requests wait for an identical request.
+	defaultMaxWait = 5 * time.Second
+	// defaultMaxBodySize is the default maximum size of a response stream read into memory to be shared.
+	defaultMaxBodySize = 1 << 20
+)
+
+// Rule is the rule of coalescing requests of a Service.
+type Rule struct {
+	// Headers is the names of request headers that identify requests, such as Accept-Language.
+	// Requests with different values of these headers are not coalesced.
+	Headers []string
+	// Query is the names of query parameters that identify requests. All query parameters identify requests
+	// if it is empty, and none does if IgnoreQuery is true.
+	Query []string
+	// IgnoreQuery coalesces requests regardless of their query parameters.
+	IgnoreQuery bool
+	// MaxWait is how long requests wait for an identical request. A request handles itself if it times out.
+	// It is 5 seconds if zero.
+	MaxWait time.Duration
+	// MaxBodySize is the maximum size of a response stream read into memory to be shared. Requests waiting for
+	// a larger response handle themselves. It is 1 MiB if zero.
+	MaxBodySize int64
+	// Credentials coalesces requests with Authorization or Cookie headers, which are not coalesced by default.
+	// Responses are then shared between different users, unless Headers includes the credential headers,
+	// so it is only for responses which do not depend on the user.
+	Credentials bool
+}
+
+// Config is the configuration of the coalescing middleware.
+type Config struct {
+	// Rules is the rules of Service, looked up with gateway.LookupService.
+	// Requests of Service without a rule are not coalesced.
+	Rules map[string]Rule
+}
+
+// response is a response shared by coalesced requests.
+type response struct {
+	statusCode int
+	header     http.Header
+	trailer    http.Header
+	body       []byte
+}
+
+// Middleware provides a middleware that coalesces identical concurrent GET and HEAD requests.
+//
+// Requests are identical if they have the same method, host, path, query parameters and headers of the Rule.
+// The first request runs the following handlers, and the others wait for it and get copies of its status code,
+// headers, trailers and body, without running the following handlers. The headers set before the middleware
+// calls the following handlers are not copied. Response streams are read into memory to be shared,
+// so Service of long-lived streams (such as server-sent events) should not be coalesced.
+//
+// Requests with credentials (Authorization or Cookie headers) are not coalesced unless Rule.Credentials is set.
+// Responses with a Set-Cookie header or "Cache-Control: private" or "no-store" are never shared,
+// and the waiting requests handle themselves.
+func Middleware(config Config) gateway.Handler {
+	rules := make(map[string]Rule, len(config.Rules))
+	for name, rule := range config.Rules {
+		if rule.MaxWait < 0 || rule.MaxBodySize < 0 {
+			panic("invalid coalescing rule of " + name)
+		}
+		if rule.MaxWait == 0 {
+			rule.MaxWait = defaultMaxWait
+		}
+		if rule.MaxBodySize == 0 {
+			rule.MaxBodySize = defaultMaxBodySize
+		}
+		rules[name] = rule
+	}
+	calls := &gateway.CallGroup[*response]{}
+
+	return func(context *gateway.Context) {
+		req := context.Request
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return
+		}
+		name, rule, ok := gateway.LookupService(rules, context.GetConfiguredServiceName())
+		if !ok {
+			return
+		}
+		if !rule.Credentials && (req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "") {
+			return
+		}
+		key := name + "|" + requestKey(req, rule)
+
+		current, leader := calls.Join(key)
+		if !leader {
+			resp, ok := current.Wait(req.Context(), rule.MaxWait)
+			switch {
+			case resp != nil:
+				resp.copyTo(context)
+				context.Interrupt()
+				return
+			case req.Context().Err() != nil:
+				context.Interrupt()
+				return
+			case !ok:
+				context.Logger.WithField("key", key).Warn("timed out waiting for coalesced request")
+			}
+			context.Next()
+			return
+		}
+
+		// the call is finished without a response if the handlers panic
+		defer current.Finish(nil)
+		before := context.Header.Clone()
+		context.Next()
+		current.Finish(share(context, before, rule.MaxBodySize))
+	}
+}
+
+// requestKey gets the key identifying identical requests by the Rule.
+func requestKey(req *http.Request, rule Rule) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(strings.ToLower(req.Host))
+	b.WriteString(req.URL.EscapedPath())
+	switch {
+	case rule.IgnoreQuery:
+	case len(rule.Query) == 0:
+		b.WriteByte('?')
+		b.WriteString(sortedQuery(req.URL.Query()))
+	default:
+		all := req.URL.Query()
+		selected := url.Values{}
+		for _, name := range rule.Query {
+			if values, ok := all[name]; ok {
+				selected[name] = values
+			}
+		}
+		b.WriteByte('?')
+		b.WriteString(sortedQuery(selected))
+	}
+	for _, name := range rule.Headers {
+		b.WriteByte(0)
+		b.WriteString(http.CanonicalHeaderKey(name))
+		b.WriteByte('=')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// sortedQuery encodes the query parameters sorted by name, keeping the order of values.
+func sortedQuery(values url.Values) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range values[name] {
+			parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// share gets the response of the Context to be shared. A response stream is read into memory, and is replaced
+// with the data read. It returns nil if the response cannot be shared.
+func share(context *gateway.Context, before http.Header, maxBodySize int64) *response {
+	header := gateway.DiffHeader(before, context.Header)
+	if !shareable(header) {
+		return nil
+	}
+	body := context.Response
+	if stream := context.ResponseStream; stream != nil {
+		data, err := ioutil.ReadAll(io.LimitReader(stream, maxBodySize+1))
+		if err != nil || int64(len(data)) > maxBodySize {
+			// the rest of the stream is still written to the first request
+			context.ResponseStream = &readCloser{Reader: io.MultiReader(bytes.NewReader(data), stream), stream: stream}
+			return nil
+		}
+		if closer, ok := stream.(io.Closer); ok {
+			_ = closer.Close()
+		}
+		context.ResponseStream = nil
+		context.Response = data
+		body = data
+	}
+	return &response{
+		statusCode: context.StatusCode,
+		header:     header,
+		trailer:    context.Trailer.Clone(),
+		body:       append([]byte(nil), body...),
+	}
+}
+
+// copyTo copies the response to the Context.
+func (r *response) copyTo(context *gateway.Context) {
+	context.StatusCode = r.statusCode
+	for key, values := range r.header {
+		context.Header[key] = append([]string(nil), values...)
+	}
+	for key, values := range r.trailer {
+		context.Trailer[key] = append([]string(nil), values...)
+	}
+	context.Response = append([]byte(nil), r.body...)
+	context.ResponseStream = nil
+}
+
+// shareable checks if the response headers allow sharing the response with other users.
+func shareable(header http.Header) bool {
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(strings.SplitN(directive, "=", 2)[0])
+			if strings.EqualFold(directive, "private") || strings.EqualFold(directive, "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readCloser reads the rest of a response stream, closing the stream afterwards.
+type readCloser struct {
+	io.Reader
+	stream io.Reader
+}
+
+// Close closes the stream if it is an io.Closer.
+func (r *readCloser) Close() error {
+	if closer, ok := r.stream.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
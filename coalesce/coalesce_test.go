@@ -0,0 +1,223 @@
+package coalesce
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHandler counts executions, and responds after a delay.
+type slowHandler struct {
+	executions int64
+	delay      time.Duration
+	stream     bool
+	// header is the headers of responses.
+	header http.Header
+}
+
+func (h *slowHandler) handle(context *gateway.Context) {
+	n := atomic.AddInt64(&h.executions, 1)
+	time.Sleep(h.delay)
+	context.StatusCode = http.StatusAccepted
+	context.Header.Set("X-Execution", strconv.FormatInt(n, 10))
+	context.Header.Add("X-Values", "a")
+	context.Header.Add("X-Values", "b")
+	for key, values := range h.header {
+		context.Header[key] = values
+	}
+	body := context.Request.URL.RequestURI() + " " + context.Request.Header.Get("Accept-Language")
+	if h.stream {
+		context.ResponseStream = ioutil.NopCloser(strings.NewReader(body))
+		return
+	}
+	context.Response = []byte(body)
+}
+
+func testServer(config Config, h *slowHandler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", http.MethodGet, "api.foo")
+	cfg.Add("/foo", http.MethodPost, "api.foo")
+	cfg.Add("/bar", http.MethodGet, "other.bar")
+	s.UseConfig(cfg)
+	s.UseMiddleware(func(context *gateway.Context) {
+		context.Header.Set("X-Request", context.Request.Header.Get("X-Request"))
+	})
+	s.UseMiddleware(Middleware(config))
+	s.Register("*", h.handle)
+	return s.Handler()
+}
+
+// concurrently sends requests concurrently, returning the responses.
+func concurrently(handler http.Handler, requests ...*http.Request) []*httptest.ResponseRecorder {
+	responses := make([]*httptest.ResponseRecorder, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			responses[i] = w
+		}(i, req)
+		// the first request starts the execution
+		if i == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+	return responses
+}
+
+func newRequest(method string, target string, header http.Header) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return req
+}
+
+func TestMiddleware(t *testing.T) {
+	h := &slowHandler{delay: 100 * time.Millisecond}
+	handler := testServer(Config{Rules: map[string]Rule{
+		"api": {Headers: []string{"accept-language"}, Query: []string{"id"}},
+	}}, h)
+
+	responses := concurrently(handler,
+		newRequest(http.MethodGet, "/foo?id=1&page=1", http.Header{"X-Request": {"1"}}),
+		newRequest(http.MethodGet, "/foo?page=2&id=1", http.Header{"X-Request": {"2"}}),
+		newRequest(http.MethodGet, "/foo?id=1", http.Header{"X-Request": {"3"}}),
+	)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&h.executions))
+	for i, w := range responses {
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "/foo?id=1&page=1 ", w.Body.String())
+		assert.Equal(t, "1", w.Header().Get("X-Execution"))
+		assert.Equal(t, []string{"a", "b"}, w.Header().Values("X-Values"))
+		// headers set before the middleware are kept
+		assert.Equal(t, strconv.Itoa(i+1), w.Header().Get("X-Request"))
+	}
+
+	// different selected query parameters, headers and methods are not coalesced
+	responses = concurrently(handler,
+		newRequest(http.MethodGet, "/foo?id=1", nil),
+		newRequest(http.MethodGet, "/foo?id=2", nil),
+		newRequest(http.MethodGet, "/foo?id=1", http.Header{"Accept-Language": {"fr"}}),
+		newRequest(http.MethodPost, "/foo?id=1", nil),
+	)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&h.executions))
+	assert.Equal(t, "/foo?id=1 fr", responses[2].Body.String())
+
+	// Service without a rule are not coalesced
+	concurrently(handler, newRequest(http.MethodGet, "/bar", nil), newRequest(http.MethodGet, "/bar", nil))
+	assert.Equal(t, int64(7), atomic.LoadInt64(&h.executions))
+}
+
+func TestMiddleware_Stream(t *testing.T) {
+	h := &slowHandler{delay: 100 * time.Millisecond, stream: true}
+	handler := testServer(Config{Rules: map[string]Rule{"*": {IgnoreQuery: true}}}, h)
+	responses := concurrently(handler,
+		newRequest(http.MethodGet, "/foo?a=1", nil),
+		newRequest(http.MethodGet, "/foo?a=2", nil),
+	)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&h.executions))
+	for _, w := range responses {
+		assert.Equal(t, "/foo?a=1 ", w.Body.String())
+	}
+
+	// waiters of larger streams handle themselves
+	handler = testServer(Config{Rules: map[string]Rule{"*": {MaxBodySize: 4}}}, h)
+	responses = concurrently(handler,
+		newRequest(http.MethodGet, "/foo", http.Header{"X-Request": {"1"}}),
+		newRequest(http.MethodGet, "/foo", http.Header{"X-Request": {"2"}}),
+	)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&h.executions))
+	for _, w := range responses {
+		assert.Equal(t, "/foo ", w.Body.String())
+	}
+}
+
+func TestMiddleware_MaxWait(t *testing.T) {
+	h := &slowHandler{delay: 200 * time.Millisecond}
+	handler := testServer(Config{Rules: map[string]Rule{"*": {MaxWait: 10 * time.Millisecond}}}, h)
+	responses := concurrently(handler, newRequest(http.MethodGet, "/foo", nil), newRequest(http.MethodGet, "/foo", nil))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&h.executions))
+	assert.Equal(t, "2", responses[1].Header().Get("X-Execution"))
+
+	assert.Panics(t, func() {
+		Middleware(Config{Rules: map[string]Rule{"*": {MaxWait: -1}}})
+	})
+}
+
+func TestMiddleware_Credentials(t *testing.T) {
+	h := &slowHandler{delay: 100 * time.Millisecond}
+	handler := testServer(Config{Rules: map[string]Rule{"api": {}, "other": {Credentials: true}}}, h)
+
+	// requests of different users are not coalesced
+	responses := concurrently(handler,
+		newRequest(http.MethodGet, "/foo", http.Header{"Authorization": {"Bearer alice"}}),
+		newRequest(http.MethodGet, "/foo", http.Header{"Authorization": {"Bearer bob"}}),
+	)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&h.executions))
+	assert.NotEqual(t, responses[0].Header().Get("X-Execution"), responses[1].Header().Get("X-Execution"))
+	concurrently(handler,
+		newRequest(http.MethodGet, "/foo", http.Header{"Cookie": {"session=alice"}}),
+		newRequest(http.MethodGet, "/foo", http.Header{"Cookie": {"session=bob"}}),
+	)
+	assert.Equal(t, int64(4), atomic.LoadInt64(&h.executions))
+
+	// unless the rule allows it
+	concurrently(handler,
+		newRequest(http.MethodGet, "/bar", http.Header{"Authorization": {"Bearer alice"}}),
+		newRequest(http.MethodGet, "/bar", http.Header{"Authorization": {"Bearer bob"}}),
+	)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&h.executions))
+
+	// requests of different hosts are not coalesced
+	other := newRequest(http.MethodGet, "/foo", nil)
+	other.Host = "other.example.com"
+	concurrently(handler, newRequest(http.MethodGet, "/foo", nil), other)
+	assert.Equal(t, int64(7), atomic.LoadInt64(&h.executions))
+}
+
+func TestMiddleware_Private(t *testing.T) {
+	for _, header := range []http.Header{
+		{"Set-Cookie": {"session=alice"}},
+		{"Cache-Control": {"private, max-age=60"}},
+		{"Cache-Control": {"No-Store"}},
+	} {
+		h := &slowHandler{delay: 100 * time.Millisecond, header: header}
+		handler := testServer(Config{Rules: map[string]Rule{"*": {}}}, h)
+		responses := concurrently(handler, newRequest(http.MethodGet, "/foo", nil), newRequest(http.MethodGet, "/foo", nil))
+		// the waiting request handles itself
+		assert.Equal(t, int64(2), atomic.LoadInt64(&h.executions), header)
+		assert.Equal(t, "2", responses[1].Header().Get("X-Execution"), header)
+	}
+}
+
+func TestShareable(t *testing.T) {
+	assert.True(t, shareable(http.Header{"Cache-Control": {"public, max-age=60"}}))
+	assert.False(t, shareable(http.Header{"Cache-Control": {`max-age=60, private="X-User"`}}))
+	assert.False(t, shareable(http.Header{"Cache-Control": {"no-store"}}))
+	assert.False(t, shareable(http.Header{"Set-Cookie": {"a=1"}}))
+}
+
+func TestRequestKey(t *testing.T) {
+	req := newRequest(http.MethodGet, "/foo?b=2&a=1&a=0", http.Header{"X-A": {"1", "2"}})
+	req.Host = "Example.com"
+	assert.Equal(t, "GET example.com/foo?a=1&a=0&b=2", requestKey(req, Rule{}))
+	assert.Equal(t, "GET example.com/foo", requestKey(req, Rule{IgnoreQuery: true}))
+	assert.Equal(t, "GET example.com/foo?b=2\x00X-A=1,2\x00X-B=", requestKey(req, Rule{Query: []string{"b", "c"},
+		Headers: []string{"x-a", "X-B"}}))
+}
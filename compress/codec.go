@@ -0,0 +1,166 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is a content coding, such as gzip.
+//
+// Brotli, zstd, gzip and deflate are built in. Other content codings can be added with any implementation.
+type Codec struct {
+	// Name is the name of the content coding in Accept-Encoding and Content-Encoding, in lower case.
+	Name string
+	// NewWriter creates a writer that compresses data written to it into w.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	// NewReader creates a reader that decompresses data from r.
+	// Request bodies of the content coding cannot be decompressed if it is nil.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// brotliQuality is the quality of Brotli, which is fast enough to compress responses on the fly.
+const brotliQuality = 5
+
+// Brotli is the br content coding.
+var Brotli = Codec{
+	Name: "br",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotliQuality), nil
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(brotli.NewReader(r)), nil
+	},
+}
+
+// zstdWindowSize is the maximum window size of zstd, which HTTP clients are required to support (RFC 8878).
+const zstdWindowSize = 8 << 20
+
+// Zstd is the zstd content coding.
+var Zstd = Codec{
+	Name: "zstd",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize))
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdWindowSize))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+}
+
+// Gzip is the gzip content coding.
+var Gzip = Codec{
+	Name: "gzip",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+// Deflate is the deflate content coding, which is the zlib format rather than raw deflate, as RFC 7230 defines.
+var Deflate = Codec{
+	Name: "deflate",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(w, zlib.DefaultCompression)
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	},
+}
+
+// acceptedEncoding is an item of the Accept-Encoding header.
+type acceptedEncoding struct {
+	name string
+	q    float64
+}
+
+// parseAcceptEncoding parses the Accept-Encoding header. Items with invalid q-values are ignored.
+func parseAcceptEncoding(header string) []acceptedEncoding {
+	var result []acceptedEncoding
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		valid := true
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil || v < 0 || v > 1 {
+					valid = false
+					break
+				}
+				q = v
+			}
+		}
+		if valid {
+			result = append(result, acceptedEncoding{name: name, q: q})
+		}
+	}
+	return result
+}
+
+// negotiate chooses the Codec with the highest q-value accepted by the client.
+// Codecs are preferred in the given order if they have the same q-value. It returns nil if none is acceptable.
+func negotiate(header string, codecs []Codec) *Codec {
+	accepted := parseAcceptEncoding(header)
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	var best *Codec
+	bestQ := 0.0
+	for i := range codecs {
+		q, wildcard := -1.0, -1.0
+		for _, a := range accepted {
+			if a.name == codecs[i].Name {
+				q = a.q
+			} else if a.name == "*" {
+				wildcard = a.q
+			}
+		}
+		if q < 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = &codecs[i], q
+		}
+	}
+	return best
+}
+
+// limitedReader is a reader which fails after reading more than the limit, to prevent decompression bombs.
+type limitedReader struct {
+	r     io.ReadCloser
+	limit int64
+	read  int64
+}
+
+// Read implements io.Reader.
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit >= 0 && l.read > l.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (l *limitedReader) Close() error {
+	return l.r.Close()
+}
@@ -0,0 +1,254 @@
+// Package compress provides a middleware that compresses responses with the content coding negotiated by
+// Accept-Encoding, and optionally decompresses request bodies.
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// defaultMinSize is the default minimum size of a response body to be compressed.
+	defaultMinSize = 1024
+	// defaultMaxDecompressedSize is the default maximum size of a decompressed request body.
+	defaultMaxDecompressedSize = 10 << 20
+)
+
+// defaultSkipContentTypes is the default content types that are already compressed.
+var defaultSkipContentTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/zstd", "application/wasm",
+	"application/octet-stream",
+}
+
+// errBodyTooLarge is the error of reading a decompressed request body larger than the limit.
+var errBodyTooLarge = errors.New("decompressed request body too large")
+
+// Config is the configuration of the compression middleware.
+type Config struct {
+	// Codecs is a list of content codings, in the order of preference.
+	// Brotli, Zstd, Gzip and Deflate are used if it is empty.
+	Codecs []Codec
+	// MinSize is the minimum size in bytes of a response body to be compressed. It is 1024 if zero.
+	// Streamed bodies are compressed unless the Content-Length header is known to be smaller.
+	MinSize int
+	// SkipContentTypes is a list of content types (or prefixes ending with a slash, such as "image/")
+	// which are not compressed. Common compressed formats are skipped if it is nil.
+	SkipContentTypes []string
+	// DecompressRequests decompresses request bodies with Content-Encoding of one of the Codecs.
+	// Requests with other content codings are rejected with http.StatusUnsupportedMediaType.
+	DecompressRequests bool
+	// MaxDecompressedSize is the maximum size in bytes of a decompressed request body, which prevents
+	// decompression bombs. It is 10 MiB if zero, and there is no limit if it is negative.
+	// Reading a larger body fails with an error.
+	MaxDecompressedSize int64
+}
+
+// Middleware provides a middleware with default configurations for compression. See MiddlewareWithConfig.
+func Middleware() gateway.Handler {
+	return MiddlewareWithConfig(Config{})
+}
+
+// MiddlewareWithConfig provides a middleware that compresses Context.Response or Context.ResponseStream
+// after the following handlers have been run.
+//
+// Responses are not compressed if they already have a Content-Encoding, "Cache-Control: no-transform",
+// a content type to skip, or no content, or if the request is gRPC. "Vary: Accept-Encoding" is added to every compressible response.
+// The Content-Length is updated, and a strong ETag gets the name of the content coding as a suffix,
+// since the compressed representation is different.
+func MiddlewareWithConfig(config Config) gateway.Handler {
+	if len(config.Codecs) == 0 {
+		config.Codecs = []Codec{Brotli, Zstd, Gzip, Deflate}
+	}
+	if config.MaxDecompressedSize == 0 {
+		config.MaxDecompressedSize = defaultMaxDecompressedSize
+	}
+	if config.MinSize == 0 {
+		config.MinSize = defaultMinSize
+	}
+	if config.SkipContentTypes == nil {
+		config.SkipContentTypes = defaultSkipContentTypes
+	}
+
+	return func(context *gateway.Context) {
+		if config.DecompressRequests && !decompressRequest(context, config) {
+			return
+		}
+		context.Next()
+		compressResponse(context, config)
+	}
+}
+
+// decompressRequest replaces the request body with a decompressed one.
+// It returns false if the request has been rejected.
+func decompressRequest(context *gateway.Context, config Config) bool {
+	req := context.Request
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+
+	for _, codec := range config.Codecs {
+		if codec.Name != encoding || codec.NewReader == nil {
+			continue
+		}
+		reader, err := codec.NewReader(req.Body)
+		if err != nil {
+			context.Logger.WithError(err).Warn("failed to decompress request body")
+			context.AbortWithStatus(http.StatusBadRequest)
+			return false
+		}
+		req.Body = &limitedReader{r: reader, limit: config.MaxDecompressedSize}
+		req.ContentLength = -1
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Content-Length")
+		return true
+	}
+	context.AbortWithStatus(http.StatusUnsupportedMediaType)
+	return false
+}
+
+// compressResponse compresses the response if it is compressible and the client accepts one of the Codecs.
+func compressResponse(context *gateway.Context, config Config) {
+	header := context.Header
+	if context.StatusCode < http.StatusOK || context.StatusCode == http.StatusNoContent ||
+		context.StatusCode == http.StatusNotModified || context.StatusCode == http.StatusPartialContent {
+		return
+	}
+	if header.Get("Content-Encoding") != "" || strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return
+	}
+	if gateway.IsGRPC(context.Request) {
+		// gRPC compresses messages itself, and its framing must not be changed
+		return
+	}
+	if context.ResponseStream == nil && len(context.Response) == 0 {
+		return
+	}
+	if isSkippedContentType(contentType(context), config.SkipContentTypes) {
+		return
+	}
+
+	// the response is compressible, so it varies by Accept-Encoding whether it is compressed or not
+	addVary(header, "Accept-Encoding")
+
+	if context.ResponseStream == nil && len(context.Response) < config.MinSize {
+		return
+	}
+	if context.ResponseStream != nil {
+		if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < config.MinSize {
+			return
+		}
+	}
+	codec := negotiate(context.Request.Header.Get("Accept-Encoding"), config.Codecs)
+	if codec == nil {
+		return
+	}
+	if header.Get("Content-Type") == "" {
+		if context.ResponseStream != nil {
+			// the content type of a stream cannot be detected once it is compressed
+			return
+		}
+		// net/http would detect the content type from the compressed body otherwise
+		header.Set("Content-Type", http.DetectContentType(context.Response))
+	}
+
+	if context.ResponseStream != nil {
+		context.ResponseStream = compressStream(context.ResponseStream, *codec)
+		header.Del("Content-Length")
+	} else {
+		buf := &bytes.Buffer{}
+		w, err := codec.NewWriter(buf)
+		if err == nil {
+			_, err = w.Write(context.Response)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			context.Logger.WithError(err).Error("failed to compress response")
+			return
+		}
+		if buf.Len() >= len(context.Response) {
+			// not worth it
+			return
+		}
+		context.Response = buf.Bytes()
+		if header.Get("Content-Length") != "" {
+			header.Set("Content-Length", strconv.Itoa(len(context.Response)))
+		}
+	}
+
+	header.Set("Content-Encoding", codec.Name)
+	header.Del("Accept-Ranges")
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) && strings.HasSuffix(etag, `"`) && len(etag) >= 2 {
+		header.Set("ETag", etag[:len(etag)-1]+"-"+codec.Name+`"`)
+	}
+}
+
+// compressStream returns a reader of the compressed stream. The compression runs in a goroutine,
+// which stops when the returned reader is closed.
+func compressStream(stream io.Reader, codec Codec) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		if closer, ok := stream.(io.Closer); ok {
+			defer closer.Close()
+		}
+		w, err := codec.NewWriter(pw)
+		if err == nil {
+			_, err = io.Copy(w, stream)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// contentType gets the media type of the response, detecting it from the body if it is not set.
+func contentType(context *gateway.Context) string {
+	ct := context.Header.Get("Content-Type")
+	if ct == "" && context.ResponseStream == nil {
+		ct = http.DetectContentType(context.Response)
+	}
+	if mediaType, _, err := mime.ParseMediaType(ct); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(ct)
+}
+
+// isSkippedContentType checks if the media type matches one of the content types to skip.
+func isSkippedContentType(mediaType string, skip []string) bool {
+	for _, s := range skip {
+		if strings.HasSuffix(s, "/") {
+			if strings.HasPrefix(mediaType, s) {
+				return true
+			}
+		} else if mediaType == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds a value to the Vary header if it is not there.
+func addVary(header http.Header, value string) {
+	for _, v := range header["Vary"] {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
@@ -0,0 +1,205 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+var largeBody = strings.Repeat("hello, world. ", 200)
+
+func testServer(compress gateway.Handler, handler gateway.Handler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	cfg.Add("/hello", http.MethodPost, "api.hello")
+	s.UseConfig(cfg)
+	s.UseMiddleware(compress)
+	s.Register("api.hello", handler)
+	return s.Handler()
+}
+
+func get(handler http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_Buffered(t *testing.T) {
+	handler := testServer(Middleware(), func(context *gateway.Context) {
+		context.Header.Set("ETag", `"v1"`)
+		context.Header.Set("Content-Length", "2800")
+		context.Response = []byte(largeBody)
+	})
+
+	w := get(handler, "deflate;q=0.5, gzip;q=0.8")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `"v1-gzip"`, w.Header().Get("ETag"))
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+	r, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, largeBody, string(body))
+
+	w = get(handler, "gzip;q=0.5, deflate")
+	assert.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+	zr, err := zlib.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(zr)
+	assert.Equal(t, largeBody, string(body))
+
+	w = get(handler, "gzip, br")
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	body, _ = ioutil.ReadAll(brotli.NewReader(w.Body))
+	assert.Equal(t, largeBody, string(body))
+
+	w = get(handler, "gzip, zstd")
+	assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"v1-zstd"`, w.Header().Get("ETag"))
+	zd, err := zstd.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(zd)
+	zd.Close()
+	assert.Equal(t, largeBody, string(body))
+
+	for _, ae := range []string{"", "identity", "gzip;q=0, *;q=0", "compress"} {
+		w = get(handler, ae)
+		assert.Empty(t, w.Header().Get("Content-Encoding"), ae)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, largeBody, w.Body.String())
+	}
+	assert.Equal(t, "br", get(handler, "*").Header().Get("Content-Encoding"))
+}
+
+func TestMiddleware_Skip(t *testing.T) {
+	small := testServer(Middleware(), func(context *gateway.Context) {
+		context.Response = []byte("small")
+	})
+	w := get(small, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	image := testServer(Middleware(), func(context *gateway.Context) {
+		context.Header.Set("Content-Type", "image/png")
+		context.Response = []byte(largeBody)
+	})
+	w = get(image, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	grpc := testServer(Middleware(), func(context *gateway.Context) {
+		context.Header.Set("Content-Type", "application/grpc+proto")
+		context.Response = []byte(largeBody)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/hello", nil)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	grpc.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, largeBody, w.Body.String())
+}
+
+func TestMiddleware_Stream(t *testing.T) {
+	handler := testServer(Middleware(), func(context *gateway.Context) {
+		context.Header.Set("Content-Type", "application/json")
+		context.ResponseStream = strings.NewReader(largeBody)
+	})
+	w := get(handler, "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	r, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(r)
+	assert.Equal(t, largeBody, string(body))
+}
+
+func TestMiddleware_DecompressRequests(t *testing.T) {
+	handler := testServer(MiddlewareWithConfig(Config{DecompressRequests: true, MaxDecompressedSize: 100}),
+		func(context *gateway.Context) {
+			body, err := ioutil.ReadAll(context.Request.Body)
+			if err != nil {
+				context.StatusCode = http.StatusRequestEntityTooLarge
+				return
+			}
+			context.Response = body
+		})
+
+	post := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/hello", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	gzipped := func(s string) []byte {
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		_, _ = w.Write([]byte(s))
+		_ = w.Close()
+		return buf.Bytes()
+	}
+
+	w := post("gzip", gzipped("hello"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("gzip", gzipped(largeBody)).Code)
+	assert.Equal(t, http.StatusBadRequest, post("gzip", []byte("not gzip")).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, post("compress", []byte("data")).Code)
+
+	buf := &bytes.Buffer{}
+	bw := brotli.NewWriter(buf)
+	_, _ = bw.Write([]byte("hello"))
+	_ = bw.Close()
+	w = post("br", buf.Bytes())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+
+	buf = &bytes.Buffer{}
+	zw, err := zstd.NewWriter(buf)
+	assert.NoError(t, err)
+	_, _ = zw.Write([]byte("hello"))
+	_ = zw.Close()
+	w = post("zstd", buf.Bytes())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+
+	// decompressed bodies are limited to 10 MiB by default
+	handler = testServer(MiddlewareWithConfig(Config{DecompressRequests: true}), func(context *gateway.Context) {
+		body, err := ioutil.ReadAll(context.Request.Body)
+		if err != nil {
+			context.StatusCode = http.StatusRequestEntityTooLarge
+			return
+		}
+		context.Response = []byte(strconv.Itoa(len(body)))
+	})
+	w = post("gzip", gzipped(strings.Repeat("a", 10<<20)))
+	assert.Equal(t, strconv.Itoa(10<<20), w.Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("gzip", gzipped(strings.Repeat("a", 10<<20+1))).Code)
+}
+
+func TestNegotiate(t *testing.T) {
+	codecs := []Codec{Gzip, Deflate}
+	assert.Equal(t, "gzip", negotiate("gzip, deflate", codecs).Name)
+	assert.Equal(t, "deflate", negotiate("gzip;q=0.1, deflate;q=0.2", codecs).Name)
+	assert.Equal(t, "deflate", negotiate("gzip;q=0, *", codecs).Name)
+	assert.Equal(t, "gzip", negotiate("gzip;q=invalid, deflate;q=0.1, gzip;q=1", codecs).Name)
+	assert.Nil(t, negotiate("identity", codecs))
+	assert.Nil(t, negotiate("", codecs))
+}
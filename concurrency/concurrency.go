@@ -0,0 +1,190 @@
+// Package concurrency provides a middleware that limits the number of concurrent requests of Service,
+// queueing requests by priority and shedding excess load with "503 Service Unavailable".
+// The limits can be adjusted adaptively by the observed latency.
+package concurrency
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/metrics"
+)
+
+const (
+	// defaultMaxWait is the default time that requests wait in the queue.
+	defaultMaxWait = time.Second
+	// defaultBackoff is the default ratio of decreasing the limit of AIMD.
+	defaultBackoff = 0.9
+	// defaultRetryAfter is the default value of the Retry-After header of shed requests.
+	defaultRetryAfter = time.Second
+)
+
+// Algorithm is the algorithm adjusting a Limit.
+type Algorithm int
+
+const (
+	// Fixed keeps the limit unchanged.
+	Fixed Algorithm = iota
+	// AIMD increases the limit additively (by one for a limit's worth of requests) when requests succeed
+	// within Limit.Latency while the limit is in use, and decreases it multiplicatively by Limit.Backoff
+	// when requests fail with a 5xx status or take longer.
+	AIMD
+	// Gradient adjusts the limit by the ratio of the long-term average latency to the short-term average latency,
+	// so that the limit decreases as soon as requests queue up in the upstream and the latency rises.
+	Gradient
+)
+
+// Priority is the priority class of a request. Requests of higher priority leave the queue earlier,
+// and evict requests of lower priority if the queue is full.
+type Priority int
+
+const (
+	// Low is the priority of requests that are shed first, such as batch jobs.
+	Low Priority = iota - 1
+	// Normal is the default priority.
+	Normal
+	// High is the priority of requests that are preferred, such as interactive ones.
+	High
+	// Critical is the priority of requests that are never queued or shed, such as health checks.
+	// They are still counted by the limit.
+	Critical
+)
+
+// String returns the name of the Priority.
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Normal:
+		return "normal"
+	case High:
+		return "high"
+	case Critical:
+		return "critical"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// Limit is the limit of concurrent requests.
+type Limit struct {
+	// Algorithm is the algorithm adjusting the limit. It is Fixed by default.
+	Algorithm Algorithm
+	// Limit is the maximum number of concurrent requests, which is the initial one of adaptive algorithms.
+	Limit int
+	// MinLimit is the minimum limit of adaptive algorithms. It is 1 if zero.
+	MinLimit int
+	// MaxLimit is the maximum limit of adaptive algorithms. It is 10 times of Limit if zero.
+	MaxLimit int
+	// Queue is the maximum number of requests waiting for the limit. Requests are shed immediately if it is zero.
+	Queue int
+	// MaxWait is the maximum time that requests wait in the queue. It is 1 second if zero.
+	MaxWait time.Duration
+	// Latency is the latency above which AIMD decreases the limit. It is required by AIMD.
+	Latency time.Duration
+	// Backoff is the ratio of decreasing the limit by AIMD, between 0 and 1. It is 0.9 if zero.
+	Backoff float64
+	// RetryAfter is the value of the Retry-After header of shed requests. It is 1 second if zero.
+	RetryAfter time.Duration
+}
+
+// PriorityFunc gets the priority class of a request.
+type PriorityFunc func(context *gateway.Context) Priority
+
+// Config is the configuration of the concurrency limiting middleware.
+type Config struct {
+	// Limits is the limits of Service, looked up with gateway.LookupService. Requests are counted separately for each name in Limits, so all Service under "api.foo"
+	// share the same limit if only "api.foo" is configured. Requests of Service without a limit are not limited.
+	Limits map[string]Limit
+	// Priority gets the priority class of a request. All requests are Normal if it is nil.
+	Priority PriorityFunc
+	// Metrics records the state of the limits, labeled by the names in Limits, and the shed requests.
+	// The state is not recorded if it is nil.
+	Metrics *metrics.Metrics
+}
+
+// Middleware provides a middleware that limits the number of concurrent requests.
+//
+// Requests over the limit wait in a queue ordered by priority. Requests are shed if the queue is full,
+// if they wait for longer than Limit.MaxWait, or if they are evicted by a request of higher priority.
+// Shed requests get "503 Service Unavailable" from the error handler of the Server, with a Retry-After header.
+// The latency of a request is measured until its response has been written.
+func Middleware(config Config) gateway.Handler {
+	limiters := make(map[string]*limiter, len(config.Limits))
+	for name, limit := range config.Limits {
+		if limit.Limit <= 0 || limit.Queue < 0 || limit.MaxWait < 0 || limit.MinLimit < 0 || limit.MaxLimit < 0 ||
+			limit.Backoff < 0 || limit.Backoff >= 1 || (limit.Algorithm == AIMD && limit.Latency <= 0) {
+			panic("invalid concurrency limit of " + name)
+		}
+		if limit.MinLimit == 0 {
+			limit.MinLimit = 1
+		}
+		if limit.MaxLimit == 0 {
+			limit.MaxLimit = limit.Limit * 10
+		}
+		if limit.MinLimit > limit.Limit || limit.MaxLimit < limit.Limit {
+			panic("invalid concurrency limit of " + name)
+		}
+		if limit.MaxWait == 0 {
+			limit.MaxWait = defaultMaxWait
+		}
+		if limit.Backoff == 0 {
+			limit.Backoff = defaultBackoff
+		}
+		if limit.RetryAfter == 0 {
+			limit.RetryAfter = defaultRetryAfter
+		}
+		limiters[name] = newLimiter(name, limit, config.Metrics)
+	}
+	if config.Priority == nil {
+		config.Priority = func(*gateway.Context) Priority {
+			return Normal
+		}
+	}
+
+	return func(context *gateway.Context) {
+		_, l, _ := gateway.LookupService(limiters, context.GetConfiguredServiceName())
+		if l == nil {
+			return
+		}
+		priority := config.Priority(context)
+		if reason := l.acquire(context.Request.Context(), priority); reason != "" {
+			if config.Metrics != nil {
+				config.Metrics.ObserveShed(l.name, priority.String(), reason)
+			}
+			context.Logger.WithField("limiter", l.name).WithField("reason", reason).Info("request shed")
+			context.Header.Set("Retry-After", strconv.Itoa(int((l.config.RetryAfter+time.Second-1)/time.Second)))
+			context.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		context.OnFinish(func() {
+			l.release(time.Since(start), context.StatusCode >= http.StatusInternalServerError)
+		})
+	}
+}
+
+// ByHeader gets the priority class by the value of a request header. Requests with other values are Normal.
+// Enable it only for headers set by trusted clients or proxies, since clients can choose their own priority.
+func ByHeader(name string, classes map[string]Priority) PriorityFunc {
+	return func(context *gateway.Context) Priority {
+		if priority, ok := classes[context.Request.Header.Get(name)]; ok {
+			return priority
+		}
+		return Normal
+	}
+}
+
+// ByService gets the priority class of the Service, looked up with gateway.LookupService.
+// Requests of other Service are Normal.
+func ByService(classes map[string]Priority) PriorityFunc {
+	return func(context *gateway.Context) Priority {
+		if _, priority, ok := gateway.LookupService(classes, context.GetConfiguredServiceName()); ok {
+			return priority
+		}
+		return Normal
+	}
+}
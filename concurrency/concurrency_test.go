@@ -0,0 +1,128 @@
+package concurrency
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/metrics"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(config Config, release chan struct{}) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", http.MethodGet, "api.foo")
+	cfg.Add("/bar", http.MethodGet, "api.bar")
+	cfg.Add("/health", http.MethodGet, "health")
+	s.UseConfig(cfg)
+	s.UseMiddleware(Middleware(config))
+	s.SetErrorHandler(http.StatusServiceUnavailable, func(context *gateway.Context) {
+		context.Response = []byte("overloaded")
+	})
+	s.Register("api", func(context *gateway.Context) {
+		<-release
+		context.Response = []byte("ok")
+	})
+	s.Register("health", func(context *gateway.Context) {
+		context.Response = []byte("healthy")
+	})
+	return s.Handler()
+}
+
+func get(handler http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	m := metrics.New()
+	release := make(chan struct{})
+	handler := testServer(Config{
+		Limits:   map[string]Limit{"api": {Limit: 1, Queue: 1, MaxWait: time.Second, RetryAfter: 1500 * time.Millisecond}},
+		Priority: ByHeader("X-Priority", map[string]Priority{"batch": Low}),
+		Metrics:  m,
+	}, release)
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 2)
+	for i, path := range []string{"/foo", "/bar"} {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			responses[i] = get(handler, path, nil)
+		}(i, path)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// api.foo and api.bar share the limit of api, and the queue is full
+	w := get(handler, "/foo", http.Header{"X-Priority": {"batch"}})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "overloaded", w.Body.String())
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// Service without a limit are not limited
+	assert.Equal(t, "healthy", get(handler, "/health", nil).Body.String())
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, m.Registry.WriteText(buf))
+	text := buf.String()
+	assert.Contains(t, text, `gateway_concurrency_limit{limiter="api"} 1`)
+	assert.Contains(t, text, `gateway_concurrency_in_flight{limiter="api"} 1`)
+	assert.Contains(t, text, `gateway_concurrency_queued{limiter="api"} 1`)
+	assert.Contains(t, text, `gateway_requests_shed_total{limiter="api",priority="low",reason="queue_full"} 1`)
+
+	close(release)
+	wg.Wait()
+	for _, w := range responses {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ok", w.Body.String())
+	}
+	buf.Reset()
+	assert.NoError(t, m.Registry.WriteText(buf))
+	assert.Contains(t, buf.String(), `gateway_concurrency_in_flight{limiter="api"} 0`)
+}
+
+func TestMiddleware_Invalid(t *testing.T) {
+	for _, limit := range []Limit{
+		{},
+		{Limit: 1, Queue: -1},
+		{Limit: 1, Backoff: 1},
+		{Limit: 1, Algorithm: AIMD},
+		{Limit: 10, MinLimit: 20},
+		{Limit: 10, MaxLimit: 5},
+	} {
+		assert.Panics(t, func() {
+			Middleware(Config{Limits: map[string]Limit{"api": limit}})
+		})
+	}
+}
+
+func TestByService(t *testing.T) {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", http.MethodGet, "api.foo")
+	cfg.Add("/bar", http.MethodGet, "batch.bar")
+	cfg.Add("/baz", http.MethodGet, "baz")
+	s.UseConfig(cfg)
+	priority := ByService(map[string]Priority{"api": High, "batch": Low})
+	s.Register("*", func(context *gateway.Context) {
+		context.Response = []byte(priority(context).String())
+	})
+	handler := s.Handler()
+	assert.Equal(t, "high", get(handler, "/foo", nil).Body.String())
+	assert.Equal(t, "low", get(handler, "/bar", nil).Body.String())
+	assert.Equal(t, "normal", get(handler, "/baz", nil).Body.String())
+}
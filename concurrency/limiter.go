@@ -0,0 +1,206 @@
+package concurrency
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway/metrics"
+)
+
+const (
+	// gradientSmoothing is the weight of a new limit of the Gradient algorithm.
+	gradientSmoothing = 0.2
+	// longRTTWeight is the weight of a sample in the long-term latency of the Gradient algorithm.
+	longRTTWeight = 1.0 / 600
+	// shortRTTWeight is the weight of a sample in the short-term latency of the Gradient algorithm.
+	shortRTTWeight = 0.1
+)
+
+// Reasons of shedding requests.
+const (
+	reasonQueueFull = "queue_full"
+	reasonEvicted   = "evicted"
+	reasonTimeout   = "timeout"
+	reasonCanceled  = "canceled"
+)
+
+// limiter limits the concurrency of requests of a Limit.
+type limiter struct {
+	name    string
+	config  Limit
+	metrics *metrics.Metrics
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queue    []*waiter
+	// longRTT and shortRTT are the long-term and short-term latency in seconds of the Gradient algorithm.
+	longRTT  float64
+	shortRTT float64
+}
+
+// waiter is a request waiting in the queue.
+type waiter struct {
+	priority Priority
+	// ready receives true when the request is admitted, or false when it is evicted by a request of higher priority.
+	ready chan bool
+}
+
+// newLimiter creates a limiter of the Limit.
+func newLimiter(name string, config Limit, m *metrics.Metrics) *limiter {
+	l := &limiter{name: name, config: config, metrics: m, limit: float64(config.Limit)}
+	l.report()
+	return l
+}
+
+// acquire admits a request, waiting in the queue if the limit is reached.
+// It returns an empty string if the request is admitted, or the reason of shedding it.
+func (l *limiter) acquire(ctx context.Context, priority Priority) string {
+	l.mu.Lock()
+	if priority == Critical || (l.inFlight < l.currentLimit() && len(l.queue) == 0) {
+		l.inFlight++
+		l.report()
+		l.mu.Unlock()
+		return ""
+	}
+	if len(l.queue) >= l.config.Queue {
+		// evict the queued request of the lowest priority in favor of a higher one
+		last := len(l.queue) - 1
+		if last < 0 || l.queue[last].priority >= priority {
+			l.mu.Unlock()
+			return reasonQueueFull
+		}
+		l.queue[last].ready <- false
+		l.queue = l.queue[:last]
+	}
+	w := &waiter{priority: priority, ready: make(chan bool, 1)}
+	l.enqueue(w)
+	l.report()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.config.MaxWait)
+	defer timer.Stop()
+	reason := ""
+	select {
+	case ok := <-w.ready:
+		if ok {
+			return ""
+		}
+		return reasonEvicted
+	case <-timer.C:
+		reason = reasonTimeout
+	case <-ctx.Done():
+		reason = reasonCanceled
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dequeue(w) {
+		// it has been admitted or evicted meanwhile
+		if <-w.ready {
+			return ""
+		}
+		return reasonEvicted
+	}
+	l.report()
+	return reason
+}
+
+// release releases an admitted request, adjusting the limit by its latency and whether it has failed,
+// and admits queued requests.
+func (l *limiter) release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inFlight := l.inFlight
+	l.inFlight--
+	switch l.config.Algorithm {
+	case AIMD:
+		l.aimd(latency, failed, inFlight)
+	case Gradient:
+		l.gradient(latency, inFlight)
+	}
+	for len(l.queue) > 0 && l.inFlight < l.currentLimit() {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+		l.inFlight++
+		w.ready <- true
+	}
+	l.report()
+}
+
+// aimd adjusts the limit additively when requests succeed in time while the limit is in use,
+// and multiplicatively when they fail or are slow.
+func (l *limiter) aimd(latency time.Duration, failed bool, inFlight int) {
+	if failed || latency > l.config.Latency {
+		l.setLimit(l.limit * l.config.Backoff)
+	} else if float64(inFlight)*2 >= l.limit {
+		l.setLimit(l.limit + 1/l.limit)
+	}
+}
+
+// gradient adjusts the limit by the ratio of the long-term latency to the short-term latency,
+// with headroom of the square root of the limit for queueing.
+func (l *limiter) gradient(latency time.Duration, inFlight int) {
+	rtt := latency.Seconds()
+	if rtt <= 0 {
+		return
+	}
+	if l.longRTT == 0 {
+		l.longRTT, l.shortRTT = rtt, rtt
+		return
+	}
+	l.shortRTT += (rtt - l.shortRTT) * shortRTTWeight
+	l.longRTT += (rtt - l.longRTT) * longRTTWeight
+	if l.longRTT > l.shortRTT*2 {
+		// the latency has dropped for a long time, so let the long-term latency catch up
+		l.longRTT *= 0.95
+	}
+	gradient := math.Max(0.5, math.Min(1, l.longRTT/l.shortRTT))
+	if gradient == 1 && float64(inFlight)*2 < l.limit {
+		// do not grow the limit if it is not in use
+		return
+	}
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	l.setLimit(l.limit*(1-gradientSmoothing) + newLimit*gradientSmoothing)
+}
+
+// setLimit sets the limit within the bounds.
+func (l *limiter) setLimit(limit float64) {
+	l.limit = math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), limit))
+}
+
+// currentLimit gets the limit as an integer.
+func (l *limiter) currentLimit() int {
+	return int(l.limit)
+}
+
+// enqueue inserts the waiter in the order of priority and arrival.
+func (l *limiter) enqueue(w *waiter) {
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].priority < w.priority {
+		i--
+	}
+	l.queue = append(l.queue, nil)
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = w
+}
+
+// dequeue removes the waiter from the queue. It returns false if it is not in the queue.
+func (l *limiter) dequeue(w *waiter) bool {
+	for i, queued := range l.queue {
+		if queued == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// report records the state in metrics. l.mu must be held.
+func (l *limiter) report() {
+	if l.metrics != nil {
+		l.metrics.SetConcurrency(l.name, l.currentLimit(), l.inFlight, len(l.queue))
+	}
+}
@@ -0,0 +1,115 @@
+package concurrency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Queue(t *testing.T) {
+	l := newLimiter("api", Limit{Limit: 1, MinLimit: 1, MaxLimit: 1, Queue: 2, MaxWait: time.Second}, nil)
+	ctx := context.Background()
+	assert.Equal(t, "", l.acquire(ctx, Normal))
+
+	results := map[string]chan string{}
+	start := func(name string, priority Priority) {
+		result := make(chan string, 1)
+		results[name] = result
+		go func() {
+			result <- l.acquire(ctx, priority)
+		}()
+		// wait until it is queued
+		time.Sleep(20 * time.Millisecond)
+	}
+	start("low", Low)
+	start("normal", Normal)
+	// the queue is full, and the low priority request is evicted
+	start("high", High)
+	assert.Equal(t, reasonEvicted, <-results["low"])
+	assert.Equal(t, reasonQueueFull, l.acquire(ctx, Low))
+	assert.Equal(t, reasonQueueFull, l.acquire(ctx, Normal))
+	// critical requests are never queued
+	assert.Equal(t, "", l.acquire(ctx, Critical))
+	l.release(0, false)
+	assert.Equal(t, 1, inFlight(l))
+
+	// the high priority request is admitted first
+	l.release(0, false)
+	assert.Equal(t, "", <-results["high"])
+	l.release(0, false)
+	assert.Equal(t, "", <-results["normal"])
+	l.release(0, false)
+	assert.Equal(t, 0, inFlight(l))
+	assert.Len(t, l.queue, 0)
+}
+
+// inFlight gets the number of admitted requests of the limiter.
+func inFlight(l *limiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+func TestLimiter_Timeout(t *testing.T) {
+	l := newLimiter("api", Limit{Limit: 1, MinLimit: 1, MaxLimit: 1, Queue: 1, MaxWait: 10 * time.Millisecond}, nil)
+	assert.Equal(t, "", l.acquire(context.Background(), Normal))
+	assert.Equal(t, reasonTimeout, l.acquire(context.Background(), Normal))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, reasonCanceled, l.acquire(ctx, Normal))
+	assert.Len(t, l.queue, 0)
+	assert.Equal(t, 1, l.inFlight)
+}
+
+func TestLimiter_AIMD(t *testing.T) {
+	l := newLimiter("api", Limit{Algorithm: AIMD, Limit: 10, MinLimit: 2, MaxLimit: 12, Latency: 100 * time.Millisecond,
+		Backoff: 0.5}, nil)
+
+	// the limit grows only if it is in use
+	l.inFlight = 1
+	l.release(time.Millisecond, false)
+	assert.Equal(t, 10.0, l.limit)
+	for i := 0; i < 11; i++ {
+		l.inFlight = 10
+		l.release(time.Millisecond, false)
+	}
+	assert.Equal(t, 11, l.currentLimit())
+	for i := 0; i < 100; i++ {
+		l.inFlight = 12
+		l.release(time.Millisecond, false)
+	}
+	assert.Equal(t, 12, l.currentLimit())
+
+	// slow or failed requests decrease the limit
+	l.inFlight = 1
+	l.release(time.Second, false)
+	assert.Equal(t, 6, l.currentLimit())
+	l.inFlight = 1
+	l.release(time.Millisecond, true)
+	assert.Equal(t, 3, l.currentLimit())
+	l.inFlight = 1
+	l.release(time.Millisecond, true)
+	assert.Equal(t, 2, l.currentLimit())
+}
+
+func TestLimiter_Gradient(t *testing.T) {
+	l := newLimiter("api", Limit{Algorithm: Gradient, Limit: 20, MinLimit: 1, MaxLimit: 100}, nil)
+	for i := 0; i < 50; i++ {
+		l.inFlight = 20
+		l.release(10*time.Millisecond, false)
+	}
+	// the latency is stable, so the limit grows
+	grown := l.currentLimit()
+	assert.True(t, grown > 20, grown)
+
+	// the latency rises, so the limit drops
+	for i := 0; i < 50; i++ {
+		l.inFlight = 20
+		l.release(100*time.Millisecond, false)
+	}
+	assert.True(t, l.currentLimit() < grown/2, l.currentLimit())
+	assert.True(t, l.currentLimit() >= 1)
+}
@@ -11,6 +11,7 @@ type Config map[Endpoint]string
 // For example:
 //
 // "/api/echo" can be handled by "/api/echo" or "/api/*", but not "/api" or "/".
+// "/api/*" also handles "/api" itself.
 //
 // If multiple prefixes exist, the prefix that matches the most will be the handler.
 //
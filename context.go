@@ -1,6 +1,8 @@
 package gateway
 
 import (
+	"context"
+	"io"
 	"net/http"
 
 	"github.com/LYZhelloworld/go-logger"
@@ -14,8 +16,14 @@ type Context struct {
 	StatusCode int
 	// Response holds the response body.
 	Response []byte
+	// ResponseStream is the response body streamed to the client, such as a large file.
+	// If it is not nil, it is written instead of Response, and is closed afterwards if it is an io.Closer.
+	ResponseStream io.Reader
 	// Header holds HTTP headers in the response.
 	Header http.Header
+	// Trailer holds HTTP trailers sent after the response body, such as the gRPC status.
+	// Trailers may be added while ResponseStream is being read, until it ends.
+	Trailer http.Header
 	// Data is a map that holds data of any type for value exchange between middlewares and main handler.
 	Data map[string]interface{}
 	// Logger is the current logger used in this context.
@@ -23,10 +31,22 @@ type Context struct {
 
 	// serviceName is the name of the Service of the request.
 	serviceName string
+	// configuredServiceName is the name of the Service configured for the endpoint, before matching.
+	configuredServiceName string
+	// server is the Server handling the request.
+	server *Server
 	// responseWriter is the http.ResponseWriter from the handler.
 	responseWriter http.ResponseWriter
 	// isWritten is a flag shows whether the response has been written to the http.ResponseWriter.
 	isWritten bool
+	// bytesWritten is the number of bytes of the response body written to the http.ResponseWriter.
+	bytesWritten int64
+	// panic is the panic recovered while handling the request.
+	panic *Panic
+	// principal is the authenticated identity of the request.
+	principal *Principal
+	// finishers are the functions called after the response has been written.
+	finishers []func()
 
 	// handlerSeq is a pointer to the handlers going to be run.
 	handlerSeq []Handler
@@ -34,16 +54,35 @@ type Context struct {
 	handlerCounter int
 }
 
+// streamBufferSize is the size of the buffer of writing ResponseStream.
+const streamBufferSize = 32 * 1024
+
+// contextKey is the key of the Context in the context.Context of the request.
+type contextKey struct{}
+
+// FromContext gets the Context of the request from its context.Context.
+// Outgoing requests created from Context.Request.Context() (for example, proxied requests to the upstream)
+// carry the Context, so that an http.RoundTripper can get information of the original request.
+// It returns nil if there is no Context.
+func FromContext(ctx context.Context) *Context {
+	if c, ok := ctx.Value(contextKey{}).(*Context); ok {
+		return c
+	}
+	return nil
+}
+
 // createContext creates an empty Context.
 func createContext(w http.ResponseWriter, req *http.Request, server *Server) *Context {
 	ctx := &Context{
-		Request:        req,
 		StatusCode:     http.StatusOK,
 		Header:         map[string][]string{},
+		Trailer:        map[string][]string{},
 		Data:           map[string]interface{}{},
 		Logger:         server.logger,
+		server:         server,
 		responseWriter: w,
 	}
+	ctx.Request = req.WithContext(context.WithValue(req.Context(), contextKey{}, ctx))
 	ctx.handlerSeq = make([]Handler, 0, len(server.middleware)+1)
 	for _, m := range server.middleware {
 		ctx.handlerSeq = append(ctx.handlerSeq, m)
@@ -56,6 +95,7 @@ func (c *Context) write() {
 	if !c.isWritten {
 		c.isWritten = true
 		w := c.responseWriter
+		c.writeGRPCStatus()
 
 		for key, values := range c.Header {
 			w.Header().Del(key)
@@ -63,15 +103,73 @@ func (c *Context) write() {
 				w.Header().Add(key, value)
 			}
 		}
+		// trailers known before writing the body are declared
+		declared := map[string]bool{}
+		for key := range c.Trailer {
+			key = http.CanonicalHeaderKey(key)
+			declared[key] = true
+			w.Header().Add("Trailer", key)
+		}
 
 		w.WriteHeader(c.StatusCode)
-		_, err := w.Write(c.Response)
+		defer c.writeTrailer(declared)
+		if c.ResponseStream != nil {
+			c.writeStream()
+			return
+		}
+		n, err := w.Write(c.Response)
+		c.bytesWritten += int64(n)
 		if err != nil {
 			panic(err)
 		}
 	}
 }
 
+// writeTrailer writes the trailers after the body. Trailers not declared before the body are sent with
+// http.TrailerPrefix.
+func (c *Context) writeTrailer(declared map[string]bool) {
+	w := c.responseWriter
+	for key, values := range c.Trailer {
+		key = http.CanonicalHeaderKey(key)
+		if !declared[key] {
+			key = http.TrailerPrefix + key
+		}
+		w.Header()[key] = values
+	}
+}
+
+// writeStream copies ResponseStream to the http.ResponseWriter, flushing after every chunk if possible.
+func (c *Context) writeStream() {
+	if closer, ok := c.ResponseStream.(io.Closer); ok {
+		defer closer.Close()
+	}
+	w := c.responseWriter
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, streamBufferSize)
+	for {
+		n, err := c.ResponseStream.Read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			c.bytesWritten += int64(written)
+			if werr != nil {
+				// the client has probably gone away
+				c.Logger.WithError(werr).Warn("failed to write response stream")
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			c.Logger.WithError(err).Error("failed to read response stream")
+			return
+		}
+	}
+}
+
 // run runs all handlers.
 func (c *Context) run() {
 	for c.handlerCounter = 0; !c.isDone(); {
@@ -111,6 +209,49 @@ func (c *Context) GetServiceName() string {
 	return c.serviceName
 }
 
+// GetConfiguredServiceName gets the Service name configured for the endpoint of the request.
+// It may be more specific than GetServiceName, which is the name of the Service that actually handles the request.
+// For example: if "/hello" is configured as "api.gateway.hello" and handled by "api.gateway",
+// it gives "api.gateway.hello".
+func (c *Context) GetConfiguredServiceName() string {
+	return c.configuredServiceName
+}
+
+// GetServer gets the Server handling the request. Middlewares can use it to serve requests of their own
+// through the Server, such as revalidating cached responses in background. It returns nil if there is no Server.
+func (c *Context) GetServer() *Server {
+	return c.server
+}
+
+// AbortWithStatus sets the status code, stops the following handlers from executing,
+// and generates the response with the error handler of the status code (see Server.SetErrorHandler) if there is one.
+// It is used by middlewares to reject a request, for example, with http.StatusTooManyRequests.
+func (c *Context) AbortWithStatus(statusCode int) {
+	c.Interrupt()
+	c.StatusCode = statusCode
+	if c.server == nil {
+		return
+	}
+	if handler, ok := c.server.errorConfig[statusCode]; ok {
+		handler(c)
+	}
+}
+
+// BytesWritten gets the number of bytes of the response body written to the client, either from Response
+// or from ResponseStream. It is complete only after the response has been written (see OnFinish).
+func (c *Context) BytesWritten() int64 {
+	return c.bytesWritten
+}
+
+// OnFinish registers a function which is called after the response has been written, in the reverse order
+// of registration. Middlewares can use it to record the request with the final status code:
+// if a handler panics, the status code is already http.StatusInternalServerError (unless the response had been
+// written before the panic), the error response has been written, and GetPanic gives the panic.
+// Panics from the function are recovered and logged.
+func (c *Context) OnFinish(fn func()) {
+	c.finishers = append(c.finishers, fn)
+}
+
 // Interrupt stops the following handlers from executing, but does not stop the current handler.
 // This method can be used in either pre-/post-processors or the main handler.
 // Calling this method multiple times does not have side effects.
@@ -2,6 +2,8 @@ package gateway
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -65,3 +67,53 @@ func TestContext_GetServiceName(t *testing.T) {
 	c := Context{serviceName: serviceName}
 	assert.EqualValues(t, serviceName, c.GetServiceName())
 }
+
+func TestFromContext(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Nil(t, FromContext(req.Context()))
+
+	c := createContext(httptest.NewRecorder(), req, Default())
+	assert.Equal(t, c, FromContext(c.Request.Context()))
+}
+
+func TestContext_GetConfiguredServiceName(t *testing.T) {
+	c := Context{serviceName: "api", configuredServiceName: "api.hello"}
+	assert.Equal(t, "api.hello", c.GetConfiguredServiceName())
+}
+
+func TestContext_WriteStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := createContext(w, httptest.NewRequest(http.MethodGet, "/", nil), Default())
+	c.StatusCode = http.StatusCreated
+	c.Response = []byte("ignored")
+	c.ResponseStream = strings.NewReader("streamed")
+	c.write()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "streamed", w.Body.String())
+	assert.True(t, w.Flushed)
+	assert.Equal(t, int64(8), c.BytesWritten())
+}
+
+func TestContext_AbortWithStatus(t *testing.T) {
+	s := Default()
+	s.SetErrorHandler(http.StatusTooManyRequests, func(context *Context) {
+		context.Response = []byte("slow down")
+	})
+	c := createContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), s)
+	c.handlerSeq = append(c.handlerSeq, func(context *Context) {
+		context.AbortWithStatus(http.StatusTooManyRequests)
+	}, func(context *Context) {
+		context.Response = []byte("not run")
+	})
+	c.run()
+	assert.Equal(t, http.StatusTooManyRequests, c.StatusCode)
+	assert.Equal(t, []byte("slow down"), c.Response)
+}
+
+func TestContext_Principal(t *testing.T) {
+	c := Context{}
+	assert.Nil(t, c.GetPrincipal())
+	principal := &Principal{Subject: "alice", Method: "basic"}
+	c.SetPrincipal(principal)
+	assert.Equal(t, principal, c.GetPrincipal())
+}
@@ -5,21 +5,28 @@ import "strings"
 // endpointConfig is a map that matches string endpoint to routerConfig.
 type endpointConfig map[string]*routerConfig
 
-// getEndpointConfig gets corresponding endpoint Config from the Server.
-func (e *endpointConfig) get(path string) *routerConfig {
-	// remove trailing slash
-	path = strings.TrimSuffix(path, "/")
+// get gets the routerConfig of the path, which is the one of the path itself, or the longest prefix ("/*")
+// matching it. For example: "/api/foo/bar" is matched by "/api/foo/*" before "/api/*", and "/api" by "/api/*".
+// It also returns whether the path itself is matched.
+func (e *endpointConfig) get(path string) (*routerConfig, bool) {
 	if config, ok := (*e)[path]; ok {
-		return config
-	} else {
-		// check prefixes
-		for p := removeLastDir(path); p != ""; p = removeLastDir(p) {
-			if config, ok := (*e)[path+"/*"]; ok {
-				return config
-			}
+		return config, true
+	}
+	if !strings.HasPrefix(path, "/") {
+		// such as "*" of "OPTIONS *"
+		return nil, false
+	}
+	// check prefixes
+	for p := path; p != ""; p = removeLastDir(p) {
+		prefix := p + "/*"
+		if p == "/" {
+			prefix = "/*"
+		}
+		if config, ok := (*e)[prefix]; ok {
+			return config, false
 		}
-		return nil
 	}
+	return nil, false
 }
 
 // routerConfig holds Service for different methods, with method string as the key
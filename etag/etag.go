@@ -0,0 +1,191 @@
+// Package etag provides a middleware that generates ETags of responses and handles conditional requests with
+// If-Match, If-None-Match, If-Modified-Since and If-Unmodified-Since, responding "304 Not Modified" or
+// "412 Precondition Failed".
+package etag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// Config is the configuration of the ETag middleware.
+type Config struct {
+	// Weak generates weak ETags, which only mean that responses are semantically equivalent.
+	// Strong ETags are generated by default, which mean that responses are byte-for-byte identical.
+	Weak bool
+}
+
+// Middleware provides a middleware with default configurations for ETags. See MiddlewareWithConfig.
+func Middleware() gateway.Handler {
+	return MiddlewareWithConfig(Config{})
+}
+
+// MiddlewareWithConfig provides a middleware that generates ETags and handles conditional GET and HEAD requests
+// after the following handlers have been run.
+//
+// An ETag is generated from Context.Response of a "200 OK" response if there is no ETag header.
+// Streamed responses do not get generated ETags, so handlers of them set validators explicitly with Set and
+// SetLastModified (or check them before producing the stream with Check).
+// Conditional headers are only evaluated for 2xx responses. Preconditions failing with
+// "412 Precondition Failed" are responded by the error handler of the Server.
+//
+// Requests of other methods are not evaluated, since the handler has already changed the resource.
+// Their handlers call Check before changing it instead.
+//
+// Use it before compress.Middleware, so that the ETags of compressed responses are compared as they are sent.
+func MiddlewareWithConfig(config Config) gateway.Handler {
+	return func(context *gateway.Context) {
+		context.Next()
+		req := context.Request
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return
+		}
+		if context.StatusCode < 200 || context.StatusCode >= 300 {
+			return
+		}
+		header := context.Header
+		if header.Get("ETag") == "" && context.StatusCode == http.StatusOK && context.ResponseStream == nil &&
+			(req.Method == http.MethodGet || len(context.Response) > 0) {
+			header.Set("ETag", Generate(context.Response, config.Weak))
+		}
+		modified, _ := http.ParseTime(header.Get("Last-Modified"))
+		respond(context, Evaluate(req, true, header.Get("ETag"), modified))
+	}
+}
+
+// Generate generates an ETag from the hash of the data.
+func Generate(data []byte, weak bool) string {
+	sum := sha256.Sum256(data)
+	return format(hex.EncodeToString(sum[:16]), weak)
+}
+
+// Set sets the ETag of the response from an opaque tag, such as a version number, which is quoted.
+func Set(context *gateway.Context, tag string, weak bool) {
+	context.Header.Set("ETag", format(tag, weak))
+}
+
+// SetLastModified sets the Last-Modified header of the response.
+func SetLastModified(context *gateway.Context, modified time.Time) {
+	context.Header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+}
+
+// Check evaluates the conditional headers of the request against the current ETag and modification time of
+// the resource, either of which can be empty. Both are empty if the resource does not exist, so that
+// "If-None-Match: *" allows creating it. It sets the validators of the response, and returns true
+// if the request should be handled.
+//
+// It returns false after responding "304 Not Modified" if the client has the current version of the resource,
+// or "412 Precondition Failed" with the error handler of the Server if a precondition fails. Handlers call it
+// before changing the resource for requests such as PUT with If-Match, or to avoid producing a response stream
+// that the client already has.
+func Check(context *gateway.Context, etag string, modified time.Time) bool {
+	if etag != "" {
+		context.Header.Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		SetLastModified(context, modified)
+	}
+	status := Evaluate(context.Request, etag != "" || !modified.IsZero(), etag, modified)
+	respond(context, status)
+	return status == 0
+}
+
+// format formats an ETag from an opaque tag.
+func format(tag string, weak bool) string {
+	if weak {
+		return `W/"` + tag + `"`
+	}
+	return `"` + tag + `"`
+}
+
+// Evaluate evaluates the conditional headers of the request (If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since) in the order of RFC 9110 against the current ETag and modification time of the resource,
+// either of which can be empty, where exists tells if the resource has a current representation.
+// It returns "304 Not Modified", "412 Precondition Failed", or 0 if the request should be handled.
+//
+// It only evaluates the headers, so that handlers serving resources themselves (such as static files and cached
+// responses) respond the status code in their own way. Other handlers use Check.
+func Evaluate(req *http.Request, exists bool, etag string, modified time.Time) int {
+	modified = modified.Truncate(time.Second)
+	if im := req.Header.Get("If-Match"); im != "" {
+		if !exists || !Match(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := req.Header.Get("If-Unmodified-Since"); ius != "" && !modified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && modified.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	safe := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if exists && Match(inm, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && safe && !modified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !modified.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// respond responds the status code of Evaluate, discarding the response body.
+func respond(context *gateway.Context, status int) {
+	if status == 0 {
+		return
+	}
+	if closer, ok := context.ResponseStream.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	context.ResponseStream = nil
+	context.Response = nil
+	if status == http.StatusNotModified {
+		context.Interrupt()
+		context.StatusCode = status
+		header := context.Header
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		header.Del("Content-Encoding")
+		if header.Get("ETag") != "" {
+			header.Del("Last-Modified")
+		}
+		return
+	}
+	context.AbortWithStatus(status)
+}
+
+// Match checks if the ETag is in the list of an If-Match, If-None-Match or If-Range header.
+// Strong comparison requires both to be strong, and weak comparison ignores the "W/" prefix.
+// "*" matches any ETag, including an empty one.
+func Match(list string, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if etag == "" || (!weak && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "W/") {
+			if !weak {
+				continue
+			}
+			item = item[2:]
+		}
+		if item == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package etag
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// closeRecorder is a response stream recording whether it is closed.
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+var modified = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func testServer(config Config, stream *closeRecorder) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/json", http.MethodGet, "json")
+	cfg.Add("/json", http.MethodHead, "json")
+	cfg.Add("/stream", http.MethodGet, "stream")
+	cfg.Add("/resource", http.MethodPut, "resource")
+	cfg.Add("/missing", http.MethodGet, "missing")
+	s.UseConfig(cfg)
+	s.UseMiddleware(MiddlewareWithConfig(config))
+	s.SetErrorHandler(http.StatusPreconditionFailed, func(context *gateway.Context) {
+		context.Response = []byte("precondition failed")
+	})
+	s.Register("json", func(context *gateway.Context) {
+		context.Header.Set("Content-Type", "application/json")
+		context.Response = []byte(`{"hello":"world"}`)
+	})
+	s.Register("stream", func(context *gateway.Context) {
+		Set(context, "v1", false)
+		SetLastModified(context, modified)
+		context.ResponseStream = stream
+	})
+	s.Register("resource", func(context *gateway.Context) {
+		if !Check(context, `"v1"`, modified) {
+			return
+		}
+		context.Response = []byte("updated")
+	})
+	s.Register("missing", func(context *gateway.Context) {
+		context.StatusCode = http.StatusNotFound
+		context.Response = []byte("not found")
+	})
+	return s.Handler()
+}
+
+func request(handler http.Handler, method string, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := testServer(Config{}, nil)
+	w := request(handler, http.MethodGet, "/json", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, Generate([]byte(`{"hello":"world"}`), false), etag)
+	assert.Len(t, etag, 34)
+
+	w = request(handler, http.MethodGet, "/json", http.Header{"If-None-Match": {`"other", W/` + etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, "", w.Header().Get("Content-Type"))
+
+	w = request(handler, http.MethodGet, "/json", http.Header{"If-None-Match": {`"other"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"hello":"world"}`, w.Body.String())
+
+	// HEAD requests get the same ETag
+	w = request(handler, http.MethodHead, "/json", nil)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = request(handler, http.MethodGet, "/json", http.Header{"If-Match": {etag}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(handler, http.MethodGet, "/json", http.Header{"If-Match": {`"other"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "precondition failed", w.Body.String())
+
+	// errors are not evaluated
+	w = request(handler, http.MethodGet, "/missing", http.Header{"If-Match": {`"other"`}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "", w.Header().Get("ETag"))
+
+	handler = testServer(Config{Weak: true}, nil)
+	w = request(handler, http.MethodGet, "/json", nil)
+	assert.Equal(t, "W/"+etag, w.Header().Get("ETag"))
+	// weak ETags never match If-Match
+	w = request(handler, http.MethodGet, "/json", http.Header{"If-Match": {"W/" + etag}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestMiddleware_Stream(t *testing.T) {
+	stream := &closeRecorder{Reader: strings.NewReader("stream")}
+	handler := testServer(Config{}, stream)
+	w := request(handler, http.MethodGet, "/stream", nil)
+	assert.Equal(t, "stream", w.Body.String())
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Thu, 02 Jan 2020 03:04:05 GMT", w.Header().Get("Last-Modified"))
+
+	stream = &closeRecorder{Reader: strings.NewReader("stream")}
+	handler = testServer(Config{}, stream)
+	w = request(handler, http.MethodGet, "/stream", http.Header{"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "", w.Body.String())
+	assert.True(t, stream.closed)
+
+	stream = &closeRecorder{Reader: strings.NewReader("stream")}
+	handler = testServer(Config{}, stream)
+	w = request(handler, http.MethodGet, "/stream", http.Header{
+		"If-None-Match":     {`"v0"`},
+		"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, _ := ioutil.ReadAll(w.Body)
+	assert.Equal(t, "stream", string(body))
+
+	stream = &closeRecorder{Reader: strings.NewReader("stream")}
+	handler = testServer(Config{}, stream)
+	w = request(handler, http.MethodGet, "/stream", http.Header{"If-Unmodified-Since": {"Wed, 01 Jan 2020 00:00:00 GMT"}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestCheck(t *testing.T) {
+	handler := testServer(Config{}, nil)
+	w := request(handler, http.MethodPut, "/resource", http.Header{"If-Match": {`"v1"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "updated", w.Body.String())
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+
+	w = request(handler, http.MethodPut, "/resource", http.Header{"If-Match": {`"v0"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "precondition failed", w.Body.String())
+
+	// the resource exists
+	w = request(handler, http.MethodPut, "/resource", http.Header{"If-None-Match": {"*"}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = request(handler, http.MethodPut, "/resource", http.Header{"If-Unmodified-Since": {"Fri, 03 Jan 2020 00:00:00 GMT"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestEvaluate(t *testing.T) {
+	req := func(method string, header http.Header) *http.Request {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header = header
+		return r
+	}
+	// If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, 0, Evaluate(req(http.MethodGet, http.Header{
+		"If-None-Match":     {`"a"`},
+		"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"},
+	}), true, `"b"`, modified))
+	// If-Match takes precedence over If-Unmodified-Since
+	assert.Equal(t, 0, Evaluate(req(http.MethodGet, http.Header{
+		"If-Match":            {"*"},
+		"If-Unmodified-Since": {"Wed, 01 Jan 2020 00:00:00 GMT"},
+	}), true, "", modified))
+	// a missing resource fails If-Match but allows If-None-Match
+	assert.Equal(t, http.StatusPreconditionFailed, Evaluate(req(http.MethodPut, http.Header{"If-Match": {"*"}}),
+		false, "", time.Time{}))
+	assert.Equal(t, 0, Evaluate(req(http.MethodPut, http.Header{"If-None-Match": {"*"}}), false, "", time.Time{}))
+	// If-Modified-Since is only for GET and HEAD
+	assert.Equal(t, 0, Evaluate(req(http.MethodPost, http.Header{
+		"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"},
+	}), true, "", modified))
+	// sub-second modification times are truncated
+	assert.Equal(t, http.StatusNotModified, Evaluate(req(http.MethodGet, http.Header{
+		"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"},
+	}), true, "", modified.Add(time.Millisecond)))
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match(`"a", "b"`, `"b"`, false))
+	assert.True(t, Match("*", `"a"`, false))
+	assert.True(t, Match("*", "", true))
+	// strong comparison requires both to be strong
+	assert.False(t, Match(`W/"a"`, `"a"`, false))
+	assert.False(t, Match(`"a"`, `W/"a"`, false))
+	assert.True(t, Match(`W/"a"`, `"a"`, true))
+	assert.True(t, Match(`"a"`, `W/"a"`, true))
+	assert.False(t, Match(`"a"`, "", true))
+}
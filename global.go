@@ -1,7 +1,9 @@
 package gateway
 
 import (
+	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
 )
 
@@ -60,4 +62,21 @@ func checkNonNilHandler(handler Handler) {
 	if handler == nil {
 		panic("nil handler")
 	}
-}
\ No newline at end of file
+}
+
+// handlerName gets the name of the function behind a handler, without the package path.
+// For example: a closure returned by middleware.Logger() gives "middleware.Logger.func1".
+func handlerName(handler Handler) string {
+	if handler == nil {
+		return ""
+	}
+	fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		name = name[idx+1:]
+	}
+	return name
+}
@@ -1,8 +1,17 @@
 module github.com/LYZhelloworld/go-gateway
 
-go 1.12
+go 1.24
 
 require (
 	github.com/LYZhelloworld/go-logger v1.0.0
+	github.com/andybalholm/brotli v1.2.6
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.6.1
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
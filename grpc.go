@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes used by the Server. See https://github.com/grpc/grpc/blob/master/doc/statuscodes.md.
+const (
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// UseGRPC routes gRPC requests to "/package.Service/Method" which are not configured as endpoints
+// onto the Service "package.Service.Method", matched like other Service names. For example, a handler registered
+// as "helloworld.Greeter" handles all methods of the gRPC service helloworld.Greeter.
+//
+// Only the Service named in Config or in services are routed, including the Service under them:
+// "helloworld.Greeter" routes all methods of helloworld.Greeter. Calls are never routed to the "*" Service handler.
+// Other calls get "404 Not Found" (UNIMPLEMENTED). They are routed before prefix endpoints (such as "/*"),
+// which still serve the requests not matching any Service.
+//
+// Requests rejected by the Server (such as 404) or by middlewares (such as authentication and rate limiting)
+// are answered with the gRPC status mapped from the status code, whether UseGRPC is called or not.
+func (s *Server) UseGRPC(services ...string) {
+	for _, name := range services {
+		if name == baseServiceHandler || !isValidService(name) {
+			panic("invalid gRPC service: " + name)
+		}
+	}
+	s.grpc = true
+	s.grpcServices = append(s.grpcServices, services...)
+}
+
+// matchGRPCService finds the Service handler of a gRPC call, which must be under a Service named in Config
+// or passed to UseGRPC. It returns nil if the call is not routed.
+func (s *Server) matchGRPCService(name string) (string, Handler) {
+	if _, _, ok := LookupService(s.grpcRoutes, name); !ok {
+		return "", nil
+	}
+	matchedName, handler := s.matchService(name)
+	if matchedName == baseServiceHandler {
+		return "", nil
+	}
+	return matchedName, handler
+}
+
+// IsGRPC checks if the request is a gRPC or gRPC-Web request by its content type.
+func IsGRPC(req *http.Request) bool {
+	return grpcContentType(req) != ""
+}
+
+// grpcContentType gets the base content type of a gRPC or gRPC-Web request, without the message format
+// (such as "+proto"). It returns an empty string if the request is not gRPC.
+func grpcContentType(req *http.Request) string {
+	contentType := req.Header.Get("Content-Type")
+	if idx := strings.IndexAny(contentType, "+;"); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	switch contentType = strings.TrimSpace(contentType); contentType {
+	case "application/grpc", "application/grpc-web", "application/grpc-web-text":
+		return contentType
+	}
+	return ""
+}
+
+// grpcServiceName gets the Service name of a gRPC path. For example: "/helloworld.Greeter/SayHello" gives
+// "helloworld.Greeter.SayHello".
+func grpcServiceName(path string) (string, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" || parts[2] == "" || strings.Contains(parts[2], ".") {
+		return "", false
+	}
+	name := parts[1] + "." + parts[2]
+	if !serviceRegexp.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// grpcStatus maps a status code of HTTP to a gRPC status code.
+func grpcStatus(statusCode int) int {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return grpcUnimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusInternalServerError:
+		return grpcInternal
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGone:
+		return grpcNotFound
+	}
+	return grpcUnknown
+}
+
+// writeGRPCStatus turns a response of an error status code to a gRPC request into a gRPC response with
+// only the status in headers (Trailers-Only), unless it already has a gRPC status.
+func (c *Context) writeGRPCStatus() {
+	if c.Request == nil {
+		return
+	}
+	contentType := grpcContentType(c.Request)
+	if contentType == "" || c.StatusCode == http.StatusOK ||
+		c.Header.Get("Grpc-Status") != "" || c.Trailer.Get("Grpc-Status") != "" {
+		return
+	}
+	message := http.StatusText(c.StatusCode)
+	code := grpcStatus(c.StatusCode)
+	if closer, ok := c.ResponseStream.(interface{ Close() error }); ok {
+		_ = closer.Close()
+	}
+	c.StatusCode = http.StatusOK
+	c.Response = nil
+	c.ResponseStream = nil
+	// headers of middlewares (such as CORS) are kept
+	c.Header.Del("Content-Length")
+	c.Header.Del("Content-Encoding")
+	c.Header.Set("Content-Type", contentType)
+	c.Header.Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		c.Header.Set("Grpc-Message", grpcEncodeMessage(message))
+	}
+}
+
+// grpcEncodeMessage percent-encodes a gRPC status message.
+func grpcEncodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if ch := message[i]; ch >= ' ' && ch <= '~' && ch != '%' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,198 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// frame creates a gRPC message frame.
+func frame(message string) []byte {
+	buf := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(message)))
+	return append(buf, message...)
+}
+
+// readFrames reads gRPC frames, returning the messages and the trailers in a gRPC-Web trailer frame if any.
+func readFrames(t *testing.T, r io.Reader) ([]string, string) {
+	messages, trailer, err := parseFrames(r)
+	assert.NoError(t, err)
+	return messages, trailer
+}
+
+// parseFrames parses gRPC frames, returning the messages and the trailers in a gRPC-Web trailer frame if any.
+func parseFrames(r io.Reader) ([]string, string, error) {
+	var messages []string
+	var trailer string
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return messages, trailer, nil
+		} else if err != nil {
+			return nil, "", err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, "", err
+		}
+		if header[0]&trailerFlag != 0 {
+			trailer = string(payload)
+		} else {
+			messages = append(messages, string(payload))
+		}
+	}
+}
+
+// newUpstream starts a gRPC server of the service test.Echo over h2c.
+func newUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") ||
+			req.Header.Get("Te") != "trailers" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		messages, _, err := parseFrames(req.Body)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("Grpc-Status", "3")
+			return
+		}
+		switch req.URL.Path {
+		case "/test.Echo/Say":
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("X-Upstream", "echo")
+			for _, message := range messages {
+				_, _ = w.Write(frame("echo: " + message))
+			}
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", "")
+		case "/test.Echo/Fail":
+			// Trailers-Only
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "not%20found")
+		default:
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("Grpc-Status", "12")
+		}
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	return upstream
+}
+
+// newGateway starts a Server proxying test.Echo to the upstream over h2c.
+func newGateway(t *testing.T, upstream string, middlewares ...gateway.Handler) *httptest.Server {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.UseGRPC("test.Echo")
+	s.UseMiddlewares(middlewares...)
+	s.Register("test.Echo", Proxy(ProxyConfig{Target: upstream}))
+	svr := httptest.NewUnstartedServer(s.Handler())
+	svr.Config.Protocols = new(http.Protocols)
+	svr.Config.Protocols.SetHTTP1(true)
+	svr.Config.Protocols.SetUnencryptedHTTP2(true)
+	svr.Start()
+	return svr
+}
+
+// call makes a gRPC call over h2c.
+func call(t *testing.T, url string, messages ...string) *http.Response {
+	var body bytes.Buffer
+	for _, message := range messages {
+		body.Write(frame(message))
+	}
+	req, _ := http.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+	resp, err := (&http.Client{Transport: gateway.NewHTTP2Transport(nil)}).Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return resp
+}
+
+func TestProxy(t *testing.T) {
+	upstream := newUpstream(t)
+	defer upstream.Close()
+	svr := newGateway(t, upstream.URL)
+	defer svr.Close()
+
+	resp := call(t, svr.URL+"/test.Echo/Say", "hello", "world")
+	messages, _ := readFrames(t, resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, "echo", resp.Header.Get("X-Upstream"))
+	assert.Equal(t, []string{"echo: hello", "echo: world"}, messages)
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+
+	// Trailers-Only responses are passed through
+	resp = call(t, svr.URL+"/test.Echo/Fail", "hello")
+	_, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "5", resp.Header.Get("Grpc-Status"))
+	assert.Equal(t, "not%20found", resp.Header.Get("Grpc-Message"))
+
+	// unknown services are not routed
+	resp = call(t, svr.URL+"/test.Other/Say", "hello")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "12", resp.Header.Get("Grpc-Status"))
+}
+
+func TestProxy_Rejected(t *testing.T) {
+	upstream := newUpstream(t)
+	defer upstream.Close()
+	svr := newGateway(t, upstream.URL, func(context *gateway.Context) {
+		switch context.Request.Header.Get("Authorization") {
+		case "":
+			context.AbortWithStatus(http.StatusUnauthorized)
+		case "limited":
+			context.AbortWithStatus(http.StatusTooManyRequests)
+		}
+	})
+	defer svr.Close()
+
+	resp := call(t, svr.URL+"/test.Echo/Say", "hello")
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/grpc", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "16", resp.Header.Get("Grpc-Status"))
+	assert.Equal(t, "Unauthorized", resp.Header.Get("Grpc-Message"))
+
+	req, _ := http.NewRequest(http.MethodPost, svr.URL+"/test.Echo/Say", bytes.NewReader(frame("hello")))
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Authorization", "limited")
+	resp, err := (&http.Client{Transport: gateway.NewHTTP2Transport(nil)}).Do(req)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, "8", resp.Header.Get("Grpc-Status"))
+		assert.Equal(t, "Too Many Requests", resp.Header.Get("Grpc-Message"))
+	}
+}
+
+func TestProxy_Unavailable(t *testing.T) {
+	upstream := newUpstream(t)
+	upstream.Close()
+	svr := newGateway(t, upstream.URL)
+	defer svr.Close()
+
+	resp := call(t, svr.URL+"/test.Echo/Say", "hello")
+	_ = resp.Body.Close()
+	assert.Equal(t, "14", resp.Header.Get("Grpc-Status"))
+
+	assert.Panics(t, func() {
+		Proxy(ProxyConfig{Target: "orders:50051"})
+	})
+}
@@ -0,0 +1,107 @@
+// Package grpc provides a handler proxying gRPC calls to upstream gRPC servers with trailers,
+// and a middleware translating gRPC-Web requests of browsers to gRPC.
+package grpc
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// hopHeaders is the hop-by-hop headers, which are not proxied.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// ProxyConfig is the configuration of the gRPC proxy.
+type ProxyConfig struct {
+	// Target is the URL of the upstream gRPC server, such as "http://orders:50051" for h2c,
+	// or "https://orders.example.com" for TLS. It is required.
+	Target string
+	// Transport sends the requests to the upstream. It must speak HTTP/2.
+	// It is gateway.NewHTTP2Transport(nil) if nil.
+	Transport http.RoundTripper
+}
+
+// Proxy provides a handler proxying gRPC calls to the upstream, including streaming calls.
+// Register it for the Service names of gRPC services, with Server.UseGRPC to route them. For example:
+//
+//	s.UseGRPC("helloworld.Greeter")
+//	s.Register("helloworld.Greeter", grpc.Proxy(grpc.ProxyConfig{Target: "http://greeter:50051"}))
+//
+// Headers and trailers (such as the gRPC status) of the upstream are passed through.
+// The call fails with UNAVAILABLE if the upstream cannot be reached.
+func Proxy(config ProxyConfig) gateway.Handler {
+	target, err := url.Parse(config.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		panic("invalid gRPC target: " + config.Target)
+	}
+	transport := config.Transport
+	if transport == nil {
+		transport = gateway.NewHTTP2Transport(nil)
+	}
+
+	return func(context *gateway.Context) {
+		req := context.Request
+		u := *target
+		u.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+		u.RawPath = ""
+		u.RawQuery = ""
+		out, err := http.NewRequest(http.MethodPost, u.String(), req.Body)
+		if err != nil {
+			context.Logger.WithError(err).Error("failed to create gRPC request")
+			context.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		out = out.WithContext(req.Context())
+		copyHeader(out.Header, req.Header)
+		out.Header.Set("Te", "trailers")
+		out.ContentLength = req.ContentLength
+
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			context.Logger.WithError(err).Warn("failed to call gRPC upstream")
+			context.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		copyHeader(context.Header, resp.Header)
+		context.StatusCode = resp.StatusCode
+		context.ResponseStream = &trailerReader{resp: resp, trailer: context.Trailer}
+	}
+}
+
+// copyHeader copies headers except the hop-by-hop ones.
+func copyHeader(dst http.Header, src http.Header) {
+	for key, values := range src {
+		dst[key] = append([]string(nil), values...)
+	}
+	for _, key := range hopHeaders {
+		dst.Del(key)
+	}
+}
+
+// trailerReader reads the body of the upstream response, and copies its trailers once the body ends.
+type trailerReader struct {
+	resp    *http.Response
+	trailer http.Header
+}
+
+// Read reads the body.
+func (r *trailerReader) Read(p []byte) (int, error) {
+	n, err := r.resp.Body.Read(p)
+	if err == io.EOF {
+		for key, values := range r.resp.Trailer {
+			r.trailer[key] = values
+		}
+	}
+	return n, err
+}
+
+// Close closes the body.
+func (r *trailerReader) Close() error {
+	return r.resp.Body.Close()
+}
@@ -0,0 +1,207 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// trailerFlag is the flag of the frame of trailers in gRPC-Web responses.
+const trailerFlag = 0x80
+
+// Web provides a middleware translating gRPC-Web requests of browsers to gRPC for the following handlers,
+// such as Proxy, and translating their responses back. Unary and server streaming calls are supported,
+// in both the binary ("application/grpc-web") and the text ("application/grpc-web-text") formats.
+// Trailers are sent in the response body, since browsers cannot read HTTP trailers.
+//
+// Other requests are passed through. For cross-origin requests, the CORS middleware should allow the headers
+// "Content-Type", "X-Grpc-Web" and "X-User-Agent", and expose "Grpc-Status" and "Grpc-Message".
+func Web() gateway.Handler {
+	return func(context *gateway.Context) {
+		req := context.Request
+		contentType := req.Header.Get("Content-Type")
+		base, format := contentType, ""
+		if idx := strings.IndexAny(contentType, "+;"); idx != -1 {
+			base, format = contentType[:idx], contentType[idx:]
+		}
+		var text bool
+		switch strings.TrimSpace(base) {
+		case "application/grpc-web":
+		case "application/grpc-web-text":
+			text = true
+		default:
+			context.Next()
+			return
+		}
+
+		req.Header.Set("Content-Type", "application/grpc"+format)
+		req.Header.Set("Te", "trailers")
+		req.Header.Del("X-Grpc-Web")
+		if text && req.Body != nil && req.Body != http.NoBody {
+			req.Body = &textDecoder{r: req.Body, c: req.Body}
+			req.ContentLength = -1
+			req.Header.Del("Content-Length")
+		}
+		context.Next()
+		// rejections of the Server are answered with the gRPC-Web content type
+		req.Header.Set("Content-Type", contentType)
+
+		if context.StatusCode != http.StatusOK || !isGRPCResponse(context.Header) {
+			return
+		}
+		responseType := "application/grpc-web"
+		if text {
+			responseType = "application/grpc-web-text"
+		}
+		context.Header.Set("Content-Type", responseType+strings.TrimPrefix(context.Header.Get("Content-Type"), "application/grpc"))
+		context.Header.Del("Content-Length")
+		body := context.ResponseStream
+		if body == nil {
+			body = bytes.NewReader(context.Response)
+		}
+		context.Response = nil
+		context.ResponseStream = &webStream{body: body, trailer: context.Trailer, text: text}
+	}
+}
+
+// isGRPCResponse checks if the response is a gRPC response by its content type.
+func isGRPCResponse(header http.Header) bool {
+	contentType := header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// webStream is a gRPC-Web response body, which is the gRPC body followed by a frame of the trailers.
+type webStream struct {
+	body    io.Reader
+	trailer http.Header
+	text    bool
+	buf     bytes.Buffer
+	chunk   []byte
+	done    bool
+}
+
+// Read reads the body.
+func (s *webStream) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if s.chunk == nil {
+			s.chunk = make([]byte, 32*1024)
+		}
+		n, err := s.body.Read(s.chunk)
+		if n > 0 {
+			s.emit(s.chunk[:n])
+		}
+		if err == io.EOF {
+			s.done = true
+			if frame := trailerFrame(s.trailer); frame != nil {
+				s.emit(frame)
+			}
+			// the trailers are in the body instead
+			for key := range s.trailer {
+				delete(s.trailer, key)
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return s.buf.Read(p)
+}
+
+// emit adds data to the output, encoding it with base64 in the text format.
+// Every chunk is padded, which is allowed by gRPC-Web.
+func (s *webStream) emit(data []byte) {
+	if !s.text {
+		s.buf.Write(data)
+		return
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, &s.buf)
+	_, _ = encoder.Write(data)
+	_ = encoder.Close()
+}
+
+// Close closes the gRPC body.
+func (s *webStream) Close() error {
+	if closer, ok := s.body.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// trailerFrame creates the frame of the trailers. It returns nil if there are no trailers.
+func trailerFrame(trailer http.Header) []byte {
+	if len(trailer) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(trailer))
+	for key := range trailer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var payload bytes.Buffer
+	for _, key := range keys {
+		for _, value := range trailer[key] {
+			payload.WriteString(strings.ToLower(key) + ": " + value + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+payload.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+	return append(frame, payload.Bytes()...)
+}
+
+// textDecoder decodes a request body in the gRPC-Web text format, which is base64 in padded chunks.
+type textDecoder struct {
+	r   io.Reader
+	c   io.Closer
+	buf []byte
+	in  []byte
+	out []byte
+	err error
+}
+
+// Read reads the decoded body.
+func (d *textDecoder) Read(p []byte) (int, error) {
+	if d.buf == nil {
+		d.buf = make([]byte, 4*1024)
+	}
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.r.Read(d.buf)
+		d.in = append(d.in, d.buf[:n]...)
+		// decode every complete quantum, since each one may be padded
+		for len(d.in) >= 4 {
+			decoded := make([]byte, 3)
+			m, derr := base64.StdEncoding.Decode(decoded, d.in[:4])
+			if derr != nil {
+				d.err = derr
+				break
+			}
+			d.out = append(d.out, decoded[:m]...)
+			d.in = d.in[4:]
+		}
+		if err == io.EOF && len(d.in) > 0 && d.err == nil {
+			d.err = io.ErrUnexpectedEOF
+		} else if err != nil && d.err == nil {
+			d.err = err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// Close closes the body.
+func (d *textDecoder) Close() error {
+	return d.c.Close()
+}
@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/stretchr/testify/assert"
+)
+
+// callWeb makes a gRPC-Web call over HTTP/1.1, like browsers.
+func callWeb(t *testing.T, url string, contentType string, body []byte) (*http.Response, []byte) {
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, data
+}
+
+func TestWeb(t *testing.T) {
+	upstream := newUpstream(t)
+	defer upstream.Close()
+	svr := newGateway(t, upstream.URL, Web())
+	defer svr.Close()
+
+	resp, body := callWeb(t, svr.URL+"/test.Echo/Say", "application/grpc-web+proto", frame("hello"))
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+	assert.Equal(t, "application/grpc-web+proto", resp.Header.Get("Content-Type"))
+	messages, trailer := readFrames(t, bytes.NewReader(body))
+	assert.Equal(t, []string{"echo: hello"}, messages)
+	assert.Equal(t, "grpc-message: \r\ngrpc-status: 0\r\n", trailer)
+	assert.Empty(t, resp.Trailer.Get("Grpc-Status"))
+
+	// the text format in padded chunks
+	request := base64.StdEncoding.EncodeToString(frame("hi")) + base64.StdEncoding.EncodeToString(frame("there"))
+	resp, body = callWeb(t, svr.URL+"/test.Echo/Say", "application/grpc-web-text", []byte(request))
+	assert.Equal(t, "application/grpc-web-text+proto", resp.Header.Get("Content-Type"))
+	decoded := decodeChunks(t, string(body))
+	messages, trailer = readFrames(t, bytes.NewReader(decoded))
+	assert.Equal(t, []string{"echo: hi", "echo: there"}, messages)
+	assert.Contains(t, trailer, "grpc-status: 0\r\n")
+
+	// Trailers-Only responses are kept in headers
+	resp, body = callWeb(t, svr.URL+"/test.Echo/Fail", "application/grpc-web+proto", frame("hello"))
+	assert.Equal(t, "5", resp.Header.Get("Grpc-Status"))
+	assert.Equal(t, "application/grpc-web+proto", resp.Header.Get("Content-Type"))
+	assert.Empty(t, body)
+
+	// the call fails if the text is invalid
+	resp, _ = callWeb(t, svr.URL+"/test.Echo/Say", "application/grpc-web-text", []byte("!!!!"))
+	assert.NotEmpty(t, resp.Header.Get("Grpc-Status"))
+	assert.NotEqual(t, "0", resp.Header.Get("Grpc-Status"))
+}
+
+func TestWeb_Rejected(t *testing.T) {
+	upstream := newUpstream(t)
+	defer upstream.Close()
+	svr := newGateway(t, upstream.URL, Web(), func(context *gateway.Context) {
+		if context.Request.Header.Get("Authorization") == "" {
+			context.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+	defer svr.Close()
+
+	resp, body := callWeb(t, svr.URL+"/test.Echo/Say", "application/grpc-web-text+proto",
+		[]byte(base64.StdEncoding.EncodeToString(frame("hello"))))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/grpc-web-text", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "16", resp.Header.Get("Grpc-Status"))
+	assert.Empty(t, body)
+}
+
+func TestWeb_PassThrough(t *testing.T) {
+	upstream := newUpstream(t)
+	defer upstream.Close()
+	svr := newGateway(t, upstream.URL, Web())
+	defer svr.Close()
+
+	// gRPC requests are not translated
+	resp := call(t, svr.URL+"/test.Echo/Say", "hello")
+	messages, trailer := readFrames(t, resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "application/grpc+proto", resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"echo: hello"}, messages)
+	assert.Empty(t, trailer)
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+}
+
+// decodeChunks decodes base64 in padded chunks.
+func decodeChunks(t *testing.T, text string) []byte {
+	var decoded []byte
+	for _, chunk := range strings.SplitAfter(text, "=") {
+		chunk = strings.TrimLeft(chunk, "=")
+		if chunk == "" {
+			continue
+		}
+		for len(chunk)%4 != 0 {
+			chunk += "="
+		}
+		data, err := base64.StdEncoding.DecodeString(chunk)
+		assert.NoError(t, err)
+		decoded = append(decoded, data...)
+	}
+	return decoded
+}
+
+func TestTextDecoder(t *testing.T) {
+	body := base64.StdEncoding.EncodeToString([]byte("a")) + base64.StdEncoding.EncodeToString([]byte("bcde"))
+	d := &textDecoder{r: strings.NewReader(body), c: ioutil.NopCloser(nil)}
+	data, err := ioutil.ReadAll(d)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcde", string(data))
+
+	d = &textDecoder{r: strings.NewReader("YWJ"), c: ioutil.NopCloser(nil)}
+	_, err = ioutil.ReadAll(d)
+	assert.Error(t, err)
+}
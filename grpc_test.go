@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGRPCServiceName(t *testing.T) {
+	name, ok := grpcServiceName("/helloworld.Greeter/SayHello")
+	assert.True(t, ok)
+	assert.Equal(t, "helloworld.Greeter.SayHello", name)
+	for _, path := range []string{"/", "/helloworld.Greeter", "/helloworld.Greeter/", "//SayHello",
+		"/helloworld.Greeter/Say.Hello", "/a/b/c", "/hello world/Say"} {
+		_, ok := grpcServiceName(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestIsGRPC(t *testing.T) {
+	for contentType, expected := range map[string]bool{
+		"application/grpc":                true,
+		"application/grpc+proto":          true,
+		"application/grpc-web+json":       true,
+		"application/grpc-web-text":       true,
+		"application/grpc; charset=utf-8": true,
+		"application/json":                false,
+		"":                                false,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Content-Type", contentType)
+		assert.Equal(t, expected, IsGRPC(req), contentType)
+	}
+}
+
+func TestGRPCStatus(t *testing.T) {
+	assert.Equal(t, grpcUnauthenticated, grpcStatus(http.StatusUnauthorized))
+	assert.Equal(t, grpcPermissionDenied, grpcStatus(http.StatusForbidden))
+	assert.Equal(t, grpcResourceExhausted, grpcStatus(http.StatusTooManyRequests))
+	assert.Equal(t, grpcUnimplemented, grpcStatus(http.StatusNotFound))
+	assert.Equal(t, grpcUnavailable, grpcStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, grpcUnknown, grpcStatus(http.StatusTeapot))
+	assert.Equal(t, "caf%C3%A9 100%25", grpcEncodeMessage("café 100%"))
+}
+
+func TestServer_GRPCRouting(t *testing.T) {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.Register("helloworld.Greeter", func(context *Context) {
+		context.Header.Set("Content-Type", "application/grpc")
+		context.Response = []byte(context.GetServiceName() + " " + context.GetConfiguredServiceName())
+		context.Trailer.Set("Grpc-Status", "0")
+	})
+	s.SetErrorHandler(http.StatusNotFound, func(context *Context) {
+		context.Response = []byte("not found")
+	})
+	call := func(path string, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(""))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	// not routed without UseGRPC, and rejected with a gRPC status
+	w := call("/helloworld.Greeter/SayHello", "application/grpc+proto")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/grpc", w.Header().Get("Content-Type"))
+	assert.Equal(t, "12", w.Header().Get("Grpc-Status"))
+	assert.Equal(t, "Not Found", w.Header().Get("Grpc-Message"))
+	assert.Empty(t, w.Body.String())
+
+	s.UseGRPC("helloworld.Greeter")
+	w = call("/helloworld.Greeter/SayHello", "application/grpc+proto")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "helloworld.Greeter helloworld.Greeter.SayHello", w.Body.String())
+	assert.Equal(t, "0", w.Result().Trailer.Get("Grpc-Status"))
+
+	// other requests are not routed
+	w = call("/helloworld.Greeter/SayHello", "application/json")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "not found", w.Body.String())
+	w = call("/helloworld.Other/SayHello", "application/grpc-web")
+	assert.Equal(t, "application/grpc-web", w.Header().Get("Content-Type"))
+	assert.Equal(t, "12", w.Header().Get("Grpc-Status"))
+}
+
+func TestServer_GRPCRouting_PrefixEndpoint(t *testing.T) {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.UseGRPC("helloworld.Greeter")
+	cfg := Config{}
+	cfg.Add("/*", http.MethodGet, "static")
+	cfg.Add("/helloworld.Greeter/SayBye", http.MethodPost, "bye")
+	s.UseConfig(cfg)
+	s.Register("static", func(context *Context) {
+		context.Response = []byte("static")
+	})
+	s.Register("bye", func(context *Context) {
+		context.Response = []byte("bye")
+	})
+	s.Register("helloworld.Greeter", func(context *Context) {
+		context.Response = []byte(context.GetConfiguredServiceName())
+	})
+	handler := s.Handler()
+
+	call := func(method string, path string, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(""))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// gRPC calls are routed before the prefix endpoint
+	w := call(http.MethodPost, "/helloworld.Greeter/SayHello", "application/grpc")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "helloworld.Greeter.SayHello", w.Body.String())
+	// but not before endpoints of the path itself
+	assert.Equal(t, "bye", call(http.MethodPost, "/helloworld.Greeter/SayBye", "application/grpc").Body.String())
+	// other requests are served by the prefix endpoint
+	assert.Equal(t, "static", call(http.MethodGet, "/helloworld.Greeter/SayHello", "").Body.String())
+	assert.Equal(t, "static", call(http.MethodGet, "/index.html", "").Body.String())
+}
+
+func TestServer_GRPCRouting_Unconfigured(t *testing.T) {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.UseGRPC("helloworld.Greeter")
+	cfg := Config{}
+	cfg.Add("/echo", http.MethodPost, "test.Echo")
+	s.UseConfig(cfg)
+	s.Register("*", func(context *Context) {
+		context.Response = []byte("catch-all")
+	})
+	s.Register("test", func(context *Context) {
+		context.Response = []byte(context.GetConfiguredServiceName())
+	})
+	s.Register("internal.Admin", func(context *Context) {
+		context.Response = []byte("admin")
+	})
+	handler := s.Handler()
+
+	call := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/grpc")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Service in Config is routed
+	w := call("/test.Echo/Say")
+	assert.Equal(t, "test.Echo.Say", w.Body.String())
+	// registered but not configured Service is not routed
+	w = call("/internal.Admin/Reset")
+	assert.Equal(t, "12", w.Header().Get("Grpc-Status"))
+	assert.Empty(t, w.Body.String())
+	// allowed Service without handler never falls back to "*"
+	w = call("/helloworld.Greeter/SayHello")
+	assert.Equal(t, "12", w.Header().Get("Grpc-Status"))
+	assert.Empty(t, w.Body.String())
+	// nor does an unknown Service
+	w = call("/test2.Echo/Say")
+	assert.Equal(t, "12", w.Header().Get("Grpc-Status"))
+	assert.Empty(t, w.Body.String())
+
+	assert.Panics(t, func() { s.UseGRPC("*") })
+	assert.Panics(t, func() { s.UseGRPC("a..b") })
+}
+
+func TestContext_Trailer(t *testing.T) {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.config.Add("/declared", http.MethodGet, "declared")
+	s.config.Add("/stream", http.MethodGet, "stream")
+	s.Register("declared", func(context *Context) {
+		context.Response = []byte("hello")
+		context.Trailer.Set("X-Checksum", "abc")
+	})
+	s.Register("stream", func(context *Context) {
+		context.ResponseStream = &trailerStream{Reader: strings.NewReader("hello"), trailer: context.Trailer}
+	})
+	svr := httptest.NewServer(s.Handler())
+	defer svr.Close()
+
+	for _, path := range []string{"/declared", "/stream"} {
+		resp, err := http.Get(svr.URL + path)
+		if assert.NoError(t, err) {
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, "hello", string(body))
+			assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"), path)
+		}
+	}
+}
+
+// trailerStream adds a trailer when it ends.
+type trailerStream struct {
+	*strings.Reader
+	trailer http.Header
+}
+
+func (s *trailerStream) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err != nil {
+		s.trailer.Set("X-Checksum", "abc")
+	}
+	return n, err
+}
@@ -0,0 +1,272 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// http2Preface is the connection preface sent by HTTP/2 clients.
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	// http2FrameHeaderSize is the size of the header of HTTP/2 frames.
+	http2FrameHeaderSize = 9
+	// http2MaxFrameSize is the maximum size of HTTP/2 frames before the peers agree on a larger one.
+	http2MaxFrameSize = 16384
+	// h2cPrefaceTimeout is the timeout of reading the connection preface after switching to h2c.
+	h2cPrefaceTimeout = 10 * time.Second
+)
+
+// Types and flags of HTTP/2 frames used when upgrading to h2c.
+const (
+	http2FrameHeaders      = 0x1
+	http2FrameSettings     = 0x4
+	http2FrameContinuation = 0x9
+	http2FlagEndStream     = 0x1
+	http2FlagAck           = 0x1
+	http2FlagEndHeaders    = 0x4
+)
+
+// NewHTTP2Transport creates an http.Transport speaking only HTTP/2 to upstreams: over TLS for "https" URLs,
+// and over cleartext with prior knowledge (h2c) for "http" URLs, such as gRPC services inside a cluster.
+// The TLS configuration of "https" URLs may be nil. Requests fail if the upstream does not support HTTP/2.
+func NewHTTP2Transport(tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.ForceAttemptHTTP2 = true
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	transport.Protocols = protocols
+	return transport
+}
+
+// h2cHandler upgrades HTTP/1.1 connections asking to upgrade to h2c (RFC 7540 section 3.2), and serves other
+// requests with the next handler.
+//
+// The standard library serves h2c only with prior knowledge, so the upgraded connection is handed back to
+// the http.Server as a new one which starts with the connection preface, and the request which asked to upgrade
+// is inserted after the first SETTINGS frame of the client as the stream 1.
+type h2cHandler struct {
+	server *http.Server
+	next   http.Handler
+}
+
+// ServeHTTP implements http.Handler.
+func (h *h2cHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.TLS != nil || !isH2CUpgrade(req) {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+	upgraded, err := upgradeH2C(conn, rw, req)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	l := &connListener{conn: upgraded}
+	if err := h.server.Serve(l); err == http.ErrServerClosed && !l.accepted {
+		_ = upgraded.Close()
+	}
+}
+
+// isH2CUpgrade checks if the request asks to upgrade to h2c. Requests with a body are not upgraded,
+// since the body would have to be read before switching protocols.
+func isH2CUpgrade(req *http.Request) bool {
+	if req.ProtoMajor != 1 || req.ProtoMinor != 1 || req.Method == http.MethodConnect {
+		return false
+	}
+	if req.ContentLength != 0 || len(req.TransferEncoding) > 0 {
+		return false
+	}
+	if !headerHasToken(req.Header, "Upgrade", "h2c") || !headerHasToken(req.Header, "Connection", "Upgrade") ||
+		!headerHasToken(req.Header, "Connection", "HTTP2-Settings") {
+		return false
+	}
+	settings := req.Header["Http2-Settings"]
+	if len(settings) != 1 {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(settings[0], "="))
+	return err == nil
+}
+
+// headerHasToken checks if the comma-separated values of the header contain the token, case-insensitively.
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header[name] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeH2C switches the hijacked connection to h2c, and reads the connection preface and the first SETTINGS
+// frame of the client. It returns the connection replaying them, followed by the request as the stream 1.
+func upgradeH2C(conn net.Conn, rw *bufio.ReadWriter, req *http.Request) (net.Conn, error) {
+	if _, err := rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(h2cPrefaceTimeout))
+	prefix := make([]byte, len(http2Preface)+http2FrameHeaderSize)
+	if _, err := io.ReadFull(rw, prefix); err != nil {
+		return nil, err
+	}
+	if string(prefix[:len(http2Preface)]) != http2Preface {
+		return nil, errors.New("invalid HTTP/2 connection preface")
+	}
+	header := prefix[len(http2Preface):]
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if header[3] != http2FrameSettings || header[4]&http2FlagAck != 0 ||
+		binary.BigEndian.Uint32(header[5:])&0x7fffffff != 0 || length > http2MaxFrameSize {
+		return nil, errors.New("HTTP/2 connection preface is not followed by SETTINGS")
+	}
+	settings := make([]byte, length)
+	if _, err := io.ReadFull(rw, settings); err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	buf := bytes.NewBuffer(prefix)
+	buf.Write(settings)
+	buf.Write(h2cRequestFrames(req))
+	return &prefixConn{Conn: conn, r: io.MultiReader(buf, rw)}, nil
+}
+
+// h2cRequestFrames encodes the request without a body as the HEADERS and CONTINUATION frames of the stream 1.
+// Header fields are literals without indexing, so that the HPACK state of the client is not changed.
+func h2cRequestFrames(req *http.Request) []byte {
+	block := &bytes.Buffer{}
+	writeHPACKField(block, ":method", req.Method)
+	writeHPACKField(block, ":scheme", "http")
+	writeHPACKField(block, ":authority", req.Host)
+	writeHPACKField(block, ":path", req.RequestURI)
+	connectionHeaders := map[string]bool{}
+	for _, value := range req.Header["Connection"] {
+		for _, item := range strings.Split(value, ",") {
+			connectionHeaders[http.CanonicalHeaderKey(strings.TrimSpace(item))] = true
+		}
+	}
+	for key, values := range req.Header {
+		switch key {
+		case "Connection", "Upgrade", "Http2-Settings", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Host":
+			continue
+		case "Te":
+			// only "trailers" is allowed in HTTP/2
+			values = []string{"trailers"}
+			if !headerHasToken(req.Header, "Te", "trailers") {
+				continue
+			}
+		}
+		if connectionHeaders[key] {
+			continue
+		}
+		for _, value := range values {
+			writeHPACKField(block, strings.ToLower(key), value)
+		}
+	}
+
+	frames := &bytes.Buffer{}
+	data := block.Bytes()
+	frameType, flags := byte(http2FrameHeaders), byte(http2FlagEndStream)
+	for {
+		n := len(data)
+		if n > http2MaxFrameSize {
+			n = http2MaxFrameSize
+		} else {
+			flags |= http2FlagEndHeaders
+		}
+		header := [http2FrameHeaderSize]byte{byte(n >> 16), byte(n >> 8), byte(n), frameType, flags}
+		binary.BigEndian.PutUint32(header[5:], 1)
+		frames.Write(header[:])
+		frames.Write(data[:n])
+		data = data[n:]
+		if len(data) == 0 {
+			return frames.Bytes()
+		}
+		frameType, flags = http2FrameContinuation, 0
+	}
+}
+
+// writeHPACKField writes a header field as a literal without indexing with a new name (RFC 7541 section 6.2.2).
+func writeHPACKField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteByte(0)
+	writeHPACKString(buf, name)
+	writeHPACKString(buf, value)
+}
+
+// writeHPACKString writes a string literal without Huffman encoding (RFC 7541 section 5.2).
+func writeHPACKString(buf *bytes.Buffer, s string) {
+	// the length is an integer with a 7-bit prefix (RFC 7541 section 5.1)
+	n := len(s)
+	if n < 0x7f {
+		buf.WriteByte(byte(n))
+	} else {
+		buf.WriteByte(0x7f)
+		for n -= 0x7f; n >= 0x80; n >>= 7 {
+			buf.WriteByte(byte(n&0x7f) | 0x80)
+		}
+		buf.WriteByte(byte(n))
+	}
+	buf.WriteString(s)
+}
+
+// prefixConn is a connection which reads from the given reader, which ends with the connection itself.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read implements net.Conn.
+func (c *prefixConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener is a listener which accepts a single connection, and fails afterwards.
+// It hands a connection to http.Server.Serve.
+type connListener struct {
+	conn     net.Conn
+	accepted bool
+}
+
+// Accept implements net.Listener.
+func (l *connListener) Accept() (net.Conn, error) {
+	if l.accepted {
+		return nil, io.EOF
+	}
+	l.accepted = true
+	return l.conn, nil
+}
+
+// Close implements net.Listener. The accepted connection is not closed.
+func (l *connListener) Close() error {
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
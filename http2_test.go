@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveH2C serves the Server on a local port without TLS, returning the address and a function stopping it.
+func serveH2C(t *testing.T, s *Server) (string, func()) {
+	svr := s.prepare("")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = s.serveListeners(svr, []net.Listener{ln})
+	}()
+	return ln.Addr().String(), func() {
+		_ = svr.Close()
+	}
+}
+
+func TestServer_H2C(t *testing.T) {
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte(context.Request.Proto)
+	})
+	s.UseServerOptions(ServerOptions{H2C: true})
+	addr, stop := serveH2C(t, s)
+	defer stop()
+
+	get := func(client *http.Client, header http.Header) (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/hello", nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return "", ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.Proto, string(body)
+	}
+	proto, body := get(&http.Client{Transport: NewHTTP2Transport(nil)}, nil)
+	assert.Equal(t, "HTTP/2.0", proto)
+	assert.Equal(t, "HTTP/2.0", body)
+
+	// HTTP/1.1 is still served
+	proto, body = get(http.DefaultClient, nil)
+	assert.Equal(t, "HTTP/1.1", proto)
+	assert.Equal(t, "HTTP/1.1", body)
+}
+
+func TestServer_H2C_Upgrade(t *testing.T) {
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte(context.Request.Proto + " " + context.Request.Header.Get("X-Foo"))
+	})
+	s.UseServerOptions(ServerOptions{H2C: true})
+	addr, stop := serveH2C(t, s)
+	defer stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /hello HTTP/1.1\r\nHost: " + addr + "\r\nX-Foo: bar\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAoAAAAAIAAAAA\r\n\r\n"))
+	assert.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+
+	// the connection preface and an empty SETTINGS frame
+	_, err = conn.Write(append([]byte(http2Preface), 0, 0, 0, http2FrameSettings, 0, 0, 0, 0, 0))
+	assert.NoError(t, err)
+	// the response to the upgrade request is sent on the stream 1
+	body := []byte{}
+	for {
+		header := make([]byte, http2FrameHeaderSize)
+		if _, err := io.ReadFull(r, header); !assert.NoError(t, err) {
+			return
+		}
+		payload := make([]byte, int(header[0])<<16|int(header[1])<<8|int(header[2]))
+		if _, err := io.ReadFull(r, payload); !assert.NoError(t, err) {
+			return
+		}
+		// DATA frames
+		if header[3] == 0 && binary.BigEndian.Uint32(header[5:]) == 1 {
+			body = append(body, payload...)
+			if header[4]&http2FlagEndStream != 0 {
+				break
+			}
+		}
+	}
+	assert.Equal(t, "HTTP/2.0 bar", string(body))
+}
+
+func TestIsH2CUpgrade(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "upgrade, http2-settings")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("HTTP2-Settings", "AAMAAABkAAQAoAAAAAIAAAAA")
+	assert.True(t, isH2CUpgrade(req))
+
+	req.Header.Set("HTTP2-Settings", "!")
+	assert.False(t, isH2CUpgrade(req))
+	req.Header.Set("HTTP2-Settings", "AAMAAABkAAQAoAAAAAIAAAAA")
+	req.Header.Set("Upgrade", "websocket")
+	assert.False(t, isH2CUpgrade(req))
+	req.Header.Set("Upgrade", "h2c")
+	req.ContentLength = 1
+	assert.False(t, isH2CUpgrade(req))
+}
+
+func TestWriteHPACKString(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeHPACKString(buf, "foo")
+	assert.Equal(t, []byte{3, 'f', 'o', 'o'}, buf.Bytes())
+
+	buf.Reset()
+	writeHPACKString(buf, strings.Repeat("a", 1337))
+	// 1337 - 127 = 1210 = 0b1001_0111010
+	assert.Equal(t, []byte{0x7f, 0xba, 0x09}, buf.Bytes()[:3])
+	assert.Equal(t, 3+1337, buf.Len())
+}
+
+func TestServer_H2C_Disabled(t *testing.T) {
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte(context.Request.Proto)
+	})
+	addr, stop := serveH2C(t, s)
+	defer stop()
+
+	_, err := (&http.Client{Transport: NewHTTP2Transport(nil)}).Get("http://" + addr + "/hello")
+	assert.Error(t, err)
+}
+
+func TestNewHTTP2Transport_TLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.close()
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte(context.Request.Proto)
+	})
+	s.UseTLS(TLSConfig{Certificates: []CertificateFile{ca.serverCert("server", "localhost")}})
+	addr, stop := serveTLS(t, s)
+	defer stop()
+
+	client := &http.Client{Transport: NewHTTP2Transport(tlsClient(ca, "localhost").Transport.(*http.Transport).TLSClientConfig)}
+	resp, err := client.Get("https://" + addr + "/hello")
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "HTTP/2.0", string(body))
+	}
+}
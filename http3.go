@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultAltSvcMaxAge is the default time of clients remembering the HTTP/3 endpoint.
+const defaultAltSvcMaxAge = 24 * time.Hour
+
+// HTTP3Server serves HTTP/3 over QUIC. The standard library has no QUIC implementation,
+// so it is an adapter of a third-party one, such as github.com/quic-go/quic-go/http3.
+type HTTP3Server interface {
+	// Serve serves requests from the UDP connection with the handler until Close is called.
+	// It returns http.ErrServerClosed after Close. The connection is closed by the Server afterwards.
+	Serve(conn net.PacketConn, config *tls.Config, handler http.Handler) error
+	// Close stops the server.
+	Close() error
+}
+
+// HTTP3Config is the configuration of serving HTTP/3.
+type HTTP3Config struct {
+	// Server is the HTTP/3 server. It is required.
+	Server HTTP3Server
+	// Addr is the UDP address of HTTP/3. It is the address of the first main listener if empty,
+	// which must be a TCP listener.
+	Addr string
+	// MaxAge is the time of clients remembering the HTTP/3 endpoint advertised with the Alt-Svc header.
+	// It is 24 hours if zero.
+	MaxAge time.Duration
+}
+
+// UseHTTP3 serves HTTP/3 alongside TLS with the same routing and middlewares. It requires UseTLS.
+// HTTP/3 is advertised to clients with the Alt-Svc header of responses over TLS.
+func (s *Server) UseHTTP3(config HTTP3Config) {
+	if config.Server == nil {
+		panic("nil HTTP/3 server")
+	}
+	if config.MaxAge < 0 {
+		panic("negative Alt-Svc max age")
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = defaultAltSvcMaxAge
+	}
+	s.http3 = &config
+}
+
+// http3Addr gets the UDP address of HTTP/3 and its Alt-Svc header.
+func (s *Server) http3Addr(listeners []net.Listener) (string, string, error) {
+	if s.tls == nil {
+		return "", "", errors.New("HTTP/3 requires TLS")
+	}
+	addr := s.http3.Addr
+	if addr == "" {
+		if len(listeners) == 0 {
+			return "", "", errors.New("no listener for HTTP/3")
+		}
+		if _, ok := listeners[0].Addr().(*net.TCPAddr); !ok {
+			return "", "", fmt.Errorf("HTTP/3 address is required for listener %s", listeners[0].Addr())
+		}
+		addr = listeners[0].Addr().String()
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	return addr, fmt.Sprintf(`h3=":%s"; ma=%d`, port, int64(s.http3.MaxAge/time.Second)), nil
+}
+
+// listenHTTP3 opens the UDP connection of HTTP/3, before the Server is ready. If the process is started by
+// an upgrade, the connection inherited from the parent process is used instead.
+func (s *Server) listenHTTP3(addr string) (net.PacketConn, error) {
+	if conn := takeInheritedPacketConn(); conn != nil {
+		return conn, nil
+	}
+	return net.ListenPacket("udp", addr)
+}
+
+// serveHTTP3 serves HTTP/3 on the UDP connection, returning http.ErrServerClosed after it is closed.
+func (s *Server) serveHTTP3(conn net.PacketConn) error {
+	s.logger.WithField("addr", conn.LocalAddr().String()).Info("start HTTP/3 server")
+	err := s.http3.Server.Serve(conn, s.tls.http3Config(), s)
+	if err == nil {
+		err = http.ErrServerClosed
+	}
+	return err
+}
+
+// setAltSvc advertises HTTP/3 in responses over TLS with HTTP/1.1 and HTTP/2.
+func (s *Server) setAltSvc(w http.ResponseWriter, req *http.Request) {
+	if req.TLS == nil || req.ProtoMajor >= 3 {
+		return
+	}
+	if altSvc, _ := s.altSvc.Load().(string); altSvc != "" {
+		w.Header().Set("Alt-Svc", altSvc)
+	}
+}
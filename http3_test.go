@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHTTP3Server records how it is served, and serves until it is closed.
+type fakeHTTP3Server struct {
+	err     error
+	served  chan struct{}
+	mu      sync.Mutex
+	addr    string
+	config  *tls.Config
+	handler http.Handler
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newFakeHTTP3Server(err error) *fakeHTTP3Server {
+	return &fakeHTTP3Server{err: err, served: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (f *fakeHTTP3Server) Serve(conn net.PacketConn, config *tls.Config, handler http.Handler) error {
+	f.mu.Lock()
+	f.addr, f.config, f.handler = conn.LocalAddr().String(), config, handler
+	f.mu.Unlock()
+	close(f.served)
+	if f.err != nil {
+		return f.err
+	}
+	<-f.closed
+	return http.ErrServerClosed
+}
+
+func (f *fakeHTTP3Server) Close() error {
+	f.once.Do(func() {
+		close(f.closed)
+	})
+	return nil
+}
+
+func TestServer_HTTP3(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.close()
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte(context.Request.Proto)
+	})
+	s.UseTLS(TLSConfig{Certificates: []CertificateFile{ca.serverCert("server", "localhost")}})
+	h3 := newFakeHTTP3Server(nil)
+	s.UseHTTP3(HTTP3Config{Server: h3})
+	assert.Equal(t, defaultAltSvcMaxAge, s.http3.MaxAge)
+
+	svr := s.prepare("")
+	assert.NoError(t, s.tls.reload())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- s.serveListeners(svr, []net.Listener{ln})
+	}()
+	<-h3.served
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// HTTP/3 is served on the same port with the same handler
+	h3.mu.Lock()
+	assert.Equal(t, ln.Addr().String(), h3.addr)
+	assert.Equal(t, []string{"h3"}, h3.config.NextProtos)
+	config, err := h3.config.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"h3"}, config.NextProtos)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	handler := h3.handler
+	h3.mu.Unlock()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "https://localhost/hello", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/3.0", 3, 0
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "HTTP/3.0", w.Body.String())
+	assert.Empty(t, w.Header().Get("Alt-Svc"))
+
+	// it is advertised over TLS
+	resp, err := tlsClient(ca, "localhost").Get("https://" + ln.Addr().String() + "/hello")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, `h3=":`+port+`"; ma=86400`, resp.Header.Get("Alt-Svc"))
+	}
+
+	// it stops with the main listeners
+	assert.NoError(t, svr.Close())
+	select {
+	case err := <-done:
+		assert.Equal(t, http.ErrServerClosed, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "HTTP/3 is not stopped")
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://localhost/hello", nil))
+	assert.Empty(t, w.Header().Get("Alt-Svc"))
+}
+
+func TestServer_HTTP3_Errors(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.close()
+	listen := func() []net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		return []net.Listener{ln}
+	}
+
+	// TLS is required
+	s := lifecycleServer(func(context *Context) {})
+	s.UseHTTP3(HTTP3Config{Server: newFakeHTTP3Server(nil)})
+	assert.EqualError(t, s.serveListeners(s.prepare(""), listen()), "HTTP/3 requires TLS")
+
+	// a failure of HTTP/3 stops the main listeners
+	s.UseTLS(TLSConfig{Certificates: []CertificateFile{ca.serverCert("server", "localhost")}})
+	assert.NoError(t, s.tls.reload())
+	s.UseHTTP3(HTTP3Config{Server: newFakeHTTP3Server(errors.New("quic: handshake failure")), Addr: "127.0.0.1:0",
+		MaxAge: time.Minute})
+	assert.EqualError(t, s.serveListeners(s.prepare(""), listen()), "quic: handshake failure")
+	s.UseHTTP3(HTTP3Config{Server: newFakeHTTP3Server(nil), Addr: ":8443", MaxAge: time.Minute})
+	_, altSvc, err := s.http3Addr(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `h3=":8443"; ma=60`, altSvc)
+
+	// the UDP address is bound before the Server is ready
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		h3 := newFakeHTTP3Server(nil)
+		s.UseHTTP3(HTTP3Config{Server: h3, Addr: conn.LocalAddr().String()})
+		started := false
+		s.OnStart(func() {
+			started = true
+		})
+		err = s.serveListeners(s.prepare(""), listen())
+		assert.Error(t, err)
+		assert.False(t, started)
+		select {
+		case <-h3.served:
+			assert.Fail(t, "HTTP/3 is served")
+		default:
+		}
+	}
+
+	assert.Panics(t, func() {
+		s.UseHTTP3(HTTP3Config{})
+	})
+	assert.Panics(t, func() {
+		s.UseHTTP3(HTTP3Config{Server: newFakeHTTP3Server(nil), MaxAge: -1})
+	})
+}
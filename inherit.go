@@ -0,0 +1,212 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// listenFDsEnv is the environment variable with the number of main listeners inherited from the parent process,
+	// which are the file descriptors from 3.
+	listenFDsEnv = "GATEWAY_LISTEN_FDS"
+	// adminFDEnv is the environment variable with the file descriptor of the inherited admin listener.
+	adminFDEnv = "GATEWAY_ADMIN_FD"
+	// http3FDEnv is the environment variable with the file descriptor of the inherited UDP connection of HTTP/3.
+	http3FDEnv = "GATEWAY_HTTP3_FD"
+	// readyFDEnv is the environment variable with the file descriptor of the pipe to the parent process,
+	// which is written and closed when the Server is ready.
+	readyFDEnv = "GATEWAY_READY_FD"
+	// readyMessage is written to the ready pipe.
+	readyMessage = "ready\n"
+	// firstInheritedFD is the first file descriptor of inherited files, after stdin, stdout and stderr.
+	firstInheritedFD = 3
+)
+
+// inherited is the files inherited from the parent process during an upgrade.
+// They are read once per process, and the listeners are used by the first Server which runs.
+var inherited struct {
+	once      sync.Once
+	notify    sync.Once
+	err       error
+	ready     *os.File
+	mu        sync.Mutex
+	listeners []net.Listener
+	admin     net.Listener
+	http3Conn net.PacketConn
+}
+
+// loadInherited reads the files inherited from the parent process during an upgrade.
+// The environment variables are removed, so that they are not passed to other child processes.
+func loadInherited() error {
+	inherited.once.Do(func() {
+		defer os.Unsetenv(listenFDsEnv)
+		defer os.Unsetenv(adminFDEnv)
+		defer os.Unsetenv(http3FDEnv)
+		defer os.Unsetenv(readyFDEnv)
+
+		if value := os.Getenv(listenFDsEnv); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				inherited.err = fmt.Errorf("invalid %s: %q", listenFDsEnv, value)
+				return
+			}
+			if inherited.listeners, inherited.err = fileListeners(firstInheritedFD, n); inherited.err != nil {
+				return
+			}
+		}
+		if value := os.Getenv(adminFDEnv); value != "" {
+			fd, err := strconv.Atoi(value)
+			if err != nil || fd < firstInheritedFD {
+				inherited.err = fmt.Errorf("invalid %s: %q", adminFDEnv, value)
+				return
+			}
+			if inherited.admin, inherited.err = fileListener(uintptr(fd)); inherited.err != nil {
+				return
+			}
+		}
+		if value := os.Getenv(http3FDEnv); value != "" {
+			fd, err := strconv.Atoi(value)
+			if err != nil || fd < firstInheritedFD {
+				inherited.err = fmt.Errorf("invalid %s: %q", http3FDEnv, value)
+				return
+			}
+			if inherited.http3Conn, inherited.err = filePacketConn(uintptr(fd)); inherited.err != nil {
+				return
+			}
+		}
+		if value := os.Getenv(readyFDEnv); value != "" {
+			fd, err := strconv.Atoi(value)
+			if err != nil || fd < firstInheritedFD {
+				inherited.err = fmt.Errorf("invalid %s: %q", readyFDEnv, value)
+				return
+			}
+			inherited.ready = os.NewFile(uintptr(fd), "ready")
+		}
+	})
+	return inherited.err
+}
+
+// takeInheritedListeners takes the main listeners inherited from the parent process. It returns nil if there are
+// none, or they have been taken.
+func takeInheritedListeners() ([]net.Listener, error) {
+	if err := loadInherited(); err != nil {
+		return nil, err
+	}
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	listeners := inherited.listeners
+	inherited.listeners = nil
+	return listeners, nil
+}
+
+// takeInheritedAdmin takes the admin listener inherited from the parent process. It returns nil if there is none,
+// or it has been taken.
+func takeInheritedAdmin() net.Listener {
+	if loadInherited() != nil {
+		return nil
+	}
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	admin := inherited.admin
+	inherited.admin = nil
+	return admin
+}
+
+// takeInheritedPacketConn takes the UDP connection of HTTP/3 inherited from the parent process. It returns nil
+// if there is none, or it has been taken.
+func takeInheritedPacketConn() net.PacketConn {
+	if loadInherited() != nil {
+		return nil
+	}
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	conn := inherited.http3Conn
+	inherited.http3Conn = nil
+	return conn
+}
+
+// SystemdListeners gets the listeners passed by systemd socket activation (the LISTEN_PID and LISTEN_FDS
+// environment variables), in the order of the sockets in the socket unit. Add them with AddNetListener,
+// and run the Server with an empty address to serve only them.
+//
+// It returns nil if the process is not socket-activated. The environment variables are removed, so that they are
+// not passed to child processes.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		// the sockets are for another process
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", fds)
+	}
+	return fileListeners(firstInheritedFD, n)
+}
+
+// fileListeners creates listeners from n file descriptors from the first one.
+func fileListeners(first int, n int) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, n)
+	for fd := first; fd < first+n; fd++ {
+		ln, err := fileListener(uintptr(fd))
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// fileListener creates a listener from a file descriptor, which is closed afterwards
+// (the listener has its own duplicate).
+func fileListener(fd uintptr) (net.Listener, error) {
+	file := os.NewFile(fd, "listener")
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d: %v", fd, err)
+	}
+	return ln, nil
+}
+
+// filePacketConn creates a packet connection from a file descriptor, which is closed afterwards
+// (the connection has its own duplicate).
+func filePacketConn(fd uintptr) (net.PacketConn, error) {
+	file := os.NewFile(fd, "packet")
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer file.Close()
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d: %v", fd, err)
+	}
+	return conn, nil
+}
+
+// notifyReady tells the parent process that the Server is ready, if it is started by an upgrade.
+func notifyReady() {
+	if loadInherited() != nil || inherited.ready == nil {
+		return
+	}
+	inherited.notify.Do(func() {
+		_, _ = inherited.ready.Write([]byte(readyMessage))
+		_ = inherited.ready.Close()
+	})
+}
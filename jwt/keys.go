@@ -0,0 +1,357 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// defaultRefreshInterval is the default interval of refreshing a JWKS.
+	defaultRefreshInterval = time.Hour
+	// defaultMinRefreshInterval is the default minimum interval of refreshing a JWKS for an unknown key ID.
+	defaultMinRefreshInterval = time.Minute
+	// defaultFetchTimeout is the default timeout of fetching a JWKS.
+	defaultFetchTimeout = 10 * time.Second
+)
+
+// ErrKeyNotFound is the error of a token signed with an unknown key.
+var ErrKeyNotFound = errors.New("jwt: key not found")
+
+// KeySet gets keys to verify tokens. It should be safe for concurrent use.
+type KeySet interface {
+	// Key gets the key of the key ID (which may be empty) for the algorithm.
+	// The key is []byte for HS256, *rsa.PublicKey for RS256, *ecdsa.PublicKey for ES256, ES384 and ES512,
+	// and ed25519.PublicKey for EdDSA.
+	Key(kid string, alg string) (interface{}, error)
+}
+
+// StaticKeys is a KeySet of fixed keys by key ID. The key of an empty key ID is used for tokens without
+// a "kid" header, or with a key ID not in the map.
+type StaticKeys map[string]interface{}
+
+// Key implements KeySet.
+func (s StaticKeys) Key(kid string, alg string) (interface{}, error) {
+	if key, ok := s[kid]; ok {
+		return key, nil
+	}
+	if key, ok := s[""]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// ParsePublicKeyPEM parses an RSA, ECDSA or Ed25519 public key (PKIX, "PUBLIC KEY") or certificate in PEM.
+func ParsePublicKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM data")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// JSONWebKey is a key in a JWKS (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+	// oct
+	K string `json:"k,omitempty"`
+}
+
+// jwkCurves is the curves of EC keys by name.
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// PublicKey converts the JSON web key to a key which verifies tokens.
+func (k JSONWebKey) PublicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("jwt: invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := jwkCurves[k.Curve]
+		if !ok {
+			return nil, fmt.Errorf("jwt: unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("jwt: invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("jwt: unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwt: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("jwt: unsupported key type %q", k.KeyType)
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("jwt: invalid integer in key")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// jwksKey is a key loaded from a JWKS.
+type jwksKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// JWKSConfig is the configuration of JWKS.
+type JWKSConfig struct {
+	// URL is the URL of the JWKS. Either URL or File is required.
+	URL string
+	// File is the path of a JWKS file.
+	File string
+	// Client is the HTTP client of fetching the JWKS. http.DefaultClient is used if it is nil.
+	Client *http.Client
+	// RefreshInterval is the interval of reloading the JWKS. It is one hour if zero.
+	RefreshInterval time.Duration
+	// MinRefreshInterval is the minimum interval of reloading the JWKS when a token has an unknown key ID,
+	// which happens when the keys are rotated. It is one minute if zero.
+	MinRefreshInterval time.Duration
+}
+
+// JWKS is a KeySet loaded from a JSON Web Key Set (RFC 7517) at a URL or in a file.
+//
+// The keys are cached and reloaded every RefreshInterval, or when a token has an unknown key ID,
+// so that rotated keys are picked up. The cached keys are kept if reloading fails.
+// The keys are reloaded once for concurrent callers, who wait for the reload.
+type JWKS struct {
+	config JWKSConfig
+	// reloads is the reload in progress, which concurrent callers wait for.
+	reloads gateway.CallGroup[error]
+
+	mu         sync.Mutex
+	keys       []jwksKey
+	loaded     time.Time
+	lastLoaded time.Time
+	err        error
+}
+
+// NewJWKS creates a JWKS at the URL.
+func NewJWKS(url string) *JWKS {
+	return NewJWKSWithConfig(JWKSConfig{URL: url})
+}
+
+// NewJWKSFile creates a JWKS in the file.
+func NewJWKSFile(filename string) *JWKS {
+	return NewJWKSWithConfig(JWKSConfig{File: filename})
+}
+
+// NewJWKSWithConfig creates a JWKS with the configuration. The keys are loaded when they are first needed.
+func NewJWKSWithConfig(config JWKSConfig) *JWKS {
+	if config.URL == "" && config.File == "" {
+		panic("no JWKS URL or file")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: defaultFetchTimeout}
+	}
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = defaultRefreshInterval
+	}
+	if config.MinRefreshInterval == 0 {
+		config.MinRefreshInterval = defaultMinRefreshInterval
+	}
+	return &JWKS{config: config}
+}
+
+// Key implements KeySet.
+func (j *JWKS) Key(kid string, alg string) (interface{}, error) {
+	if j.due(false) {
+		_ = j.refresh()
+	}
+	if key, ok := j.find(kid, alg); ok {
+		return key, nil
+	}
+	if j.due(true) {
+		// the key may have been rotated
+		_ = j.refresh()
+		if key, ok := j.find(kid, alg); ok {
+			return key, nil
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.keys) == 0 && j.err != nil {
+		return nil, j.err
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Refresh reloads the keys.
+func (j *JWKS) Refresh() error {
+	return j.refresh()
+}
+
+// due checks if the keys should be reloaded: they have never been loaded, or they are stale (or a key is unknown)
+// and have not been reloaded within MinRefreshInterval.
+func (j *JWKS) due(unknown bool) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	if j.lastLoaded.IsZero() {
+		return true
+	}
+	stale := j.loaded.IsZero() || now.Sub(j.loaded) >= j.config.RefreshInterval
+	return (stale || unknown) && now.Sub(j.lastLoaded) >= j.config.MinRefreshInterval
+}
+
+// refresh reloads the keys, keeping the cached ones if it fails. The keys are loaded without holding the lock,
+// and concurrent callers wait for the same reload.
+func (j *JWKS) refresh() (err error) {
+	current, leader := j.reloads.Join("")
+	if !leader {
+		if err, ok := current.Wait(context.Background(), defaultFetchTimeout); ok {
+			return err
+		}
+		return errors.New("jwt: timeout waiting for JWKS")
+	}
+	defer func() {
+		current.Finish(err)
+	}()
+
+	now := time.Now()
+	j.mu.Lock()
+	j.lastLoaded = now
+	j.mu.Unlock()
+	keys, err := j.load()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.err = err
+	if err == nil {
+		j.keys, j.loaded = keys, now
+	}
+	return err
+}
+
+// find finds the key of the key ID for the algorithm. A token without a key ID matches the only key of the algorithm.
+// Keys of other types, or EC keys of other curves, are skipped.
+func (j *JWKS) find(kid string, alg string) (interface{}, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var found interface{}
+	count := 0
+	for _, k := range j.keys {
+		if (k.alg != "" && k.alg != alg) || !keyMatches(alg, k.key) {
+			continue
+		}
+		if kid != "" {
+			if k.kid == kid {
+				return k.key, true
+			}
+			continue
+		}
+		found = k.key
+		count++
+	}
+	return found, count == 1
+}
+
+// load loads the keys from the URL or the file. Keys which are not for signatures or not supported are skipped.
+func (j *JWKS) load() ([]jwksKey, error) {
+	var data []byte
+	var err error
+	if j.config.URL != "" {
+		var resp *http.Response
+		resp, err = j.config.Client.Get(j.config.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwt: failed to fetch JWKS: %s", resp.Status)
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+	} else {
+		data, err = ioutil.ReadFile(j.config.File)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]jwksKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, jwksKey{kid: k.KeyID, alg: k.Algorithm, key: key})
+	}
+	return keys, nil
+}
@@ -0,0 +1,242 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func toJWK(kid string, key interface{}) JSONWebKey {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{KeyType: "RSA", KeyID: kid, Algorithm: RS256, Use: "sig",
+			N: enc(k.N.Bytes()), E: enc(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return JSONWebKey{KeyType: "EC", KeyID: kid, Curve: k.Curve.Params().Name,
+			X: enc(k.X.Bytes()), Y: enc(k.Y.Bytes())}
+	case ed25519.PublicKey:
+		return JSONWebKey{KeyType: "OKP", KeyID: kid, Curve: "Ed25519", X: enc(k)}
+	case []byte:
+		return JSONWebKey{KeyType: "oct", KeyID: kid, Algorithm: HS256, K: enc(k)}
+	}
+	panic("unknown key")
+}
+
+func marshalJWKS(t *testing.T, keys ...JSONWebKey) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	assert.NoError(t, err)
+	return data
+}
+
+func TestJSONWebKey_PublicKey(t *testing.T) {
+	keys := newTestKeys(t)
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(t, err)
+	for _, public := range []interface{}{&keys.rsa.PublicKey, &keys.ecdsa.PublicKey, &p521.PublicKey,
+		keys.ed25519Pub, keys.hmac} {
+		key, err := toJWK("k", public).PublicKey()
+		assert.NoError(t, err)
+		assert.Equal(t, public, key)
+	}
+
+	invalid := []JSONWebKey{
+		{KeyType: "RSA", N: "", E: "AQAB"},
+		{KeyType: "EC", Curve: "P-384"},
+		{KeyType: "EC", Curve: "P-224", X: "AQ", Y: "AQ"},
+		{KeyType: "EC", Curve: "P-256", X: "AQ", Y: "AQ"},
+		{KeyType: "OKP", Curve: "Ed25519", X: "AQ"},
+		{KeyType: "OKP", Curve: "X25519"},
+		{KeyType: "unknown"},
+	}
+	for _, k := range invalid {
+		_, err := k.PublicKey()
+		assert.Error(t, err, k.KeyType)
+	}
+}
+
+func TestJWKS_URL(t *testing.T) {
+	keys := newTestKeys(t)
+	var mu sync.Mutex
+	jwks := marshalJWKS(t, toJWK("rsa1", &keys.rsa.PublicKey), JSONWebKey{KeyType: "RSA", Use: "enc", KeyID: "enc"})
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+
+	set := NewJWKSWithConfig(JWKSConfig{URL: server.URL, MinRefreshInterval: time.Millisecond})
+	key, err := set.Key("rsa1", RS256)
+	assert.NoError(t, err)
+	assert.Equal(t, &keys.rsa.PublicKey, key)
+	// without a key ID, the only key of the algorithm is used
+	key, err = set.Key("", RS256)
+	assert.NoError(t, err)
+	assert.Equal(t, &keys.rsa.PublicKey, key)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// rotated
+	mu.Lock()
+	jwks = marshalJWKS(t, toJWK("ec1", &keys.ecdsa.PublicKey))
+	mu.Unlock()
+	time.Sleep(2 * time.Millisecond)
+	key, err = set.Key("ec1", ES256)
+	assert.NoError(t, err)
+	assert.Equal(t, &keys.ecdsa.PublicKey, key)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	_, err = set.Key("rsa1", RS256)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestJWKS_MinRefreshInterval(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	set := NewJWKS(server.URL)
+	for i := 0; i < 5; i++ {
+		_, err := set.Key("unknown", RS256)
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKS_ConcurrentRefresh(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := marshalJWKS(t, toJWK("rsa1", &keys.rsa.PublicKey))
+	var fetches int32
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-block
+		}
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+
+	set := NewJWKS(server.URL)
+	_, err := set.Key("rsa1", RS256)
+	assert.NoError(t, err)
+
+	// cached keys are used while the keys are being reloaded
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, set.Refresh())
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&fetches) == 2
+	}, time.Second, time.Millisecond)
+	key, err := set.Key("rsa1", RS256)
+	assert.NoError(t, err)
+	assert.Equal(t, &keys.rsa.PublicKey, key)
+
+	// concurrent reloads share the same fetch
+	time.Sleep(10 * time.Millisecond)
+	close(block)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKS_Error(t *testing.T) {
+	failing := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"keys":[{"kty":"oct","kid":"k","k":"c2VjcmV0"}]}`))
+	}))
+	defer server.Close()
+
+	set := NewJWKS(server.URL)
+	_, err := set.Key("k", HS256)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrKeyNotFound, err)
+
+	atomic.StoreInt32(&failing, 0)
+	assert.NoError(t, set.Refresh())
+	key, err := set.Key("k", HS256)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	// the cached keys are kept
+	atomic.StoreInt32(&failing, 1)
+	assert.Error(t, set.Refresh())
+	_, err = set.Key("k", HS256)
+	assert.NoError(t, err)
+}
+
+func TestJWKS_File(t *testing.T) {
+	keys := newTestKeys(t)
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jwks.json")
+	assert.NoError(t, ioutil.WriteFile(filename, marshalJWKS(t, toJWK("ed", keys.ed25519Pub)), 0600))
+
+	key, err := NewJWKSFile(filename).Key("ed", EdDSA)
+	assert.NoError(t, err)
+	assert.Equal(t, keys.ed25519Pub, key)
+
+	assert.Panics(t, func() {
+		NewJWKSWithConfig(JWKSConfig{})
+	})
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	keys := newTestKeys(t)
+	der, err := x509.MarshalPKIXPublicKey(&keys.ecdsa.PublicKey)
+	assert.NoError(t, err)
+	key, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.Equal(t, &keys.ecdsa.PublicKey, key)
+
+	key, err = ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&keys.rsa.PublicKey)}))
+	assert.NoError(t, err)
+	assert.Equal(t, &keys.rsa.PublicKey, key)
+
+	_, err = ParsePublicKeyPEM([]byte("not pem"))
+	assert.Error(t, err)
+}
+
+func TestStaticKeys(t *testing.T) {
+	keys := StaticKeys{"a": []byte("a"), "": []byte("default")}
+	key, err := keys.Key("a", HS256)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), key)
+	key, err = keys.Key("b", HS256)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("default"), key)
+	_, err = StaticKeys{}.Key("a", HS256)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,208 @@
+// Package jwt provides a middleware that authenticates requests with JSON Web Tokens (RFC 7519)
+// signed with HS256, RS256, ES256, ES384, ES512 or EdDSA, and authorizes them with rules of claims by Service.
+package jwt
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// ClaimsKey is the key of the verified Claims in Context.Data.
+	ClaimsKey = "jwt.claims"
+	// TokenKey is the key of the verified *Token in Context.Data.
+	TokenKey = "jwt.token"
+)
+
+// defaultAlgorithms is the accepted algorithms if Config.Algorithms is empty.
+var defaultAlgorithms = []string{RS256, ES256, ES384, ES512, EdDSA, HS256}
+
+// Rule is a rule of authorizing requests to Service.
+type Rule struct {
+	// Service is a pattern of Service names configured for endpoints. It can be exact (for example: "api.admin"),
+	// or end with ".*" to match a Service and all Service under it (for example: "api.admin.*" matches "api.admin"
+	// and "api.admin.users"). An asterisk (*) matches all Service.
+	Service string
+	// Scopes is a list of scopes which the token must have all of. See Claims.Scopes.
+	Scopes []string
+	// Claims is the required values of claims. A claim which is an array must contain the value.
+	Claims map[string]string
+}
+
+// matches checks if the rule applies to the Service.
+func (r Rule) matches(service string) bool {
+	if r.Service == "*" || r.Service == service {
+		return true
+	}
+	if strings.HasSuffix(r.Service, ".*") {
+		parent := strings.TrimSuffix(r.Service, ".*")
+		return service == parent || strings.HasPrefix(service, parent+".")
+	}
+	return false
+}
+
+// authorize checks if the claims satisfy the rule, returning the first missing scope if any.
+func (r Rule) authorize(claims Claims) (string, bool) {
+	for _, scope := range r.Scopes {
+		if !claims.HasScope(scope) {
+			return scope, false
+		}
+	}
+	for name, value := range r.Claims {
+		found := false
+		for _, v := range claims.Strings(name) {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return "", true
+}
+
+// Config is the configuration of the JWT middleware.
+type Config struct {
+	// Keys gets the keys to verify tokens, such as StaticKeys or JWKS. It is required.
+	Keys KeySet
+	// Algorithms is a list of accepted algorithms. RS256, ES256, ES384, ES512, EdDSA and HS256 are accepted
+	// if it is empty.
+	// A key only verifies tokens of the algorithm of its type, so an RSA public key cannot be used as an HMAC secret.
+	Algorithms []string
+	// Header is the header with the token in the Bearer scheme. It is "Authorization" if empty.
+	Header string
+	// Cookie is the name of the cookie with the token, which is used if the header does not have a token.
+	// Cookies are not used if it is empty.
+	Cookie string
+	// Validation is the rules of validating claims.
+	Validation Validation
+	// Rules is a list of rules of authorizing requests. All rules matching the Service must be satisfied.
+	Rules []Rule
+	// Optional allows requests without a token, unless a rule matches the Service.
+	// Requests with an invalid token are always rejected.
+	Optional bool
+}
+
+// Middleware provides a middleware that verifies the token of every request, puts the claims in
+// Context.Data with ClaimsKey, and sets the Principal of the Context with the "sub" claim as the subject.
+//
+// Requests without a valid token get "401 Unauthorized" from the error handler of the Server,
+// and requests not satisfying the rules get "403 Forbidden", both with a WWW-Authenticate header (RFC 6750).
+func Middleware(config Config) gateway.Handler {
+	if config.Keys == nil {
+		panic("no keys for JWT")
+	}
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = defaultAlgorithms
+	}
+	if config.Header == "" {
+		config.Header = "Authorization"
+	}
+
+	return func(context *gateway.Context) {
+		service := context.GetConfiguredServiceName()
+		var rules []Rule
+		for _, rule := range config.Rules {
+			if rule.matches(service) {
+				rules = append(rules, rule)
+			}
+		}
+
+		raw := extractToken(context.Request, config.Header, config.Cookie)
+		if raw == "" {
+			if config.Optional && len(rules) == 0 {
+				return
+			}
+			context.Header.Set("WWW-Authenticate", "Bearer")
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		token, err := Parse(raw, config.Keys, config.Algorithms)
+		if err == nil {
+			err = config.Validation.Validate(token.Claims, time.Now())
+		}
+		if err != nil {
+			context.Logger.WithError(err).Info("invalid token")
+			context.Header.Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+
+				errorDescription(err)+`"`)
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		for _, rule := range rules {
+			if scope, ok := rule.authorize(token.Claims); !ok {
+				value := `Bearer error="insufficient_scope"`
+				if scope != "" {
+					value += `, scope="` + scope + `"`
+				}
+				context.Header.Set("WWW-Authenticate", value)
+				context.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		context.Data[ClaimsKey] = token.Claims
+		context.Data[TokenKey] = token
+		context.SetPrincipal(&gateway.Principal{
+			Subject:    token.Claims.Subject(),
+			Method:     "jwt",
+			Attributes: map[string]interface{}{"claims": token.Claims, "scopes": token.Claims.Scopes()},
+		})
+	}
+}
+
+// GetClaims gets the verified claims of the request. It returns nil if the request does not have a verified token.
+func GetClaims(context *gateway.Context) Claims {
+	claims, _ := context.Data[ClaimsKey].(Claims)
+	return claims
+}
+
+// GetToken gets the verified token of the request. It returns nil if the request does not have a verified token.
+func GetToken(context *gateway.Context) *Token {
+	token, _ := context.Data[TokenKey].(*Token)
+	return token
+}
+
+// errorDescription gets the error_description of the WWW-Authenticate header for a token error.
+// Other errors, such as failures of fetching keys, are described generally, so that their details are not exposed.
+func errorDescription(err error) string {
+	switch err {
+	case ErrMalformed:
+		return "malformed token"
+	case ErrAlgorithm:
+		return "algorithm not allowed"
+	case ErrKeyNotFound:
+		return "key not found"
+	case ErrSignature:
+		return "invalid signature"
+	case ErrExpired:
+		return "token expired"
+	case ErrNotValidYet:
+		return "token not valid yet"
+	case ErrIssuer:
+		return "invalid issuer"
+	case ErrAudience:
+		return "invalid audience"
+	default:
+		return "invalid token"
+	}
+}
+
+// extractToken gets the token from the header in the Bearer scheme, or the cookie.
+func extractToken(req *http.Request, header string, cookie string) string {
+	if value := req.Header.Get(header); len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	if cookie != "" {
+		if c, err := req.Cookie(cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package jwt
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("secret")
+
+func testServer(config Config) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", http.MethodGet, "api.foo")
+	cfg.Add("/users", http.MethodGet, "api.admin.users")
+	cfg.Add("/public", http.MethodGet, "public")
+	s.UseConfig(cfg)
+	s.UseMiddleware(Middleware(config))
+	s.Register("*", func(context *gateway.Context) {
+		if claims := GetClaims(context); claims != nil {
+			context.Response = []byte("hello " + claims.Subject())
+			if p := context.GetPrincipal(); p == nil || p.Subject != claims.Subject() || p.Method != "jwt" {
+				context.Response = []byte("invalid principal")
+			}
+		} else {
+			context.Response = []byte("hello anonymous")
+		}
+	})
+	return s.Handler()
+}
+
+func request(handler http.Handler, path string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := testServer(Config{
+		Keys:       StaticKeys{"": testSecret},
+		Validation: Validation{Issuer: "issuer", ClockSkew: time.Second},
+		Rules: []Rule{
+			{Service: "api.admin.*", Scopes: []string{"admin"}},
+		},
+	})
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	token := sign(t, Claims{"sub": "alice", "iss": "issuer", "exp": exp, "scope": "read"}, HS256, "", testSecret)
+	w := request(handler, "/foo", token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello alice", w.Body.String())
+
+	w = request(handler, "/users", token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer error="insufficient_scope", scope="admin"`, w.Header().Get("WWW-Authenticate"))
+
+	admin := sign(t, Claims{"sub": "bob", "iss": "issuer", "exp": exp, "scope": "read admin"}, HS256, "", testSecret)
+	w = request(handler, "/users", admin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello bob", w.Body.String())
+
+	w = request(handler, "/foo", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	expired := sign(t, Claims{"sub": "alice", "iss": "issuer", "exp": exp - 7200}, HS256, "", testSecret)
+	w = request(handler, "/foo", expired)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token", error_description="token expired"`,
+		w.Header().Get("WWW-Authenticate"))
+
+	forged := sign(t, Claims{"sub": "alice", "iss": "issuer", "exp": exp}, HS256, "", []byte("wrong"))
+	w = request(handler, "/foo", forged)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token", error_description="invalid signature"`,
+		w.Header().Get("WWW-Authenticate"))
+}
+
+func TestErrorDescription(t *testing.T) {
+	assert.Equal(t, "malformed token", errorDescription(ErrMalformed))
+	assert.Equal(t, "key not found", errorDescription(ErrKeyNotFound))
+	// details of other errors are not exposed
+	assert.Equal(t, "invalid token", errorDescription(errors.New(`jwt: failed to fetch JWKS: "500" \`)))
+}
+
+func TestMiddleware_Optional(t *testing.T) {
+	handler := testServer(Config{
+		Keys:     StaticKeys{"": testSecret},
+		Optional: true,
+		Rules: []Rule{
+			{Service: "api.admin.*", Claims: map[string]string{"role": "admin"}},
+		},
+	})
+
+	w := request(handler, "/public", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello anonymous", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/users", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(handler, "/public", "invalid").Code)
+
+	user := sign(t, Claims{"sub": "alice", "role": []interface{}{"user"}}, HS256, "", testSecret)
+	w = request(handler, "/users", user)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer error="insufficient_scope"`, w.Header().Get("WWW-Authenticate"))
+
+	admin := sign(t, Claims{"sub": "bob", "role": []interface{}{"user", "admin"}}, HS256, "", testSecret)
+	assert.Equal(t, http.StatusOK, request(handler, "/users", admin).Code)
+}
+
+func TestMiddleware_Cookie(t *testing.T) {
+	handler := testServer(Config{Keys: StaticKeys{"": testSecret}, Cookie: "session", Algorithms: []string{HS256}})
+
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: sign(t, Claims{"sub": "carol"}, HS256, "", testSecret)})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello carol", w.Body.String())
+}
+
+func TestRule_Matches(t *testing.T) {
+	assert.True(t, Rule{Service: "*"}.matches("anything"))
+	assert.True(t, Rule{Service: "api.admin"}.matches("api.admin"))
+	assert.False(t, Rule{Service: "api.admin"}.matches("api.admin.users"))
+	assert.True(t, Rule{Service: "api.admin.*"}.matches("api.admin"))
+	assert.True(t, Rule{Service: "api.admin.*"}.matches("api.admin.users.list"))
+	assert.False(t, Rule{Service: "api.admin.*"}.matches("api.administrator"))
+}
+
+func TestMiddleware_NoKeys(t *testing.T) {
+	assert.Panics(t, func() {
+		Middleware(Config{})
+	})
+}
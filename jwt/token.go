@@ -0,0 +1,365 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// HS256 is HMAC with SHA-256.
+	HS256 = "HS256"
+	// RS256 is RSASSA-PKCS1-v1_5 with SHA-256.
+	RS256 = "RS256"
+	// ES256 is ECDSA with P-256 and SHA-256.
+	ES256 = "ES256"
+	// ES384 is ECDSA with P-384 and SHA-384.
+	ES384 = "ES384"
+	// ES512 is ECDSA with P-521 and SHA-512.
+	ES512 = "ES512"
+	// EdDSA is EdDSA with Ed25519.
+	EdDSA = "EdDSA"
+)
+
+var (
+	// ErrMalformed is the error of a token which cannot be parsed.
+	ErrMalformed = errors.New("jwt: malformed token")
+	// ErrAlgorithm is the error of a token signed with an algorithm which is not allowed.
+	ErrAlgorithm = errors.New("jwt: algorithm not allowed")
+	// ErrSignature is the error of a token with an invalid signature.
+	ErrSignature = errors.New("jwt: invalid signature")
+	// ErrExpired is the error of an expired token.
+	ErrExpired = errors.New("jwt: token expired")
+	// ErrNotValidYet is the error of a token used before its "nbf" claim.
+	ErrNotValidYet = errors.New("jwt: token not valid yet")
+	// ErrIssuer is the error of a token with an unexpected issuer.
+	ErrIssuer = errors.New("jwt: invalid issuer")
+	// ErrAudience is the error of a token without the expected audience.
+	ErrAudience = errors.New("jwt: invalid audience")
+)
+
+// ecdsaAlgorithm is the curve and the hash function of an ECDSA algorithm.
+type ecdsaAlgorithm struct {
+	curve elliptic.Curve
+	hash  func() hash.Hash
+}
+
+// ecdsaAlgorithms is the ECDSA algorithms by name. A key only signs and verifies tokens of the algorithm of its curve.
+var ecdsaAlgorithms = map[string]ecdsaAlgorithm{
+	ES256: {curve: elliptic.P256(), hash: sha256.New},
+	ES384: {curve: elliptic.P384(), hash: sha512.New384},
+	ES512: {curve: elliptic.P521(), hash: sha512.New},
+}
+
+// digest hashes the input with the hash function of the algorithm.
+func (a ecdsaAlgorithm) digest(input []byte) []byte {
+	h := a.hash()
+	_, _ = h.Write(input)
+	return h.Sum(nil)
+}
+
+// size gets the size in bytes of each of the two integers in a signature.
+func (a ecdsaAlgorithm) size() int {
+	return (a.curve.Params().BitSize + 7) / 8
+}
+
+// Claims is the claims of a token.
+type Claims map[string]interface{}
+
+// String gets a string claim. It returns an empty string if the claim is not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings gets a claim which is a string or an array of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Time gets a NumericDate claim, such as "exp". It returns false if the claim is not a number.
+func (c Claims) Time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(v*float64(time.Second))), true
+}
+
+// Subject gets the "sub" claim.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// Audience gets the "aud" claim, which can be a string or an array of strings.
+func (c Claims) Audience() []string {
+	return c.Strings("aud")
+}
+
+// Scopes gets the scopes of the token, from the space-separated "scope" claim or the "scp" array.
+func (c Claims) Scopes() []string {
+	if scope := c.String("scope"); scope != "" {
+		return strings.Fields(scope)
+	}
+	return c.Strings("scp")
+}
+
+// HasScope checks if the token has the scope.
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Token is a verified token.
+type Token struct {
+	// Raw is the token in compact serialization.
+	Raw string
+	// Header is the JOSE header.
+	Header map[string]interface{}
+	// Claims is the claims in the payload.
+	Claims Claims
+}
+
+// Algorithm gets the "alg" header.
+func (t *Token) Algorithm() string {
+	alg, _ := t.Header["alg"].(string)
+	return alg
+}
+
+// KeyID gets the "kid" header.
+func (t *Token) KeyID() string {
+	kid, _ := t.Header["kid"].(string)
+	return kid
+}
+
+// Validation is the rules of validating claims.
+type Validation struct {
+	// Issuer is the expected "iss" claim. It is not checked if empty.
+	Issuer string
+	// Audience is a list of accepted audiences. The "aud" claim must have one of them. It is not checked if empty.
+	Audience []string
+	// ClockSkew is the leeway of checking "exp" and "nbf".
+	ClockSkew time.Duration
+	// RequireExpiry rejects tokens without an "exp" claim.
+	RequireExpiry bool
+}
+
+// Parse parses a token and verifies its signature with a key from the KeySet,
+// accepting only the given algorithms. It does not validate the claims. See Validation.Validate.
+func Parse(raw string, keys KeySet, algorithms []string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	token := &Token{Raw: raw}
+	if err := decodeSegment(parts[0], &token.Header); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := decodeSegment(parts[1], &token.Claims); err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	alg := token.Algorithm()
+	allowed := false
+	for _, a := range algorithms {
+		if a == alg {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, ErrAlgorithm
+	}
+
+	key, err := keys.Key(token.KeyID(), alg)
+	if err != nil {
+		return nil, err
+	}
+	if !verify(alg, key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrSignature
+	}
+	return token, nil
+}
+
+// Validate validates the claims at the time.
+func (v Validation) Validate(claims Claims, now time.Time) error {
+	if exp, ok := claims.Time("exp"); ok {
+		if !now.Before(exp.Add(v.ClockSkew)) {
+			return ErrExpired
+		}
+	} else if v.RequireExpiry {
+		return ErrExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.ClockSkew).Before(nbf) {
+		return ErrNotValidYet
+	}
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return ErrIssuer
+	}
+	if len(v.Audience) > 0 {
+		found := false
+		for _, aud := range claims.Audience() {
+			for _, expected := range v.Audience {
+				if aud == expected {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return ErrAudience
+		}
+	}
+	return nil
+}
+
+// Sign signs the claims into a token. The key is []byte for HS256, *rsa.PrivateKey for RS256,
+// *ecdsa.PrivateKey of the curve of the algorithm for ES256, ES384 and ES512, and ed25519.PrivateKey for EdDSA. The "kid" header is set if kid is not empty.
+func Sign(claims Claims, alg string, kid string, key interface{}) (string, error) {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			return "", ErrAlgorithm
+		}
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg != RS256 {
+			return "", ErrAlgorithm
+		}
+		if signature, err = rsa.SignPKCS1v15(nil, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		algorithm, ok := ecdsaAlgorithms[alg]
+		if !ok || k.Curve != algorithm.curve {
+			return "", ErrAlgorithm
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, algorithm.digest([]byte(input)))
+		if err != nil {
+			return "", err
+		}
+		size := algorithm.size()
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+	case ed25519.PrivateKey:
+		if alg != EdDSA {
+			return "", ErrAlgorithm
+		}
+		signature = ed25519.Sign(k, []byte(input))
+	default:
+		return "", ErrAlgorithm
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// keyMatches checks if the key verifies tokens of the algorithm: its type matches the algorithm,
+// and an EC key is of the curve of the algorithm.
+func keyMatches(alg string, key interface{}) bool {
+	switch k := key.(type) {
+	case []byte:
+		return alg == HS256
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		algorithm, ok := ecdsaAlgorithms[alg]
+		return ok && k.Curve == algorithm.curve
+	case ed25519.PublicKey:
+		return alg == EdDSA
+	}
+	return false
+}
+
+// verify verifies the signature with the key. The type of the key must match the algorithm.
+func verify(alg string, key interface{}, input []byte, signature []byte) bool {
+	switch alg {
+	case HS256:
+		k, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case ES256, ES384, ES512:
+		algorithm := ecdsaAlgorithms[alg]
+		size := algorithm.size()
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != algorithm.curve || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, algorithm.digest(input), r, s)
+	case EdDSA:
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, input, signature)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,158 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeys struct {
+	hmac       []byte
+	rsa        *rsa.PrivateKey
+	ecdsa      *ecdsa.PrivateKey
+	ed25519    ed25519.PrivateKey
+	ed25519Pub ed25519.PublicKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return testKeys{hmac: []byte("secret"), rsa: rsaKey, ecdsa: ecKey, ed25519: edKey, ed25519Pub: edPub}
+}
+
+func sign(t *testing.T, claims Claims, alg string, kid string, key interface{}) string {
+	token, err := Sign(claims, alg, kid, key)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestParse(t *testing.T) {
+	keys := newTestKeys(t)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	assert.NoError(t, err)
+	claims := Claims{"sub": "alice", "scope": "read write"}
+	cases := []struct {
+		alg     string
+		private interface{}
+		public  interface{}
+	}{
+		{HS256, keys.hmac, keys.hmac},
+		{RS256, keys.rsa, &keys.rsa.PublicKey},
+		{ES256, keys.ecdsa, &keys.ecdsa.PublicKey},
+		{ES384, p384, &p384.PublicKey},
+		{ES512, p521, &p521.PublicKey},
+		{EdDSA, keys.ed25519, keys.ed25519Pub},
+	}
+	for _, c := range cases {
+		raw := sign(t, claims, c.alg, "k1", c.private)
+		token, err := Parse(raw, StaticKeys{"k1": c.public}, defaultAlgorithms)
+		assert.NoError(t, err, c.alg)
+		assert.Equal(t, c.alg, token.Algorithm())
+		assert.Equal(t, "k1", token.KeyID())
+		assert.Equal(t, "alice", token.Claims.Subject())
+		assert.Equal(t, []string{"read", "write"}, token.Claims.Scopes())
+
+		// tampered payload
+		tampered := sign(t, Claims{"sub": "mallory"}, c.alg, "k1", c.private)
+		parts := splitToken(raw)
+		_, err = Parse(splitToken(tampered)[0]+"."+splitToken(tampered)[1]+"."+parts[2],
+			StaticKeys{"k1": c.public}, defaultAlgorithms)
+		assert.Equal(t, ErrSignature, err, c.alg)
+
+		// not allowed
+		_, err = Parse(raw, StaticKeys{"k1": c.public}, []string{"none"})
+		assert.Equal(t, ErrAlgorithm, err)
+	}
+}
+
+func splitToken(raw string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '.' {
+			parts = append(parts, raw[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, raw[start:])
+}
+
+func TestParse_KeyConfusion(t *testing.T) {
+	keys := newTestKeys(t)
+	// an HMAC token must not be verified with an RSA public key
+	raw := sign(t, Claims{"sub": "mallory"}, HS256, "", []byte("whatever"))
+	_, err := Parse(raw, StaticKeys{"": &keys.rsa.PublicKey}, defaultAlgorithms)
+	assert.Equal(t, ErrSignature, err)
+
+	// an EC key only verifies tokens of the algorithm of its curve
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	raw = sign(t, Claims{"sub": "mallory"}, ES384, "", p384)
+	_, err = Parse(raw, StaticKeys{"": &keys.ecdsa.PublicKey}, defaultAlgorithms)
+	assert.Equal(t, ErrSignature, err)
+	assert.False(t, keyMatches(ES256, &p384.PublicKey))
+	assert.True(t, keyMatches(ES384, &p384.PublicKey))
+}
+
+func TestParse_Malformed(t *testing.T) {
+	for _, raw := range []string{"", "a.b", "a.b.c", "e30.e30.!!!", "e30.bm90IGpzb24.c2ln"} {
+		_, err := Parse(raw, StaticKeys{"": []byte("k")}, defaultAlgorithms)
+		assert.Equal(t, ErrMalformed, err, raw)
+	}
+	_, err := Parse(sign(t, Claims{}, HS256, "k2", []byte("k")), StaticKeys{"k1": []byte("k")}, defaultAlgorithms)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestValidation_Validate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	v := Validation{Issuer: "https://issuer", Audience: []string{"api", "web"}, ClockSkew: 30 * time.Second}
+
+	valid := Claims{"iss": "https://issuer", "aud": []interface{}{"other", "api"}, "exp": 1010.0, "nbf": 990.0}
+	assert.NoError(t, v.Validate(valid, now))
+
+	assert.NoError(t, v.Validate(Claims{"iss": "https://issuer", "aud": "web", "exp": 980.0}, now))
+	assert.Equal(t, ErrExpired, v.Validate(Claims{"iss": "https://issuer", "aud": "web", "exp": 970.0}, now))
+	assert.NoError(t, v.Validate(Claims{"iss": "https://issuer", "aud": "web", "nbf": 1020.0}, now))
+	assert.Equal(t, ErrNotValidYet, v.Validate(Claims{"iss": "https://issuer", "aud": "web", "nbf": 1040.0}, now))
+	assert.Equal(t, ErrIssuer, v.Validate(Claims{"iss": "https://other", "aud": "web"}, now))
+	assert.Equal(t, ErrAudience, v.Validate(Claims{"iss": "https://issuer", "aud": "other"}, now))
+	assert.Equal(t, ErrAudience, v.Validate(Claims{"iss": "https://issuer"}, now))
+
+	assert.NoError(t, Validation{}.Validate(Claims{}, now))
+	assert.Equal(t, ErrExpired, Validation{RequireExpiry: true}.Validate(Claims{}, now))
+}
+
+func TestClaims(t *testing.T) {
+	claims := Claims{"scp": []interface{}{"a", "b", 1.0}, "exp": 1500.5, "name": 1.0}
+	assert.Equal(t, []string{"a", "b"}, claims.Scopes())
+	assert.True(t, claims.HasScope("b"))
+	assert.False(t, claims.HasScope("c"))
+	assert.Equal(t, "", claims.String("name"))
+	assert.Nil(t, claims.Strings("name"))
+	exp, ok := claims.Time("exp")
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1500, 500000000), exp)
+	_, ok = claims.Time("missing")
+	assert.False(t, ok)
+}
+
+func TestSign_WrongKey(t *testing.T) {
+	_, err := Sign(Claims{}, RS256, "", []byte("secret"))
+	assert.Equal(t, ErrAlgorithm, err)
+	_, err = Sign(Claims{}, HS256, "", "secret")
+	assert.Equal(t, ErrAlgorithm, err)
+	_, err = Sign(Claims{}, ES384, "", newTestKeys(t).ecdsa)
+	assert.Equal(t, ErrAlgorithm, err)
+}
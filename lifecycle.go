@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// defaultShutdownTimeout is the default timeout of shutting down the Server gracefully in RunContext.
+const defaultShutdownTimeout = 30 * time.Second
+
+// lifecycle is the lifecycle hooks of the Server.
+type lifecycle struct {
+	// onStart is called after the main listeners start listening.
+	onStart []func()
+	// onShutdown is called when the Server starts shutting down, before draining connections.
+	onShutdown []func(ctx context.Context)
+	// onStopped is called after the Server stops.
+	onStopped []func(err error)
+}
+
+// OnStart adds a hook called after the main listeners start listening, such as registering the Server
+// to service discovery. The bound addresses are available with Addrs.
+// Hooks are called in order, and requests are already being served while they run.
+func (s *Server) OnStart(hook func()) {
+	if hook == nil {
+		panic("nil hook")
+	}
+	s.lifecycle.onStart = append(s.lifecycle.onStart, hook)
+}
+
+// OnShutdown adds a hook called when the Server starts shutting down gracefully, before draining connections,
+// such as deregistering the Server from service discovery. The context is done when the shutdown timeout is reached.
+// It is only called by RunContext and RunWithShutdown.
+func (s *Server) OnShutdown(hook func(ctx context.Context)) {
+	if hook == nil {
+		panic("nil hook")
+	}
+	s.lifecycle.onShutdown = append(s.lifecycle.onShutdown, hook)
+}
+
+// OnStopped adds a hook called after the Server stops, with the error to be returned by Run, RunWithShutdown
+// or RunContext, such as flushing buffered logs or metrics.
+func (s *Server) OnStopped(hook func(err error)) {
+	if hook == nil {
+		panic("nil hook")
+	}
+	s.lifecycle.onStopped = append(s.lifecycle.onStopped, hook)
+}
+
+// Addrs gets the addresses of the main listeners, which are the actual ports if listening on port 0
+// (for example: ":0"). It returns nil if the Server is not listening.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]net.Addr(nil), s.addrs...)
+}
+
+// Addr gets the address of the first main listener, which is the one of the address given to Run,
+// RunWithShutdown or RunContext if it is not empty. It returns nil if the Server is not listening.
+func (s *Server) Addr() net.Addr {
+	if addrs := s.Addrs(); len(addrs) > 0 {
+		return addrs[0]
+	}
+	return nil
+}
+
+// setListeners sets the main listeners, their addresses and the UDP connection of HTTP/3.
+func (s *Server) setListeners(listeners []net.Listener, http3Conn net.PacketConn) {
+	var addrs []net.Addr
+	for _, ln := range listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rawListeners = listeners
+	s.http3Conn = http3Conn
+	s.addrs = addrs
+}
+
+// started calls the OnStart hooks.
+func (s *Server) started() {
+	for _, hook := range s.lifecycle.onStart {
+		hook()
+	}
+}
+
+// shuttingDown calls the OnShutdown hooks.
+func (s *Server) shuttingDown(ctx context.Context) {
+	for _, hook := range s.lifecycle.onShutdown {
+		hook(ctx)
+	}
+}
+
+// stopped calls the OnStopped hooks.
+func (s *Server) stopped(err error) {
+	for _, hook := range s.lifecycle.onStopped {
+		hook(err)
+	}
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func lifecycleServer(handler Handler) *Server {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.config.Add("/hello", http.MethodGet, "hello")
+	s.Register("hello", handler)
+	return s
+}
+
+func TestServer_RunContext(t *testing.T) {
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte("hello")
+	})
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	started := make(chan net.Addr, 1)
+	s.OnStart(func() {
+		record("start")
+		started <- s.Addr()
+	})
+	s.OnShutdown(func(ctx context.Context) {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.False(t, s.IsReady())
+		record("shutdown")
+	})
+	var stoppedErr error
+	s.OnStopped(func(err error) {
+		stoppedErr = err
+		record("stopped")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.RunContext(ctx, "127.0.0.1:0")
+	}()
+
+	addr := <-started
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	assert.True(t, ok)
+	assert.NotZero(t, tcpAddr.Port)
+	assert.Equal(t, []net.Addr{addr}, s.Addrs())
+	resp, err := http.Get("http://" + addr.String() + "/hello")
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "hello", string(body))
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.NoError(t, stoppedErr)
+	assert.Equal(t, []string{"start", "shutdown", "stopped"}, events)
+	assert.Nil(t, s.Addr())
+}
+
+func TestServer_RunContext_ShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := lifecycleServer(func(context *Context) {
+		<-release
+	})
+	s.UseServerOptions(ServerOptions{ShutdownTimeout: 50 * time.Millisecond})
+	started := make(chan struct{})
+	s.OnStart(func() {
+		close(started)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.RunContext(ctx, "127.0.0.1:0")
+	}()
+	<-started
+	go func() {
+		_, _ = http.Get("http://" + s.Addr().String() + "/hello")
+	}()
+	assert.Eventually(t, func() bool {
+		return s.InFlight() == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+}
+
+func TestServer_RunContext_Error(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	s := lifecycleServer(func(context *Context) {})
+	var stoppedErr error
+	s.OnStart(func() {
+		assert.Fail(t, "started")
+	})
+	s.OnStopped(func(err error) {
+		stoppedErr = err
+	})
+	// the address is in use
+	err = s.RunContext(context.Background(), ln.Addr().String())
+	assert.Error(t, err)
+	assert.Equal(t, err, stoppedErr)
+
+	stoppedErr = nil
+	assert.Equal(t, err.Error(), s.Run(ln.Addr().String()).Error())
+	assert.Error(t, stoppedErr)
+}
+
+func TestServer_RunContext_AdminError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	s := lifecycleServer(func(context *Context) {})
+	s.UseAdmin(ln.Addr().String())
+	var stoppedErr error
+	s.OnStart(func() {
+		assert.Fail(t, "started")
+	})
+	s.OnStopped(func(err error) {
+		stoppedErr = err
+	})
+	main, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s.AddNetListener(main)
+	// the admin address is in use
+	err = s.RunContext(context.Background(), "")
+	assert.Error(t, err)
+	assert.Equal(t, err, stoppedErr)
+	// the main listener is closed
+	_, err = main.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed))
+
+	stoppedErr = nil
+	err = s.Run("127.0.0.1:0")
+	assert.Error(t, err)
+	assert.Equal(t, err, stoppedErr)
+}
+
+func TestServer_LifecycleHooks(t *testing.T) {
+	s := Default()
+	assert.Nil(t, s.Addr())
+	assert.Nil(t, s.Addrs())
+	assert.Panics(t, func() {
+		s.OnStart(nil)
+	})
+	assert.Panics(t, func() {
+		s.OnShutdown(nil)
+	})
+	assert.Panics(t, func() {
+		s.OnStopped(nil)
+	})
+	assert.Panics(t, func() {
+		s.UseServerOptions(ServerOptions{ShutdownTimeout: -1})
+	})
+}
@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultReadHeaderTimeout is the default timeout of reading request headers.
+	defaultReadHeaderTimeout = 10 * time.Second
+	// defaultIdleTimeout is the default timeout of idle keep-alive connections.
+	defaultIdleTimeout = 2 * time.Minute
+)
+
+// ServerOptions is the options of the http.Server of the main listeners, and of shutting it down.
+type ServerOptions struct {
+	// ReadTimeout is the timeout of reading a whole request, including the body. There is no timeout if zero.
+	ReadTimeout time.Duration
+	// ReadHeaderTimeout is the timeout of reading request headers, which protects the Server from slow clients
+	// (slowloris). It is 10 seconds if zero, and there is no timeout if negative.
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout is the timeout from the end of reading request headers to the end of writing the response.
+	// There is no timeout if zero. It also limits streaming responses, so keep it zero if they are long-lived.
+	WriteTimeout time.Duration
+	// IdleTimeout is the timeout of waiting for the next request on a keep-alive connection.
+	// It is 2 minutes if zero, and there is no timeout if negative.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes is the maximum size of request headers. It is http.DefaultMaxHeaderBytes (1 MB) if zero.
+	MaxHeaderBytes int
+	// MaxConnections is the maximum number of concurrent connections of all main listeners.
+	// New connections wait to be accepted when it is reached. There is no limit if zero.
+	MaxConnections int
+	// ShutdownTimeout is the timeout of shutting down gracefully in RunContext. It is 30 seconds if zero.
+	ShutdownTimeout time.Duration
+	// H2C serves HTTP/2 without TLS (h2c) on listeners not using TLS, for clients with prior knowledge
+	// (such as gRPC clients inside a cluster). HTTP/1.1 is still served on the same listeners, and HTTP/1.1
+	// requests without a body asking to upgrade to h2c are upgraded. See NewHTTP2Transport for the client side.
+	H2C bool
+}
+
+// errListenerClosed is the error of accepting connections from a closed limitListener.
+var errListenerClosed = errors.New("listener closed")
+
+// listenerConfig is the configuration of an extra listener.
+type listenerConfig struct {
+	// network and addr are the network and the address to listen on, if listener is nil.
+	network string
+	addr    string
+	// listener is a pre-opened listener.
+	listener net.Listener
+}
+
+// UseServerOptions sets the options of the http.Server of the main listeners.
+func (s *Server) UseServerOptions(options ServerOptions) {
+	if options.MaxHeaderBytes < 0 || options.MaxConnections < 0 || options.ShutdownTimeout < 0 {
+		panic("negative limit of server options")
+	}
+	s.options = options
+}
+
+// AddListener adds a listener on the network ("tcp", "tcp4", "tcp6" or "unix") and the address,
+// which serves requests alongside the address given to Run or RunWithShutdown, with the same routing.
+// A stale Unix domain socket file at the address is removed before listening.
+//
+// If listeners are added, Run and RunWithShutdown can be given an empty address to serve only these listeners.
+func (s *Server) AddListener(network string, addr string) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		panic("unsupported network: " + network)
+	}
+	s.listeners = append(s.listeners, listenerConfig{network: network, addr: addr})
+}
+
+// AddNetListener adds a pre-opened listener, such as one inherited from the parent process. See AddListener.
+// The Server closes it when it stops.
+func (s *Server) AddNetListener(ln net.Listener) {
+	if ln == nil {
+		panic("nil listener")
+	}
+	s.listeners = append(s.listeners, listenerConfig{listener: ln})
+}
+
+// applyOptions applies the ServerOptions to the http.Server.
+func (s *Server) applyOptions(svr *http.Server) {
+	options := s.options
+	svr.ReadTimeout = options.ReadTimeout
+	svr.WriteTimeout = options.WriteTimeout
+	svr.MaxHeaderBytes = options.MaxHeaderBytes
+	switch {
+	case options.ReadHeaderTimeout == 0:
+		svr.ReadHeaderTimeout = defaultReadHeaderTimeout
+	case options.ReadHeaderTimeout > 0:
+		svr.ReadHeaderTimeout = options.ReadHeaderTimeout
+	}
+	switch {
+	case options.IdleTimeout == 0:
+		svr.IdleTimeout = defaultIdleTimeout
+	case options.IdleTimeout > 0:
+		svr.IdleTimeout = options.IdleTimeout
+	}
+	if options.H2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		svr.Protocols = protocols
+		svr.Handler = &h2cHandler{server: svr, next: svr.Handler}
+	}
+}
+
+// listen opens the listener of the address and all extra listeners.
+// The address is ":http" (or ":https" if TLS is used) if it is empty and there are no extra listeners.
+// If the process is started by an upgrade, the listeners inherited from the parent process are used instead.
+func (s *Server) listen(addr string) ([]net.Listener, error) {
+	if listeners, err := takeInheritedListeners(); err != nil || listeners != nil {
+		if listeners != nil {
+			s.logger.Info("use inherited listeners")
+			for _, config := range s.listeners {
+				if config.listener != nil {
+					_ = config.listener.Close()
+				}
+			}
+		}
+		return listeners, err
+	}
+
+	configs := s.listeners
+	if addr != "" || len(configs) == 0 {
+		if addr == "" {
+			addr = ":http"
+			if s.tls != nil {
+				addr = ":https"
+			}
+		}
+		configs = append([]listenerConfig{{network: "tcp", addr: addr}}, configs...)
+	}
+
+	var listeners []net.Listener
+	for _, config := range configs {
+		ln := config.listener
+		if ln == nil {
+			var err error
+			if ln, err = listen(config.network, config.addr); err != nil {
+				for _, l := range listeners {
+					_ = l.Close()
+				}
+				return nil, err
+			}
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// closeListeners closes the main listeners opened before the Server runs, including the inherited ones,
+// when the Server fails to start.
+func (s *Server) closeListeners() {
+	for _, config := range s.listeners {
+		if config.listener != nil {
+			_ = config.listener.Close()
+		}
+	}
+	listeners, _ := takeInheritedListeners()
+	for _, ln := range listeners {
+		_ = ln.Close()
+	}
+}
+
+// listen opens a listener, removing a stale Unix domain socket file first.
+func listen(network string, addr string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", addr); err == nil {
+				// the socket is in use
+				_ = conn.Close()
+			} else {
+				_ = os.Remove(addr)
+			}
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+// limitListener is a listener which accepts at most a number of concurrent connections.
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newLimitListener creates a limitListener sharing the slots with other listeners.
+func newLimitListener(ln net.Listener, slots chan struct{}) *limitListener {
+	return &limitListener{Listener: ln, slots: slots, done: make(chan struct{})}
+}
+
+// Accept waits for a free slot, and accepts a connection.
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, slots: l.slots}, nil
+}
+
+// Close closes the listener, and stops waiting for a free slot.
+func (l *limitListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// limitConn is a connection of a limitListener, which frees its slot when closed.
+type limitConn struct {
+	net.Conn
+	slots chan struct{}
+	once  sync.Once
+}
+
+// Close closes the connection, and frees its slot.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		<-c.slots
+	})
+	return err
+}
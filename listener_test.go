@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_ApplyOptions(t *testing.T) {
+	s := Default()
+	svr := s.prepare(":8080")
+	assert.Equal(t, defaultReadHeaderTimeout, svr.ReadHeaderTimeout)
+	assert.Equal(t, defaultIdleTimeout, svr.IdleTimeout)
+	assert.Zero(t, svr.ReadTimeout)
+	assert.Zero(t, svr.WriteTimeout)
+
+	s.UseServerOptions(ServerOptions{
+		ReadTimeout:       time.Second,
+		ReadHeaderTimeout: -1,
+		WriteTimeout:      2 * time.Second,
+		IdleTimeout:       3 * time.Second,
+		MaxHeaderBytes:    4096,
+	})
+	svr = s.prepare(":8080")
+	assert.Equal(t, time.Second, svr.ReadTimeout)
+	assert.Zero(t, svr.ReadHeaderTimeout)
+	assert.Equal(t, 2*time.Second, svr.WriteTimeout)
+	assert.Equal(t, 3*time.Second, svr.IdleTimeout)
+	assert.Equal(t, 4096, svr.MaxHeaderBytes)
+
+	assert.Panics(t, func() {
+		s.UseServerOptions(ServerOptions{MaxConnections: -1})
+	})
+}
+
+func TestServer_MultipleListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listeners")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "gateway.sock")
+
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.config.Add("/hello", http.MethodGet, "hello")
+	s.Register("hello", func(context *Context) {
+		context.Response = []byte("hello")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s.AddNetListener(ln)
+	s.AddListener("unix", socket)
+	s.UseServerOptions(ServerOptions{MaxConnections: 2})
+
+	svr := s.prepare("")
+	done := make(chan error)
+	go func() {
+		done <- s.serve(svr)
+	}()
+	assert.Eventually(t, s.IsReady, time.Second, time.Millisecond)
+
+	get := func(client *http.Client, url string) string {
+		resp, err := client.Get(url)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	assert.Equal(t, "hello", get(http.DefaultClient, "http://"+ln.Addr().String()+"/hello"))
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	assert.Equal(t, "hello", get(unixClient, "http://gateway/hello"))
+
+	assert.NoError(t, svr.Close())
+	assert.Equal(t, http.ErrServerClosed, <-done)
+	assert.False(t, s.IsReady())
+}
+
+func TestServer_ListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.AddListener("tcp", "127.0.0.1:0")
+	// the address is in use
+	s.AddListener("tcp", ln.Addr().String())
+	assert.Error(t, s.Run(""))
+
+	assert.Panics(t, func() {
+		s.AddListener("udp", ":53")
+	})
+	assert.Panics(t, func() {
+		s.AddNetListener(nil)
+	})
+}
+
+func TestListen_StaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listeners")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "gateway.sock")
+
+	ln, err := listen("unix", socket)
+	assert.NoError(t, err)
+	// a socket in use is not removed
+	_, err = listen("unix", socket)
+	assert.Error(t, err)
+
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, ln.Close())
+	ln, err = listen("unix", socket)
+	assert.NoError(t, err)
+	assert.NoError(t, ln.Close())
+}
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ln := newLimitListener(inner, make(chan struct{}, 1))
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		assert.Fail(t, "accepted beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// closing a connection frees its slot, only once
+	assert.NoError(t, first.Close())
+	_ = first.Close()
+	second := <-accepted
+	assert.NoError(t, second.Close())
+}
+
+func TestLimitListener_Close(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	slots := make(chan struct{}, 1)
+	slots <- struct{}{}
+	ln := newLimitListener(inner, slots)
+
+	// closing the listener stops waiting for a slot
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = ln.Close()
+	}()
+	_, err = ln.Accept()
+	assert.Equal(t, errListenerClosed, err)
+}
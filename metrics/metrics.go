@@ -0,0 +1,207 @@
+// Package metrics records metrics of the gateway and renders them in the Prometheus text exposition format.
+//
+// The metrics are served by an http.Handler, which is usually mounted on the admin listener:
+//
+//	m := metrics.New()
+//	s.UseMiddleware(m.Middleware())
+//	s.HandleAdmin("/metrics", m.Handler())
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed means requests are allowed.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen means some requests are allowed to probe the upstream.
+	BreakerHalfOpen
+	// BreakerOpen means requests are rejected.
+	BreakerOpen
+)
+
+// String returns the name of the BreakerState.
+func (b BreakerState) String() string {
+	switch b {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half_open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Metrics holds the metrics of the gateway.
+type Metrics struct {
+	// Registry is the Registry of all metrics. Custom metrics can be registered here as well.
+	Registry *Registry
+
+	requests         *Counter
+	inFlight         *Gauge
+	duration         *Histogram
+	responseSize     *Histogram
+	upstreamRequests *Counter
+	upstreamDuration *Histogram
+	breakerState     *Gauge
+	breakerChanges   *Counter
+	concurrencyLimit *Gauge
+	concurrencyUsed  *Gauge
+	queued           *Gauge
+	shedRequests     *Counter
+}
+
+// New creates Metrics with a new Registry.
+func New() *Metrics {
+	return NewWithRegistry(NewRegistry())
+}
+
+// NewWithRegistry creates Metrics and registers all metrics to the given Registry.
+func NewWithRegistry(r *Registry) *Metrics {
+	return &Metrics{
+		Registry: r,
+		requests: r.NewCounter("gateway_requests_total",
+			"Total number of requests handled.", "service", "method", "status_class"),
+		inFlight: r.NewGauge("gateway_requests_in_flight",
+			"Number of requests being handled.", "service", "method"),
+		duration: r.NewHistogram("gateway_request_duration_seconds",
+			"Duration of handling requests in seconds.", DefaultDurationBuckets, "service", "method", "status_class"),
+		responseSize: r.NewHistogram("gateway_response_size_bytes",
+			"Size of response bodies in bytes.", DefaultSizeBuckets, "service", "method", "status_class"),
+		upstreamRequests: r.NewCounter("gateway_upstream_requests_total",
+			"Total number of requests sent to upstreams.", "service", "host", "status_class"),
+		upstreamDuration: r.NewHistogram("gateway_upstream_request_duration_seconds",
+			"Duration of requests sent to upstreams in seconds.", DefaultDurationBuckets, "service", "host"),
+		breakerState: r.NewGauge("gateway_breaker_state",
+			"State of circuit breakers (0: closed, 1: half open, 2: open).", "breaker"),
+		breakerChanges: r.NewCounter("gateway_breaker_transitions_total",
+			"Total number of state transitions of circuit breakers.", "breaker", "state"),
+		concurrencyLimit: r.NewGauge("gateway_concurrency_limit",
+			"Current concurrency limits of concurrency limiters.", "limiter"),
+		concurrencyUsed: r.NewGauge("gateway_concurrency_in_flight",
+			"Number of requests admitted by concurrency limiters.", "limiter"),
+		queued: r.NewGauge("gateway_concurrency_queued",
+			"Number of requests waiting in the queues of concurrency limiters.", "limiter"),
+		shedRequests: r.NewCounter("gateway_requests_shed_total",
+			"Total number of requests rejected by concurrency limiters.", "limiter", "priority", "reason"),
+	}
+}
+
+// Handler returns an http.Handler that serves all metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return m.Registry.Handler()
+}
+
+// Middleware returns a middleware that records requests, labeled by the matched Service name,
+// the method (see MethodLabel) and the status class (for example: "2xx").
+// Requests made by the gateway itself (see gateway.IsBackground) are not recorded.
+func (m *Metrics) Middleware() gateway.Handler {
+	return func(context *gateway.Context) {
+		if gateway.IsBackground(context.Request) {
+			return
+		}
+		service := context.GetServiceName()
+		method := MethodLabel(context.Request.Method)
+		start := time.Now()
+		m.inFlight.Add(1, service, method)
+		context.OnFinish(func() {
+			statusCode := context.StatusCode
+			m.inFlight.Add(-1, service, method)
+			class := StatusClass(statusCode)
+			m.requests.Inc(service, method, class)
+			m.duration.Observe(time.Since(start).Seconds(), service, method, class)
+			m.responseSize.Observe(float64(context.BytesWritten()), service, method, class)
+		})
+	}
+}
+
+// ObserveUpstream records a request sent to an upstream.
+// Use an empty status code if the request fails without a response.
+func (m *Metrics) ObserveUpstream(service string, host string, statusCode int, duration time.Duration) {
+	class := "error"
+	if statusCode != 0 {
+		class = StatusClass(statusCode)
+	}
+	m.upstreamRequests.Inc(service, host, class)
+	m.upstreamDuration.Observe(duration.Seconds(), service, host)
+}
+
+// SetBreakerState records the state of a circuit breaker. It counts a transition each time it is called.
+func (m *Metrics) SetBreakerState(breaker string, state BreakerState) {
+	m.breakerState.Set(float64(state), breaker)
+	m.breakerChanges.Inc(breaker, state.String())
+}
+
+// SetConcurrency records the state of a concurrency limiter.
+func (m *Metrics) SetConcurrency(limiter string, limit int, inFlight int, queued int) {
+	m.concurrencyLimit.Set(float64(limit), limiter)
+	m.concurrencyUsed.Set(float64(inFlight), limiter)
+	m.queued.Set(float64(queued), limiter)
+}
+
+// ObserveShed records a request rejected by a concurrency limiter, with its priority and the reason.
+func (m *Metrics) ObserveShed(limiter string, priority string, reason string) {
+	m.shedRequests.Inc(limiter, priority, reason)
+}
+
+// Transport wraps an http.RoundTripper and records requests sent to upstreams.
+// If the request is created from gateway.Context.Request.Context(), it is labeled by the Service name as well.
+// http.DefaultTransport is used if the given one is nil.
+func (m *Metrics) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return gateway.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		service := ""
+		if c := gateway.FromContext(req.Context()); c != nil {
+			service = c.GetServiceName()
+		}
+		start := time.Now()
+		resp, err := rt.RoundTrip(req)
+		statusCode := 0
+		if err == nil {
+			statusCode = resp.StatusCode
+		}
+		m.ObserveUpstream(service, req.URL.Host, statusCode, time.Since(start))
+		return resp, err
+	})
+}
+
+// standardMethods is the methods defined by HTTP, which are used as labels.
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// MethodLabel returns the label of a request method. Methods other than the standard ones give "OTHER",
+// so that clients cannot create unbounded label values.
+func MethodLabel(method string) string {
+	if standardMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// StatusClass returns the class of a status code. For example: StatusClass(404) gives "4xx".
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_Middleware(t *testing.T) {
+	m := New()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: m.Transport(nil)}
+
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	cfg.Add("/hello", "BREW", "api.hello")
+	s.UseConfig(cfg)
+	s.UseMiddleware(m.Middleware())
+	s.Register("api", func(context *gateway.Context) {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req.WithContext(context.Request.Context()))
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		context.StatusCode = http.StatusCreated
+		context.Response = []byte("hello")
+	})
+	handler := s.Handler()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/hello", nil))
+	// requests made by the gateway itself are not recorded, but their upstream requests are
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(gateway.WithBackground(req.Context())))
+	m.SetBreakerState("api", BreakerOpen)
+	m.SetConcurrency("api", 10, 4, 2)
+	m.ObserveShed("api", "low", "queue_full")
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, m.Registry.WriteText(buf))
+	text := buf.String()
+	assert.Contains(t, text, `gateway_requests_total{service="api",method="GET",status_class="2xx"} 1`)
+	assert.Contains(t, text, `gateway_requests_in_flight{service="api",method="GET"} 0`)
+	assert.Contains(t, text, `gateway_requests_total{service="api",method="OTHER",status_class="2xx"} 1`)
+	assert.Contains(t, text, `gateway_requests_in_flight{service="api",method="OTHER"} 0`)
+	assert.Contains(t, text, `gateway_response_size_bytes_sum{service="api",method="GET",status_class="2xx"} 5`)
+	assert.Contains(t, text, `gateway_upstream_requests_total{service="api",host="`+upstream.Listener.Addr().String()+`",status_class="2xx"} 3`)
+	assert.Contains(t, text, `gateway_breaker_state{breaker="api"} 2`)
+	assert.Contains(t, text, `gateway_breaker_transitions_total{breaker="api",state="open"} 1`)
+	assert.Contains(t, text, `gateway_concurrency_limit{limiter="api"} 10`)
+	assert.Contains(t, text, `gateway_concurrency_in_flight{limiter="api"} 4`)
+	assert.Contains(t, text, `gateway_concurrency_queued{limiter="api"} 2`)
+	assert.Contains(t, text, `gateway_requests_shed_total{limiter="api",priority="low",reason="queue_full"} 1`)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestMethodLabel(t *testing.T) {
+	assert.Equal(t, "GET", MethodLabel(http.MethodGet))
+	assert.Equal(t, "PATCH", MethodLabel(http.MethodPatch))
+	assert.Equal(t, "OTHER", MethodLabel("get"))
+	assert.Equal(t, "OTHER", MethodLabel("PROPFIND"))
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(200))
+	assert.Equal(t, "5xx", StatusClass(503))
+	assert.Equal(t, "unknown", StatusClass(0))
+}
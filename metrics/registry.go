@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricType is the type of a metric family in the Prometheus text exposition format.
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// labelSeparator separates label values in the key of a series. It cannot appear in valid UTF-8 text.
+const labelSeparator = "\xff"
+
+// DefaultDurationBuckets is the default buckets of duration histograms, in seconds.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets is the default buckets of size histograms, in bytes.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// Registry is a collection of metric families which can be rendered in the Prometheus text exposition format.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a metric family with the same name and label names.
+type family struct {
+	name       string
+	help       string
+	typ        metricType
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a metric with specific label values.
+type series struct {
+	labelValues []string
+	// value is the value of a counter or gauge, or the sum of a histogram.
+	value float64
+	// count is the number of observations of a histogram.
+	count uint64
+	// bucketCounts is the non-cumulative count of observations in each bucket of a histogram.
+	bucketCounts []uint64
+}
+
+// register adds a metric family to the Registry, and panics if the name has been registered.
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic(fmt.Sprintf("duplicate metric: %s", f.name))
+	}
+	f.series = map[string]*series{}
+	r.families[f.name] = f
+	return f
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(&family{name: name, help: help, typ: counterType, labelNames: labelNames})}
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(&family{name: name, help: help, typ: gaugeType, labelNames: labelNames})}
+}
+
+// NewHistogram registers a histogram with the given upper bounds of buckets and label names.
+// The buckets must be sorted in increasing order. The "+Inf" bucket is added automatically.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("unsorted buckets: %s", name))
+	}
+	return &Histogram{r.register(&family{
+		name: name, help: help, typ: histogramType, labelNames: labelNames, buckets: buckets,
+	})}
+}
+
+// with runs the function with the series of the given label values while holding the lock of the family.
+func (f *family) with(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == histogramType {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+// Counter is a metric that only goes up.
+type Counter struct {
+	f *family
+}
+
+// Inc increases the counter of the given label values by 1.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter of the given label values. It panics if the delta is negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.f.with(labelValues, func(s *series) { s.value += delta })
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	f *family
+}
+
+// Set sets the gauge of the given label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value = value })
+}
+
+// Add adds delta to the gauge of the given label values. Delta can be negative.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value += delta })
+}
+
+// Histogram is a metric that counts observations in buckets.
+type Histogram struct {
+	f *family
+}
+
+// Observe adds an observation to the histogram of the given label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.with(labelValues, func(s *series) {
+		s.value += value
+		s.count++
+		for i, bound := range h.f.buckets {
+			if value <= bound {
+				s.bucketCounts[i]++
+				break
+			}
+		}
+	})
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format (version 0.0.4).
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := make([]*family, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		families = append(families, r.families[name])
+	}
+	r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns an http.Handler that serves all metrics in the Prometheus text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// writeText writes the metric family in the Prometheus text exposition format.
+func (f *family) writeText(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.bucketCounts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n",
+				f.name, formatLabels(f.labelNames, s.labelValues, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "", ""), s.count)
+	}
+}
+
+// formatLabels formats label pairs, with an optional extra label at the end.
+// It returns an empty string if there is no label.
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(values[i]))
+		sb.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(extraName)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(extraValue))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// formatFloat formats a float value in the Prometheus text exposition format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// escapeHelp escapes backslashes and line feeds in help text.
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// escapeLabelValue escapes backslashes, double quotes and line feeds in label values.
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "a")
+	g := r.NewGauge("test_gauge", "Test\ngauge.")
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1}, "b")
+
+	c.Inc(`x"y`)
+	c.Add(2, `x"y`)
+	c.Inc("z")
+	g.Set(3)
+	g.Add(-1.5)
+	h.Observe(0.05, "v")
+	h.Observe(0.5, "v")
+	h.Observe(5, "v")
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, r.WriteText(buf))
+	assert.Equal(t, `# HELP test_gauge Test\ngauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{b="v",le="0.1"} 1
+test_seconds_bucket{b="v",le="1"} 2
+test_seconds_bucket{b="v",le="+Inf"} 3
+test_seconds_sum{b="v"} 5.55
+test_seconds_count{b="v"} 3
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{a="x\"y"} 3
+test_total{a="z"} 1
+`, buf.String())
+}
+
+func TestRegistry_Panics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "a")
+	assert.Panics(t, func() { r.NewGauge("test_total", "Duplicate.") })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "a") })
+	assert.Panics(t, func() { r.NewHistogram("test_seconds", "Unsorted.", []float64{1, 0.1}) })
+}
@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// defaultCORSMethods is the allowed methods if CORSConfig.AllowedMethods is empty.
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// defaultCORSHeaders is the allowed headers if CORSConfig.AllowedHeaders is empty.
+var defaultCORSHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "X-Requested-With"}
+
+// CORSConfig is the configuration of CORS (Cross-Origin Resource Sharing).
+type CORSConfig struct {
+	// AllowedOrigins is a list of origins allowed to make cross-origin requests.
+	// An origin can be exact (for example: "https://example.com"), an asterisk (*) for all origins,
+	// or with a wildcard subdomain (for example: "https://*.example.com", which does not match "https://example.com").
+	AllowedOrigins []string
+	// AllowedOriginPatterns is a list of regular expressions of allowed origins.
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowOriginFunc checks if an origin is allowed, in addition to AllowedOrigins and AllowedOriginPatterns.
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods is a list of methods allowed in cross-origin requests. GET, HEAD and POST are allowed if empty.
+	AllowedMethods []string
+	// AllowedHeaders is a list of request headers allowed in cross-origin requests.
+	// An asterisk (*) allows all headers. Some common headers (such as Content-Type) are allowed if empty.
+	AllowedHeaders []string
+	// ExposedHeaders is a list of response headers which can be read by the client.
+	ExposedHeaders []string
+	// AllowCredentials allows requests with credentials, such as cookies.
+	AllowCredentials bool
+	// MaxAge is how long the result of a preflight request can be cached by the client.
+	// It is not sent if it is zero.
+	MaxAge time.Duration
+}
+
+// corsPolicy is a compiled CORSConfig.
+type corsPolicy struct {
+	config         CORSConfig
+	allowAll       bool
+	exactOrigins   map[string]bool
+	wildcards      [][2]string
+	methods        map[string]bool
+	allowedMethods string
+	allowAllHeader bool
+	headers        map[string]bool
+	exposedHeaders string
+	maxAge         string
+}
+
+// newCORSPolicy compiles a CORSConfig.
+func newCORSPolicy(config CORSConfig) *corsPolicy {
+	p := &corsPolicy{config: config, exactOrigins: map[string]bool{}, methods: map[string]bool{}, headers: map[string]bool{}}
+	for _, origin := range config.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			p.allowAll = true
+		} else if idx := strings.Index(origin, "*"); idx != -1 {
+			p.wildcards = append(p.wildcards, [2]string{origin[:idx], origin[idx+1:]})
+		} else {
+			p.exactOrigins[origin] = true
+		}
+	}
+
+	allowedMethods := config.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = defaultCORSMethods
+	}
+	methods := make([]string, 0, len(allowedMethods))
+	for _, method := range allowedMethods {
+		method = strings.ToUpper(method)
+		methods = append(methods, method)
+		p.methods[method] = true
+	}
+	p.allowedMethods = strings.Join(methods, ", ")
+
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	for _, header := range headers {
+		if header == "*" {
+			p.allowAllHeader = true
+		}
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	p.exposedHeaders = strings.Join(config.ExposedHeaders, ", ")
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(config.MaxAge / time.Second))
+	}
+	return p
+}
+
+// isOriginAllowed checks if the origin is allowed.
+func (p *corsPolicy) isOriginAllowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if p.exactOrigins[lower] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, pattern := range p.config.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return p.config.AllowOriginFunc != nil && p.config.AllowOriginFunc(origin)
+}
+
+// areHeadersAllowed checks if all headers in the Access-Control-Request-Headers are allowed.
+func (p *corsPolicy) areHeadersAllowed(requested string) bool {
+	if p.allowAllHeader {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// setAllowOrigin sets Access-Control-Allow-Origin and Access-Control-Allow-Credentials.
+func (p *corsPolicy) setAllowOrigin(header http.Header, origin string) {
+	if p.allowAll && !p.config.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		// the response differs by origin
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+	if p.config.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// handle handles the request with the CORS policy.
+func (p *corsPolicy) handle(context *gateway.Context) {
+	req := context.Request
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+		// preflight request, which should not reach the main handler
+		context.Interrupt()
+		context.StatusCode = http.StatusNoContent
+		context.Header.Add("Vary", "Access-Control-Request-Method")
+		context.Header.Add("Vary", "Access-Control-Request-Headers")
+
+		method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+		requestedHeaders := req.Header.Get("Access-Control-Request-Headers")
+		if !p.isOriginAllowed(origin) || !p.methods[method] || !p.areHeadersAllowed(requestedHeaders) {
+			return
+		}
+		p.setAllowOrigin(context.Header, origin)
+		context.Header.Set("Access-Control-Allow-Methods", p.allowedMethods)
+		if requestedHeaders != "" {
+			// echo the requested headers since all of them are allowed
+			context.Header.Set("Access-Control-Allow-Headers", requestedHeaders)
+		}
+		if p.maxAge != "" {
+			context.Header.Set("Access-Control-Max-Age", p.maxAge)
+		}
+		return
+	}
+
+	if !p.isOriginAllowed(origin) {
+		return
+	}
+	p.setAllowOrigin(context.Header, origin)
+	if p.exposedHeaders != "" {
+		context.Header.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+	}
+}
+
+// CORS provides a middleware that handles CORS with the same policy for all Service.
+//
+// Preflight requests are answered with "204 No Content" and do not reach the main handler.
+// An endpoint does not need an OPTIONS Service for preflight requests,
+// since the Server routes them to the Service of the requested method.
+func CORS(config CORSConfig) gateway.Handler {
+	policy := newCORSPolicy(config)
+	return func(context *gateway.Context) {
+		policy.handle(context)
+	}
+}
+
+// CORSByService provides a middleware that handles CORS with different policies for Service.
+// The policy is looked up with gateway.LookupService. Requests of Service without a policy are not handled.
+// See CORS.
+func CORSByService(configs map[string]CORSConfig) gateway.Handler {
+	policies := make(map[string]*corsPolicy, len(configs))
+	for service, config := range configs {
+		policies[service] = newCORSPolicy(config)
+	}
+	return func(context *gateway.Context) {
+		if _, policy, ok := gateway.LookupService(policies, context.GetConfiguredServiceName()); ok {
+			policy.handle(context)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCORSServer(cors gateway.Handler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	cfg.Add("/admin", http.MethodPost, "admin.users")
+	s.UseConfig(cfg)
+	s.UseMiddleware(cors)
+	s.Register("*", func(context *gateway.Context) {
+		context.Response = []byte("hello")
+	})
+	return s.Handler()
+}
+
+func preflight(handler http.Handler, path string, origin string, method string, headers string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	handler := testCORSServer(CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"get", "post"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+
+	w := preflight(handler, "/hello", "https://example.com", http.MethodGet, "authorization, content-type")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "authorization, content-type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, w.Header()["Vary"], "Origin")
+
+	w = preflight(handler, "/hello", "https://api.example.org", http.MethodGet, "")
+	assert.Equal(t, "https://api.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+
+	for _, w := range []*httptest.ResponseRecorder{
+		preflight(handler, "/hello", "https://example.org", http.MethodGet, ""),
+		preflight(handler, "/hello", "https://evil.com", http.MethodGet, ""),
+		preflight(handler, "/hello", "https://example.com", http.MethodGet, "X-Custom"),
+	} {
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	// no Service for the requested method
+	w = preflight(handler, "/hello", "https://example.com", http.MethodDelete, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCORS_Request(t *testing.T) {
+	handler := testCORSServer(CORS(CORSConfig{
+		AllowedOrigins:        []string{"*"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^https://\d+\.example\.com$`)},
+		ExposedHeaders:        []string{"X-Request-ID"},
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("Origin", "https://any.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSByService(t *testing.T) {
+	handler := testCORSServer(CORSByService(map[string]CORSConfig{
+		"api":   {AllowedOrigins: []string{"https://example.com"}},
+		"admin": {AllowOriginFunc: func(origin string) bool { return origin == "https://admin.example.com" }, AllowedMethods: []string{http.MethodPost}},
+	}))
+
+	w := preflight(handler, "/hello", "https://example.com", http.MethodGet, "")
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	w = preflight(handler, "/admin", "https://example.com", http.MethodPost, "")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	w = preflight(handler, "/admin", "https://admin.example.com", http.MethodPost, "")
+	assert.Equal(t, "https://admin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST", w.Header().Get("Access-Control-Allow-Methods"))
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+const (
+	// RequestIDHeader is the default header of request ID.
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the key of request ID in Context.Data and the field name in logs.
+	RequestIDKey = "request_id"
+)
+
+var requestIDRegexp = regexp.MustCompile("^[A-Za-z0-9._~:+=/-]{1,128}$")
+
+// RequestIDConfig is the configuration of the request ID middleware.
+type RequestIDConfig struct {
+	// Header is the header of request ID. RequestIDHeader is used if it is empty.
+	Header string
+	// Generator generates a new request ID. NewUUIDv7 is used if it is nil.
+	Generator func() string
+	// Validator checks if an incoming request ID can be accepted. If not, a new one will be generated.
+	// By default, an incoming request ID should have 1 to 128 characters of letters, digits and "._~:+=/-".
+	Validator func(id string) bool
+}
+
+// RequestID provides a middleware with default configurations for request ID. See RequestIDWithConfig.
+func RequestID() gateway.Handler {
+	return RequestIDWithConfig(RequestIDConfig{})
+}
+
+// RequestIDWithConfig provides a middleware that accepts the request ID from the request header,
+// or generates one if there is not a valid one.
+//
+// The request ID is set on the response header and the request header (so that it is forwarded with the request),
+// and stored in Context.Data with key RequestIDKey.
+// The Context.Logger is bound with the request ID, so that every log in the following handlers carries it.
+// It should be registered before other middlewares, such as Logger.
+func RequestIDWithConfig(config RequestIDConfig) gateway.Handler {
+	if config.Header == "" {
+		config.Header = RequestIDHeader
+	}
+	if config.Generator == nil {
+		config.Generator = NewUUIDv7
+	}
+	if config.Validator == nil {
+		config.Validator = requestIDRegexp.MatchString
+	}
+
+	return func(context *gateway.Context) {
+		id := context.Request.Header.Get(config.Header)
+		if !config.Validator(id) {
+			id = config.Generator()
+		}
+
+		context.Request.Header.Set(config.Header, id)
+		context.Header.Set(config.Header, id)
+		context.Data[RequestIDKey] = id
+		context.Logger = context.Logger.WithField(RequestIDKey, id)
+	}
+}
+
+// GetRequestID gets the request ID of the Context. It returns an empty string if there is no request ID.
+func GetRequestID(context *gateway.Context) string {
+	id, _ := context.Data[RequestIDKey].(string)
+	return id
+}
+
+// RequestIDTransport wraps an http.RoundTripper and sets the request ID header on requests sent to upstreams,
+// if the request is created from gateway.Context.Request.Context() and the Context has a request ID.
+// The header is RequestIDHeader if it is empty, and http.DefaultTransport is used if the given one is nil.
+func RequestIDTransport(header string, rt http.RoundTripper) http.RoundTripper {
+	if header == "" {
+		header = RequestIDHeader
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return gateway.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		c := gateway.FromContext(req.Context())
+		if c == nil {
+			return rt.RoundTrip(req)
+		}
+		id := GetRequestID(c)
+		if id == "" || req.Header.Get(header) == id {
+			return rt.RoundTrip(req)
+		}
+
+		outReq := gateway.CloneRequestHeader(req)
+		outReq.Header.Set(header, id)
+		return rt.RoundTrip(outReq)
+	})
+}
+
+// NewUUIDv7 generates a UUID version 7, which is ordered by the time of generation.
+func NewUUIDv7() string {
+	var uuid [16]byte
+	_, _ = rand.Read(uuid[6:])
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(uuid[0:6], ts[2:8])
+	uuid[6] = uuid[6]&0x0f | 0x70 // version 7
+	uuid[8] = uuid[8]&0x3f | 0x80 // variant 10
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:36], uuid[10:16])
+	return string(buf[:])
+}
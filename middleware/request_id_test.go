@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+var uuidv7Regexp = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+
+func TestRequestID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(req.Header.Get(RequestIDHeader)))
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: RequestIDTransport("", nil)}
+
+	buf := &bytes.Buffer{}
+	s := gateway.Default()
+	s.AttachLogger(logger.GetLoggerWithConfig(buf, logger.Info))
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	s.UseConfig(cfg)
+	s.UseMiddlewares(RequestID(), Logger())
+	var upstreamID string
+	s.Register("api.hello", func(context *gateway.Context) {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req.WithContext(context.Request.Context()))
+		assert.NoError(t, err)
+		body := &bytes.Buffer{}
+		_, _ = body.ReadFrom(resp.Body)
+		_ = resp.Body.Close()
+		upstreamID = body.String()
+	})
+	handler := s.Handler()
+	buf.Reset()
+
+	// accept incoming request ID
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, "abc-123", upstreamID)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.Contains(t, line, "request_id=abc-123|")
+	}
+
+	// generate a new one for an invalid request ID
+	req = httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Regexp(t, uuidv7Regexp, w.Header().Get(RequestIDHeader))
+	assert.Equal(t, w.Header().Get(RequestIDHeader), upstreamID)
+}
+
+func TestRequestIDWithConfig(t *testing.T) {
+	handler := RequestIDWithConfig(RequestIDConfig{
+		Header:    "X-Trace",
+		Generator: func() string { return "generated" },
+	})
+	c := &gateway.Context{
+		Request: httptest.NewRequest(http.MethodGet, "/", nil),
+		Header:  http.Header{},
+		Data:    map[string]interface{}{},
+		Logger:  logger.GetNopLogger(),
+	}
+	handler(c)
+	assert.Equal(t, "generated", c.Header.Get("X-Trace"))
+	assert.Equal(t, "generated", c.Request.Header.Get("X-Trace"))
+	assert.Equal(t, "generated", GetRequestID(c))
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	a, b := NewUUIDv7(), NewUUIDv7()
+	assert.Regexp(t, uuidv7Regexp, a)
+	assert.NotEqual(t, a, b)
+	assert.True(t, a[:8] <= b[:8])
+}
@@ -0,0 +1,116 @@
+package oidc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxCookieSize is the maximum size of the value of a cookie. Larger values are split into chunks.
+const maxCookieSize = 3800
+
+// errInvalidCookie is the error of a cookie which cannot be decrypted.
+var errInvalidCookie = errors.New("oidc: invalid cookie")
+
+// cookieCodec encrypts values in cookies with AES-GCM, so that they can be neither read nor forged by clients.
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+// newCookieCodec creates a cookieCodec with the secret, which is hashed into an AES-256 key.
+func newCookieCodec(secret []byte) *cookieCodec {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &cookieCodec{aead: aead}
+}
+
+// encode encrypts the value in JSON. The name of the cookie is authenticated, so that values cannot be swapped.
+func (c *cookieCodec) encode(name string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, data, []byte(name))), nil
+}
+
+// decode decrypts the value into v.
+func (c *cookieCodec) decode(name string, value string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return errInvalidCookie
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	data, err = c.aead.Open(nil, nonce, sealed, []byte(name))
+	if err != nil {
+		return errInvalidCookie
+	}
+	return json.Unmarshal(data, v)
+}
+
+// chunkCookies splits a value into cookies no larger than maxCookieSize, named "name", "name.1", "name.2" and so on.
+// The cookies have the attributes of the template.
+func chunkCookies(template http.Cookie, value string) []*http.Cookie {
+	var cookies []*http.Cookie
+	name := template.Name
+	for i := 0; i == 0 || len(value) > 0; i++ {
+		n := len(value)
+		if n > maxCookieSize {
+			n = maxCookieSize
+		}
+		cookie := template
+		if i > 0 {
+			cookie.Name = name + "." + strconv.Itoa(i)
+		}
+		cookie.Value = value[:n]
+		value = value[n:]
+		cookies = append(cookies, &cookie)
+	}
+	return cookies
+}
+
+// readChunks joins the value of the cookie split by chunkCookies, and returns the names of all chunks.
+func readChunks(req *http.Request, name string) (string, []string) {
+	chunks := map[int]string{}
+	var names []string
+	for _, c := range req.Cookies() {
+		if c.Name == name {
+			chunks[0] = c.Value
+			names = append(names, c.Name)
+		} else if strings.HasPrefix(c.Name, name+".") {
+			if i, err := strconv.Atoi(c.Name[len(name)+1:]); err == nil && i > 0 {
+				chunks[i] = c.Value
+				names = append(names, c.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	var value strings.Builder
+	for i := 0; ; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			break
+		}
+		value.WriteString(chunk)
+	}
+	return value.String(), names
+}
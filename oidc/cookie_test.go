@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieCodec(t *testing.T) {
+	codec := newCookieCodec([]byte("secret"))
+	value, err := codec.encode("session", Session{Subject: "alice"})
+	assert.NoError(t, err)
+	assert.NotContains(t, value, "alice")
+
+	var session Session
+	assert.NoError(t, codec.decode("session", value, &session))
+	assert.Equal(t, "alice", session.Subject)
+
+	// the value is bound to the name of the cookie and the secret
+	assert.Error(t, codec.decode("other", value, &session))
+	assert.Error(t, newCookieCodec([]byte("other")).decode("session", value, &session))
+	assert.Error(t, codec.decode("session", "invalid", &session))
+}
+
+func TestChunkCookies(t *testing.T) {
+	value := strings.Repeat("a", 2*maxCookieSize) + "b"
+	cookies := chunkCookies(http.Cookie{Name: "s", Path: "/"}, value)
+	assert.Len(t, cookies, 3)
+	assert.Equal(t, "s", cookies[0].Name)
+	assert.Equal(t, "s.1", cookies[1].Name)
+	assert.Equal(t, "s.2", cookies[2].Name)
+	assert.Equal(t, "/", cookies[2].Path)
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	for i := len(cookies) - 1; i >= 0; i-- {
+		req.AddCookie(cookies[i])
+	}
+	req.AddCookie(&http.Cookie{Name: "other", Value: "x"})
+	joined, names := readChunks(req, "s")
+	assert.Equal(t, value, joined)
+	assert.Equal(t, []string{"s", "s.1", "s.2"}, names)
+
+	cookies = chunkCookies(http.Cookie{Name: "s"}, "")
+	assert.Len(t, cookies, 1)
+}
@@ -0,0 +1,234 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/jwt"
+)
+
+const (
+	// IntrospectionDataKey is the key of the introspection result (jwt.Claims) in Context.Data.
+	IntrospectionDataKey = "oidc.introspection"
+	// defaultCacheTTL is the default time of caching an active result.
+	defaultCacheTTL = time.Minute
+	// defaultInactiveCacheTTL is the default time of caching an inactive result.
+	defaultInactiveCacheTTL = 10 * time.Second
+	// defaultMaxCacheEntries is the default maximum number of cached results.
+	defaultMaxCacheEntries = 10000
+)
+
+// ErrInactive is the error of a token which is not active, such as an expired, revoked or unknown token.
+var ErrInactive = errors.New("oidc: token not active")
+
+// IntrospectionConfig is the configuration of token introspection.
+type IntrospectionConfig struct {
+	// Endpoint is the introspection endpoint. Either Endpoint or Provider is required.
+	Endpoint string
+	// Provider is the OpenID provider, whose "introspection_endpoint" is used if Endpoint is empty.
+	Provider *Provider
+	// ClientID and ClientSecret are the credentials of the gateway at the introspection endpoint.
+	ClientID     string
+	ClientSecret string
+	// Client is the HTTP client of introspection requests. http.Client with a timeout of 10 seconds is used if nil.
+	Client *http.Client
+	// CacheTTL is how long an active result is cached, capped by the expiry of the token. It is one minute if zero,
+	// and results are not cached if negative. A revoked token may be accepted until its result expires.
+	CacheTTL time.Duration
+	// InactiveCacheTTL is how long an inactive result is cached. It is 10 seconds if zero,
+	// and inactive results are not cached if negative.
+	InactiveCacheTTL time.Duration
+	// MaxCacheEntries is the maximum number of cached results. It is 10000 if zero.
+	MaxCacheEntries int
+	// Public is a list of patterns of Service which can be requested without a token, such as "public.*".
+	// See jwt.Rule for the patterns. Tokens are still introspected for public Service if there is one.
+	Public []string
+}
+
+// Introspector introspects tokens (RFC 7662), caching the results by the hash of the token.
+type Introspector struct {
+	config IntrospectionConfig
+
+	mu    sync.Mutex
+	cache map[string]introspectionEntry
+}
+
+// introspectionEntry is a cached result of introspection. The claims are nil for an inactive token.
+type introspectionEntry struct {
+	claims  jwt.Claims
+	expires time.Time
+}
+
+// NewIntrospector creates an Introspector with the configuration.
+func NewIntrospector(config IntrospectionConfig) *Introspector {
+	if config.Endpoint == "" && config.Provider == nil {
+		panic("no introspection endpoint")
+	}
+	if config.Client == nil {
+		if config.Provider != nil {
+			config.Client = config.Provider.client
+		} else {
+			config.Client = &http.Client{Timeout: defaultTimeout}
+		}
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = defaultCacheTTL
+	}
+	if config.InactiveCacheTTL == 0 {
+		config.InactiveCacheTTL = defaultInactiveCacheTTL
+	}
+	if config.MaxCacheEntries == 0 {
+		config.MaxCacheEntries = defaultMaxCacheEntries
+	}
+	return &Introspector{config: config, cache: map[string]introspectionEntry{}}
+}
+
+// Introspect gets the claims of an active token, such as "sub", "scope", "client_id" and "exp".
+// It returns ErrInactive if the token is not active.
+func (i *Introspector) Introspect(token string) (jwt.Claims, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	i.mu.Lock()
+	entry, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.claims == nil {
+			return nil, ErrInactive
+		}
+		return entry.claims, nil
+	}
+
+	claims, err := i.introspect(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims == nil {
+		i.store(key, nil, now.Add(i.config.InactiveCacheTTL), now)
+		return nil, ErrInactive
+	}
+	expires := now.Add(i.config.CacheTTL)
+	if exp, ok := claims.Time("exp"); ok && exp.Before(expires) {
+		expires = exp
+	}
+	i.store(key, claims, expires, now)
+	return claims, nil
+}
+
+// introspect sends the introspection request. It returns nil claims for an inactive token.
+func (i *Introspector) introspect(token string) (jwt.Claims, error) {
+	endpoint := i.config.Endpoint
+	if endpoint == "" {
+		metadata, err := i.config.Provider.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		if metadata.IntrospectionEndpoint == "" {
+			return nil, errors.New("oidc: no introspection endpoint")
+		}
+		endpoint = metadata.IntrospectionEndpoint
+	}
+	var claims jwt.Claims
+	err := postForm(i.config.Client, endpoint, i.config.ClientID, i.config.ClientSecret, url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, nil
+	}
+	if exp, ok := claims.Time("exp"); ok && !time.Now().Before(exp) {
+		return nil, nil
+	}
+	return claims, nil
+}
+
+// store caches a result, unless it expires immediately. Expired results are removed when the cache is full,
+// and the cache is cleared if it is still full.
+func (i *Introspector) store(key string, claims jwt.Claims, expires time.Time, now time.Time) {
+	if !now.Before(expires) {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.cache) >= i.config.MaxCacheEntries {
+		for k, e := range i.cache {
+			if !now.Before(e.expires) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= i.config.MaxCacheEntries {
+			i.cache = map[string]introspectionEntry{}
+		}
+	}
+	i.cache[key] = introspectionEntry{claims: claims, expires: expires}
+}
+
+// Introspection provides a middleware that authenticates requests with bearer tokens by introspecting them
+// (RFC 7662), puts the result in Context.Data with IntrospectionDataKey, and sets the Principal of the Context
+// with the "sub" claim (or "client_id" if there is no subject) as the subject.
+//
+// Requests without an active token get "401 Unauthorized" with a WWW-Authenticate header (RFC 6750),
+// and requests which cannot be introspected get "503 Service Unavailable", both from the error handler.
+func Introspection(config IntrospectionConfig) gateway.Handler {
+	introspector := NewIntrospector(config)
+	return func(context *gateway.Context) {
+		token := bearerToken(context.Request)
+		if token == "" {
+			if isPublic(config.Public, context.GetConfiguredServiceName()) {
+				return
+			}
+			context.Header.Set("WWW-Authenticate", "Bearer")
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := introspector.Introspect(token)
+		if err == ErrInactive {
+			context.Header.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			context.Logger.WithError(err).Error("failed to introspect token")
+			context.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+
+		subject := claims.Subject()
+		if subject == "" {
+			subject = claims.String("client_id")
+		}
+		context.Data[IntrospectionDataKey] = claims
+		context.SetPrincipal(&gateway.Principal{
+			Subject:    subject,
+			Method:     "introspection",
+			Attributes: map[string]interface{}{"claims": claims, "scopes": claims.Scopes()},
+		})
+	}
+}
+
+// GetIntrospection gets the introspection result of the request. It returns nil if the request does not have
+// an active token.
+func GetIntrospection(context *gateway.Context) jwt.Claims {
+	claims, _ := context.Data[IntrospectionDataKey].(jwt.Claims)
+	return claims
+}
+
+// bearerToken gets the token in the Bearer scheme from the Authorization header.
+func bearerToken(req *http.Request) string {
+	if value := req.Header.Get("Authorization"); len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	return ""
+}
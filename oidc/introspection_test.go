@@ -0,0 +1,120 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func bearer(handler http.Handler, path string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestIntrospection(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	handler := testServer(Introspection(IntrospectionConfig{
+		Provider:     NewProvider(provider.URL, nil),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		Public:       []string{"public.*"},
+	}))
+	token := provider.issue("alice")
+
+	w := bearer(handler, "/app", token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "introspection:alice", w.Body.String())
+
+	// the result is cached
+	assert.Equal(t, http.StatusOK, bearer(handler, "/app", token).Code)
+	assert.Equal(t, 1, provider.introspected)
+
+	w = bearer(handler, "/app", "unknown")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, bearer(handler, "/app", "unknown").Code)
+	assert.Equal(t, 2, provider.introspected)
+
+	w = bearer(handler, "/app", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = bearer(handler, "/public", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "anonymous", w.Body.String())
+	assert.Equal(t, "introspection:alice", bearer(handler, "/public", token).Body.String())
+}
+
+func TestIntrospector(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	introspector := NewIntrospector(IntrospectionConfig{
+		Endpoint:         provider.URL + "/introspect",
+		ClientID:         testClientID,
+		ClientSecret:     testClientSecret,
+		CacheTTL:         -1,
+		InactiveCacheTTL: -1,
+	})
+	token := provider.issue("alice")
+
+	claims, err := introspector.Introspect(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject())
+	assert.True(t, claims.HasScope("write"))
+
+	// results are not cached, so revoked tokens are rejected immediately
+	provider.revoke()
+	_, err = introspector.Introspect(token)
+	assert.Equal(t, ErrInactive, err)
+	assert.Equal(t, 2, provider.introspected)
+	assert.Empty(t, introspector.cache)
+
+	// wrong client credentials
+	introspector = NewIntrospector(IntrospectionConfig{Endpoint: provider.URL + "/introspect", ClientID: "other"})
+	_, err = introspector.Introspect(token)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrInactive, err)
+}
+
+func TestIntrospection_Unavailable(t *testing.T) {
+	provider := newFakeProvider(t)
+	provider.Close()
+	handler := testServer(Introspection(IntrospectionConfig{Endpoint: provider.URL + "/introspect"}))
+	assert.Equal(t, http.StatusServiceUnavailable, bearer(handler, "/app", "token").Code)
+}
+
+func TestIntrospector_Cache(t *testing.T) {
+	introspector := NewIntrospector(IntrospectionConfig{Endpoint: "http://localhost/", MaxCacheEntries: 2})
+	now := time.Now()
+	introspector.store("a", nil, now.Add(time.Minute), now)
+	introspector.store("b", nil, now.Add(time.Second), now)
+	introspector.store("expired", nil, now, now)
+	assert.Len(t, introspector.cache, 2)
+
+	// expired results are removed when the cache is full
+	later := now.Add(2 * time.Second)
+	introspector.store("c", nil, later.Add(time.Minute), later)
+	assert.Len(t, introspector.cache, 2)
+	assert.Contains(t, introspector.cache, "a")
+	assert.Contains(t, introspector.cache, "c")
+
+	// the cache is cleared if it is still full
+	introspector.store("d", nil, later.Add(time.Minute), later)
+	assert.Len(t, introspector.cache, 1)
+	assert.Contains(t, introspector.cache, "d")
+}
+
+func TestIntrospection_NoEndpoint(t *testing.T) {
+	assert.Panics(t, func() {
+		Introspection(IntrospectionConfig{})
+	})
+}
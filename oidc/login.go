@@ -0,0 +1,536 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/jwt"
+)
+
+const (
+	// SessionDataKey is the key of the *Session in Context.Data.
+	SessionDataKey = "oidc.session"
+	// DefaultCookieName is the default name of the session cookie.
+	DefaultCookieName = "gateway_session"
+	// defaultSessionMaxAge is the default maximum age of a session.
+	defaultSessionMaxAge = 24 * time.Hour
+	// loginMaxAge is the maximum age of a login attempt, from the redirection to the callback.
+	loginMaxAge = 10 * time.Minute
+	// refreshLeeway is how long before the expiry a session is refreshed.
+	refreshLeeway = 30 * time.Second
+)
+
+// Session is a logged-in session of a browser.
+type Session struct {
+	// Subject is the "sub" claim of the ID token.
+	Subject string `json:"sub"`
+	// Claims is the claims of the ID token.
+	Claims jwt.Claims `json:"claims"`
+	// IDToken is the raw ID token, which is used as a hint when logging out.
+	IDToken string `json:"id_token"`
+	// AccessToken is the access token, which can be sent to upstream Service.
+	AccessToken string `json:"access_token,omitempty"`
+	// RefreshToken is the refresh token, if the provider issued one.
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// Expiry is when the access token (or the ID token, if there is no expiry of the access token) expires.
+	Expiry time.Time `json:"expiry"`
+	// Created is when the user logged in. The session ends after LoginConfig.SessionMaxAge regardless of refreshes.
+	Created time.Time `json:"created"`
+}
+
+// loginState is the state of a login attempt, kept in a cookie from the redirection to the callback.
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	ReturnTo string    `json:"return_to"`
+	Created  time.Time `json:"created"`
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// LoginConfig is the configuration of the login middleware.
+type LoginConfig struct {
+	// Provider is the OpenID provider. It is required.
+	Provider *Provider
+	// ClientID is the client ID registered at the provider. It is required.
+	ClientID string
+	// ClientSecret is the client secret. It can be empty for public clients, which rely on PKCE.
+	ClientSecret string
+	// RedirectURL is the absolute callback URL registered at the provider. It is required.
+	// Its path must be configured for an endpoint of the Server, so that the middleware can handle it.
+	RedirectURL string
+	// Scopes is a list of scopes to request. "openid" is always requested. It is "openid profile email" if empty.
+	Scopes []string
+	// SessionSecret is the secret of encrypting cookies. It is required and should be at least 32 random bytes,
+	// shared by all instances of the gateway.
+	SessionSecret []byte
+	// CookieName is the name of the session cookie. It is "gateway_session" if empty.
+	// Sessions larger than a cookie are split into "<name>.1", "<name>.2" and so on.
+	CookieName string
+	// InsecureCookie allows the cookies to be sent over plain HTTP, which should only be used in development.
+	InsecureCookie bool
+	// SessionMaxAge is the maximum age of a session, after which the user must log in again.
+	// It is 24 hours if zero.
+	SessionMaxAge time.Duration
+	// LogoutPath is the path which logs the user out. Like the callback, it must be configured for an endpoint.
+	// There is no logout path if empty.
+	LogoutPath string
+	// PostLogoutRedirectURL is where the user is redirected after logging out. It is also sent to the
+	// "end_session_endpoint" of the provider, if there is one. It is "/" if empty.
+	PostLogoutRedirectURL string
+	// Public is a list of patterns of Service which can be requested without logging in, such as "public.*".
+	// See jwt.Rule for the patterns. The session is still read for public Service if there is one.
+	Public []string
+	// Client is the HTTP client of requests to the provider. The client of the Provider is used if it is nil.
+	Client *http.Client
+}
+
+// Login provides a middleware of the OpenID Connect authorization code flow with PKCE, for browser applications.
+//
+// A request without a session is redirected to the provider if it is a GET request, or gets "401 Unauthorized"
+// from the error handler otherwise. After the user logs in, the provider redirects to RedirectURL,
+// where the middleware exchanges the code for tokens, verifies the ID token (with its nonce), sets an encrypted
+// session cookie and redirects back to the original URL. The tokens are refreshed before they expire,
+// if the provider issued a refresh token.
+//
+// The session is put in Context.Data with SessionDataKey, and the Principal of the Context is set with the
+// "sub" claim of the ID token as the subject.
+func Login(config LoginConfig) gateway.Handler {
+	if config.Provider == nil {
+		panic("no OpenID provider")
+	}
+	if config.ClientID == "" {
+		panic("no client ID")
+	}
+	if len(config.SessionSecret) == 0 {
+		panic("no session secret")
+	}
+	redirectURL, err := url.Parse(config.RedirectURL)
+	if err != nil || !redirectURL.IsAbs() {
+		panic("invalid redirect URL: " + config.RedirectURL)
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	} else if !contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if config.CookieName == "" {
+		config.CookieName = DefaultCookieName
+	}
+	if config.SessionMaxAge == 0 {
+		config.SessionMaxAge = defaultSessionMaxAge
+	}
+	if config.PostLogoutRedirectURL == "" {
+		config.PostLogoutRedirectURL = "/"
+	}
+	if config.Client == nil {
+		config.Client = config.Provider.client
+	}
+	l := &login{config: config, codec: newCookieCodec(config.SessionSecret), callbackPath: redirectURL.Path}
+	return l.handle
+}
+
+// GetSession gets the session of the request. It returns nil if the user has not logged in.
+func GetSession(context *gateway.Context) *Session {
+	session, _ := context.Data[SessionDataKey].(*Session)
+	return session
+}
+
+// login is the login middleware.
+type login struct {
+	config       LoginConfig
+	codec        *cookieCodec
+	callbackPath string
+}
+
+// handle handles a request.
+func (l *login) handle(context *gateway.Context) {
+	switch context.Request.URL.Path {
+	case l.callbackPath:
+		l.callback(context)
+		return
+	case l.config.LogoutPath:
+		if l.config.LogoutPath != "" {
+			l.logout(context)
+			return
+		}
+	}
+
+	session := l.session(context)
+	if session != nil {
+		context.Data[SessionDataKey] = session
+		context.SetPrincipal(&gateway.Principal{
+			Subject:    session.Subject,
+			Method:     "oidc",
+			Attributes: map[string]interface{}{"claims": session.Claims},
+		})
+		return
+	}
+	if isPublic(l.config.Public, context.GetConfiguredServiceName()) {
+		return
+	}
+	if context.Request.Method != http.MethodGet {
+		context.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	l.redirectToProvider(context)
+}
+
+// session reads the session cookie, refreshing the tokens if they are about to expire.
+// It returns nil if there is no valid session.
+func (l *login) session(context *gateway.Context) *Session {
+	value, names := readChunks(context.Request, l.config.CookieName)
+	if value == "" {
+		return nil
+	}
+	var session Session
+	if err := l.codec.decode(l.config.CookieName, value, &session); err != nil {
+		l.clearCookies(context, names)
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(session.Created) >= l.config.SessionMaxAge {
+		l.clearCookies(context, names)
+		return nil
+	}
+	if now.Add(refreshLeeway).Before(session.Expiry) {
+		return &session
+	}
+	if session.RefreshToken == "" {
+		l.clearCookies(context, names)
+		return nil
+	}
+
+	refreshed, err := l.refresh(&session, now)
+	if err != nil {
+		context.Logger.WithError(err).Info("failed to refresh session")
+		l.clearCookies(context, names)
+		return nil
+	}
+	if err := l.setSession(context, refreshed, names); err != nil {
+		context.Logger.WithError(err).Error("failed to set session cookie")
+		return nil
+	}
+	return refreshed
+}
+
+// refresh refreshes the tokens of the session with the refresh token.
+func (l *login) refresh(session *Session, now time.Time) (*Session, error) {
+	metadata, err := l.config.Provider.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	var resp tokenResponse
+	err = postForm(l.config.Client, metadata.TokenEndpoint, l.config.ClientID, l.config.ClientSecret, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {session.RefreshToken},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := *session
+	refreshed.AccessToken = resp.AccessToken
+	if resp.RefreshToken != "" {
+		refreshed.RefreshToken = resp.RefreshToken
+	}
+	if resp.IDToken != "" {
+		// the nonce is not included in a refreshed ID token
+		claims, err := l.verifyIDToken(resp.IDToken, "", now)
+		if err != nil {
+			return nil, err
+		}
+		if claims.Subject() != session.Subject {
+			return nil, errors.New("oidc: subject changed after refreshing")
+		}
+		refreshed.IDToken, refreshed.Claims = resp.IDToken, claims
+	}
+	refreshed.Expiry = expiry(resp, refreshed.Claims, now)
+	return &refreshed, nil
+}
+
+// redirectToProvider redirects the user to the authorization endpoint of the provider.
+func (l *login) redirectToProvider(context *gateway.Context) {
+	metadata, err := l.config.Provider.Metadata()
+	if err != nil {
+		context.Logger.WithError(err).Error("failed to discover OpenID provider")
+		context.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	state := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString() + randomString(),
+		ReturnTo: context.Request.URL.RequestURI(),
+		Created:  time.Now(),
+	}
+	value, err := l.codec.encode(l.loginCookieName(), state)
+	if err != nil {
+		context.Logger.WithError(err).Error("failed to set login cookie")
+		context.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	l.addCookie(context, &http.Cookie{
+		Name:   l.loginCookieName(),
+		Value:  value,
+		Path:   l.callbackPath,
+		MaxAge: int(loginMaxAge / time.Second),
+	})
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {l.config.ClientID},
+		"redirect_uri":          {l.config.RedirectURL},
+		"scope":                 {strings.Join(l.config.Scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	redirect(context, withQuery(metadata.AuthorizationEndpoint, query))
+}
+
+// callback handles the redirection from the provider, exchanging the code for tokens.
+func (l *login) callback(context *gateway.Context) {
+	req := context.Request
+	value, _ := readChunks(req, l.loginCookieName())
+	l.clearCookies(context, []string{l.loginCookieName()})
+
+	var state loginState
+	if value == "" || l.codec.decode(l.loginCookieName(), value, &state) != nil ||
+		time.Since(state.Created) >= loginMaxAge ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(req.URL.Query().Get("state"))) != 1 {
+		context.Logger.Info("invalid login state")
+		context.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if e := req.URL.Query().Get("error"); e != "" {
+		context.Logger.WithField("error", e).Info("login failed at OpenID provider")
+		context.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	session, err := l.exchange(req.URL.Query().Get("code"), &state)
+	if err != nil {
+		context.Logger.WithError(err).Info("failed to exchange authorization code")
+		context.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	_, names := readChunks(req, l.config.CookieName)
+	if err := l.setSession(context, session, names); err != nil {
+		context.Logger.WithError(err).Error("failed to set session cookie")
+		context.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	redirect(context, safeReturnTo(state.ReturnTo))
+}
+
+// exchange exchanges the authorization code for tokens, and verifies the ID token.
+func (l *login) exchange(code string, state *loginState) (*Session, error) {
+	if code == "" {
+		return nil, errors.New("oidc: no authorization code")
+	}
+	metadata, err := l.config.Provider.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	var resp tokenResponse
+	err = postForm(l.config.Client, metadata.TokenEndpoint, l.config.ClientID, l.config.ClientSecret, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {l.config.RedirectURL},
+		"client_id":     {l.config.ClientID},
+		"code_verifier": {state.Verifier},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IDToken == "" {
+		return nil, errors.New("oidc: no ID token")
+	}
+
+	now := time.Now()
+	claims, err := l.verifyIDToken(resp.IDToken, state.Nonce, now)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		Subject:      claims.Subject(),
+		Claims:       claims,
+		IDToken:      resp.IDToken,
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Expiry:       expiry(resp, claims, now),
+		Created:      now,
+	}, nil
+}
+
+// verifyIDToken verifies the signature and the claims of an ID token. The nonce is not checked if empty.
+func (l *login) verifyIDToken(raw string, nonce string, now time.Time) (jwt.Claims, error) {
+	metadata, err := l.config.Provider.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	algorithms := metadata.SigningAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{jwt.RS256}
+	}
+	token, err := jwt.Parse(raw, l.config.Provider, algorithms)
+	if err != nil {
+		return nil, err
+	}
+	validation := jwt.Validation{
+		Issuer:        metadata.Issuer,
+		Audience:      []string{l.config.ClientID},
+		ClockSkew:     time.Minute,
+		RequireExpiry: true,
+	}
+	if err := validation.Validate(token.Claims, now); err != nil {
+		return nil, err
+	}
+	if token.Claims.Subject() == "" {
+		return nil, errors.New("oidc: no subject in ID token")
+	}
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(token.Claims.String("nonce")), []byte(nonce)) != 1 {
+		return nil, errors.New("oidc: nonce mismatch")
+	}
+	return token.Claims, nil
+}
+
+// logout clears the session, and redirects the user to the "end_session_endpoint" of the provider if there is one.
+func (l *login) logout(context *gateway.Context) {
+	value, names := readChunks(context.Request, l.config.CookieName)
+	l.clearCookies(context, names)
+
+	var session Session
+	metadata, err := l.config.Provider.Metadata()
+	if err != nil || metadata.EndSessionEndpoint == "" ||
+		l.codec.decode(l.config.CookieName, value, &session) != nil {
+		redirect(context, l.config.PostLogoutRedirectURL)
+		return
+	}
+	redirect(context, withQuery(metadata.EndSessionEndpoint, url.Values{
+		"id_token_hint":            {session.IDToken},
+		"client_id":                {l.config.ClientID},
+		"post_logout_redirect_uri": {l.config.PostLogoutRedirectURL},
+	}))
+}
+
+// setSession sets the session cookie, clearing the chunks of the previous one which are no longer used.
+func (l *login) setSession(context *gateway.Context, session *Session, previous []string) error {
+	value, err := l.codec.encode(l.config.CookieName, session)
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, cookie := range chunkCookies(http.Cookie{
+		Name:   l.config.CookieName,
+		Path:   "/",
+		MaxAge: int(l.config.SessionMaxAge / time.Second),
+	}, value) {
+		used[cookie.Name] = true
+		l.addCookie(context, cookie)
+	}
+	var stale []string
+	for _, name := range previous {
+		if !used[name] {
+			stale = append(stale, name)
+		}
+	}
+	l.clearCookies(context, stale)
+	return nil
+}
+
+// clearCookies deletes the cookies.
+func (l *login) clearCookies(context *gateway.Context, names []string) {
+	for _, name := range names {
+		path := "/"
+		if name == l.loginCookieName() {
+			path = l.callbackPath
+		}
+		l.addCookie(context, &http.Cookie{Name: name, Path: path, MaxAge: -1})
+	}
+}
+
+// addCookie adds a cookie to the response, with the security attributes.
+func (l *login) addCookie(context *gateway.Context, cookie *http.Cookie) {
+	cookie.HttpOnly = true
+	cookie.Secure = !l.config.InsecureCookie
+	cookie.SameSite = http.SameSiteLaxMode
+	context.Header.Add("Set-Cookie", cookie.String())
+}
+
+// loginCookieName gets the name of the cookie of login attempts.
+func (l *login) loginCookieName() string {
+	return l.config.CookieName + "_login"
+}
+
+// expiry gets the expiry of a session from the token response, or from the ID token.
+func expiry(resp tokenResponse, claims jwt.Claims, now time.Time) time.Time {
+	if resp.ExpiresIn > 0 {
+		return now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	exp, _ := claims.Time("exp")
+	return exp
+}
+
+// redirect redirects the user with "302 Found".
+func redirect(context *gateway.Context, location string) {
+	context.Interrupt()
+	context.Header.Set("Location", location)
+	context.Header.Set("Cache-Control", "no-store")
+	context.StatusCode = http.StatusFound
+}
+
+// safeReturnTo only allows paths on the same host to be returned to, preventing open redirects.
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+// withQuery adds the query to a URL.
+func withQuery(endpoint string, query url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + query.Encode()
+	}
+	return endpoint + "?" + query.Encode()
+}
+
+// randomString generates a random string of 256 bits.
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// contains checks if a list contains the value.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
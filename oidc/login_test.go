@@ -0,0 +1,193 @@
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLoginConfig(provider *fakeProvider) LoginConfig {
+	return LoginConfig{
+		Provider:              NewProvider(provider.URL, nil),
+		ClientID:              testClientID,
+		ClientSecret:          testClientSecret,
+		RedirectURL:           "http://example.com/callback",
+		SessionSecret:         []byte("0123456789abcdef0123456789abcdef"),
+		InsecureCookie:        true,
+		LogoutPath:            "/logout",
+		PostLogoutRedirectURL: "http://example.com/public",
+		Public:                []string{"public.*"},
+	}
+}
+
+// logIn logs in at the provider after requesting the target, which is redirected back to.
+func logIn(t *testing.T, b *browser, provider *fakeProvider, target string, subject string) {
+	w := b.get(target)
+	assert.Equal(t, http.StatusFound, w.Code)
+	callback := provider.authorize(w.Header().Get("Location"), subject)
+	w = b.get(callback)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, target, w.Header().Get("Location"))
+}
+
+func TestLogin(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	b := newBrowser(testServer(Login(testLoginConfig(provider))))
+
+	// public Service does not require logging in, and POST requests are not redirected
+	w := b.get("/public")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "anonymous", w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, b.do(http.MethodPost, "/app").Code)
+
+	w = b.get("/app?tab=1")
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	query := location.Query()
+	assert.Equal(t, testClientID, query.Get("client_id"))
+	assert.Equal(t, "http://example.com/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "openid profile email", query.Get("scope"))
+	assert.NotEmpty(t, query.Get("state"))
+	assert.NotEmpty(t, query.Get("nonce"))
+	assert.NotEmpty(t, query.Get("code_challenge"))
+	login := b.cookies[DefaultCookieName+"_login"]
+	assert.NotNil(t, login)
+	assert.Equal(t, "/callback", login.Path)
+	assert.True(t, login.HttpOnly)
+
+	w = b.get(provider.authorize(location.String(), "alice"))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/app?tab=1", w.Header().Get("Location"))
+	assert.Nil(t, b.cookies[DefaultCookieName+"_login"])
+	assert.NotNil(t, b.cookies[DefaultCookieName])
+
+	w = b.get("/app")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "oidc:alice", w.Body.String())
+	w = b.get("/public")
+	assert.Equal(t, "oidc:alice", w.Body.String())
+
+	// the session cannot be read or forged
+	assert.NotContains(t, b.cookies[DefaultCookieName].Value, "alice")
+	b.cookies[DefaultCookieName].Value = "x" + b.cookies[DefaultCookieName].Value
+	assert.Equal(t, http.StatusFound, b.get("/app").Code)
+	assert.Nil(t, b.cookies[DefaultCookieName])
+}
+
+func TestLogin_Callback(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	b := newBrowser(testServer(Login(testLoginConfig(provider))))
+
+	// no login in progress
+	assert.Equal(t, http.StatusBadRequest, b.get("/callback?code=x&state=y").Code)
+
+	w := b.get("/app")
+	callback := provider.authorize(w.Header().Get("Location"), "alice")
+
+	// the state must match
+	u, _ := url.Parse(callback)
+	query := u.Query()
+	query.Set("state", "forged")
+	login := *b.cookies[DefaultCookieName+"_login"]
+	assert.Equal(t, http.StatusBadRequest, b.get("/callback?"+query.Encode()).Code)
+
+	// the login attempt is consumed
+	assert.Equal(t, http.StatusBadRequest, b.get(callback).Code)
+
+	// the code can be exchanged only once
+	restored := login
+	b.cookies[login.Name] = &restored
+	assert.Equal(t, http.StatusFound, b.get(callback).Code)
+	b.cookies[login.Name] = &login
+	assert.Equal(t, http.StatusUnauthorized, b.get(callback).Code)
+
+	// errors from the provider
+	b = newBrowser(testServer(Login(testLoginConfig(provider))))
+	w = b.get("/app")
+	u, _ = url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, http.StatusUnauthorized,
+		b.get("/callback?error=access_denied&state="+u.Query().Get("state")).Code)
+}
+
+func TestLogin_OpenRedirect(t *testing.T) {
+	assert.Equal(t, "/app?x=1", safeReturnTo("/app?x=1"))
+	assert.Equal(t, "/", safeReturnTo("//evil.example.com/"))
+	assert.Equal(t, "/", safeReturnTo("/\\evil.example.com/"))
+	assert.Equal(t, "/", safeReturnTo("https://evil.example.com/"))
+}
+
+func TestLogin_Refresh(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	provider.expiresIn = 10
+	b := newBrowser(testServer(Login(testLoginConfig(provider))))
+	logIn(t, b, provider, "/app", "alice")
+
+	// the tokens expire within the leeway, so they are refreshed
+	old := b.cookies[DefaultCookieName].Value
+	w := b.get("/app")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "oidc:alice", w.Body.String())
+	assert.Equal(t, 1, provider.refreshed)
+	assert.NotEqual(t, old, b.cookies[DefaultCookieName].Value)
+
+	// the user logs in again if the refresh token is revoked
+	provider.revoke()
+	assert.Equal(t, http.StatusFound, b.get("/app").Code)
+	assert.Nil(t, b.cookies[DefaultCookieName])
+}
+
+func TestLogin_SessionMaxAge(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	config := testLoginConfig(provider)
+	config.SessionMaxAge = time.Nanosecond
+	b := newBrowser(testServer(Login(config)))
+	logIn(t, b, provider, "/app", "alice")
+	assert.Equal(t, http.StatusFound, b.get("/app").Code)
+}
+
+func TestLogin_Logout(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	b := newBrowser(testServer(Login(testLoginConfig(provider))))
+	logIn(t, b, provider, "/app", "alice")
+	assert.NotNil(t, b.cookies[DefaultCookieName])
+
+	w := b.get("/logout")
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(location.String(), provider.URL+"/logout?"))
+	assert.NotEmpty(t, location.Query().Get("id_token_hint"))
+	assert.Equal(t, "http://example.com/public", location.Query().Get("post_logout_redirect_uri"))
+	assert.Nil(t, b.cookies[DefaultCookieName])
+
+	// without a session
+	w = b.get("/logout")
+	assert.Equal(t, "http://example.com/public", w.Header().Get("Location"))
+}
+
+func TestLogin_InvalidConfig(t *testing.T) {
+	provider := NewProvider("https://issuer.example.com", nil)
+	secret := []byte("secret")
+	assert.Panics(t, func() {
+		Login(LoginConfig{ClientID: "c", RedirectURL: "https://example.com/cb", SessionSecret: secret})
+	})
+	assert.Panics(t, func() {
+		Login(LoginConfig{Provider: provider, RedirectURL: "https://example.com/cb", SessionSecret: secret})
+	})
+	assert.Panics(t, func() {
+		Login(LoginConfig{Provider: provider, ClientID: "c", RedirectURL: "https://example.com/cb"})
+	})
+	assert.Panics(t, func() {
+		Login(LoginConfig{Provider: provider, ClientID: "c", RedirectURL: "/cb", SessionSecret: secret})
+	})
+}
@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/jwt"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClientID     = "gateway"
+	testClientSecret = "client-secret"
+)
+
+// fakeProvider is a fake OpenID provider, with discovery, JWKS, token, introspection and end session endpoints.
+type fakeProvider struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu            sync.Mutex
+	codes         map[string]url.Values
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	expiresIn     int64
+	introspected  int
+	refreshed     int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	p := &fakeProvider{
+		t:             t,
+		key:           key,
+		codes:         map[string]url.Values{},
+		accessTokens:  map[string]string{},
+		refreshTokens: map[string]string{},
+		expiresIn:     3600,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, Metadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+			EndSessionEndpoint:    p.URL + "/logout",
+			IntrospectionEndpoint: p.URL + "/introspect",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		writeJSON(w, map[string]interface{}{"keys": []jwt.JSONWebKey{{
+			KeyType: "RSA", KeyID: "k1", Algorithm: jwt.RS256, Use: "sig",
+			N: enc(key.N.Bytes()), E: enc(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/introspect", p.introspect)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// authorize simulates the user logging in at the authorization endpoint, returning the redirection to the callback.
+func (p *fakeProvider) authorize(location string, subject string) string {
+	u, err := url.Parse(location)
+	assert.NoError(p.t, err)
+	assert.Equal(p.t, p.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	query := u.Query()
+	assert.Equal(p.t, "code", query.Get("response_type"))
+	assert.Equal(p.t, "S256", query.Get("code_challenge_method"))
+	query.Set("sub", subject)
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = query
+	p.mu.Unlock()
+	return query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	if id, secret, _ := r.BasicAuth(); id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(w, map[string]string{"error": "invalid_client"})
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var subject, nonce string
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		query, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) ||
+			query.Get("redirect_uri") != r.PostFormValue("redirect_uri") {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		subject, nonce = query.Get("sub"), query.Get("nonce")
+	case "refresh_token":
+		var ok bool
+		if subject, ok = p.refreshTokens[r.PostFormValue("refresh_token")]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		p.refreshed++
+	}
+
+	claims := jwt.Claims{
+		"iss": p.URL,
+		"sub": subject,
+		"aud": testClientID,
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	idToken, err := jwt.Sign(claims, jwt.RS256, "k1", p.key)
+	assert.NoError(p.t, err)
+	accessToken, refreshToken := randomString(), randomString()
+	p.accessTokens[accessToken] = subject
+	p.refreshTokens[refreshToken] = subject
+	writeJSON(w, map[string]interface{}{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"refresh_token": refreshToken,
+		"expires_in":    p.expiresIn,
+		"id_token":      idToken,
+	})
+}
+
+func (p *fakeProvider) introspect(w http.ResponseWriter, r *http.Request) {
+	if id, secret, _ := r.BasicAuth(); id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.introspected++
+	subject, ok := p.accessTokens[r.PostFormValue("token")]
+	if !ok {
+		writeJSON(w, map[string]interface{}{"active": false})
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"active":    true,
+		"sub":       subject,
+		"client_id": "app",
+		"scope":     "read write",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+// issue issues an access token of the subject, which is active at the introspection endpoint.
+func (p *fakeProvider) issue(subject string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token := randomString()
+	p.accessTokens[token] = subject
+	return token
+}
+
+// revoke revokes all tokens.
+func (p *fakeProvider) revoke() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.accessTokens = map[string]string{}
+	p.refreshTokens = map[string]string{}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func testServer(middleware gateway.Handler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/app", http.MethodGet, "app.home")
+	cfg.Add("/app", http.MethodPost, "app.home")
+	cfg.Add("/public", http.MethodGet, "public.home")
+	cfg.Add("/callback", http.MethodGet, "oidc.callback")
+	cfg.Add("/logout", http.MethodGet, "oidc.logout")
+	s.UseConfig(cfg)
+	s.UseMiddleware(middleware)
+	s.Register("*", func(context *gateway.Context) {
+		if p := context.GetPrincipal(); p != nil {
+			context.Response = []byte(p.Method + ":" + p.Subject)
+		} else {
+			context.Response = []byte("anonymous")
+		}
+	})
+	return s.Handler()
+}
+
+// browser sends requests to a handler, keeping cookies like a browser.
+type browser struct {
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser(handler http.Handler) *browser {
+	return &browser{handler: handler, cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) do(method string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for _, cookie := range b.cookies {
+		if len(req.URL.Path) >= len(cookie.Path) && req.URL.Path[:len(cookie.Path)] == cookie.Path {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	b.handler.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+func (b *browser) get(target string) *httptest.ResponseRecorder {
+	return b.do(http.MethodGet, target)
+}
+
+func TestMatchService(t *testing.T) {
+	assert.True(t, matchService("*", "anything"))
+	assert.True(t, matchService("public", "public"))
+	assert.False(t, matchService("public", "public.home"))
+	assert.True(t, matchService("public.*", "public"))
+	assert.True(t, matchService("public.*", "public.home"))
+	assert.False(t, matchService("public.*", "publication"))
+}
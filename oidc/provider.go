@@ -0,0 +1,206 @@
+// Package oidc provides middleware for OpenID Connect: a relying-party login flow for browser applications,
+// and OAuth 2.0 token introspection (RFC 7662) for APIs.
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway/jwt"
+)
+
+const (
+	// defaultTimeout is the default timeout of requests to the identity provider.
+	defaultTimeout = 10 * time.Second
+	// discoveryRetryInterval is the minimum interval of retrying a failed discovery.
+	discoveryRetryInterval = 10 * time.Second
+)
+
+// Metadata is the metadata of an OpenID provider (OpenID Connect Discovery 1.0).
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint,omitempty"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint,omitempty"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// Provider is an OpenID provider, whose metadata is discovered at "/.well-known/openid-configuration"
+// of the issuer when it is first needed. It is a jwt.KeySet of the keys at the "jwks_uri" of the metadata.
+type Provider struct {
+	issuer string
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *jwt.JWKS
+	err      error
+	tried    time.Time
+}
+
+// NewProvider creates a Provider of the issuer. http.Client with a timeout of 10 seconds is used if client is nil.
+func NewProvider(issuer string, client *http.Client) *Provider {
+	if issuer == "" {
+		panic("no issuer for OpenID provider")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Provider{issuer: strings.TrimSuffix(issuer, "/"), client: client}
+}
+
+// NewProviderWithMetadata creates a Provider with the given metadata, without discovery.
+func NewProviderWithMetadata(metadata Metadata, client *http.Client) *Provider {
+	p := NewProvider(metadata.Issuer, client)
+	p.setMetadata(&metadata)
+	return p
+}
+
+// Issuer gets the issuer of the provider.
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// Metadata gets the metadata of the provider, discovering it if it is not loaded yet.
+// A failed discovery is retried after 10 seconds.
+func (p *Provider) Metadata() (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	if p.err != nil && time.Since(p.tried) < discoveryRetryInterval {
+		return nil, p.err
+	}
+	p.tried = time.Now()
+	metadata, err := p.discover()
+	p.err = err
+	if err != nil {
+		return nil, err
+	}
+	p.setMetadata(metadata)
+	return metadata, nil
+}
+
+// Key implements jwt.KeySet.
+func (p *Provider) Key(kid string, alg string) (interface{}, error) {
+	if _, err := p.Metadata(); err != nil {
+		return nil, err
+	}
+	if p.keys == nil {
+		return nil, jwt.ErrKeyNotFound
+	}
+	return p.keys.Key(kid, alg)
+}
+
+// setMetadata sets the metadata and the key set of the provider.
+func (p *Provider) setMetadata(metadata *Metadata) {
+	p.metadata = metadata
+	if metadata.JWKSURI != "" {
+		p.keys = jwt.NewJWKSWithConfig(jwt.JWKSConfig{URL: metadata.JWKSURI, Client: p.client})
+	}
+}
+
+// discover fetches the metadata of the provider.
+func (p *Provider) discover() (*Metadata, error) {
+	var metadata Metadata
+	if err := getJSON(p.client, p.issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch: %q", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete provider metadata")
+	}
+	return &metadata, nil
+}
+
+// getJSON gets a JSON document at the URL.
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// postForm posts a form to an endpoint of the provider with client credentials in basic auth (client_secret_basic),
+// and decodes the JSON response. Error responses (RFC 6749 section 5.2) are returned as *Error.
+func postForm(client *http.Client, endpoint string, clientID string, clientSecret string, form url.Values,
+	v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(e)
+		return e
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Error is an error response from the identity provider.
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("oidc: unexpected status %d", e.StatusCode)
+	}
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return "oidc: " + e.Code + ": " + e.Description
+}
+
+// matchService checks if a Service matches a pattern, which can be exact, end with ".*" to match a Service and
+// all Service under it, or be an asterisk (*) to match all Service.
+func matchService(pattern string, service string) bool {
+	if pattern == "*" || pattern == service {
+		return true
+	}
+	if strings.HasSuffix(pattern, ".*") {
+		parent := strings.TrimSuffix(pattern, ".*")
+		return service == parent || strings.HasPrefix(service, parent+".")
+	}
+	return false
+}
+
+// isPublic checks if a Service matches any of the patterns.
+func isPublic(patterns []string, service string) bool {
+	for _, pattern := range patterns {
+		if matchService(pattern, service) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvider(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.Close()
+	p := NewProvider(provider.URL+"/", nil)
+	assert.Equal(t, provider.URL, p.Issuer())
+
+	metadata, err := p.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, provider.URL+"/token", metadata.TokenEndpoint)
+	assert.Equal(t, provider.URL+"/introspect", metadata.IntrospectionEndpoint)
+
+	key, err := p.Key("k1", jwt.RS256)
+	assert.NoError(t, err)
+	assert.Equal(t, &provider.key.PublicKey, key)
+}
+
+func TestProvider_IssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, Metadata{Issuer: "https://other.example.com", AuthorizationEndpoint: "a", TokenEndpoint: "t",
+			JWKSURI: "j"})
+	}))
+	defer server.Close()
+	p := NewProvider(server.URL, nil)
+	_, err := p.Metadata()
+	assert.Error(t, err)
+
+	// the failure is cached for a while
+	server.Close()
+	_, err2 := p.Metadata()
+	assert.Equal(t, err, err2)
+	_, err = p.Key("", jwt.RS256)
+	assert.Error(t, err)
+}
+
+func TestNewProviderWithMetadata(t *testing.T) {
+	p := NewProviderWithMetadata(Metadata{Issuer: "https://issuer.example.com", TokenEndpoint: "t"}, nil)
+	metadata, err := p.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, "t", metadata.TokenEndpoint)
+	_, err = p.Key("", jwt.RS256)
+	assert.Equal(t, jwt.ErrKeyNotFound, err)
+
+	assert.Panics(t, func() {
+		NewProvider("", nil)
+	})
+}
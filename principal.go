@@ -0,0 +1,21 @@
+package gateway
+
+// Principal is the authenticated identity of a request, regardless of the authentication method.
+type Principal struct {
+	// Subject is the identity, such as a user name, a key ID, or the subject of a certificate.
+	Subject string
+	// Method is the authentication method, such as "basic", "hmac", "mtls", "jwt", "apikey", "oidc" or "introspection".
+	Method string
+	// Attributes holds extra information from the authentication method, such as scopes or claims.
+	Attributes map[string]interface{}
+}
+
+// SetPrincipal sets the authenticated identity of the request. It is called by authentication middleware.
+func (c *Context) SetPrincipal(principal *Principal) {
+	c.principal = principal
+}
+
+// GetPrincipal gets the authenticated identity of the request. It returns nil if the request is not authenticated.
+func (c *Context) GetPrincipal() *Principal {
+	return c.principal
+}
@@ -0,0 +1,192 @@
+// Package ratelimit provides a middleware that limits the rate of requests with token buckets or sliding windows,
+// keyed by client IP, API key, header, JWT claim or Service name.
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/jwt"
+)
+
+// Algorithm is the algorithm of a Limit.
+type Algorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit.Burst requests, refilled at the rate of Limit.Requests per Limit.Period.
+	TokenBucket Algorithm = iota
+	// SlidingWindow allows up to Limit.Requests requests in any Limit.Period, estimated with two fixed windows.
+	SlidingWindow
+)
+
+// Limit is the limit of the rate of requests.
+type Limit struct {
+	// Algorithm is the algorithm of the limit. It is TokenBucket by default.
+	Algorithm Algorithm
+	// Requests is the number of requests allowed in a Period.
+	Requests int
+	// Period is the period of the limit.
+	Period time.Duration
+	// Burst is the capacity of the token bucket. It is the same as Requests if zero.
+	// It is not used by SlidingWindow.
+	Burst int
+}
+
+// burst gets the capacity of the token bucket.
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// rate gets the number of tokens refilled per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// KeyFunc gets the key which requests are counted by. Requests with an empty key are not limited.
+type KeyFunc func(context *gateway.Context) string
+
+// Config is the configuration of the rate limiting middleware.
+type Config struct {
+	// Limits is the limits of Service, looked up with gateway.LookupService. Requests are counted separately for each name in Limits, so all Service under "api.foo"
+	// share the same limit if only "api.foo" is configured. Requests of Service without a limit are not limited.
+	Limits map[string]Limit
+	// Key gets the key which requests are counted by. ByClientIP(false) is used if it is nil.
+	Key KeyFunc
+	// Store keeps the state of limits. A new MemoryStore is used if it is nil.
+	Store Store
+	// FailClosed rejects requests if the Store fails. Requests are allowed by default.
+	FailClosed bool
+}
+
+// Middleware provides a middleware that limits the rate of requests.
+//
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set on every limited response.
+// Rejected requests get "429 Too Many Requests" from the error handler of the Server, with a Retry-After header.
+// Requests made by the gateway itself (see gateway.IsBackground) are not limited.
+func Middleware(config Config) gateway.Handler {
+	for name, limit := range config.Limits {
+		if limit.Requests <= 0 || limit.Period <= 0 {
+			panic("invalid rate limit of " + name)
+		}
+	}
+	if config.Key == nil {
+		config.Key = ByClientIP(false)
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryStore()
+	}
+
+	return func(context *gateway.Context) {
+		if gateway.IsBackground(context.Request) {
+			return
+		}
+		name, limit, ok := gateway.LookupService(config.Limits, context.GetConfiguredServiceName())
+		if !ok {
+			return
+		}
+		key := config.Key(context)
+		if key == "" {
+			return
+		}
+
+		var result Result
+		var err error
+		storeKey := name + "|" + key
+		if limit.Algorithm == SlidingWindow {
+			result, err = config.Store.SlidingWindow(storeKey, limit, time.Now())
+		} else {
+			result, err = config.Store.TokenBucket(storeKey, limit, time.Now())
+		}
+		if err != nil {
+			context.Logger.WithError(err).Error("failed to check rate limit")
+			if config.FailClosed {
+				context.AbortWithStatus(http.StatusServiceUnavailable)
+			}
+			return
+		}
+
+		header := context.Header
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			context.Logger.WithField("key", key).WithField("limit", name).Info("rate limit exceeded")
+			context.AbortWithStatus(http.StatusTooManyRequests)
+		}
+	}
+}
+
+// ceilSeconds converts a duration to seconds, rounded up.
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// ByClientIP gets the key of the client IP.
+// See gateway.ClientIP for trustProxyHeaders.
+func ByClientIP(trustProxyHeaders bool) KeyFunc {
+	return func(context *gateway.Context) string {
+		return gateway.ClientIP(context.Request, trustProxyHeaders)
+	}
+}
+
+// ByHeader gets the key of the value of a request header.
+func ByHeader(name string) KeyFunc {
+	return func(context *gateway.Context) string {
+		return context.Request.Header.Get(name)
+	}
+}
+
+// ByAPIKey gets the key of the API key in the given header, or in the given query parameter if not in the header.
+// Either of them can be empty.
+func ByAPIKey(header string, query string) KeyFunc {
+	return func(context *gateway.Context) string {
+		if header != "" {
+			if key := context.Request.Header.Get(header); key != "" {
+				return key
+			}
+		}
+		if query != "" {
+			return context.Request.URL.Query().Get(query)
+		}
+		return ""
+	}
+}
+
+// ByService gets the key of the Service name configured for the endpoint,
+// so that all clients share the same limit of a Service.
+func ByService() KeyFunc {
+	return func(context *gateway.Context) string {
+		return context.GetConfiguredServiceName()
+	}
+}
+
+// ByJWTClaim gets the key of a claim of the token verified by jwt.Middleware.
+// Only string and number claims are supported.
+//
+// jwt.Middleware must run before the rate limit middleware. Requests without a verified token get an empty key
+// and are not limited, since the claims of unverified tokens are chosen by clients.
+func ByJWTClaim(claim string) KeyFunc {
+	return func(context *gateway.Context) string {
+		return claimKey(jwt.GetClaims(context)[claim])
+	}
+}
+
+// claimKey converts a string or number claim to a key.
+func claimKey(claim interface{}) string {
+	switch v := claim.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
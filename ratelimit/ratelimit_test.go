@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/jwt"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(config Config) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", http.MethodGet, "api.foo")
+	cfg.Add("/bar", http.MethodGet, "api.bar")
+	cfg.Add("/admin", http.MethodGet, "admin.users")
+	cfg.Add("/public", http.MethodGet, "public")
+	s.UseConfig(cfg)
+	s.UseMiddleware(Middleware(config))
+	s.SetErrorHandler(http.StatusTooManyRequests, func(context *gateway.Context) {
+		context.Response = []byte("slow down")
+	})
+	s.Register("*", func(context *gateway.Context) {
+		context.Response = []byte("ok")
+	})
+	return s.Handler()
+}
+
+func get(handler http.Handler, path string, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := testServer(Config{
+		Limits: map[string]Limit{
+			"api":   {Requests: 2, Period: time.Minute},
+			"admin": {Algorithm: SlidingWindow, Requests: 1, Period: time.Hour},
+		},
+	})
+
+	w := get(handler, "/foo", "1.2.3.4:1000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+
+	// api.foo and api.bar share the limit of api
+	w = get(handler, "/bar", "1.2.3.4:1001")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = get(handler, "/foo", "1.2.3.4:1002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "slow down", w.Body.String())
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	// other clients are not affected
+	w = get(handler, "/foo", "5.6.7.8:1000")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = get(handler, "/admin", "1.2.3.4:1000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = get(handler, "/admin", "1.2.3.4:1000")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// no limit
+	for i := 0; i < 5; i++ {
+		w = get(handler, "/public", "1.2.3.4:1000")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestMiddleware_Background(t *testing.T) {
+	handler := testServer(Config{Limits: map[string]Limit{"api": {Requests: 1, Period: time.Minute}}})
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		req = req.WithContext(gateway.WithBackground(req.Context()))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+	// requests made by the gateway itself are not counted
+	assert.Equal(t, http.StatusOK, get(handler, "/foo", "1.2.3.4:1000").Code)
+}
+
+func TestMiddleware_Default(t *testing.T) {
+	handler := testServer(Config{
+		Limits: map[string]Limit{"*": {Requests: 1, Period: time.Second}},
+		Key:    ByService(),
+	})
+
+	assert.Equal(t, http.StatusOK, get(handler, "/public", "1.2.3.4:1000").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get(handler, "/public", "5.6.7.8:1000").Code)
+	assert.Equal(t, http.StatusOK, get(handler, "/foo", "1.2.3.4:1000").Code)
+}
+
+type failingStore struct{}
+
+func (failingStore) TokenBucket(string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("unavailable")
+}
+
+func (failingStore) SlidingWindow(string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("unavailable")
+}
+
+func TestMiddleware_StoreError(t *testing.T) {
+	limits := map[string]Limit{"*": {Requests: 1, Period: time.Second}}
+
+	handler := testServer(Config{Limits: limits, Store: failingStore{}})
+	w := get(handler, "/foo", "1.2.3.4:1000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	handler = testServer(Config{Limits: limits, Store: failingStore{}, FailClosed: true})
+	assert.Equal(t, http.StatusServiceUnavailable, get(handler, "/foo", "1.2.3.4:1000").Code)
+}
+
+func TestMiddleware_InvalidLimit(t *testing.T) {
+	assert.Panics(t, func() {
+		Middleware(Config{Limits: map[string]Limit{"*": {Requests: 1}}})
+	})
+}
+
+func testKey(fn KeyFunc, req *http.Request) string {
+	var key string
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/foo", req.Method, "api.foo")
+	s.UseConfig(cfg)
+	s.Register("*", func(context *gateway.Context) {
+		key = fn(context)
+	})
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	return key
+}
+
+func TestKeyFuncs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/foo?api_key=query-key", nil)
+	req.RemoteAddr = "1.2.3.4:1000"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 9.9.9.9")
+	req.Header.Set("X-Tenant", "tenant-a")
+
+	assert.Equal(t, "1.2.3.4", testKey(ByClientIP(false), req))
+	assert.Equal(t, "9.9.9.9", testKey(ByClientIP(true), req))
+	assert.Equal(t, "tenant-a", testKey(ByHeader("X-Tenant"), req))
+	assert.Equal(t, "api.foo", testKey(ByService(), req))
+	assert.Equal(t, "query-key", testKey(ByAPIKey("X-API-Key", "api_key"), req))
+	req.Header.Set("X-API-Key", "header-key")
+	assert.Equal(t, "header-key", testKey(ByAPIKey("X-API-Key", "api_key"), req))
+	assert.Equal(t, "", testKey(ByAPIKey("", ""), req))
+
+	verified := func(claim string) KeyFunc {
+		return func(context *gateway.Context) string {
+			context.Data[jwt.ClaimsKey] = jwt.Claims{"sub": "alice", "tenant": float64(42)}
+			return ByJWTClaim(claim)(context)
+		}
+	}
+	assert.Equal(t, "alice", testKey(verified("sub"), req))
+	assert.Equal(t, "42", testKey(verified("tenant"), req))
+	assert.Equal(t, "", testKey(verified("missing"), req))
+	// unverified tokens are not used
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`))
+	req.Header.Set("Authorization", "Bearer e30."+payload+".sig")
+	assert.Equal(t, "", testKey(ByJWTClaim("sub"), req))
+}
@@ -0,0 +1,349 @@
+package ratelimit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRedisPoolSize is the default number of idle connections kept by RedisStore.
+	defaultRedisPoolSize = 16
+	// defaultRedisTimeout is the default timeout of dialing and commands of RedisStore.
+	defaultRedisTimeout = time.Second
+	// maxRedisRetries is the maximum number of retries of an optimistic transaction conflicting with others.
+	maxRedisRetries = 10
+)
+
+// errRedisNil is the nil reply of Redis.
+var errRedisNil = errors.New("redis: nil")
+
+// errRedisConflict is the error of a transaction aborted by a change of a watched key.
+var errRedisConflict = errors.New("redis: transaction conflicted too many times")
+
+// RedisError is an error reply of Redis.
+type RedisError string
+
+// Error implements error.
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisConfig is the configuration of RedisStore.
+type RedisConfig struct {
+	// Addr is the address of the server, such as "localhost:6379".
+	Addr string
+	// Password is used to AUTH if it is not empty.
+	Password string
+	// DB is the database selected if it is not zero.
+	DB int
+	// Prefix is the prefix of keys. It is "ratelimit:" if empty.
+	Prefix string
+	// PoolSize is the number of idle connections kept. It is 16 if zero.
+	PoolSize int
+	// Timeout is the timeout of dialing and every command. It is one second if zero.
+	Timeout time.Duration
+}
+
+// RedisStore is a Store in a server speaking the Redis protocol (RESP), shared by multiple gateways.
+//
+// Only basic commands (GET, SET, INCRBY, DECRBY, PEXPIRE, WATCH, MULTI and EXEC) are used,
+// so any compatible server works without scripting.
+type RedisStore struct {
+	config RedisConfig
+	pool   chan *redisConn
+}
+
+// redisConn is a connection to the server.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore creates a RedisStore of the server at the address.
+func NewRedisStore(addr string) *RedisStore {
+	return NewRedisStoreWithConfig(RedisConfig{Addr: addr})
+}
+
+// NewRedisStoreWithConfig creates a RedisStore with the configuration.
+// Connections are made when needed, so the server does not have to be up when it is created.
+func NewRedisStoreWithConfig(config RedisConfig) *RedisStore {
+	if config.Addr == "" {
+		panic("empty redis address")
+	}
+	if config.Prefix == "" {
+		config.Prefix = "ratelimit:"
+	}
+	if config.PoolSize == 0 {
+		config.PoolSize = defaultRedisPoolSize
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultRedisTimeout
+	}
+	return &RedisStore{config: config, pool: make(chan *redisConn, config.PoolSize)}
+}
+
+// Close closes idle connections.
+func (r *RedisStore) Close() error {
+	for {
+		select {
+		case c := <-r.pool:
+			_ = c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// TokenBucket implements Store. The state is kept as "tokens:nanoseconds" and updated in an optimistic transaction.
+func (r *RedisStore) TokenBucket(key string, limit Limit, now time.Time) (Result, error) {
+	key = r.config.Prefix + "tb:" + key
+	var result Result
+	err := r.withConn(func(c *redisConn) error {
+		for i := 0; i < maxRedisRetries; i++ {
+			if _, err := c.do("WATCH", key); err != nil {
+				return err
+			}
+			tokens, last := float64(limit.burst()), now
+			reply, err := c.do("GET", key)
+			if err != nil && err != errRedisNil {
+				return err
+			}
+			if s, ok := reply.(string); ok {
+				tokens, last = parseBucketState(s, tokens, last)
+			}
+
+			var remaining float64
+			result, remaining = takeToken(limit, tokens, last, now)
+			state := strconv.FormatFloat(remaining, 'f', -1, 64) + ":" + strconv.FormatInt(now.UnixNano(), 10)
+			ttl := result.Reset/time.Millisecond + 1
+			_, err = c.multi([]interface{}{"SET", key, state, "PX", int64(ttl)})
+			if err == errRedisNil {
+				// the key has been changed by others, so try again
+				continue
+			}
+			return err
+		}
+		return errRedisConflict
+	})
+	return result, err
+}
+
+// SlidingWindow implements Store. The counter of the current window is increased first,
+// and decreased again if the request is rejected.
+func (r *RedisStore) SlidingWindow(key string, limit Limit, now time.Time) (Result, error) {
+	index := windowIndex(limit, now)
+	current := r.config.Prefix + "sw:" + key + ":" + strconv.FormatInt(index, 10)
+	previous := r.config.Prefix + "sw:" + key + ":" + strconv.FormatInt(index-1, 10)
+	ttl := int64(2 * limit.Period / time.Millisecond)
+
+	var result Result
+	err := r.withConn(func(c *redisConn) error {
+		reply, err := c.multi(
+			[]interface{}{"GET", previous},
+			[]interface{}{"INCRBY", current, 1},
+			[]interface{}{"PEXPIRE", current, ttl},
+		)
+		if err != nil {
+			return err
+		}
+		replies, ok := reply.([]interface{})
+		if !ok || len(replies) != 3 {
+			return fmt.Errorf("redis: unexpected reply %v", reply)
+		}
+		prevCount := 0
+		if s, ok := replies[0].(string); ok {
+			prevCount, _ = strconv.Atoi(s)
+		}
+		count, ok := replies[1].(int64)
+		if !ok {
+			return fmt.Errorf("redis: unexpected reply %v", replies[1])
+		}
+
+		result = countRequest(limit, prevCount, int(count), now)
+		if !result.Allowed {
+			_, err = c.do("DECRBY", current, 1)
+		}
+		return err
+	})
+	return result, err
+}
+
+// withConn runs the function with a connection from the pool. The connection is discarded if it fails.
+func (r *RedisStore) withConn(fn func(c *redisConn) error) error {
+	var c *redisConn
+	select {
+	case c = <-r.pool:
+	default:
+		var err error
+		if c, err = r.dial(); err != nil {
+			return err
+		}
+	}
+
+	_ = c.conn.SetDeadline(time.Now().Add(r.config.Timeout))
+	err := fn(c)
+	if _, ok := err.(RedisError); err != nil && !ok {
+		_ = c.conn.Close()
+		return err
+	}
+	select {
+	case r.pool <- c:
+	default:
+		_ = c.conn.Close()
+	}
+	return err
+}
+
+// dial makes a new connection, authenticates and selects the database.
+func (r *RedisStore) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", r.config.Addr, r.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(r.config.Timeout))
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if r.config.Password != "" {
+		if _, err := c.do("AUTH", r.config.Password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if r.config.DB != 0 {
+		if _, err := c.do("SELECT", r.config.DB); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do sends a command and reads the reply.
+func (c *redisConn) do(args ...interface{}) (interface{}, error) {
+	if err := c.write(args); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// multi runs the commands in a transaction and returns the array of their replies.
+// It returns errRedisNil if the transaction is aborted because of a watched key.
+func (c *redisConn) multi(commands ...[]interface{}) (interface{}, error) {
+	if err := c.write([]interface{}{"MULTI"}); err != nil {
+		return nil, err
+	}
+	for _, command := range commands {
+		if err := c.write(command); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.write([]interface{}{"EXEC"}); err != nil {
+		return nil, err
+	}
+
+	// replies of MULTI and the queued commands
+	var queueErr error
+	for i := 0; i <= len(commands); i++ {
+		if _, err := c.read(); err != nil {
+			if _, ok := err.(RedisError); !ok {
+				return nil, err
+			}
+			queueErr = err
+		}
+	}
+	reply, err := c.read()
+	if queueErr != nil && err == nil {
+		err = queueErr
+	}
+	return reply, err
+}
+
+// write writes a command as an array of bulk strings.
+func (c *redisConn) write(args []interface{}) error {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		s := fmt.Sprint(arg)
+		b.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// read reads a reply, which is a string, an int64, a slice of replies, or nil.
+// A nil bulk string or array is returned as errRedisNil, and an error reply as RedisError.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			reply, err := c.read()
+			if err == errRedisNil {
+				reply, err = nil, nil
+			}
+			if err != nil {
+				if _, ok := err.(RedisError); !ok {
+					return nil, err
+				}
+				reply = err
+			}
+			replies[i] = reply
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
+
+// parseBucketState parses the state of a token bucket, returning the defaults if it is invalid.
+func parseBucketState(s string, tokens float64, last time.Time) (float64, time.Time) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return tokens, last
+	}
+	t, err1 := strconv.ParseFloat(parts[0], 64)
+	ns, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return tokens, last
+	}
+	return t, time.Unix(0, ns)
+}
@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis is a local stand-in of a Redis server, supporting the commands used by RedisStore.
+// Expiry is not implemented, since the tests use fixed times.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]string
+	versions map[string]int
+	password string
+	commands []string
+}
+
+// newFakeRedis starts a fakeRedis requiring the password, or no password if it is empty.
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &fakeRedis{listener: l, data: map[string]string{}, versions: map[string]int{}, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) Close() {
+	_ = f.listener.Close()
+}
+
+func (f *fakeRedis) readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	watched := map[string]int{}
+	var queue [][]string
+	inMulti := false
+	authed := f.password == ""
+	for {
+		args, err := f.readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		f.mu.Lock()
+		f.commands = append(f.commands, cmd)
+		var reply string
+		switch {
+		case cmd == "AUTH":
+			authed = args[1] == f.password
+			if authed {
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "MULTI":
+			inMulti, queue = true, nil
+			reply = "+OK\r\n"
+		case cmd == "EXEC":
+			conflicted := false
+			for key, version := range watched {
+				if f.versions[key] != version {
+					conflicted = true
+				}
+			}
+			watched = map[string]int{}
+			inMulti = false
+			if conflicted {
+				reply = "*-1\r\n"
+			} else {
+				reply = "*" + strconv.Itoa(len(queue)) + "\r\n"
+				for _, q := range queue {
+					reply += f.exec(q)
+				}
+			}
+		case inMulti:
+			queue = append(queue, args)
+			reply = "+QUEUED\r\n"
+		case cmd == "WATCH":
+			watched[args[1]] = f.versions[args[1]]
+			reply = "+OK\r\n"
+		default:
+			reply = f.exec(args)
+		}
+		f.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if v, ok := f.data[args[1]]; ok {
+			return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+		}
+		return "$-1\r\n"
+	case "SET":
+		f.data[args[1]] = args[2]
+		f.versions[args[1]]++
+		return "+OK\r\n"
+	case "INCRBY", "DECRBY":
+		v, _ := strconv.Atoi(f.data[args[1]])
+		delta, _ := strconv.Atoi(args[2])
+		if strings.ToUpper(args[0]) == "DECRBY" {
+			delta = -delta
+		}
+		v += delta
+		f.data[args[1]] = strconv.Itoa(v)
+		f.versions[args[1]]++
+		return ":" + strconv.Itoa(v) + "\r\n"
+	case "PEXPIRE":
+		return ":1\r\n"
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+// touch changes a key as another client does.
+func (f *fakeRedis) touch(key string) {
+	f.mu.Lock()
+	f.versions[key]++
+	f.mu.Unlock()
+}
+
+func TestRedisStore_TokenBucket(t *testing.T) {
+	server := newFakeRedis(t, "")
+	defer server.Close()
+	store := NewRedisStore(server.listener.Addr().String())
+	defer store.Close()
+
+	testTokenBucket(t, store)
+	assert.Contains(t, server.data, "ratelimit:tb:k")
+}
+
+func TestRedisStore_SlidingWindow(t *testing.T) {
+	server := newFakeRedis(t, "")
+	defer server.Close()
+	store := NewRedisStore(server.listener.Addr().String())
+	defer store.Close()
+
+	testSlidingWindow(t, store)
+	// rejected requests are not counted
+	assert.Equal(t, "4", server.data["ratelimit:sw:k:2000"])
+}
+
+func TestRedisStore_Conflict(t *testing.T) {
+	server := newFakeRedis(t, "")
+	defer server.Close()
+	store := NewRedisStore(server.listener.Addr().String())
+	defer store.Close()
+
+	limit := Limit{Requests: 1, Period: time.Second}
+	conflicted := false
+	err := store.withConn(func(c *redisConn) error {
+		_, err := c.do("WATCH", "ratelimit:tb:k")
+		assert.NoError(t, err)
+		server.touch("ratelimit:tb:k")
+		_, err = c.multi([]interface{}{"SET", "ratelimit:tb:k", "1:0"})
+		conflicted = err == errRedisNil
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, conflicted)
+
+	result, err := store.TokenBucket("k", limit, time.Unix(1000, 0))
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestRedisStore_Auth(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	defer server.Close()
+
+	limit := Limit{Requests: 1, Period: time.Second}
+	store := NewRedisStoreWithConfig(RedisConfig{Addr: server.listener.Addr().String(), Password: "wrong"})
+	_, err := store.TokenBucket("k", limit, time.Now())
+	assert.Equal(t, RedisError("WRONGPASS invalid password"), err)
+	assert.NoError(t, store.Close())
+
+	store = NewRedisStoreWithConfig(RedisConfig{Addr: server.listener.Addr().String(), Password: "secret", Prefix: "rl:"})
+	defer store.Close()
+	result, err := store.TokenBucket("k", limit, time.Now())
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Contains(t, server.data, "rl:tb:k")
+}
+
+func TestRedisStore_Unavailable(t *testing.T) {
+	server := newFakeRedis(t, "")
+	addr := server.listener.Addr().String()
+	server.Close()
+
+	store := NewRedisStoreWithConfig(RedisConfig{Addr: addr, Timeout: 100 * time.Millisecond})
+	_, err := store.SlidingWindow("k", Limit{Algorithm: SlidingWindow, Requests: 1, Period: time.Second}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestNewRedisStore_EmptyAddr(t *testing.T) {
+	assert.Panics(t, func() {
+		NewRedisStore("")
+	})
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultShards is the number of shards of the MemoryStore.
+const defaultShards = 64
+
+// sweepInterval is the number of operations on a shard between two sweeps of expired entries.
+const sweepInterval = 1024
+
+// Result is the result of taking a request from a limit.
+type Result struct {
+	// Allowed is whether the request is allowed.
+	Allowed bool
+	// Limit is the number of requests allowed in a period.
+	Limit int
+	// Remaining is the number of requests that can be made immediately after this one.
+	Remaining int
+	// Reset is the time until the limit is fully available again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request can be allowed. It is zero if the request is allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the state of limits. It should be safe for concurrent use.
+type Store interface {
+	// TokenBucket takes a token from the bucket of the key for a request.
+	TokenBucket(key string, limit Limit, now time.Time) (Result, error)
+	// SlidingWindow counts a request in the sliding window of the key.
+	SlidingWindow(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// MemoryStore is a Store in memory, sharded by key to reduce lock contention.
+type MemoryStore struct {
+	shards []*memoryShard
+}
+
+// memoryShard is a shard of MemoryStore.
+type memoryShard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucketState
+	windows map[string]*windowState
+	ops     int
+}
+
+// bucketState is the state of a token bucket.
+type bucketState struct {
+	tokens  float64
+	last    time.Time
+	expires time.Time
+}
+
+// windowState is the state of a sliding window.
+type windowState struct {
+	index    int64
+	current  int
+	previous int
+	expires  time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{shards: make([]*memoryShard, defaultShards)}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{buckets: map[string]*bucketState{}, windows: map[string]*windowState{}}
+	}
+	return m
+}
+
+// shard gets the shard of the key, and sweeps expired entries in it from time to time.
+func (m *MemoryStore) shard(key string, now time.Time) *memoryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	s := m.shards[h.Sum32()%uint32(len(m.shards))]
+	s.mu.Lock()
+	s.ops++
+	if s.ops >= sweepInterval {
+		s.ops = 0
+		for k, b := range s.buckets {
+			if now.After(b.expires) {
+				delete(s.buckets, k)
+			}
+		}
+		for k, w := range s.windows {
+			if now.After(w.expires) {
+				delete(s.windows, k)
+			}
+		}
+	}
+	return s
+}
+
+// TokenBucket implements Store.
+func (m *MemoryStore) TokenBucket(key string, limit Limit, now time.Time) (Result, error) {
+	s := m.shard(key, now)
+	defer s.mu.Unlock()
+
+	state, ok := s.buckets[key]
+	if !ok {
+		state = &bucketState{tokens: float64(limit.burst()), last: now}
+		s.buckets[key] = state
+	}
+	result, tokens := takeToken(limit, state.tokens, state.last, now)
+	state.tokens, state.last = tokens, now
+	state.expires = now.Add(result.Reset)
+	return result, nil
+}
+
+// SlidingWindow implements Store.
+func (m *MemoryStore) SlidingWindow(key string, limit Limit, now time.Time) (Result, error) {
+	s := m.shard(key, now)
+	defer s.mu.Unlock()
+
+	index := windowIndex(limit, now)
+	state, ok := s.windows[key]
+	if !ok {
+		state = &windowState{index: index}
+		s.windows[key] = state
+	}
+	switch {
+	case state.index == index-1:
+		state.previous, state.current = state.current, 0
+	case state.index != index:
+		state.previous, state.current = 0, 0
+	}
+	state.index = index
+	state.expires = now.Add(2 * limit.Period)
+
+	result := countRequest(limit, state.previous, state.current+1, now)
+	if result.Allowed {
+		state.current++
+	}
+	return result, nil
+}
+
+// takeToken refills the bucket and takes a token from it, returning the result and the remaining tokens.
+func takeToken(limit Limit, tokens float64, last time.Time, now time.Time) (Result, float64) {
+	burst := float64(limit.burst())
+	rate := limit.rate()
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(burst, tokens+elapsed*rate)
+	}
+
+	result := Result{Limit: limit.Requests}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((burst - tokens) / rate)
+	return result, tokens
+}
+
+// countRequest estimates the number of requests in the sliding window with the counts of the previous window
+// and the current one (including this request), weighting the previous count by its overlap with the sliding window.
+func countRequest(limit Limit, previous int, current int, now time.Time) Result {
+	elapsed := now.Sub(windowStart(limit, now))
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	estimated := float64(previous)*weight + float64(current)
+
+	result := Result{Limit: limit.Requests, Reset: limit.Period - elapsed}
+	if estimated <= float64(limit.Requests) {
+		result.Allowed = true
+		result.Remaining = int(math.Floor(float64(limit.Requests) - estimated))
+		return result
+	}
+
+	// find the time when previous * weight + current <= limit
+	if current > limit.Requests || previous == 0 {
+		result.RetryAfter = limit.Period - elapsed
+	} else {
+		target := 1 - float64(limit.Requests-current)/float64(previous)
+		result.RetryAfter = time.Duration(target*float64(limit.Period)) - elapsed
+		if result.RetryAfter <= 0 {
+			result.RetryAfter = time.Millisecond
+		}
+	}
+	return result
+}
+
+// windowIndex is the index of the fixed window where the time is.
+func windowIndex(limit Limit, now time.Time) int64 {
+	return now.UnixNano() / int64(limit.Period)
+}
+
+// windowStart is the start time of the fixed window where the time is.
+func windowStart(limit Limit, now time.Time) time.Time {
+	return time.Unix(0, windowIndex(limit, now)*int64(limit.Period))
+}
+
+// seconds converts seconds in float to time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTokenBucket(t *testing.T, store Store) {
+	limit := Limit{Requests: 2, Period: time.Second, Burst: 3}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 3; i++ {
+		result, err := store.TokenBucket("k", limit, now)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2-i, result.Remaining)
+		assert.Equal(t, 2, result.Limit)
+	}
+	result, err := store.TokenBucket("k", limit, now)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, result.Reset)
+
+	// refilled by one token in 500ms
+	result, err = store.TokenBucket("k", limit, now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// other keys are not affected
+	result, err = store.TokenBucket("other", limit, now)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func testSlidingWindow(t *testing.T, store Store) {
+	limit := Limit{Algorithm: SlidingWindow, Requests: 4, Period: time.Second}
+	start := time.Unix(2000, 0)
+
+	for i := 0; i < 4; i++ {
+		result, err := store.SlidingWindow("k", limit, start)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3-i, result.Remaining)
+	}
+	result, err := store.SlidingWindow("k", limit, start.Add(100*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 900*time.Millisecond, result.RetryAfter)
+
+	// a quarter of the previous window is out of the sliding window, so one request is allowed
+	result, err = store.SlidingWindow("k", limit, start.Add(1250*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	result, err = store.SlidingWindow("k", limit, start.Add(1250*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 250*time.Millisecond, result.RetryAfter)
+
+	// the previous window is gone
+	result, err = store.SlidingWindow("k", limit, start.Add(3*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 3, result.Remaining)
+}
+
+func TestMemoryStore_TokenBucket(t *testing.T) {
+	testTokenBucket(t, NewMemoryStore())
+}
+
+func TestMemoryStore_SlidingWindow(t *testing.T) {
+	testSlidingWindow(t, NewMemoryStore())
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 1, Period: time.Second}
+	now := time.Unix(1000, 0)
+	_, _ = store.TokenBucket("k", limit, now)
+
+	s := store.shard("k", now)
+	s.ops = sweepInterval - 1
+	s.mu.Unlock()
+	assert.Len(t, s.buckets, 1)
+
+	store.shard("k", now.Add(time.Hour)).mu.Unlock()
+	assert.Empty(t, s.buckets)
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/LYZhelloworld/go-logger"
+)
+
+// Panic holds information of a panic recovered while handling a request.
+type Panic struct {
+	// Value is the value passed to panic().
+	Value interface{}
+	// Stack is the stack trace of the goroutine where the panic happened.
+	Stack []byte
+	// Service is the name of the Service of the request.
+	// It is empty if the panic happened before the Service is matched.
+	Service string
+}
+
+// Error formats the panic value.
+func (p *Panic) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// PanicHook is a function that reports a recovered panic, for example, to an error tracking service.
+type PanicHook func(context *Context, p *Panic)
+
+// OnPanic sets a hook which is called every time a panic is recovered, after it has been logged
+// and before the error response is generated.
+// Panics from the hook itself are recovered and logged.
+func (s *Server) OnPanic(hook PanicHook) {
+	s.panicHook = hook
+}
+
+// GetPanic gets the panic recovered while handling the request. It returns nil if there is no panic.
+// The error handler of http.StatusInternalServerError can use it to generate the error response.
+func (c *Context) GetPanic() *Panic {
+	return c.panic
+}
+
+// recoverPanic recovers a panic while handling the request, logs it with the stack trace,
+// and writes an error response of http.StatusInternalServerError if the response has not been written.
+// It must be called with defer directly.
+func (s *Server) recoverPanic(context *Context) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if r == http.ErrAbortHandler {
+		// http.ErrAbortHandler aborts the response on purpose and is handled by net/http
+		panic(r)
+	}
+
+	p := &Panic{Value: r, Stack: debug.Stack(), Service: context.serviceName}
+	context.panic = p
+	var log logger.Logger
+	if err, ok := r.(error); ok {
+		log = s.logger.WithError(err)
+	} else {
+		log = s.logger.WithField("err", r)
+	}
+	log.WithField("service", p.Service).
+		WithField("stack", string(p.Stack)).Error("server panic")
+
+	if s.panicHook != nil {
+		s.safeCall(func() { s.panicHook(context, p) }, "panic hook")
+	}
+
+	if context.isWritten {
+		// the status code has been sent and cannot be changed
+		return
+	}
+	resetResponse(context)
+	if handler, ok := s.errorConfig[http.StatusInternalServerError]; ok {
+		if !s.safeCall(func() { handler(context) }, "error handler") {
+			resetResponse(context)
+		}
+	}
+	s.safeCall(context.write, "write response")
+}
+
+// resetResponse discards the response for the error response of a panic, closing the response stream if any.
+func resetResponse(context *Context) {
+	context.StatusCode = http.StatusInternalServerError
+	context.Response = nil
+	if closer, ok := context.ResponseStream.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	context.ResponseStream = nil
+	context.Trailer = http.Header{}
+}
+
+// finish calls the functions registered by Context.OnFinish after the response has been written.
+// It must be deferred before recoverPanic, so that it is called after the error response of a panic is written.
+func (s *Server) finish(context *Context) {
+	for i := len(context.finishers) - 1; i >= 0; i-- {
+		s.safeCall(context.finishers[i], "finish function")
+	}
+}
+
+// safeCall calls the function and logs the panic from it, if any.
+// It returns false if the function panics.
+func (s *Server) safeCall(fn func(), name string) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+			s.logger.WithField("err", r).
+				WithField("stack", string(debug.Stack())).Error(name + " panic")
+		}
+	}()
+	fn()
+	return true
+}
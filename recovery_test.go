@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecoveryServer(handler Handler) *Server {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := Config{}
+	cfg.Add("/panic", http.MethodGet, "api.panic")
+	s.UseConfig(cfg)
+	s.Register("api.panic", handler)
+	return s
+}
+
+func TestServer_RecoverPanic(t *testing.T) {
+	s := testRecoveryServer(func(context *Context) {
+		context.Response = []byte("partial")
+		panic(errors.New("boom"))
+	})
+	var hooked *Panic
+	s.OnPanic(func(context *Context, p *Panic) {
+		hooked = p
+	})
+	s.SetErrorHandler(http.StatusInternalServerError, func(context *Context) {
+		context.Response = []byte("error: " + context.GetPanic().Error())
+	})
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "error: panic: boom", w.Body.String())
+	assert.NotNil(t, hooked)
+	assert.Equal(t, "api.panic", hooked.Service)
+	assert.Contains(t, string(hooked.Stack), "recovery_test.go")
+}
+
+func TestServer_RecoverPanicAfterNext(t *testing.T) {
+	s := testRecoveryServer(func(context *Context) {
+		context.Response = []byte("ok")
+	})
+	s.UseMiddleware(func(context *Context) {
+		context.Next()
+		panic("after next")
+	})
+	s.OnPanic(func(context *Context, p *Panic) {
+		panic("hook panic")
+	})
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+// closeRecorder is a response stream recording if it is closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestServer_RecoverPanicWithStream(t *testing.T) {
+	streams := []*closeRecorder{}
+	newStream := func(context *Context) {
+		stream := &closeRecorder{Reader: strings.NewReader("partial")}
+		streams = append(streams, stream)
+		context.ResponseStream = stream
+		context.Trailer.Set("Grpc-Status", "0")
+	}
+	s := testRecoveryServer(func(context *Context) {
+		newStream(context)
+		panic("boom")
+	})
+	s.SetErrorHandler(http.StatusInternalServerError, func(context *Context) {
+		assert.Nil(t, context.ResponseStream)
+		assert.Empty(t, context.Trailer)
+		newStream(context)
+		panic("error handler panic")
+	})
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Empty(t, w.Result().Trailer)
+	assert.Len(t, streams, 2)
+	for _, stream := range streams {
+		assert.True(t, stream.closed)
+	}
+}
+
+func TestContext_OnFinish(t *testing.T) {
+	s := testRecoveryServer(func(context *Context) {
+		panic("boom")
+	})
+	var finished []string
+	s.UseMiddleware(func(context *Context) {
+		context.OnFinish(func() {
+			finished = append(finished, "outer")
+			assert.Equal(t, http.StatusInternalServerError, context.StatusCode)
+			assert.NotNil(t, context.GetPanic())
+		})
+	})
+	s.UseMiddleware(func(context *Context) {
+		context.OnFinish(func() {
+			finished = append(finished, "inner")
+			panic("finish panic")
+		})
+		context.Next()
+	})
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, []string{"inner", "outer"}, finished)
+}
+
+func TestServer_RecoverPanicInErrorHandler(t *testing.T) {
+	s := testRecoveryServer(func(context *Context) {
+		panic("boom")
+	})
+	s.SetErrorHandler(http.StatusInternalServerError, func(context *Context) {
+		context.StatusCode = http.StatusOK
+		panic("error handler panic")
+	})
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestServer_RecoverAbortHandler(t *testing.T) {
+	s := testRecoveryServer(func(context *Context) {
+		panic(http.ErrAbortHandler)
+	})
+	handler := s.Handler()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+}
+
+func TestServer_ErrorHandler(t *testing.T) {
+	s := testRecoveryServer(func(context *Context) {})
+	s.SetErrorHandler(http.StatusNotFound, func(context *Context) {
+		context.Response = []byte("not found")
+	})
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "not found", w.Body.String())
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Route is an entry of the compiled route table.
+type Route struct {
+	// Path is the path of the endpoint.
+	Path string `json:"path"`
+	// Method is the HTTP method of the endpoint.
+	Method string `json:"method"`
+	// Service is the Service name configured for the endpoint.
+	Service string `json:"service"`
+	// MatchedService is the name of the Service which actually handles the endpoint.
+	// It differs from Service when a more generic Service is used as a fallback.
+	MatchedService string `json:"matched_service"`
+	// Middleware is the names of the middlewares run before the handler, in order of execution.
+	Middleware []string `json:"middleware"`
+}
+
+// IsFallback checks if the endpoint is handled by a more generic Service than the configured one.
+func (r Route) IsFallback() bool {
+	return r.Service != r.MatchedService
+}
+
+// Routes returns the route table compiled when the Server starts, sorted by path and method.
+// It returns an empty table if the Server has not been started, or Handler has not been called.
+func (s *Server) Routes() []Route {
+	middleware := make([]string, 0, len(s.middleware))
+	for _, m := range s.middleware {
+		middleware = append(middleware, handlerName(m))
+	}
+
+	routes := make([]Route, 0, len(s.endpointConfig))
+	for path, config := range s.endpointConfig {
+		for method, service := range *config {
+			routes = append(routes, Route{
+				Path:           path,
+				Method:         method,
+				Service:        service.requestedName,
+				MatchedService: service.name,
+				Middleware:     middleware,
+			})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// RouteTableHandler returns an http.Handler that serves the route table.
+// The table is served as JSON by default,
+// and as an HTML page if the query has "format=html" or the client accepts "text/html".
+//
+// The handler is not registered anywhere. Mount it on an internal address only,
+// since it exposes the structure of all Service.
+func (s *Server) RouteTableHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		routes := s.Routes()
+		if wantsHTML(req) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := routeTableTemplate.Execute(w, routes); err != nil {
+				s.logger.WithError(err).Error("failed to render route table")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(routes); err != nil {
+			s.logger.WithError(err).Error("failed to render route table")
+		}
+	})
+}
+
+// wantsHTML checks if the request asks for an HTML page instead of JSON.
+func wantsHTML(req *http.Request) bool {
+	switch req.URL.Query().Get("format") {
+	case "html":
+		return true
+	case "json":
+		return false
+	}
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+var routeTableTemplate = template.Must(template.New("routes").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Route Table</title></head>
+<body>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Path</th><th>Method</th><th>Service</th><th>Matched Service</th><th>Middleware</th></tr>
+{{range .}}<tr{{if .IsFallback}} style="background-color: #fff3cd"{{end}}><td>{{.Path}}</td><td>{{.Method}}</td><td>{{.Service}}</td><td>{{.MatchedService}}</td><td>{{join .Middleware " → "}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRouteServer() *Server {
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := Config{}
+	cfg.Add("/hello", http.MethodGet, "api.gateway.hello")
+	cfg.Add("/hello", http.MethodPost, "api.gateway")
+	cfg.Add("/foo", http.MethodGet, "foo")
+	s.UseConfig(cfg)
+	s.Register("api.gateway", func(context *Context) {})
+	s.Register("*", func(context *Context) {})
+	s.UseMiddleware(func(context *Context) {})
+	return s
+}
+
+func TestServer_Routes(t *testing.T) {
+	s := testRouteServer()
+	assert.Empty(t, s.Routes())
+
+	s.prepare("")
+	routes := s.Routes()
+	assert.Len(t, routes, 3)
+	assert.Equal(t, Route{
+		Path: "/foo", Method: http.MethodGet, Service: "foo", MatchedService: "*",
+		Middleware: []string{"go-gateway.testRouteServer.func3"},
+	}, routes[0])
+	assert.Equal(t, "/hello", routes[1].Path)
+	assert.Equal(t, http.MethodGet, routes[1].Method)
+	assert.Equal(t, "api.gateway.hello", routes[1].Service)
+	assert.Equal(t, "api.gateway", routes[1].MatchedService)
+	assert.True(t, routes[1].IsFallback())
+	assert.Equal(t, http.MethodPost, routes[2].Method)
+	assert.False(t, routes[2].IsFallback())
+}
+
+func TestServer_RouteTableHandler(t *testing.T) {
+	s := testRouteServer()
+	s.prepare("")
+	handler := s.RouteTableHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var routes []Route
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &routes))
+	assert.Equal(t, s.Routes(), routes)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/routes?format=html", nil))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<td>api.gateway.hello</td>")
+}
@@ -2,10 +2,14 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -14,6 +18,16 @@ import (
 
 // Server is a struct for HTTP router.
 type Server struct {
+	// inFlight is the number of requests being handled. It is accessed atomically
+	// and is kept as the first field to be 64-bit aligned.
+	inFlight int64
+	// listening is set to 1 when the main listener starts listening. It is accessed atomically.
+	listening int32
+	// draining is set to 1 when the Server starts draining. It is accessed atomically.
+	draining int32
+	// upgrading is set to 1 while upgrading. It is accessed atomically.
+	upgrading int32
+
 	// config is the configuration mapping endpoints and methods to service.
 	config Config
 	// errorConfig is a map that matches status codes to Handler.
@@ -29,6 +43,43 @@ type Server struct {
 	logger logger.Logger
 	// endpointConfig is a map with endpoint as key and routerConfig as value.
 	endpointConfig endpointConfig
+	// adminConfig is the configuration of the admin listener.
+	adminConfig adminConfig
+	// panicHook is called every time a panic is recovered.
+	panicHook PanicHook
+	// tls keeps the TLS configuration of the main listeners. TLS is not used if it is nil.
+	tls *tlsStore
+	// options is the options of the http.Server of the main listeners.
+	options ServerOptions
+	// listeners is a list of extra main listeners.
+	listeners []listenerConfig
+	// lifecycle is the lifecycle hooks.
+	lifecycle lifecycle
+	// upgrade is the configuration of upgrades. Upgrades are disabled if it is nil.
+	upgrade *UpgradeConfig
+	// http3 is the configuration of HTTP/3. HTTP/3 is not served if it is nil.
+	http3 *HTTP3Config
+	// grpc enables routing gRPC requests onto Service names.
+	grpc bool
+	// grpcServices is the Service names routed for gRPC requests, besides the ones in Config.
+	grpcServices []string
+	// grpcRoutes is the Service names routed for gRPC requests, compiled from Config and grpcServices.
+	grpcRoutes map[string]bool
+	// altSvc is the Alt-Svc header advertising HTTP/3 while serving it.
+	altSvc atomic.Value
+
+	// mu guards the fields below.
+	mu sync.Mutex
+	// addrs is the addresses of the main listeners while listening.
+	addrs []net.Addr
+	// rawListeners is the main listeners while listening, before being wrapped, which are inherited by upgrades.
+	rawListeners []net.Listener
+	// http3Conn is the UDP connection of HTTP/3 while listening, which is inherited by upgrades.
+	http3Conn net.PacketConn
+	// adminListener is the admin listener while listening.
+	adminListener net.Listener
+	// stop shuts down the Server gracefully while running with RunContext or RunWithShutdown.
+	stop context.CancelFunc
 }
 
 // Default creates a Server with default configurations.
@@ -43,6 +94,18 @@ func Default() *Server {
 
 // prepare sets all configurations before running.
 func (s *Server) prepare(addr string) *http.Server {
+	s.compile()
+
+	svr := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+	s.applyOptions(svr)
+	return svr
+}
+
+// compile checks configurations and matches every endpoint to its Service.
+func (s *Server) compile() {
 	if s.config == nil {
 		s.config = Config{}
 		s.logger.Warn("config is nil. Use empty config instead.")
@@ -55,7 +118,14 @@ func (s *Server) prepare(addr string) *http.Server {
 
 	// parse service
 	s.endpointConfig = endpointConfig{}
+	s.grpcRoutes = map[string]bool{}
+	for _, name := range s.grpcServices {
+		s.grpcRoutes[name] = true
+	}
 	for endpoint, name := range s.config {
+		if name != baseServiceHandler {
+			s.grpcRoutes[name] = true
+		}
 		matchedName, handler := s.matchService(name)
 		if handler == nil {
 			s.logger.WithField("endpoint", endpoint.Path).
@@ -66,68 +136,234 @@ func (s *Server) prepare(addr string) *http.Server {
 		if s.endpointConfig[endpoint.Path] == nil {
 			s.endpointConfig[endpoint.Path] = &routerConfig{}
 		}
-		(*s.endpointConfig[endpoint.Path])[endpoint.Method] = serviceInfo{name: matchedName, handler: handler}
+		(*s.endpointConfig[endpoint.Path])[endpoint.Method] = serviceInfo{name: matchedName, requestedName: name, handler: handler}
 		s.logger.WithField("endpoint", endpoint.Path).
 			WithField("method", endpoint.Method).
 			WithField("service", matchedName).
 			Info("service matched")
 	}
+}
 
-	svr := &http.Server{
-		Addr:    addr,
-		Handler: s,
-	}
-	return svr
+// Handler compiles the current Config and returns the Server as an http.Handler,
+// so that it can be served by a custom http.Server, or tested with the net/http/httptest package.
+// The Server is not marked as listening, and the admin listener is not started.
+func (s *Server) Handler() http.Handler {
+	s.compile()
+	return s
 }
 
 // Run starts the server with the current Config.
 func (s *Server) Run(addr string) error {
 	svr := s.prepare(addr)
-	s.logger.Info("start server")
-	return svr.ListenAndServe()
+	admin := s.prepareAdmin()
+	if err := s.runAdmin(admin); err != nil {
+		s.stopped(err)
+		return err
+	}
+	if admin != nil {
+		defer admin.Close()
+	}
+	err := s.serve(svr)
+	s.stopped(err)
+	return err
 }
 
 // RunWithShutdown starts the server with the current Config.
-// It catches a SIGINT or SIGTERM as shutdown signal.
+// It catches a SIGINT or SIGTERM as shutdown signal, and a SIGUSR2 as upgrade signal if upgrades are enabled.
+// See RunContext and UseUpgrade.
 func (s *Server) RunWithShutdown(addr string, shutdownTimeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	quit := make(chan os.Signal, 1)
+	// kill: SIGTERM
+	// kill -2: SIGINT
+	// kill -9: SIGKILL (cannot be caught)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	upgrade := make(chan os.Signal, 1)
+	if s.upgrade != nil && upgradeSignal != nil {
+		signal.Notify(upgrade, upgradeSignal)
+		defer signal.Stop(upgrade)
+	}
+	go func() {
+		for {
+			select {
+			case <-quit:
+				cancel()
+				return
+			case <-upgrade:
+				go func() {
+					if err := s.Upgrade(); err != nil {
+						s.logger.WithError(err).Error("failed to upgrade")
+					}
+				}()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s.runContext(ctx, addr, shutdownTimeout)
+}
+
+// RunContext starts the server with the current Config, and shuts it down gracefully when the context is done,
+// without handling any signals. The Server stops reporting readiness, calls the OnShutdown hooks, and waits for
+// in-flight requests to finish within ServerOptions.ShutdownTimeout (30 seconds by default).
+//
+// It returns nil after shutting down gracefully, context.DeadlineExceeded if the shutdown timeout is reached,
+// or the error of listening or serving.
+func (s *Server) RunContext(ctx context.Context, addr string) error {
+	timeout := s.options.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return s.runContext(ctx, addr, timeout)
+}
+
+// runContext runs the Server until the context is done, and shuts it down within the timeout.
+func (s *Server) runContext(ctx context.Context, addr string, shutdownTimeout time.Duration) (err error) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	s.mu.Lock()
+	s.stop = stop
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.stop = nil
+		s.mu.Unlock()
+	}()
+
 	svr := s.prepare(addr)
-	errChan := make(chan error)
+	admin := s.prepareAdmin()
+	defer func() {
+		s.stopped(err)
+	}()
+	if err := s.runAdmin(admin); err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
 	go func() {
-		s.logger.Info("start server")
-		if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := s.serve(svr); err != nil && err != http.ErrServerClosed {
 			errChan <- err
 		} else {
 			errChan <- nil
 		}
 	}()
 
-	quit := make(chan os.Signal)
-	// kill: SIGTERM
-	// kill -2: SIGINT
-	// kill -9: SIGKILL (cannot be caught)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	select {
-	case <-quit:
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
-		if err := svr.Shutdown(ctx); err != nil {
+		atomic.StoreInt32(&s.draining, 1)
+		s.shuttingDown(shutdownCtx)
+		if admin != nil {
+			defer admin.Shutdown(shutdownCtx)
+		}
+		if err := svr.Shutdown(shutdownCtx); err != nil {
 			return err
 		}
 
 		select {
-		case <-ctx.Done():
+		case <-shutdownCtx.Done():
 			s.logger.Info("shutdown server")
-			return ctx.Err()
+			return shutdownCtx.Err()
 		case err := <-errChan:
 			s.logger.Info("shutdown server")
 			return err
 		}
 	case err := <-errChan:
+		if admin != nil {
+			_ = admin.Close()
+		}
 		s.logger.Info("shutdown server")
 		return err
 	}
 }
 
+// serve listens on the address of the http.Server and all extra listeners, and serves requests.
+func (s *Server) serve(svr *http.Server) error {
+	if s.tls != nil {
+		// load the certificates before listening, so that errors are reported early
+		s.tls.logger = s.logger
+		if err := s.tls.reload(); err != nil {
+			return err
+		}
+	}
+	listeners, err := s.listen(svr.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serveListeners(svr, listeners)
+}
+
+// serveListeners serves requests from the listeners, wrapping them with the connection limit and TLS if used.
+// It returns when any listener stops, after stopping the others.
+// The Server is marked as listening once the listeners are created, which is required by readiness.
+func (s *Server) serveListeners(svr *http.Server, listeners []net.Listener) error {
+	var http3Conn net.PacketConn
+	if s.http3 != nil {
+		// HTTP/3 is bound before the Server is ready, so that a failure does not stop it after an upgrade
+		addr, altSvc, err := s.http3Addr(listeners)
+		if err == nil {
+			http3Conn, err = s.listenHTTP3(addr)
+		}
+		if err != nil {
+			for _, ln := range listeners {
+				_ = ln.Close()
+			}
+			return err
+		}
+		defer http3Conn.Close()
+		s.altSvc.Store(altSvc)
+		defer s.altSvc.Store("")
+	}
+	var slots chan struct{}
+	if s.options.MaxConnections > 0 {
+		slots = make(chan struct{}, s.options.MaxConnections)
+	}
+	n := len(listeners)
+	errChan := make(chan error, n+1)
+	s.setListeners(listeners, http3Conn)
+	defer s.setListeners(nil, nil)
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+	for _, ln := range listeners {
+		s.logger.WithField("addr", ln.Addr().String()).Info("start server")
+		if slots != nil {
+			ln = newLimitListener(ln, slots)
+		}
+		if s.tls != nil {
+			ln = tls.NewListener(ln, s.tls.serverConfig())
+		}
+		go func(ln net.Listener) {
+			errChan <- svr.Serve(ln)
+		}(ln)
+	}
+	if s.http3 != nil {
+		n++
+		go func() {
+			errChan <- s.serveHTTP3(http3Conn)
+		}()
+	}
+	s.started()
+	notifyReady()
+
+	err := <-errChan
+	if err != http.ErrServerClosed {
+		// a listener failed, so the others are stopped
+		_ = svr.Close()
+	}
+	if s.http3 != nil {
+		// HTTP/3 stops with the main listeners
+		_ = s.http3.Server.Close()
+	}
+	for i := 1; i < n; i++ {
+		<-errChan
+	}
+	return err
+}
+
 // matchService finds Service that is the closest to the given one.
 // For example, if the given Service is "foo.bar" and there are Service like:
 //
@@ -152,55 +388,89 @@ func (s *Server) matchService(name string) (string, Handler) {
 
 // ServeHTTP serves HTTP requests.
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// catch all panics here so that the panics from handlers will not make the server crash
-	defer func() {
-		if r := recover(); r != nil {
-			var log logger.Logger
-			if err, ok := r.(error); ok {
-				log = s.logger.WithError(err)
-			} else {
-				log = s.logger.WithField("err", r)
-			}
-			log.Error("server panic")
-		}
-	}()
+	atomic.AddInt64(&s.inFlight, 1)
+	defer atomic.AddInt64(&s.inFlight, -1)
+	s.setAltSvc(w, req)
 
 	ctx := createContext(w, req, s)
+	defer s.finish(ctx)
+	// catch all panics here so that the panics from handlers will not make the server crash
+	defer s.recoverPanic(ctx)
+
 	path := req.URL.EscapedPath()
 	method := req.Method
 
-	config := s.endpointConfig[path]
+	config, exact := s.endpointConfig.get(path)
+	if !exact && s.grpc && method == http.MethodPost && IsGRPC(req) {
+		// gRPC calls are routed before prefix endpoints, such as "/*" of single-page applications
+		if name, ok := grpcServiceName(path); ok {
+			if matchedName, handler := s.matchGRPCService(name); handler != nil {
+				ctx.serviceName = matchedName
+				ctx.configuredServiceName = name
+				s.response(ctx, handler)
+				return
+			}
+		}
+	}
 	if config == nil {
 		s.generalResponse(ctx, http.StatusNotFound)
 		return
 	}
 
 	service, ok := (*config)[method]
+	if !ok && isPreflight(req) {
+		// route CORS preflight requests to the Service of the requested method,
+		// so that the middlewares (such as CORS) of the Service can handle them
+		if service, ok = (*config)[req.Header.Get("Access-Control-Request-Method")]; ok {
+			ctx.serviceName = service.name
+			ctx.configuredServiceName = service.requestedName
+			s.response(ctx, preflightHandler)
+			return
+		}
+	}
 	if !ok {
 		s.generalResponse(ctx, http.StatusNotFound)
 		return
 	}
 
 	ctx.serviceName = service.name
+	ctx.configuredServiceName = service.requestedName
 	s.response(ctx, service.handler)
 	return
 }
 
 // response generates HTTP response using the handler.
 // ServeHTTP must return after calling this method.
+// The response is not written if any handler panics, so that recoverPanic can write an error response instead.
 func (s *Server) response(context *Context, handler Handler) {
-	defer context.write()
-
 	context.handlerSeq = append(context.handlerSeq, handler)
 	context.run()
+	context.write()
 }
 
-// generalResponse generates error messages depending on the status code.
+// generalResponse generates error messages depending on the status code,
+// using the error handler of the status code if there is one.
 // ServeHTTP must return after calling this method.
 func (s *Server) generalResponse(context *Context, statusCode int) {
 	defer context.write()
 
 	context.StatusCode = statusCode
+	if handler, ok := s.errorConfig[statusCode]; ok {
+		handler(context)
+	}
+}
+
+// isPreflight checks if the request is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// preflightHandler is the main handler of CORS preflight requests to endpoints without an OPTIONS Service.
+// It responds with no content, and the middlewares are responsible for CORS headers.
+func preflightHandler(context *Context) {
+	context.StatusCode = http.StatusNoContent
 }
 
 // Handler is a function that handles the Service.
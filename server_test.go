@@ -29,3 +29,25 @@ func TestServer_MatchService(t *testing.T) {
 	name, _ = svr.matchService("bar")
 	assert.Equal(t, "*", name)
 }
+
+func TestEndpointConfig_Get(t *testing.T) {
+	root, api, foo, echo := &routerConfig{}, &routerConfig{}, &routerConfig{}, &routerConfig{}
+	e := endpointConfig{"/*": root, "/api/*": api, "/api/foo/*": foo, "/api/echo": echo}
+	get := func(path string, expected *routerConfig, expectedExact bool) {
+		config, exact := e.get(path)
+		assert.Same(t, expected, config, path)
+		assert.Equal(t, expectedExact, exact, path)
+	}
+	get("/api/echo", echo, true)
+	get("/api/echo/1", api, false)
+	get("/api", api, false)
+	get("/api/", api, false)
+	get("/api/foo/bar", foo, false)
+	get("/", root, false)
+	get("/other", root, false)
+	get("*", nil, false)
+
+	e = endpointConfig{"/api/echo": echo}
+	get("/api/echo/1", nil, false)
+	get("/", nil, false)
+}
@@ -19,4 +19,33 @@ func (s *Server) Register(name string, handler Handler) {
 		panic("invalid service")
 	}
 	s.service[name] = handler
-}
\ No newline at end of file
+}
+
+// ServiceHierarchy returns the Service name followed by its parent Service names from the most specific one,
+// and the asterisk (*) at last. For example: ServiceHierarchy("foo.bar") gives ["foo.bar", "foo", "*"].
+//
+// It can be used to look up per-Service configurations in the same way that Service handlers are matched.
+// See LookupService.
+func ServiceHierarchy(name string) []string {
+	var names []string
+	for thisName := name; thisName != "" && thisName != baseServiceHandler; thisName = removeLastSubService(thisName) {
+		names = append(names, thisName)
+	}
+	return append(names, baseServiceHandler)
+}
+
+// LookupService looks up the value of the Service name in a map keyed by Service names, in the same way that
+// Service handlers are matched: "api.foo.bar" uses the value of "api.foo" if there is none of "api.foo.bar",
+// and "*" is the default. It returns the name which the value is found by, and false if there is none.
+//
+// Middlewares use it to look up per-Service configurations (such as rate limits) by the Service name configured
+// for the endpoint (see Context.GetConfiguredServiceName).
+func LookupService[T any](values map[string]T, name string) (string, T, bool) {
+	for _, thisName := range ServiceHierarchy(name) {
+		if value, ok := values[thisName]; ok {
+			return thisName, value, true
+		}
+	}
+	var zero T
+	return "", zero, false
+}
@@ -4,6 +4,8 @@ package gateway
 type serviceInfo struct {
 	// name is the name of a Service.
 	name string
+	// requestedName is the name of the Service configured for the endpoint, before matching.
+	requestedName string
 	// handler is the Handler of a Service.
 	handler Handler
 }
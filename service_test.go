@@ -0,0 +1,34 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceHierarchy(t *testing.T) {
+	assert.Equal(t, []string{"foo.bar.baz", "foo.bar", "foo", "*"}, ServiceHierarchy("foo.bar.baz"))
+	assert.Equal(t, []string{"foo", "*"}, ServiceHierarchy("foo"))
+	assert.Equal(t, []string{"*"}, ServiceHierarchy("*"))
+	assert.Equal(t, []string{"*"}, ServiceHierarchy(""))
+}
+
+func TestLookupService(t *testing.T) {
+	values := map[string]int{"foo": 1, "foo.bar.baz": 2, "*": 3}
+	name, value, ok := LookupService(values, "foo.bar")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", name)
+	assert.Equal(t, 1, value)
+	name, value, _ = LookupService(values, "foo.bar.baz")
+	assert.Equal(t, "foo.bar.baz", name)
+	assert.Equal(t, 2, value)
+	name, value, _ = LookupService(values, "other")
+	assert.Equal(t, "*", name)
+	assert.Equal(t, 3, value)
+
+	delete(values, "*")
+	name, value, ok = LookupService(values, "other")
+	assert.False(t, ok)
+	assert.Empty(t, name)
+	assert.Zero(t, value)
+}
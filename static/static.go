@@ -0,0 +1,492 @@
+// Package static provides a handler serving static files from a directory or an fs.FS,
+// such as frontends hosted next to the APIs.
+package static
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-gateway/etag"
+)
+
+// defaultIndex is the default index file of directories.
+const defaultIndex = "index.html"
+
+// encodings is the precompressed variants of files, in the order of preference.
+var encodings = []struct {
+	name   string
+	suffix string
+}{
+	{name: "br", suffix: ".br"},
+	{name: "gzip", suffix: ".gz"},
+}
+
+// Config is the configuration of the static file handler.
+type Config struct {
+	// Root is the directory of the files. Either Root or FS is required.
+	Root string
+	// FS is the file system of the files, such as an embed.FS. Either Root or FS is required.
+	FS fs.FS
+	// Prefix is removed from the request path to get the path of the file, such as "/app" for the endpoint "/app/*".
+	Prefix string
+	// Index is the file served for directories. It is "index.html" if empty.
+	Index string
+	// SPA serves the index file of the root for paths which are not found and have no file extension,
+	// so that a single-page application can handle its routes. Missing files with an extension (such as
+	// "/app/main.js") are still not found.
+	SPA bool
+	// CacheControl is the Cache-Control header of files, such as "public, max-age=31536000, immutable" for assets
+	// with hashed names. Index files always have "no-cache", so that new versions are picked up. No header is set
+	// if it is empty.
+	CacheControl string
+	// Precompressed serves the precompressed variants of files (with the suffix ".br" or ".gz") if the client
+	// accepts their content coding.
+	Precompressed bool
+}
+
+// Handler provides a handler serving files for GET and HEAD requests. Register it for the endpoints of the files,
+// usually a prefix. For example:
+//
+//	cfg.Add("/app/*", http.MethodGet, "frontend")
+//	cfg.Add("/app/*", http.MethodHead, "frontend")
+//	s.Register("frontend", static.Handler(static.Config{Root: "./dist", Prefix: "/app", SPA: true}))
+//
+// Files are streamed with Context.ResponseStream, supporting single byte ranges, and conditional requests with
+// ETag and Last-Modified. Paths are cleaned so that they cannot be outside of the root, and hidden files
+// (with names starting with a dot) are not served. Directories are redirected to the path with a trailing slash,
+// and served with their index file. Directories are not listed.
+func Handler(config Config) gateway.Handler {
+	if (config.Root == "") == (config.FS == nil) {
+		panic("either Root or FS is required")
+	}
+	fsys := config.FS
+	if fsys == nil {
+		fsys = os.DirFS(config.Root)
+	}
+	if config.Index == "" {
+		config.Index = defaultIndex
+	}
+	config.Prefix = strings.TrimSuffix(config.Prefix, "/")
+	h := &handler{config: config, fs: fsys}
+	return h.serve
+}
+
+// handler serves files.
+type handler struct {
+	config Config
+	fs     fs.FS
+	// etags is the ETags of files without modification times, such as the ones of an embed.FS,
+	// which are hashes of their content.
+	etags sync.Map
+}
+
+// file is a file to be served.
+type file struct {
+	f        fs.File
+	info     fs.FileInfo
+	name     string
+	encoding string
+	index    bool
+}
+
+// serve serves a request.
+func (h *handler) serve(context *gateway.Context) {
+	req := context.Request
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		context.Header.Set("Allow", "GET, HEAD")
+		context.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := h.name(req.URL.Path)
+	if !ok {
+		context.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	f, err := h.open(name, req)
+	if os.IsNotExist(err) && h.config.SPA && path.Ext(name) == "" {
+		f, err = h.open(h.config.Index, req)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			context.AbortWithStatus(http.StatusNotFound)
+		} else if _, redirect := err.(redirectError); redirect {
+			location := req.URL.Path + "/"
+			if req.URL.RawQuery != "" {
+				location += "?" + req.URL.RawQuery
+			}
+			context.Header.Set("Location", location)
+			context.StatusCode = http.StatusMovedPermanently
+		} else {
+			context.Logger.WithError(err).WithField("file", name).Error("failed to open file")
+			context.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	h.write(context, f)
+}
+
+// name gets the name of the file in the file system from the request path.
+// It returns false if the path is not under the prefix, or it is a hidden file.
+func (h *handler) name(urlPath string) (string, bool) {
+	if h.config.Prefix != "" {
+		if urlPath != h.config.Prefix && !strings.HasPrefix(urlPath, h.config.Prefix+"/") {
+			return "", false
+		}
+		urlPath = urlPath[len(h.config.Prefix):]
+	}
+	if strings.ContainsAny(urlPath, "\\\x00") {
+		return "", false
+	}
+	// cleaning a rooted path removes all ".." elements
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	for _, element := range strings.Split(name, "/") {
+		if strings.HasPrefix(element, ".") && element != "." {
+			return "", false
+		}
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	if strings.HasSuffix(urlPath, "/") {
+		// keep the trailing slash of directories
+		name += "/"
+	}
+	return name, true
+}
+
+// redirectError is returned by open for directories requested without a trailing slash.
+type redirectError struct{}
+
+// Error gets the error message.
+func (redirectError) Error() string {
+	return "directory without trailing slash"
+}
+
+// open opens the file of the name, or the index file of a directory, choosing a precompressed variant
+// if accepted by the client.
+func (h *handler) open(name string, req *http.Request) (*file, error) {
+	dir := strings.HasSuffix(name, "/")
+	name = strings.TrimSuffix(name, "/")
+	info, err := fs.Stat(h.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	index := false
+	if info.IsDir() {
+		if !dir {
+			return nil, redirectError{}
+		}
+		name = path.Join(name, h.config.Index)
+		if info, err = fs.Stat(h.fs, name); err != nil {
+			return nil, err
+		}
+		index = true
+	} else if dir {
+		return nil, os.ErrNotExist
+	}
+	if info.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	if name == h.config.Index {
+		index = true
+	}
+
+	if h.config.Precompressed {
+		accepted := acceptedEncodings(req.Header.Get("Accept-Encoding"))
+		for _, encoding := range encodings {
+			if !accepted[encoding.name] {
+				continue
+			}
+			f, err := h.fs.Open(name + encoding.suffix)
+			if err != nil {
+				continue
+			}
+			if compressedInfo, err := f.Stat(); err == nil && !compressedInfo.IsDir() {
+				return &file{f: f, info: compressedInfo, name: name, encoding: encoding.name, index: index}, nil
+			}
+			_ = f.Close()
+		}
+	}
+	f, err := h.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err = f.Stat(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &file{f: f, info: info, name: name, index: index}, nil
+}
+
+// write writes the file as the response, handling conditional and range requests.
+func (h *handler) write(context *gateway.Context, f *file) {
+	header := context.Header
+	closeFile := true
+	defer func() {
+		if closeFile {
+			_ = f.f.Close()
+		}
+	}()
+
+	tag, err := h.etag(f)
+	if err != nil {
+		context.Logger.WithError(err).WithField("file", f.name).Error("failed to read file")
+		context.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	modTime := f.info.ModTime()
+	if isZeroTime(modTime) {
+		modTime = time.Time{}
+	}
+	header.Set("ETag", tag)
+	if !modTime.IsZero() {
+		header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if f.index {
+		header.Set("Cache-Control", "no-cache")
+	} else if h.config.CacheControl != "" {
+		header.Set("Cache-Control", h.config.CacheControl)
+	}
+	if h.config.Precompressed {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	req := context.Request
+	switch etag.Evaluate(req, true, tag, modTime) {
+	case http.StatusNotModified:
+		header.Del("Content-Type")
+		context.StatusCode = http.StatusNotModified
+		return
+	case http.StatusPreconditionFailed:
+		context.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+
+	contentType, err := h.contentType(f)
+	if err != nil {
+		context.Logger.WithError(err).WithField("file", f.name).Error("failed to read file")
+		context.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	header.Set("Content-Type", contentType)
+	if f.encoding != "" {
+		header.Set("Content-Encoding", f.encoding)
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	size := f.info.Size()
+	start, length := int64(0), size
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" && ifRange(req, tag, modTime) {
+		var ok bool
+		start, length, ok = parseRange(rangeHeader, size)
+		if !ok {
+			header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			context.AbortWithStatus(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if length != size {
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+			context.StatusCode = http.StatusPartialContent
+		}
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	if req.Method == http.MethodHead || length == 0 {
+		return
+	}
+
+	if start > 0 {
+		if err := skip(f.f, start); err != nil {
+			context.Logger.WithError(err).WithField("file", f.name).Error("failed to read file")
+			context.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	closeFile = false
+	context.ResponseStream = &fileStream{Reader: io.LimitReader(f.f, length), Closer: f.f}
+}
+
+// fileStream is a part of a file, which closes the file.
+type fileStream struct {
+	io.Reader
+	io.Closer
+}
+
+// etag gets the ETag of a file from its modification time and size, or a hash of its content if it has no
+// modification time. Precompressed variants have the name of the content coding as a suffix.
+func (h *handler) etag(f *file) (string, error) {
+	var tag string
+	if modTime := f.info.ModTime(); !isZeroTime(modTime) {
+		tag = strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(f.info.Size(), 36)
+	} else {
+		key := f.name + "\x00" + f.encoding
+		if cached, ok := h.etags.Load(key); ok {
+			tag = cached.(string)
+		} else {
+			// read a separate handle, since the file is served from the start
+			content, err := h.fs.Open(f.name + suffixOf(f.encoding))
+			if err != nil {
+				return "", err
+			}
+			hash := sha256.New()
+			_, err = io.Copy(hash, content)
+			_ = content.Close()
+			if err != nil {
+				return "", err
+			}
+			tag = hex.EncodeToString(hash.Sum(nil)[:16])
+			h.etags.Store(key, tag)
+		}
+	}
+	if f.encoding != "" {
+		tag += "-" + f.encoding
+	}
+	return `"` + tag + `"`, nil
+}
+
+// contentType gets the content type by the file extension, or detects it from the content.
+func (h *handler) contentType(f *file) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(f.name)); contentType != "" {
+		return contentType, nil
+	}
+	if f.encoding != "" {
+		// the content is compressed
+		return "application/octet-stream", nil
+	}
+	content, err := h.fs.Open(f.name)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// suffixOf gets the file name suffix of a content coding.
+func suffixOf(encoding string) string {
+	for _, e := range encodings {
+		if e.name == encoding {
+			return e.suffix
+		}
+	}
+	return ""
+}
+
+// isZeroTime checks if the modification time is unknown.
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+// skip skips the start of a file, seeking if possible.
+func skip(f fs.File, n int64) error {
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, f, n)
+	return err
+}
+
+// acceptedEncodings gets the content codings accepted by Accept-Encoding, except the ones with q=0.
+func acceptedEncodings(acceptEncoding string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q > 0
+	}
+	return accepted
+}
+
+// ifRange checks if the range should be served by If-Range, which is an ETag (compared strongly) or a date.
+func ifRange(req *http.Request, tag string, modTime time.Time) bool {
+	value := strings.TrimSpace(req.Header.Get("If-Range"))
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return etag.Match(value, tag, false)
+	}
+	t, err := http.ParseTime(value)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(t)
+}
+
+// parseRange parses a Range header of a single byte range, returning the start and the length.
+// Multiple ranges are served as the full content. It returns false if the range is not satisfiable.
+func parseRange(rangeHeader string, size int64) (int64, int64, bool) {
+	if !strings.HasPrefix(rangeHeader, "bytes=") {
+		return 0, size, true
+	}
+	spec := strings.TrimSpace(rangeHeader[len("bytes="):])
+	if strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	idx := strings.Index(spec, "-")
+	if idx == -1 {
+		return 0, size, true
+	}
+	first, last := strings.TrimSpace(spec[:idx]), strings.TrimSpace(spec[idx+1:])
+	if first == "" {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, true
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, size, true
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
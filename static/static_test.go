@@ -0,0 +1,304 @@
+package static
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer serves the handler for "/app/*".
+func testServer(handler gateway.Handler) http.Handler {
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost} {
+		cfg.Add("/app/*", method, "frontend")
+	}
+	s.UseConfig(cfg)
+	s.Register("frontend", handler)
+	return s.Handler()
+}
+
+func request(handler http.Handler, method string, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// testDir creates a directory of a frontend.
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "static")
+	assert.NoError(t, err)
+	files := map[string]string{
+		"index.html":      "<html>index</html>",
+		"main.js":         "console.log('hello, world')",
+		"main.js.gz":      "gzipped",
+		"main.js.br":      "brotli",
+		"docs/index.html": "<html>docs</html>",
+		"docs/readme":     "plain text",
+		".env":            "SECRET=1",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	}
+	// a secret outside of the root
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "..", filepath.Base(dir)+".secret"), []byte("secret"), 0644))
+	return dir
+}
+
+func removeDir(dir string) {
+	_ = os.RemoveAll(dir)
+	_ = os.Remove(filepath.Join(dir, "..", filepath.Base(dir)+".secret"))
+}
+
+func TestHandler(t *testing.T) {
+	dir := testDir(t)
+	defer removeDir(dir)
+	handler := testServer(Handler(Config{Root: dir, Prefix: "/app/", CacheControl: "public, max-age=60"}))
+
+	w := request(handler, http.MethodGet, "/app/main.js", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "console.log('hello, world')", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+	assert.Equal(t, "27", w.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	// directories are served with their index files
+	w = request(handler, http.MethodGet, "/app/", nil)
+	assert.Equal(t, "<html>index</html>", w.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	w = request(handler, http.MethodGet, "/app/docs/", nil)
+	assert.Equal(t, "<html>docs</html>", w.Body.String())
+	w = request(handler, http.MethodGet, "/app/docs?x=1", nil)
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/app/docs/?x=1", w.Header().Get("Location"))
+	w = request(handler, http.MethodGet, "/app", nil)
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/app/", w.Header().Get("Location"))
+
+	// the content type is detected without a known extension
+	w = request(handler, http.MethodGet, "/app/docs/readme", nil)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w = request(handler, http.MethodHead, "/app/main.js", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "27", w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+
+	w = request(handler, http.MethodPost, "/app/main.js", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+
+	// no SPA fallback
+	assert.Equal(t, http.StatusNotFound, request(handler, http.MethodGet, "/app/users/1", nil).Code)
+	assert.Equal(t, http.StatusNotFound, request(handler, http.MethodGet, "/app/main.js/", nil).Code)
+}
+
+func TestHandler_Traversal(t *testing.T) {
+	dir := testDir(t)
+	defer removeDir(dir)
+	handler := Handler(Config{Root: dir, Prefix: "/app"})
+	secret := "/" + filepath.Base(dir) + ".secret"
+
+	for _, target := range []string{
+		"/app/.." + secret,
+		"/app/../.." + secret,
+		"/app/%2e%2e" + secret,
+		"/app/docs/../../" + secret,
+		"/app/..%5c" + secret[1:],
+		"/app/.env",
+		"/app/docs/%2e%2e/.env",
+	} {
+		// the handler is called directly, since the Server only routes clean paths
+		context := &gateway.Context{
+			Request:    httptest.NewRequest(http.MethodGet, "/app/", nil),
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+		}
+		context.Request.URL.Path = mustUnescape(t, target)
+		handler(context)
+		assert.NotEqual(t, http.StatusOK, context.StatusCode, target)
+		assert.Nil(t, context.ResponseStream, target)
+	}
+
+	// paths not under the prefix
+	context := &gateway.Context{Request: httptest.NewRequest(http.MethodGet, "/application/main.js", nil),
+		StatusCode: http.StatusOK, Header: http.Header{}}
+	handler(context)
+	assert.Equal(t, http.StatusNotFound, context.StatusCode)
+}
+
+func mustUnescape(t *testing.T, target string) string {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost"+target, nil)
+	assert.NoError(t, err)
+	return req.URL.Path
+}
+
+func TestHandler_SPA(t *testing.T) {
+	dir := testDir(t)
+	defer removeDir(dir)
+	handler := testServer(Handler(Config{Root: dir, Prefix: "/app", SPA: true}))
+
+	for _, target := range []string{"/app/users/1", "/app/users/1/", "/app/settings"} {
+		w := request(handler, http.MethodGet, target, nil)
+		assert.Equal(t, http.StatusOK, w.Code, target)
+		assert.Equal(t, "<html>index</html>", w.Body.String(), target)
+		assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	}
+	// missing assets are not found
+	assert.Equal(t, http.StatusNotFound, request(handler, http.MethodGet, "/app/missing.js", nil).Code)
+}
+
+func TestHandler_Range(t *testing.T) {
+	dir := testDir(t)
+	defer removeDir(dir)
+	handler := testServer(Handler(Config{Root: dir, Prefix: "/app"}))
+
+	for _, c := range []struct {
+		rangeHeader string
+		status      int
+		body        string
+	}{
+		{"bytes=0-6", http.StatusPartialContent, "console"},
+		{"bytes=8-", http.StatusPartialContent, "log('hello, world')"},
+		{"bytes=-7", http.StatusPartialContent, "world')"},
+		{"bytes=20-100", http.StatusPartialContent, "world')"},
+		// the whole content
+		{"bytes=-100", http.StatusOK, "console.log('hello, world')"},
+		// multiple ranges and invalid ones are ignored
+		{"bytes=0-1,3-4", http.StatusOK, "console.log('hello, world')"},
+		{"items=0-1", http.StatusOK, "console.log('hello, world')"},
+		{"bytes=invalid-1", http.StatusOK, "console.log('hello, world')"},
+	} {
+		w := request(handler, http.MethodGet, "/app/main.js", map[string]string{"Range": c.rangeHeader})
+		assert.Equal(t, c.status, w.Code, c.rangeHeader)
+		assert.Equal(t, c.body, w.Body.String(), c.rangeHeader)
+		assert.Equal(t, strconv.Itoa(len(c.body)), w.Header().Get("Content-Length"), c.rangeHeader)
+	}
+	w := request(handler, http.MethodGet, "/app/main.js", map[string]string{"Range": "bytes=8-11"})
+	assert.Equal(t, "bytes 8-11/27", w.Header().Get("Content-Range"))
+
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"Range": "bytes=27-"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	assert.Equal(t, "bytes */27", w.Header().Get("Content-Range"))
+
+	// If-Range
+	etag := request(handler, http.MethodGet, "/app/main.js", nil).Header().Get("ETag")
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"Range": "bytes=0-6", "If-Range": etag})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"Range": "bytes=0-6", "If-Range": `"old"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "console.log('hello, world')", w.Body.String())
+}
+
+func TestHandler_Conditional(t *testing.T) {
+	dir := testDir(t)
+	defer removeDir(dir)
+	handler := testServer(Handler(Config{Root: dir, Prefix: "/app"}))
+
+	w := request(handler, http.MethodGet, "/app/main.js", nil)
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-None-Match": "W/" + etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-None-Match": `"other"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-Modified-Since": lastModified})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-Modified-Since": past})
+	assert.Equal(t, http.StatusOK, w.Code)
+	// If-None-Match takes precedence
+	w = request(handler, http.MethodGet, "/app/main.js",
+		map[string]string{"If-Modified-Since": lastModified, "If-None-Match": `"other"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// preconditions
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-Match": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"If-Match": `"other"`})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestHandler_Precompressed(t *testing.T) {
+	dir := testDir(t)
+	defer removeDir(dir)
+	handler := testServer(Handler(Config{Root: dir, Prefix: "/app", Precompressed: true}))
+
+	w := request(handler, http.MethodGet, "/app/main.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	assert.Equal(t, "brotli", w.Body.String())
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	brETag := w.Header().Get("ETag")
+	assert.Contains(t, brETag, `-br"`)
+
+	w = request(handler, http.MethodGet, "/app/main.js", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	assert.Equal(t, "gzipped", w.Body.String())
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	w = request(handler, http.MethodGet, "/app/main.js", nil)
+	assert.Equal(t, "console.log('hello, world')", w.Body.String())
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.NotEqual(t, brETag, w.Header().Get("ETag"))
+
+	// files without variants
+	w = request(handler, http.MethodGet, "/app/", map[string]string{"Accept-Encoding": "gzip, br"})
+	assert.Equal(t, "<html>index</html>", w.Body.String())
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestHandler_FS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":     {Data: []byte("<html>embedded</html>")},
+		"assets/app.css": {Data: []byte("body {}")},
+	}
+	handler := testServer(Handler(Config{FS: fsys, Prefix: "/app"}))
+
+	w := request(handler, http.MethodGet, "/app/assets/app.css", nil)
+	assert.Equal(t, "body {}", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/css")
+	// files without modification times have ETags from their content
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	w = request(handler, http.MethodGet, "/app/assets/app.css", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = request(handler, http.MethodGet, "/app/", nil)
+	assert.Equal(t, "<html>embedded</html>", w.Body.String())
+
+	assert.Panics(t, func() {
+		Handler(Config{})
+	})
+	assert.Panics(t, func() {
+		Handler(Config{Root: ".", FS: fsys})
+	})
+}
@@ -0,0 +1,245 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/LYZhelloworld/go-logger"
+)
+
+// defaultTLSReloadInterval is the default interval of checking certificate files for changes.
+const defaultTLSReloadInterval = 10 * time.Second
+
+// CertificateFile is a certificate and its private key in PEM files.
+type CertificateFile struct {
+	// CertFile is the certificate, followed by the intermediate certificates if any.
+	CertFile string
+	// KeyFile is the private key.
+	KeyFile string
+}
+
+// TLSConfig is the configuration of serving TLS.
+type TLSConfig struct {
+	// Certificates is a list of certificates. The certificate is chosen by the server name (SNI) of the client,
+	// and the first one is used if none matches. At least one certificate is required.
+	Certificates []CertificateFile
+	// MinVersion is the minimum version of TLS, such as tls.VersionTLS13. It is TLS 1.2 if zero.
+	MinVersion uint16
+	// CipherSuites is a list of enabled cipher suites for TLS 1.2 and below. The defaults of crypto/tls are used
+	// if it is empty. Cipher suites of TLS 1.3 are not configurable.
+	CipherSuites []uint16
+	// ClientCAFile is a bundle of CA certificates in PEM, which verify client certificates (mutual TLS).
+	// Client certificates are not requested if it is empty.
+	ClientCAFile string
+	// ClientAuth is the policy of client certificates if ClientCAFile is set.
+	// It is tls.RequireAndVerifyClientCert if zero. Use tls.VerifyClientCertIfGiven to make them optional.
+	ClientAuth tls.ClientAuthType
+	// ReloadInterval is the interval of checking the files for changes, which are reloaded without restarting.
+	// It is 10 seconds if zero, and the files are only reloaded by Server.ReloadTLS if negative.
+	// The current certificates are kept if reloading fails.
+	ReloadInterval time.Duration
+}
+
+// UseTLS serves TLS on the main listener with the configuration. The files are loaded when the Server starts.
+func (s *Server) UseTLS(config TLSConfig) {
+	if len(config.Certificates) == 0 {
+		panic("no TLS certificates")
+	}
+	for _, cert := range config.Certificates {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			panic("no TLS certificate or key file")
+		}
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.ClientAuth == tls.NoClientCert && config.ClientCAFile != "" {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = defaultTLSReloadInterval
+	}
+	s.tls = &tlsStore{config: config, logger: s.logger}
+}
+
+// ReloadTLS reloads the certificates and the client CAs from the files. The current ones are kept if it fails.
+func (s *Server) ReloadTLS() error {
+	if s.tls == nil {
+		return errors.New("TLS is not used")
+	}
+	return s.tls.reload()
+}
+
+// ClientCertificate gets the client certificate of the request, verified by the TLS listener of the Server.
+// It returns nil if the request is not over TLS, or does not have a verified client certificate.
+func (c *Context) ClientCertificate() *x509.Certificate {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// ClientIdentity gets the identity of the verified client certificate of the request. See CertificateIdentity.
+// It returns an empty string if there is no verified client certificate.
+func (c *Context) ClientIdentity() string {
+	if cert := c.ClientCertificate(); cert != nil {
+		return CertificateIdentity(cert)
+	}
+	return ""
+}
+
+// CertificateIdentity gets the identity of a certificate: the first URI SAN (such as a SPIFFE ID) if any,
+// or the common name of the subject.
+func CertificateIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.CommonName
+}
+
+// tlsStore keeps the TLS configuration loaded from files, reloading it when the files change.
+type tlsStore struct {
+	config TLSConfig
+	logger logger.Logger
+
+	mu       sync.Mutex
+	current  *tls.Config
+	http3    *tls.Config
+	modTimes map[string]time.Time
+	checked  time.Time
+}
+
+// serverConfig gets the tls.Config of the listener, which gets the current configuration for every connection.
+func (t *tlsStore) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: t.config.MinVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.get(), nil
+		},
+	}
+}
+
+// http3Config gets the tls.Config of HTTP/3, which is the same as serverConfig with TLS 1.3 and the "h3" protocol.
+func (t *tlsStore) http3Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		NextProtos: []string{"h3"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.check()
+			return t.http3, nil
+		},
+	}
+}
+
+// get gets the current configuration, reloading it if the files have changed since the last check.
+func (t *tlsStore) get() *tls.Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.check()
+	return t.current
+}
+
+// check reloads the files if they have changed since the last check. t.mu must be held.
+func (t *tlsStore) check() {
+	now := time.Now()
+	if t.config.ReloadInterval > 0 && now.Sub(t.checked) >= t.config.ReloadInterval {
+		t.checked = now
+		if t.changed() {
+			if err := t.load(); err != nil {
+				t.logger.WithError(err).Error("failed to reload TLS certificates")
+			} else {
+				t.logger.Info("TLS certificates reloaded")
+			}
+		}
+	}
+}
+
+// reload reloads the files.
+func (t *tlsStore) reload() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.checked = time.Now()
+	return t.load()
+}
+
+// files gets all files of the configuration.
+func (t *tlsStore) files() []string {
+	var files []string
+	for _, cert := range t.config.Certificates {
+		files = append(files, cert.CertFile, cert.KeyFile)
+	}
+	if t.config.ClientCAFile != "" {
+		files = append(files, t.config.ClientCAFile)
+	}
+	return files
+}
+
+// changed checks if any file has been modified since it was loaded.
+func (t *tlsStore) changed() bool {
+	for _, file := range t.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// the file may be being replaced
+			continue
+		}
+		if !info.ModTime().Equal(t.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// load loads the files. The current configuration is kept if it fails.
+func (t *tlsStore) load() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range t.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	config := &tls.Config{
+		MinVersion:   t.config.MinVersion,
+		CipherSuites: t.config.CipherSuites,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	for _, file := range t.config.Certificates {
+		cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate %s: %v", file.CertFile, err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if t.config.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(t.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", t.config.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = t.config.ClientAuth
+	}
+
+	http3 := config.Clone()
+	http3.MinVersion = tls.VersionTLS13
+	http3.NextProtos = []string{"h3"}
+
+	t.current = config
+	t.http3 = http3
+	t.modTimes = modTimes
+	return nil
+}
@@ -0,0 +1,266 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA is a CA issuing certificates for tests.
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	ca := &testCA{t: t, dir: dir, cert: cert, key: key, pool: pool}
+	ca.write("ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) write(name string, blockType string, der []byte) string {
+	filename := filepath.Join(ca.dir, name)
+	assert.NoError(ca.t, ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return filename
+}
+
+// issue issues a certificate, returning it and the files of the certificate and the key.
+func (ca *testCA) issue(name string, template *x509.Certificate) (tls.Certificate, CertificateFile) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(ca.t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(ca.t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(ca.t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(ca.t, err)
+	file := CertificateFile{
+		CertFile: ca.write(name+".pem", "CERTIFICATE", der),
+		KeyFile:  ca.write(name+"-key.pem", "EC PRIVATE KEY", keyDER),
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, file
+}
+
+func (ca *testCA) serverCert(name string, host string) CertificateFile {
+	_, file := ca.issue(name, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: host},
+		DNSNames:    []string{host},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return file
+}
+
+func (ca *testCA) close() {
+	_ = os.RemoveAll(ca.dir)
+}
+
+// serveTLS serves the Server on a local port, returning the address and a function stopping it.
+func serveTLS(t *testing.T, s *Server) (string, func()) {
+	svr := s.prepare("")
+	assert.NoError(t, s.tls.reload())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = s.serveListeners(svr, []net.Listener{ln})
+	}()
+	return ln.Addr().String(), func() {
+		_ = svr.Close()
+	}
+}
+
+func tlsClient(ca *testCA, serverName string, certs ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: &tls.Config{
+		RootCAs:      ca.pool,
+		ServerName:   serverName,
+		Certificates: certs,
+	}}}
+}
+
+func TestServer_TLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.close()
+
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.config.Add("/hello", http.MethodGet, "hello")
+	s.Register("hello", func(context *Context) {
+		context.Response = []byte(context.Request.TLS.ServerName)
+	})
+	s.UseTLS(TLSConfig{
+		Certificates: []CertificateFile{
+			ca.serverCert("a", "a.example.com"),
+			ca.serverCert("b", "b.example.com"),
+		},
+		MinVersion: tls.VersionTLS13,
+	})
+	addr, stop := serveTLS(t, s)
+	defer stop()
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		resp, err := tlsClient(ca, host).Get("https://" + addr + "/hello")
+		if assert.NoError(t, err) {
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, host, string(body))
+			assert.Equal(t, host, resp.TLS.PeerCertificates[0].Subject.CommonName)
+			assert.Equal(t, "HTTP/2.0", resp.Proto)
+		}
+	}
+
+	// the first certificate is the default
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool, ServerName: "a.example.com"})
+	if assert.NoError(t, err) {
+		_ = conn.Close()
+	}
+
+	// the minimum version is enforced
+	_, err = tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool, ServerName: "a.example.com",
+		MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.close()
+	spiffe, _ := url.Parse("spiffe://example.org/orders")
+	clientCert, _ := ca.issue("client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "orders"},
+		URIs:        []*url.URL{spiffe},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	other := newTestCA(t)
+	defer other.close()
+	untrusted, _ := other.issue("client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "untrusted"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	s := Default()
+	s.AttachLogger(logger.GetNopLogger())
+	s.config.Add("/hello", http.MethodGet, "hello")
+	s.Register("hello", func(context *Context) {
+		if cert := context.ClientCertificate(); cert != nil {
+			context.Response = []byte(cert.Subject.CommonName + " " + context.ClientIdentity())
+		} else {
+			context.Response = []byte("anonymous")
+		}
+	})
+	s.UseTLS(TLSConfig{
+		Certificates: []CertificateFile{ca.serverCert("server", "localhost")},
+		ClientCAFile: filepath.Join(ca.dir, "ca.pem"),
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	addr, stop := serveTLS(t, s)
+	defer stop()
+
+	get := func(client *http.Client) (string, error) {
+		resp, err := client.Get("https://" + addr + "/hello")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+	body, err := get(tlsClient(ca, "localhost", clientCert))
+	assert.NoError(t, err)
+	assert.Equal(t, "orders spiffe://example.org/orders", body)
+
+	body, err = get(tlsClient(ca, "localhost"))
+	assert.NoError(t, err)
+	assert.Equal(t, "anonymous", body)
+
+	_, err = get(tlsClient(ca, "localhost", untrusted))
+	assert.Error(t, err)
+}
+
+func TestTLSStore_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.close()
+	file := ca.serverCert("server", "old.example.com")
+	store := &tlsStore{
+		config: TLSConfig{Certificates: []CertificateFile{file}, ReloadInterval: time.Nanosecond},
+		logger: logger.GetNopLogger(),
+	}
+	assert.NoError(t, store.reload())
+	commonName := func() string {
+		cert, err := x509.ParseCertificate(store.get().Certificates[0].Certificate[0])
+		assert.NoError(t, err)
+		return cert.Subject.CommonName
+	}
+	assert.Equal(t, "old.example.com", commonName())
+
+	// the certificate is renewed on disk
+	renewed := ca.serverCert("server", "new.example.com")
+	assert.Equal(t, file, renewed)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(file.CertFile, future, future))
+	assert.NoError(t, os.Chtimes(file.KeyFile, future, future))
+	assert.Equal(t, "new.example.com", commonName())
+
+	// the current certificate is kept if the files are invalid
+	assert.NoError(t, ioutil.WriteFile(file.KeyFile, []byte("invalid"), 0600))
+	future = future.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(file.KeyFile, future, future))
+	assert.Equal(t, "new.example.com", commonName())
+	assert.Error(t, store.reload())
+	assert.Equal(t, "new.example.com", commonName())
+}
+
+func TestServer_UseTLS(t *testing.T) {
+	s := Default()
+	assert.Error(t, s.ReloadTLS())
+	assert.Panics(t, func() {
+		s.UseTLS(TLSConfig{})
+	})
+	assert.Panics(t, func() {
+		s.UseTLS(TLSConfig{Certificates: []CertificateFile{{CertFile: "cert.pem"}}})
+	})
+
+	s.UseTLS(TLSConfig{Certificates: []CertificateFile{{CertFile: "cert.pem", KeyFile: "key.pem"}}, ClientCAFile: "ca.pem"})
+	assert.Equal(t, uint16(tls.VersionTLS12), s.tls.config.MinVersion)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, s.tls.config.ClientAuth)
+	assert.Error(t, s.ReloadTLS())
+	assert.Error(t, s.Run("127.0.0.1:0"))
+}
@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	// ExportSpans exports a batch of spans.
+	ExportSpans(spans []SpanData) error
+	// Shutdown releases resources of the Exporter. ExportSpans is not called after shutting down.
+	Shutdown(ctx context.Context) error
+}
+
+// JSONExporter writes every span as a line of JSON.
+type JSONExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewJSONExporter creates a JSONExporter which writes to the given writer.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// NewJSONFileExporter creates a JSONExporter which appends to a file.
+// The file is closed when the Exporter shuts down.
+func NewJSONFileExporter(filename string) *JSONExporter {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		panic(err)
+	}
+	return &JSONExporter{w: f, closer: f}
+}
+
+// ExportSpans implements Exporter.
+func (e *JSONExporter) ExportSpans(spans []SpanData) error {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown implements Exporter.
+func (e *JSONExporter) Shutdown(ctx context.Context) error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP/HTTP in JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the URL of the traces endpoint of the collector. For example: "http://localhost:4318/v1/traces".
+	Endpoint string
+	// Header holds extra headers sent to the collector, such as authorization.
+	Header http.Header
+	// Client is the client used to send requests.
+	Client *http.Client
+}
+
+// NewOTLPExporter creates an OTLPExporter with the default http.Client.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{Endpoint: endpoint, Header: http.Header{}, Client: http.DefaultClient}
+}
+
+// ExportSpans implements Exporter.
+func (e *OTLPExporter) ExportSpans(spans []SpanData) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(toOTLP(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range e.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp export failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown implements Exporter.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// otlpTraces is the request body of the OTLP/HTTP traces endpoint.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// toOTLP converts spans to the OTLP request body, grouped by service name.
+func toOTLP(spans []SpanData) otlpTraces {
+	traces := otlpTraces{}
+	index := map[string]int{}
+	for _, span := range spans {
+		i, ok := index[span.ServiceName]
+		if !ok {
+			i = len(traces.ResourceSpans)
+			index[span.ServiceName] = i
+			traces.ResourceSpans = append(traces.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", span.ServiceName)}},
+				ScopeSpans: []otlpScopeSpans{{
+					Scope: otlpScope{Name: "github.com/LYZhelloworld/go-gateway/tracing"},
+				}},
+			})
+		}
+
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			TraceState:        span.TraceState,
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Status:            otlpStatus{Code: int(span.StatusCode), Message: span.StatusMessage},
+		}
+		if span.ParentSpanID.IsValid() {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttr(key, value))
+		}
+		scope := &traces.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, s)
+	}
+	return traces
+}
+
+// otlpAttr converts a key/value pair to an OTLP attribute.
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v map[string]interface{}
+	switch value := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": value}
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/LYZhelloworld/go-gateway"
+)
+
+// spanKey is the key of the current Span in context.Context.
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of the context.Context with the Span as the current one.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext gets the current Span from the context.Context. It returns nil if there is no Span.
+func SpanFromContext(ctx context.Context) *Span {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span
+	}
+	return nil
+}
+
+// CurrentSpan gets the current Span of the request.
+// It is the server span created by Middleware, or the span of the middleware being run if it is wrapped by Wrap.
+// It returns nil if the request is not traced.
+func CurrentSpan(context *gateway.Context) *Span {
+	return SpanFromContext(context.Request.Context())
+}
+
+// setCurrentSpan sets the current Span of the request.
+func setCurrentSpan(context *gateway.Context, span *Span) {
+	context.Request = context.Request.WithContext(ContextWithSpan(context.Request.Context(), span))
+}
+
+// Middleware returns a middleware that creates a server span for every request, named after the Service.
+// The span continues the trace from the incoming traceparent and tracestate headers, if any.
+//
+// The traceparent of the request is replaced with the server span, so that handlers forwarding the headers
+// of the request propagate the trace as well.
+func Middleware(tracer *Tracer) gateway.Handler {
+	return func(context *gateway.Context) {
+		req := context.Request
+		span := tracer.Start(Extract(req.Header), context.GetServiceName(), SpanKindServer)
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.target", req.URL.RequestURI())
+		span.SetAttribute("http.host", req.Host)
+		span.SetAttribute("gateway.service", context.GetServiceName())
+
+		setCurrentSpan(context, span)
+		Inject(span.SpanContext(), context.Request.Header)
+		context.OnFinish(func() {
+			statusCode := context.StatusCode
+			if p := context.GetPanic(); p != nil {
+				span.SetStatus(StatusError, p.Error())
+			} else if statusCode >= http.StatusInternalServerError {
+				span.SetStatus(StatusError, http.StatusText(statusCode))
+			}
+			span.SetAttribute("http.status_code", statusCode)
+			span.SetAttribute("http.response_content_length", context.BytesWritten())
+			span.End()
+		})
+	}
+}
+
+// Wrap wraps a middleware with a span named after it, as a child of the current span.
+// The span covers the following handlers as well if the middleware calls gateway.Context.Next().
+func Wrap(tracer *Tracer, name string, handler gateway.Handler) gateway.Handler {
+	if handler == nil {
+		panic("nil handler")
+	}
+	return func(context *gateway.Context) {
+		parent := CurrentSpan(context)
+		if parent == nil {
+			handler(context)
+			return
+		}
+
+		span := tracer.Start(parent.SpanContext(), name, SpanKindInternal)
+		setCurrentSpan(context, span)
+		completed := false
+		defer func() {
+			setCurrentSpan(context, parent)
+			if !completed {
+				span.SetStatus(StatusError, "panic")
+			}
+			span.End()
+		}()
+		handler(context)
+		completed = true
+	}
+}
+
+// Transport wraps an http.RoundTripper, creating a client span for every request sent to an upstream
+// and injecting the traceparent and tracestate headers.
+// If the request is created from gateway.Context.Request.Context(), the span is a child of the current span.
+// http.DefaultTransport is used if the given one is nil.
+func Transport(tracer *Tracer, rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return gateway.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var parent SpanContext
+		if span := SpanFromContext(req.Context()); span != nil {
+			parent = span.SpanContext()
+		}
+		span := tracer.Start(parent, req.Method+" "+req.URL.Host, SpanKindClient)
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.url", req.URL.String())
+		span.SetAttribute("net.peer.name", req.URL.Host)
+		defer span.End()
+
+		outReq := gateway.CloneRequestHeader(req)
+		Inject(span.SpanContext(), outReq.Header)
+
+		resp, err := rt.RoundTrip(outReq)
+		if err != nil {
+			span.SetStatus(StatusError, err.Error())
+			return resp, err
+		}
+		span.SetAttribute("http.status_code", resp.StatusCode)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(StatusError, http.StatusText(resp.StatusCode))
+		}
+		return resp, nil
+	})
+}
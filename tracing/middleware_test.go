@@ -0,0 +1,172 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/LYZhelloworld/go-gateway"
+	"github.com/LYZhelloworld/go-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryExporter keeps exported spans in memory.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (m *memoryExporter) ExportSpans(spans []SpanData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func (m *memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("gateway", exporter)
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamTraceparent = req.Header.Get(TraceparentHeader)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: Transport(tracer, nil)}
+
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/hello", http.MethodGet, "api.hello")
+	s.UseConfig(cfg)
+	s.UseMiddlewares(Middleware(tracer), Wrap(tracer, "auth", func(context *gateway.Context) {
+		assert.Equal(t, "auth", CurrentSpan(context).data.Name)
+	}))
+	var serverSpan *Span
+	s.Register("api.hello", func(context *gateway.Context) {
+		serverSpan = CurrentSpan(context)
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req.WithContext(context.Request.Context()))
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Len(t, exporter.spans, 3)
+	byKind := map[SpanKind]SpanData{}
+	for _, span := range exporter.spans {
+		byKind[span.Kind] = span
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String())
+	}
+	server := byKind[SpanKindServer]
+	assert.Equal(t, "api.hello", server.Name)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID.String())
+	assert.Equal(t, http.StatusOK, server.Attributes["http.status_code"])
+	assert.Equal(t, server.SpanID, serverSpan.SpanContext().SpanID)
+	assert.Equal(t, server.SpanID, byKind[SpanKindInternal].ParentSpanID)
+	clientSpan := byKind[SpanKindClient]
+	assert.Equal(t, server.SpanID, clientSpan.ParentSpanID)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+clientSpan.SpanID.String()+"-01", upstreamTraceparent)
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("gateway", exporter)
+	tracer.SetSampleRatio(0)
+	tracer.Start(SpanContext{}, "dropped", SpanKindServer).End()
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tracer.Start(parent, "sampled", SpanKindServer).End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+	assert.Len(t, exporter.spans, 1)
+	assert.Equal(t, "sampled", exporter.spans[0].Name)
+}
+
+func TestJSONExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := NewTracer("gateway", NewJSONExporter(buf))
+	span := tracer.Start(SpanContext{}, "test", SpanKindServer)
+	span.SetAttribute("foo", "bar")
+	span.End()
+	span.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 1)
+	result := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(lines[0], &result))
+	assert.Equal(t, "test", result["name"])
+	assert.Equal(t, "server", result["kind"])
+	assert.Equal(t, span.SpanContext().TraceID.String(), result["trace_id"])
+	assert.Equal(t, "bar", result["attributes"].(map[string]interface{})["foo"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received otlpTraces
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/traces", req.URL.Path)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", req.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL + "/v1/traces")
+	exporter.Header.Set("Authorization", "secret")
+	tracer := NewTracer("gateway", exporter)
+	parent := tracer.Start(SpanContext{}, "parent", SpanKindServer)
+	child := tracer.Start(parent.SpanContext(), "child", SpanKindClient)
+	child.SetAttribute("http.status_code", 200)
+	child.End()
+	parent.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Len(t, received.ResourceSpans, 1)
+	assert.Equal(t, "service.name", received.ResourceSpans[0].Resource.Attributes[0].Key)
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, 3, spans[0].Kind)
+	assert.Equal(t, parent.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	assert.Equal(t, "200", spans[0].Attributes[0].Value["intValue"])
+
+	collector.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	assert.Error(t, exporter.ExportSpans([]SpanData{{Name: "test"}}))
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer("gateway", exporter)
+	s := gateway.Default()
+	s.AttachLogger(logger.GetNopLogger())
+	cfg := gateway.Config{}
+	cfg.Add("/panic", http.MethodGet, "api.panic")
+	s.UseConfig(cfg)
+	s.UseMiddleware(Middleware(tracer))
+	s.Register("api.panic", func(context *gateway.Context) {
+		panic("boom")
+	})
+
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+	assert.Len(t, exporter.spans, 1)
+	span := exporter.spans[0]
+	assert.Equal(t, http.StatusInternalServerError, span.Attributes["http.status_code"])
+	assert.Equal(t, StatusError, span.StatusCode)
+	assert.Equal(t, "panic: boom", span.StatusMessage)
+}
@@ -0,0 +1,213 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader is the header of W3C Trace Context which holds the trace ID, parent span ID and flags.
+	TraceparentHeader = "traceparent"
+	// TracestateHeader is the header of W3C Trace Context which holds vendor-specific trace data.
+	TracestateHeader = "tracestate"
+
+	// traceparentLength is the length of a version 00 traceparent.
+	traceparentLength = 55
+	// maxTracestateMembers is the maximum number of list members in a tracestate.
+	maxTracestateMembers = 32
+	// maxTracestateLength is the maximum length of a tracestate that will be propagated.
+	maxTracestateLength = 512
+	// flagSampled is the trace flag of a sampled trace.
+	flagSampled = 0x01
+)
+
+// ErrInvalidTraceparent is the error of parsing an invalid traceparent header.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID is the identifier of a trace.
+type TraceID [16]byte
+
+// String returns the TraceID in lower case hex.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid checks if the TraceID is not all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t TraceID) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// SpanID is the identifier of a span.
+type SpanID [8]byte
+
+// String returns the SpanID in lower case hex.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid checks if the SpanID is not all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s SpanID) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// SpanContext is the part of a span which is propagated across services.
+type SpanContext struct {
+	// TraceID is the identifier of the trace.
+	TraceID TraceID
+	// SpanID is the identifier of the span.
+	SpanID SpanID
+	// Flags is the trace flags. Only the sampled flag (0x01) is defined.
+	Flags byte
+	// TraceState is the vendor-specific trace data from the tracestate header.
+	TraceState string
+}
+
+// IsValid checks if both TraceID and SpanID are valid.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID.IsValid() && s.SpanID.IsValid()
+}
+
+// IsSampled checks if the sampled flag is set.
+func (s SpanContext) IsSampled() bool {
+	return s.Flags&flagSampled != 0
+}
+
+// Traceparent formats the SpanContext as a version 00 traceparent header.
+func (s SpanContext) Traceparent() string {
+	return "00-" + s.TraceID.String() + "-" + s.SpanID.String() + "-" + hex.EncodeToString([]byte{s.Flags})
+}
+
+// ParseTraceparent parses a traceparent header.
+// Versions other than 00 are parsed as version 00, ignoring the extra fields, as the specification requires.
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	sc := SpanContext{}
+	traceparent = strings.TrimSpace(traceparent)
+	if len(traceparent) < traceparentLength {
+		return sc, ErrInvalidTraceparent
+	}
+
+	version, err := decodeHex(traceparent[0:2], 1)
+	if err != nil || version[0] == 0xff || traceparent[2] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	if version[0] == 0 && len(traceparent) != traceparentLength {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(traceparent) > traceparentLength && traceparent[traceparentLength] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	if traceparent[35] != '-' || traceparent[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+
+	traceID, err := decodeHex(traceparent[3:35], 16)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, err := decodeHex(traceparent[36:52], 8)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, err := decodeHex(traceparent[53:55], 1)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lower case hex of the given number of bytes.
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != n*2 || strings.ToLower(s) != s {
+		return nil, ErrInvalidTraceparent
+	}
+	return hex.DecodeString(s)
+}
+
+// Extract gets SpanContext from the traceparent and tracestate headers.
+// It returns an invalid SpanContext if there is no valid traceparent.
+func Extract(header http.Header) SpanContext {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+	sc.TraceState = normalizeTracestate(header[http.CanonicalHeaderKey(TracestateHeader)])
+	return sc
+}
+
+// Inject sets the traceparent and tracestate headers from the SpanContext.
+// It does nothing if the SpanContext is invalid.
+func Inject(sc SpanContext, header http.Header) {
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// normalizeTracestate combines multiple tracestate headers into one, removing empty list members.
+// The tracestate is discarded if it has too many list members or is too long.
+func normalizeTracestate(values []string) string {
+	members := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			if !strings.Contains(member, "=") {
+				return ""
+			}
+			members = append(members, member)
+		}
+	}
+	if len(members) > maxTracestateMembers {
+		return ""
+	}
+	tracestate := strings.Join(members, ",")
+	if len(tracestate) > maxTracestateLength {
+		return ""
+	}
+	return tracestate
+}
+
+// newTraceID generates a random TraceID.
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID generates a random SpanID.
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
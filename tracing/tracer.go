@@ -0,0 +1,286 @@
+// Package tracing provides distributed tracing with W3C Trace Context propagation.
+//
+// A Tracer creates spans and sends finished spans to an Exporter in batches.
+// Middleware creates a server span for every request, and Transport creates a client span
+// for every request sent to an upstream, injecting the traceparent and tracestate headers:
+//
+//	tracer := tracing.NewTracer("gateway", tracing.NewOTLPExporter("http://localhost:4318/v1/traces"))
+//	defer tracer.Shutdown(context.Background())
+//	s.UseMiddleware(tracing.Middleware(tracer))
+//	client := &http.Client{Transport: tracing.Transport(tracer, nil)}
+package tracing
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultQueueSize is the maximum number of finished spans waiting to be exported.
+	defaultQueueSize = 2048
+	// defaultBatchSize is the maximum number of spans exported at once.
+	defaultBatchSize = 512
+	// defaultFlushInterval is the interval of exporting spans if the batch is not full.
+	defaultFlushInterval = 5 * time.Second
+)
+
+// SpanKind is the kind of a span.
+type SpanKind int
+
+const (
+	// SpanKindInternal is a span of an operation inside the gateway, such as a middleware.
+	SpanKindInternal SpanKind = iota + 1
+	// SpanKindServer is a span of handling an incoming request.
+	SpanKindServer
+	// SpanKindClient is a span of sending a request to an upstream.
+	SpanKindClient
+)
+
+// String returns the name of the SpanKind.
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "unspecified"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k SpanKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// StatusCode is the status of a span.
+type StatusCode int
+
+const (
+	// StatusUnset is the default status.
+	StatusUnset StatusCode = iota
+	// StatusOK means the operation succeeded.
+	StatusOK
+	// StatusError means the operation failed.
+	StatusError
+)
+
+// Tracer creates spans and exports them when they end.
+type Tracer struct {
+	// serviceName is the name of the gateway reported to the tracing backend.
+	serviceName string
+	// exporter is the Exporter of finished spans.
+	exporter Exporter
+	// sampleRatio is the ratio of new traces to be sampled.
+	sampleRatio float64
+
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTracer creates a Tracer which samples all new traces and exports spans in background.
+// Call Tracer.Shutdown before the application stops to export the remaining spans.
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	if exporter == nil {
+		panic("nil exporter")
+	}
+	t := &Tracer{
+		serviceName: serviceName,
+		exporter:    exporter,
+		sampleRatio: 1,
+		queue:       make(chan SpanData, defaultQueueSize),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+// SetSampleRatio sets the ratio (from 0 to 1) of new traces to be sampled.
+// Traces continued from an incoming traceparent follow the sampled flag of the parent instead.
+func (t *Tracer) SetSampleRatio(ratio float64) {
+	if ratio < 0 || ratio > 1 {
+		panic("invalid sample ratio")
+	}
+	t.sampleRatio = ratio
+}
+
+// Start starts a span as a child of the parent SpanContext,
+// or as the root of a new trace if the parent is invalid.
+func (t *Tracer) Start(parent SpanContext, name string, kind SpanKind) *Span {
+	sc := SpanContext{SpanID: newSpanID()}
+	var parentSpanID SpanID
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+		parentSpanID = parent.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+		if t.sampleRatio >= 1 || rand.Float64() < t.sampleRatio {
+			sc.Flags |= flagSampled
+		}
+	}
+
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			ServiceName:  t.serviceName,
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parentSpanID,
+			TraceState:   sc.TraceState,
+			StartTime:    time.Now(),
+			Attributes:   map[string]interface{}{},
+		},
+		spanContext: sc,
+	}
+}
+
+// Flush exports all finished spans, until the context is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	ch := make(chan struct{})
+	select {
+	case t.flush <- ch:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports all finished spans and shuts down the Exporter.
+// Spans ended after shutting down are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	err := t.Flush(ctx)
+	t.stopOnce.Do(func() {
+		close(t.done)
+		if e := t.exporter.Shutdown(ctx); err == nil {
+			err = e
+		}
+	})
+	return err
+}
+
+// export queues a finished span. The span is dropped if the queue is full.
+func (t *Tracer) export(data SpanData) {
+	select {
+	case <-t.done:
+	case t.queue <- data:
+	default:
+	}
+}
+
+// loop collects finished spans and exports them in batches.
+func (t *Tracer) loop() {
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, defaultBatchSize)
+	exportBatch := func() {
+		if len(batch) > 0 {
+			_ = t.exporter.ExportSpans(batch)
+			batch = make([]SpanData, 0, defaultBatchSize)
+		}
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= defaultBatchSize {
+				exportBatch()
+			}
+		case <-ticker.C:
+			exportBatch()
+		case ch := <-t.flush:
+			for n := len(t.queue); n > 0; n-- {
+				batch = append(batch, <-t.queue)
+			}
+			exportBatch()
+			close(ch)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// SpanData is the snapshot of a span which is sent to the Exporter.
+type SpanData struct {
+	Name          string                 `json:"name"`
+	Kind          SpanKind               `json:"kind"`
+	ServiceName   string                 `json:"service_name"`
+	TraceID       TraceID                `json:"trace_id"`
+	SpanID        SpanID                 `json:"span_id"`
+	ParentSpanID  SpanID                 `json:"parent_span_id"`
+	TraceState    string                 `json:"trace_state,omitempty"`
+	StartTime     time.Time              `json:"start_time"`
+	EndTime       time.Time              `json:"end_time"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	StatusCode    StatusCode             `json:"status_code"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+// Span is an operation in a trace.
+type Span struct {
+	tracer      *Tracer
+	spanContext SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the SpanContext to be propagated to child spans.
+func (s *Span) SpanContext() SpanContext {
+	return s.spanContext
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// SetStatus sets the status of the span.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = code
+	s.data.StatusMessage = message
+}
+
+// End ends the span and exports it if the trace is sampled. Calling this method multiple times has no side effects.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	if s.spanContext.IsSampled() {
+		s.tracer.export(data)
+	}
+}
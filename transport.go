@@ -0,0 +1,26 @@
+package gateway
+
+import (
+	"net/http"
+)
+
+// RoundTripperFunc is a function that implements http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// CloneRequestHeader returns a shallow copy of the request with a copy of its headers,
+// so that an http.RoundTripper can set headers of the request sent to the upstream
+// without modifying the request it is given.
+func CloneRequestHeader(req *http.Request) *http.Request {
+	out := new(http.Request)
+	*out = *req
+	out.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		out.Header[key] = append([]string(nil), values...)
+	}
+	return out
+}
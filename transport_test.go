@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTripperFunc(t *testing.T) {
+	var rt http.RoundTripper = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusTeapot, Request: req}, nil
+	})
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	resp, err := rt.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	assert.Equal(t, req, resp.Request)
+}
+
+func TestCloneRequestHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	req.Header.Add("X-Foo", "1")
+	out := CloneRequestHeader(req)
+	out.Header.Add("X-Foo", "2")
+	out.Header.Set("X-Bar", "1")
+	assert.Equal(t, []string{"1", "2"}, out.Header["X-Foo"])
+	assert.Equal(t, []string{"1"}, req.Header["X-Foo"])
+	assert.Empty(t, req.Header.Get("X-Bar"))
+	assert.Equal(t, req.URL, out.URL)
+	assert.Equal(t, req.Context(), out.Context())
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultUpgradeTimeout is the default timeout of waiting for the new process to be ready.
+const defaultUpgradeTimeout = 30 * time.Second
+
+// UpgradeConfig is the configuration of zero-downtime upgrades.
+type UpgradeConfig struct {
+	// Path is the executable of the new process. It is the current executable if empty, which should have been
+	// replaced by the new binary.
+	Path string
+	// Args is the arguments of the new process. They are the arguments of the current process if nil.
+	Args []string
+	// Timeout is the timeout of waiting for the new process to be ready. It is 30 seconds if zero.
+	Timeout time.Duration
+}
+
+// UseUpgrade enables zero-downtime upgrades. An upgrade is triggered by Upgrade, a SIGUSR2 in RunWithShutdown,
+// or a POST request to "/upgrade" on the admin listener.
+func (s *Server) UseUpgrade(config UpgradeConfig) {
+	if config.Timeout < 0 {
+		panic("negative upgrade timeout")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultUpgradeTimeout
+	}
+	s.upgrade = &config
+}
+
+// Upgrade starts the new process with the listening sockets (including the admin listener and the UDP socket
+// of HTTP/3) as inherited files, waits for it to be ready, and then shuts down the Server gracefully,
+// so that no connections are refused.
+// The new process serves the inherited sockets instead of the addresses it is configured with.
+//
+// The Server must be running with RunContext or RunWithShutdown, which returns after the shutdown.
+// The Server keeps running if the new process fails to be ready within the timeout, and it is killed.
+// Upgrades are not supported on Windows.
+func (s *Server) Upgrade() error {
+	if s.upgrade == nil {
+		return errors.New("upgrade is not enabled")
+	}
+	if !atomic.CompareAndSwapInt32(&s.upgrading, 0, 1) {
+		return errors.New("upgrade in progress")
+	}
+	defer atomic.StoreInt32(&s.upgrading, 0)
+
+	s.mu.Lock()
+	listeners, admin, http3Conn, stop := s.rawListeners, s.adminListener, s.http3Conn, s.stop
+	s.mu.Unlock()
+	if stop == nil || len(listeners) == 0 {
+		return errors.New("server is not running with RunContext or RunWithShutdown")
+	}
+
+	process, ready, err := s.startUpgrade(listeners, admin, http3Conn)
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	logger := s.logger.WithField("pid", process.Pid)
+	logger.Info("new process started")
+
+	readyChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(readyMessage))
+		if _, err := io.ReadFull(ready, buf); err != nil || string(buf) != readyMessage {
+			readyChan <- errors.New("new process exited before being ready")
+			return
+		}
+		readyChan <- nil
+	}()
+	timer := time.NewTimer(s.upgrade.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-readyChan:
+	case <-timer.C:
+		err = errors.New("timeout waiting for new process to be ready")
+	}
+	if err != nil {
+		_ = process.Kill()
+		_, _ = process.Wait()
+		logger.WithError(err).Error("upgrade failed")
+		return err
+	}
+	go func() {
+		// reap the new process if this one keeps running after the shutdown
+		_, _ = process.Wait()
+	}()
+
+	// the socket files are now served by the new process
+	for _, ln := range listeners {
+		if unix, ok := ln.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	if unix, ok := admin.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+	logger.Info("new process ready, shutting down")
+	stop()
+	return nil
+}
+
+// startUpgrade starts the new process with the listeners and the UDP connection of HTTP/3 (which may be nil),
+// returning the read end of its ready pipe.
+func (s *Server) startUpgrade(listeners []net.Listener, admin net.Listener, http3Conn net.PacketConn) (*os.Process,
+	*os.File, error) {
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			closeFD(fd)
+		}
+	}()
+	for _, ln := range listeners {
+		fd, err := dupListener(ln)
+		if err != nil {
+			return nil, nil, err
+		}
+		fds = append(fds, fd)
+	}
+	env := upgradeEnv(os.Environ())
+	env = append(env, listenFDsEnv+"="+strconv.Itoa(len(listeners)))
+	if admin != nil {
+		fd, err := dupListener(admin)
+		if err != nil {
+			return nil, nil, err
+		}
+		env = append(env, adminFDEnv+"="+strconv.Itoa(firstInheritedFD+len(fds)))
+		fds = append(fds, fd)
+	}
+	if http3Conn != nil {
+		fd, err := dupPacketConn(http3Conn)
+		if err != nil {
+			return nil, nil, err
+		}
+		env = append(env, http3FDEnv+"="+strconv.Itoa(firstInheritedFD+len(fds)))
+		fds = append(fds, fd)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer w.Close()
+	env = append(env, readyFDEnv+"="+strconv.Itoa(firstInheritedFD+len(fds)))
+	files := append(append([]int(nil), fds...), int(w.Fd()))
+
+	path, args := s.upgrade.Path, s.upgrade.Args
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			_ = r.Close()
+			return nil, nil, err
+		}
+	}
+	if args == nil {
+		args = os.Args[1:]
+	}
+	process, err := startProcess(path, args, env, files)
+	if err != nil {
+		_ = r.Close()
+		return nil, nil, fmt.Errorf("start new process: %v", err)
+	}
+	return process, r, nil
+}
+
+// upgradeEnv removes the environment variables of inherited files, which are set again for the new process.
+func upgradeEnv(environ []string) []string {
+	var env []string
+	for _, e := range environ {
+		name := strings.SplitN(e, "=", 2)[0]
+		switch name {
+		case listenFDsEnv, adminFDEnv, http3FDEnv, readyFDEnv, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES":
+			continue
+		}
+		env = append(env, e)
+	}
+	return env
+}
+
+// serveUpgrade serves the upgrade trigger on the admin listener.
+func (s *Server) serveUpgrade(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Upgrade(); err != nil {
+		writeAdminJSON(w, http.StatusInternalServerError, map[string]interface{}{"upgraded": false, "error": err.Error()})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"upgraded": true})
+}
@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// upgradeChildEnv is set for the new process started by TestServer_Upgrade, which runs the test as the child.
+const upgradeChildEnv = "GATEWAY_TEST_UPGRADE_CHILD"
+
+func TestServer_Upgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("upgrades are not supported on Windows")
+	}
+	if os.Getenv(upgradeChildEnv) == "1" {
+		runUpgradeChild(t)
+		return
+	}
+
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte("parent")
+	})
+	s.UseUpgrade(UpgradeConfig{Path: os.Args[0], Args: []string{"-test.run=^TestServer_Upgrade$"}})
+	assert.NoError(t, os.Setenv(upgradeChildEnv, "1"))
+	defer os.Unsetenv(upgradeChildEnv)
+	started := make(chan struct{})
+	s.OnStart(func() {
+		close(started)
+	})
+	done := make(chan error)
+	go func() {
+		done <- s.RunContext(context.Background(), "127.0.0.1:0")
+	}()
+	<-started
+	addr := s.Addr().String()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() string {
+		resp, err := client.Get("http://" + addr + "/hello")
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	assert.Equal(t, "parent", get())
+
+	assert.NoError(t, s.Upgrade())
+	assert.NoError(t, <-done)
+	// the socket is served by the new process after this one stops
+	assert.Equal(t, "child", get())
+}
+
+// runUpgradeChild runs the new process of TestServer_Upgrade, which stops after serving a request.
+func runUpgradeChild(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := lifecycleServer(func(context *Context) {
+		context.Response = []byte("child")
+		time.AfterFunc(100*time.Millisecond, cancel)
+	})
+	// the address is ignored, since the listener is inherited
+	err := s.RunContext(ctx, "127.0.0.1:1")
+	// the output of the test binary is not printed, since it shares stdout with the parent test
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestServer_Upgrade_Errors(t *testing.T) {
+	s := lifecycleServer(func(context *Context) {})
+	assert.EqualError(t, s.Upgrade(), "upgrade is not enabled")
+	s.UseUpgrade(UpgradeConfig{})
+	assert.Equal(t, defaultUpgradeTimeout, s.upgrade.Timeout)
+	assert.EqualError(t, s.Upgrade(), "server is not running with RunContext or RunWithShutdown")
+	assert.Panics(t, func() {
+		s.UseUpgrade(UpgradeConfig{Timeout: -1})
+	})
+}
+
+func TestServer_Upgrade_ChildFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("upgrades are not supported on Windows")
+	}
+	path, err := exec.LookPath("true")
+	if err != nil {
+		t.Skip("true is not found")
+	}
+	s := lifecycleServer(func(context *Context) {})
+	// the new process exits immediately without being ready
+	s.UseUpgrade(UpgradeConfig{Path: path, Args: []string{}})
+	started := make(chan struct{})
+	s.OnStart(func() {
+		close(started)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.RunContext(ctx, "127.0.0.1:0")
+	}()
+	<-started
+
+	assert.EqualError(t, s.Upgrade(), "new process exited before being ready")
+	// the Server keeps running
+	assert.NotNil(t, s.Addr())
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestServer_AdminUpgrade(t *testing.T) {
+	s := lifecycleServer(func(context *Context) {})
+	s.UseAdmin(":0")
+	assert.NotContains(t, adminRoutes(s), "/upgrade")
+	s.UseUpgrade(UpgradeConfig{})
+
+	w := httptest.NewRecorder()
+	s.prepareAdmin().Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/upgrade", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w = httptest.NewRecorder()
+	s.prepareAdmin().Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upgrade", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"upgraded": false`)
+}
+
+// adminRoutes gets the status of GET requests to the admin routes.
+func adminRoutes(s *Server) map[string]int {
+	routes := map[string]int{}
+	for _, path := range []string{"/healthz", "/upgrade"} {
+		w := httptest.NewRecorder()
+		s.prepareAdmin().Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			routes[path] = w.Code
+		}
+	}
+	return routes
+}
+
+func TestSystemdListeners(t *testing.T) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	lns, err := SystemdListeners()
+	assert.NoError(t, err)
+	assert.Nil(t, lns)
+
+	// the sockets are for another process
+	assert.NoError(t, os.Setenv("LISTEN_PID", "1"))
+	assert.NoError(t, os.Setenv("LISTEN_FDS", "1"))
+	lns, err = SystemdListeners()
+	assert.NoError(t, err)
+	assert.Nil(t, lns)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	assert.NoError(t, os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())))
+	assert.NoError(t, os.Setenv("LISTEN_FDS", "x"))
+	_, err = SystemdListeners()
+	assert.Error(t, err)
+
+	assert.NoError(t, os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())))
+	assert.NoError(t, os.Setenv("LISTEN_FDS", "0"))
+	lns, err = SystemdListeners()
+	assert.NoError(t, err)
+	assert.Empty(t, lns)
+}
+
+func TestFileListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("upgrades are not supported on Windows")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	fd, err := dupListener(ln)
+	assert.NoError(t, err)
+	inheritedLn, err := fileListener(uintptr(fd))
+	assert.NoError(t, err)
+	defer inheritedLn.Close()
+	assert.Equal(t, ln.Addr().String(), inheritedLn.Addr().String())
+
+	_, err = dupListener(newLimitListener(ln, nil))
+	assert.Error(t, err)
+}
+
+func TestFilePacketConn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("upgrades are not supported on Windows")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fd, err := dupPacketConn(conn)
+	assert.NoError(t, err)
+	inheritedConn, err := filePacketConn(uintptr(fd))
+	assert.NoError(t, err)
+	defer inheritedConn.Close()
+	assert.Equal(t, conn.LocalAddr().String(), inheritedConn.LocalAddr().String())
+}
+
+func TestUpgradeEnv(t *testing.T) {
+	env := upgradeEnv([]string{"PATH=/bin", listenFDsEnv + "=2", "LISTEN_FDS=1", readyFDEnv + "=5", http3FDEnv + "=6",
+		"HOME=/root"})
+	assert.Equal(t, []string{"PATH=/bin", "HOME=/root"}, env)
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+// +build !windows
+
+package gateway
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// upgradeSignal is the signal triggering an upgrade in RunWithShutdown.
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// dupListener duplicates the file descriptor of a listener. Unlike the File method of listeners,
+// it does not change the listener to blocking mode, which would stop it from being closed while accepting.
+func dupListener(ln net.Listener) (int, error) {
+	conn, ok := ln.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("listener cannot be inherited: " + ln.Addr().String())
+	}
+	return dupConn(conn)
+}
+
+// dupPacketConn duplicates the file descriptor of a packet connection, like dupListener.
+func dupPacketConn(pc net.PacketConn) (int, error) {
+	conn, ok := pc.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("packet connection cannot be inherited: " + pc.LocalAddr().String())
+	}
+	return dupConn(conn)
+}
+
+// dupConn duplicates the file descriptor of a socket.
+func dupConn(conn syscall.Conn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	if err := raw.Control(func(s uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(s)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	}); err != nil {
+		return -1, err
+	}
+	return fd, dupErr
+}
+
+// startProcess starts a process with stdin, stdout and stderr of this process, and the files from descriptor 3.
+func startProcess(path string, args []string, env []string, files []int) (*os.Process, error) {
+	fds := []uintptr{0, 1, 2}
+	for _, f := range files {
+		fds = append(fds, uintptr(f))
+	}
+	pid, err := syscall.ForkExec(path, append([]string{path}, args...), &syscall.ProcAttr{Env: env, Files: fds})
+	if err != nil {
+		return nil, err
+	}
+	return os.FindProcess(pid)
+}
+
+// closeFD closes a file descriptor.
+func closeFD(fd int) {
+	_ = syscall.Close(fd)
+}
@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+package gateway
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// errUpgradeNotSupported is the error of upgrading on Windows.
+var errUpgradeNotSupported = errors.New("upgrade is not supported on Windows")
+
+// upgradeSignal is the signal triggering an upgrade in RunWithShutdown. There is none on Windows.
+var upgradeSignal os.Signal
+
+// dupListener is not supported on Windows.
+func dupListener(ln net.Listener) (int, error) {
+	return -1, errUpgradeNotSupported
+}
+
+// dupPacketConn is not supported on Windows.
+func dupPacketConn(conn net.PacketConn) (int, error) {
+	return -1, errUpgradeNotSupported
+}
+
+// startProcess is not supported on Windows.
+func startProcess(path string, args []string, env []string, files []int) (*os.Process, error) {
+	return nil, errUpgradeNotSupported
+}
+
+// closeFD does nothing on Windows.
+func closeFD(fd int) {}